@@ -0,0 +1,263 @@
+package cas
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// extraHashes computes additional refs of the content while it's being stored.
+type extraHashes struct {
+	names []string
+	hs    []hash.Hash
+	sized []string // hashes that require the size of the content
+}
+
+func newExtraHashes(names []string, indexOnly bool) (*extraHashes, error) {
+	e := &extraHashes{}
+	for _, name := range names {
+		f := types.GetHash(name)
+		if f == nil {
+			return nil, fmt.Errorf("unsupported hash: %q", name)
+		}
+		if f.New == nil {
+			if f.NewSized == nil {
+				return nil, fmt.Errorf("hash %q cannot be computed", name)
+			} else if indexOnly {
+				// we need to read the content again
+				return nil, fmt.Errorf("hash %q cannot be computed in index-only mode", name)
+			}
+			e.sized = append(e.sized, name)
+			continue
+		}
+		// hashes that cannot address blobs are still accepted for aliases
+		e.names = append(e.names, name)
+		e.hs = append(e.hs, f.New())
+	}
+	return e, nil
+}
+
+func (e *extraHashes) Write(p []byte) (int, error) {
+	for _, h := range e.hs {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// storeAlias stores an alias object for a canonical ref using the refs computed by e.
+func (s *Storage) storeAlias(ctx context.Context, sr SizedRef, e *extraHashes) error {
+	if sr.Ref.Zero() || sr.Size == 0 {
+		// empty blobs are resolved for any hash
+		return nil
+	}
+	var refs []Ref
+	add := func(ref Ref) {
+		if ref == sr.Ref {
+			return
+		}
+		for _, r := range refs {
+			if r == ref {
+				return
+			}
+		}
+		refs = append(refs, ref)
+	}
+	for i, h := range e.hs {
+		// hash is known to be registered, so this won't fail
+		ref, _ := types.MakeRef(e.names[i], h.Sum(nil))
+		add(ref)
+	}
+	if len(e.sized) != 0 {
+		rc, _, err := s.openContent(ctx, sr.Ref)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		hs := make([]hash.Hash, 0, len(e.sized))
+		ws := make([]io.Writer, 0, len(e.sized))
+		for _, name := range e.sized {
+			h := types.GetHash(name).NewSized(sr.Size)
+			hs = append(hs, h)
+			ws = append(ws, h)
+		}
+		if _, err = io.Copy(io.MultiWriter(ws...), rc); err != nil {
+			return err
+		}
+		for i, name := range e.sized {
+			// hash is known to be registered, so this won't fail
+			ref, _ := types.MakeRef(name, hs[i].Sum(nil))
+			add(ref)
+		}
+	}
+	return s.storeAliasRefs(ctx, sr, refs)
+}
+
+// aliasIndex is a kind of index pins that map alias refs to alias objects.
+const aliasIndex = "alias"
+
+// storeAliasRefs stores an alias object for a canonical ref and indexes it by all alias refs.
+// Refs must be computed from the content of the canonical ref by the caller.
+func (s *Storage) storeAliasRefs(ctx context.Context, sr SizedRef, refs []Ref) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	asr, err := s.StoreSchema(ctx, &schema.Alias{Ref: sr, Refs: refs})
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err = s.st.SetPin(ctx, indexPin(aliasIndex, refKey(ref)), asr.Ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupAlias finds an alias object that lists a given ref. It returns ErrNotFound if there is none.
+// Refs computed with the hash used for new blobs are never aliased, thus the index is not checked for them.
+//
+// Aliases are only indexed after their refs were computed from the content, and clients of CAS servers cannot
+// change index pins, thus the alias is trusted. Reads are still verified by openAlias.
+func (s *Storage) lookupAlias(ctx context.Context, ref Ref) (*schema.Alias, error) {
+	ctx, err := s.hashContext(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if ref.Name() == storage.HashName(ctx) {
+		return nil, storage.ErrNotFound
+	}
+	aref, err := s.st.GetPin(ctx, indexPin(aliasIndex, refKey(ref)))
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.DecodeSchema(ctx, aref)
+	if err == schema.ErrNotSchema {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if a, ok := obj.(*schema.Alias); ok {
+		for _, r := range a.Refs {
+			if r == ref {
+				return a, nil
+			}
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+// openAlias opens the content of a blob that is aliased by ref. The content is verified against ref while
+// it's being read.
+func (s *Storage) openAlias(ctx context.Context, ref Ref) (io.ReadCloser, uint64, error) {
+	a, err := s.lookupAlias(ctx, ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	rc, sr, err := s.openContent(ctx, a.Ref.Ref)
+	if err != nil {
+		return nil, 0, err
+	} else if sr.Size != a.Ref.Size {
+		rc.Close()
+		return nil, 0, storage.ErrSizeMissmatch{Exp: a.Ref.Size, Got: sr.Size}
+	}
+	return storage.VerifySizedReader(rc, ref, sr.Size), sr.Size, nil
+}
+
+// statAlias returns the size of a blob that is aliased by ref. The content is not read, since only verified aliases
+// are indexed, but the size recorded in the alias must match the size of the content.
+func (s *Storage) statAlias(ctx context.Context, ref Ref) (uint64, error) {
+	a, err := s.lookupAlias(ctx, ref)
+	if err != nil {
+		return 0, err
+	}
+	rc, sr, err := s.openContent(ctx, a.Ref.Ref)
+	if err != nil {
+		return 0, err
+	}
+	rc.Close()
+	if sr.Size != a.Ref.Size {
+		return 0, storage.ErrSizeMissmatch{Exp: a.Ref.Size, Got: sr.Size}
+	}
+	return sr.Size, nil
+}
+
+// reindexAliases adds alias objects to the index. Aliases are only indexed if the content of the canonical ref
+// matches the alias refs. If force is false, aliases that are indexed already are not verified again.
+func (s *Storage) reindexAliases(ctx context.Context, force bool) error {
+	it := s.IterateSchema(ctx, typeAlias)
+	defer it.Close()
+	for it.Next() {
+		obj, err := it.Decode()
+		if err != nil {
+			return err
+		}
+		a, ok := obj.(*schema.Alias)
+		if !ok {
+			return fmt.Errorf("unexpected type: %T", obj)
+		}
+		aref := it.SchemaRef().Ref
+		var refs []Ref
+		for _, r := range a.Refs {
+			if !force {
+				if cur, err := s.st.GetPin(ctx, indexPin(aliasIndex, refKey(r))); err == nil && cur == aref {
+					continue
+				}
+			}
+			refs = append(refs, r)
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		if refs, err = s.verifyAlias(ctx, a.Ref, refs); err != nil {
+			return err
+		}
+		for _, r := range refs {
+			if err = s.st.SetPin(ctx, indexPin(aliasIndex, refKey(r)), aref); err != nil {
+				return err
+			}
+		}
+	}
+	return it.Err()
+}
+
+// verifyAlias computes refs of the content of sr and returns refs that match it.
+func (s *Storage) verifyAlias(ctx context.Context, sr SizedRef, refs []Ref) ([]Ref, error) {
+	rc, got, err := s.openContent(ctx, sr.Ref)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if got.Size != sr.Size {
+		return nil, nil
+	}
+	hs := make([]hash.Hash, len(refs))
+	ws := make([]io.Writer, 0, len(refs))
+	for i, r := range refs {
+		hs[i] = r.Hash()
+		if hs[i] == nil {
+			if f := types.GetHash(r.Name()); f != nil && f.NewSized != nil {
+				hs[i] = f.NewSized(sr.Size)
+			}
+		}
+		if hs[i] != nil {
+			ws = append(ws, hs[i])
+		}
+	}
+	if _, err = io.Copy(io.MultiWriter(ws...), rc); err != nil {
+		return nil, err
+	}
+	var out []Ref
+	for i, r := range refs {
+		if hs[i] != nil && r.WithHash(hs[i]) == r {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
@@ -34,6 +34,8 @@ func (it *filesInIterator) addRefsFrom(obj schema.Object) {
 		for _, ent := range obj.List {
 			it.addRefsFrom(ent)
 		}
+	case schema.BlobWrapper:
+		it.refs = append(it.refs, obj.DataBlob())
 	}
 }
 
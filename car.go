@@ -0,0 +1,548 @@
+package cas
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/dennwc/cas/ipfs"
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+var typeUnixFS = schema.MustTypeOf(&schema.UnixFS{})
+
+// sha256Name is a name of the hash used by IPFS. Blobs hashed with it have the same digest as raw IPFS blocks.
+const sha256Name = "sha256"
+
+// unixfsIndex is a kind of index pins that map CIDs of UnixFS files and directories to refs.
+const unixfsIndex = "unixfs"
+
+// storeUnixFS stores the mapping of CIDs to refs and indexes it by CIDs.
+// CIDs must be computed from the content by the caller.
+func (s *Storage) storeUnixFS(ctx context.Context, m *schema.UnixFS) error {
+	if _, err := s.StoreSchema(ctx, m); err != nil {
+		return err
+	}
+	return s.indexUnixFS(ctx, m)
+}
+
+// indexUnixFS adds index pins for all nodes of a UnixFS mapping.
+func (s *Storage) indexUnixFS(ctx context.Context, m *schema.UnixFS) error {
+	for id, ref := range m.Nodes {
+		if err := s.st.SetPin(ctx, indexPin(unixfsIndex, id), ref); err != nil {
+			return err
+		}
+	}
+	return s.st.SetPin(ctx, indexPin(unixfsIndex, m.CID), m.Root)
+}
+
+// lookupUnixFS finds a file or a directory that was mapped to a CID. It returns false if there is none.
+func (s *Storage) lookupUnixFS(ctx context.Context, id ipfs.CID) (Ref, bool, error) {
+	ref, err := s.st.GetPin(ctx, indexPin(unixfsIndex, id.String()))
+	if err == storage.ErrNotFound {
+		return Ref{}, false, nil
+	} else if err != nil {
+		return Ref{}, false, err
+	}
+	return ref, true, nil
+}
+
+// reindexUnixFS adds CIDs of files and directories mapped by UnixFS objects to the index.
+// Since the DAG of an imported CAR file might use a different layout, CIDs are computed again
+// from the stored content, and only the computed CIDs are indexed.
+// If force is false, objects with an indexed root are not verified again.
+func (s *Storage) reindexUnixFS(ctx context.Context, force bool) error {
+	it := s.IterateSchema(ctx, typeUnixFS)
+	defer it.Close()
+	for it.Next() {
+		obj, err := it.Decode()
+		if err != nil {
+			return err
+		}
+		m, ok := obj.(*schema.UnixFS)
+		if !ok {
+			return fmt.Errorf("unexpected type: %T", obj)
+		}
+		if !force {
+			if cur, err := s.st.GetPin(ctx, indexPin(unixfsIndex, m.CID)); err == nil && cur == m.Root {
+				continue
+			}
+		}
+		e := newCARExporter(s)
+		root, err := e.node(ctx, m.Root)
+		if err == storage.ErrNotFound {
+			continue // content is not stored
+		} else if err != nil {
+			return err
+		}
+		err = s.indexUnixFS(ctx, &schema.UnixFS{CID: root.id.String(), Root: m.Root, Nodes: e.nodes})
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// carNode is a node of a UnixFS DAG that is exported to a CAR file.
+type carNode struct {
+	id    ipfs.CID
+	block []byte // dag-pb node or an empty raw block; nil for raw blocks of file content
+	size  uint64 // size of the file content
+	tsize uint64 // total size of blocks in the DAG
+	ref   Ref    // file content; only set for roots of file DAGs
+	subs  []*carNode
+}
+
+type carExporter struct {
+	s       *Storage
+	w       *ipfs.Writer
+	byRef   map[Ref]*carNode
+	nodes   map[string]Ref // files and directories by CID
+	written map[ipfs.CID]struct{}
+}
+
+// ExportCAR writes a directory tree or a file as a CARv1 file with a UnixFS DAG and returns the root CID.
+//
+// Files are split into raw leaves using the default IPFS layout. File modes and modification times are not exported.
+// The mapping of CIDs to refs is stored, thus importing the CAR file back restores the original tree.
+func (s *Storage) ExportCAR(ctx context.Context, ref Ref, w io.Writer) (ipfs.CID, error) {
+	if err := s.checkSigned(ctx, "export", ref); err != nil {
+		return ipfs.CID{}, err
+	}
+	e := newCARExporter(s)
+	// commits and annotated pins are exported as the tree they point to
+	ref, _, err := s.unwrap(ctx, ref)
+	if err != nil {
+		return ipfs.CID{}, err
+	}
+	// the root CID must be written first, thus the DAG is built before the content is written
+	root, err := e.node(ctx, ref)
+	if err != nil {
+		return ipfs.CID{}, err
+	}
+	e.w, err = ipfs.NewWriter(w, []ipfs.CID{root.id})
+	if err != nil {
+		return ipfs.CID{}, err
+	}
+	if err = e.write(ctx, root, nil); err != nil {
+		return ipfs.CID{}, err
+	}
+	err = s.storeUnixFS(ctx, &schema.UnixFS{CID: root.id.String(), Root: ref, Nodes: e.nodes})
+	if err != nil {
+		return ipfs.CID{}, err
+	}
+	return root.id, nil
+}
+
+func newCARExporter(s *Storage) *carExporter {
+	return &carExporter{
+		s:       s,
+		byRef:   make(map[Ref]*carNode),
+		nodes:   make(map[string]Ref),
+		written: make(map[ipfs.CID]struct{}),
+	}
+}
+
+func emptyCARNode() *carNode {
+	return &carNode{id: ipfs.SumCID(ipfs.CodecRaw, nil), block: []byte{}}
+}
+
+// node builds a DAG for a file or a directory.
+func (e *carExporter) node(ctx context.Context, ref Ref) (*carNode, error) {
+	if ref.Zero() || ref.Empty() {
+		return emptyCARNode(), nil
+	} else if n, ok := e.byRef[ref]; ok {
+		return n, nil
+	}
+	cref, obj, err := e.s.unwrap(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	var n *carNode
+	if storage.IsDirObject(obj) {
+		n, err = e.dir(ctx, obj)
+	} else {
+		n, err = e.file(ctx, cref, obj == nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.byRef[ref] = n
+	e.nodes[n.id.String()] = cref
+	return n, nil
+}
+
+func (e *carExporter) dir(ctx context.Context, obj schema.Object) (*carNode, error) {
+	n := &carNode{}
+	dn := &ipfs.Node{Data: (&ipfs.UnixFS{Type: ipfs.TypeDirectory}).Encode()}
+	err := storage.ReadDir(ctx, e.s.index, obj, func(ent *schema.DirEntry) error {
+		var sub *carNode
+		if ent.IsSymlink() {
+			u := &ipfs.UnixFS{Type: ipfs.TypeSymlink, Data: []byte(ent.Link)}
+			block := (&ipfs.Node{Data: u.Encode()}).Encode()
+			sub = &carNode{id: ipfs.SumCID(ipfs.CodecDagPB, block), block: block, tsize: uint64(len(block))}
+		} else {
+			var err error
+			if sub, err = e.node(ctx, ent.Ref); err != nil {
+				return err
+			}
+		}
+		dn.Links = append(dn.Links, ipfs.Link{CID: sub.id, Name: ent.Name, Size: sub.tsize})
+		n.subs = append(n.subs, sub)
+		n.tsize += sub.tsize
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	dn.SortLinks()
+	n.block = dn.Encode()
+	n.id = ipfs.SumCID(ipfs.CodecDagPB, n.block)
+	n.tsize += uint64(len(n.block))
+	return n, nil
+}
+
+// file builds a balanced DAG of a file content. The content is read to compute CIDs of leaves.
+func (e *carExporter) file(ctx context.Context, ref Ref, isBlob bool) (*carNode, error) {
+	if isBlob && ref.Name() == sha256Name {
+		// small blobs are raw blocks already
+		if size, err := e.s.StatBlob(ctx, ref); err == nil && size <= ipfs.ChunkSize {
+			id := ipfs.NewCID(ipfs.CodecRaw, ipfs.HashSHA256, ref.Data())
+			return &carNode{id: id, size: size, tsize: size, ref: ref}, nil
+		}
+	}
+	rc, _, err := e.s.openContent(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var nodes []*carNode
+	buf := make([]byte, ipfs.ChunkSize)
+	for {
+		n, err := io.ReadFull(rc, buf)
+		if n > 0 {
+			id := ipfs.SumCID(ipfs.CodecRaw, buf[:n])
+			nodes = append(nodes, &carNode{id: id, size: uint64(n), tsize: uint64(n)})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(nodes) == 0 {
+		return emptyCARNode(), nil
+	}
+	for len(nodes) > 1 {
+		var next []*carNode
+		for i := 0; i < len(nodes); i += ipfs.MaxLinks {
+			group := nodes[i:]
+			if len(group) > ipfs.MaxLinks {
+				group = group[:ipfs.MaxLinks]
+			}
+			p := &carNode{subs: group}
+			links := make([]ipfs.Link, 0, len(group))
+			sizes := make([]uint64, 0, len(group))
+			for _, c := range group {
+				links = append(links, ipfs.Link{CID: c.id, Size: c.tsize})
+				sizes = append(sizes, c.size)
+				p.size += c.size
+				p.tsize += c.tsize
+			}
+			p.block = ipfs.FileNode(links, sizes)
+			p.id = ipfs.SumCID(ipfs.CodecDagPB, p.block)
+			p.tsize += uint64(len(p.block))
+			next = append(next, p)
+		}
+		nodes = next
+	}
+	root := nodes[0]
+	root.ref = ref
+	return root, nil
+}
+
+// write writes blocks of the DAG, parents first. Content of file nodes is read from r.
+func (e *carExporter) write(ctx context.Context, n *carNode, r io.Reader) error {
+	if _, ok := e.written[n.id]; ok {
+		if r != nil {
+			// skip the same content inside the file
+			_, err := io.CopyN(ioutil.Discard, r, int64(n.size))
+			return err
+		}
+		return nil
+	}
+	e.written[n.id] = struct{}{}
+	if !n.ref.Zero() {
+		rc, _, err := e.s.openContent(ctx, n.ref)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		r = rc
+	}
+	if n.block == nil {
+		buf := make([]byte, n.size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		return e.w.WriteBlock(n.id, buf)
+	}
+	if err := e.w.WriteBlock(n.id, n.block); err != nil {
+		return err
+	}
+	for _, c := range n.subs {
+		if err := e.write(ctx, c, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CARRoot is a root of a DAG imported from a CAR file.
+type CARRoot struct {
+	CID ipfs.CID
+	Ref Ref
+}
+
+type carImporter struct {
+	s     *Storage
+	car   *ipfs.CAR
+	conf  *StoreConfig
+	nodes map[string]Ref // files and directories of the current DAG
+}
+
+// ImportCAR stores UnixFS DAGs from a CARv1 file as files and directory trees. It returns refs of the CAR roots.
+//
+// Files that were imported or exported previously are not stored again, as well as raw blocks that exist as blobs.
+// The mapping of CIDs to refs is stored for each root.
+func (s *Storage) ImportCAR(ctx context.Context, r io.ReaderAt, size int64, conf *StoreConfig) ([]CARRoot, error) {
+	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return nil, err
+	}
+	car, err := ipfs.OpenCAR(r, size)
+	if err != nil {
+		return nil, err
+	}
+	imp := &carImporter{s: s, car: car, conf: conf}
+	out := make([]CARRoot, 0, len(car.Roots))
+	for _, id := range car.Roots {
+		if ref, ok, err := s.lookupUnixFS(ctx, id); err != nil {
+			return nil, err
+		} else if ok {
+			out = append(out, CARRoot{CID: id, Ref: ref})
+			continue
+		}
+		imp.nodes = make(map[string]Ref)
+		ent, err := imp.entry(ctx, id)
+		if err != nil {
+			return nil, err
+		} else if ent.IsSymlink() {
+			return nil, fmt.Errorf("root %v is a symlink", id)
+		}
+		// blocks are verified against CIDs when they are read from the CAR file
+		err = s.storeUnixFS(ctx, &schema.UnixFS{CID: id.String(), Root: ent.Ref, Nodes: imp.nodes})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, CARRoot{CID: id, Ref: ent.Ref})
+	}
+	return out, nil
+}
+
+// node reads a dag-pb node with UnixFS data.
+func (imp *carImporter) node(id ipfs.CID) (*ipfs.Node, *ipfs.UnixFS, error) {
+	data, err := imp.car.Block(id)
+	if err == ipfs.ErrNotFound {
+		return nil, nil, fmt.Errorf("block %v is not in the CAR file", id)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	n, err := ipfs.DecodeNode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := ipfs.DecodeUnixFS(n.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return n, u, nil
+}
+
+// entry imports a file, a directory or a symlink. The name of the entry is not set.
+func (imp *carImporter) entry(ctx context.Context, id ipfs.CID) (schema.DirEntry, error) {
+	var u *ipfs.UnixFS
+	switch id.Codec() {
+	case ipfs.CodecRaw:
+	case ipfs.CodecDagPB:
+		n, nu, err := imp.node(id)
+		if err != nil {
+			return schema.DirEntry{}, err
+		}
+		u = nu
+		switch u.Type {
+		case ipfs.TypeDirectory, ipfs.TypeHAMTShard:
+			return imp.dir(ctx, id, n, u)
+		case ipfs.TypeSymlink:
+			return schema.DirEntry{Mode: schema.ModeSymlink | 0777, Link: string(u.Data)}, nil
+		case ipfs.TypeFile, ipfs.TypeRaw:
+		default:
+			return schema.DirEntry{}, fmt.Errorf("unsupported UnixFS node type %d in %v", u.Type, id)
+		}
+	default:
+		return schema.DirEntry{}, fmt.Errorf("unsupported codec 0x%x of %v", id.Codec(), id)
+	}
+	ent := schema.DirEntry{Mode: schema.ModeRegular | 0644}
+	if u != nil && u.Mode&0777 != 0 {
+		ent.Mode = schema.ModeRegular | u.Mode&schema.ModePerm
+	}
+	sr, err := imp.file(ctx, id, u)
+	if err != nil {
+		return schema.DirEntry{}, err
+	}
+	ent.Ref = sr.Ref
+	ent.Stats = Stats{schema.StatDataSize: sr.Size}
+	imp.nodes[id.String()] = sr.Ref
+	return ent, nil
+}
+
+// file stores the content of a file DAG, unless it's stored already.
+func (imp *carImporter) file(ctx context.Context, id ipfs.CID, u *ipfs.UnixFS) (SizedRef, error) {
+	if u == nil {
+		// raw blocks with SHA-256 hashes might be stored as blobs
+		if hash, digest := id.Hash(); hash == ipfs.HashSHA256 {
+			ref, err := types.MakeRef(sha256Name, digest)
+			if err == nil {
+				if size, err := imp.s.StatBlob(ctx, ref); err == nil {
+					return SizedRef{Ref: ref, Size: size}, nil
+				}
+			}
+		}
+	}
+	if ref, ok, err := imp.s.lookupUnixFS(ctx, id); err != nil {
+		return SizedRef{}, err
+	} else if ok {
+		if u != nil {
+			return SizedRef{Ref: ref, Size: u.FileSize}, nil
+		} else if size, err := imp.s.StatBlob(ctx, ref); err == nil {
+			return SizedRef{Ref: ref, Size: size}, nil
+		}
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(imp.writeFile(pw, id, 0))
+	}()
+	c := *imp.conf
+	c.Expect = SizedRef{}
+	sr, err := imp.s.StoreBlob(ctx, pr, &c)
+	pr.Close()
+	if err != nil {
+		return SizedRef{}, err
+	}
+	if sr.Ref.Zero() {
+		sr.Ref = emptyRef(ctx)
+	}
+	return sr, nil
+}
+
+// writeFile writes the content of a file DAG.
+func (imp *carImporter) writeFile(w io.Writer, id ipfs.CID, depth int) error {
+	if depth > 64 {
+		return fmt.Errorf("file DAG is too deep")
+	}
+	if id.Codec() == ipfs.CodecRaw {
+		data, err := imp.car.Block(id)
+		if err == ipfs.ErrNotFound {
+			return fmt.Errorf("block %v is not in the CAR file", id)
+		} else if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	} else if id.Codec() != ipfs.CodecDagPB {
+		return fmt.Errorf("unsupported codec 0x%x of %v", id.Codec(), id)
+	}
+	n, u, err := imp.node(id)
+	if err != nil {
+		return err
+	} else if u.Type != ipfs.TypeFile && u.Type != ipfs.TypeRaw {
+		return fmt.Errorf("expected a file node, got type %d in %v", u.Type, id)
+	}
+	if len(u.Data) != 0 {
+		if _, err = w.Write(u.Data); err != nil {
+			return err
+		}
+	}
+	for _, l := range n.Links {
+		if err = imp.writeFile(w, l.CID, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *carImporter) dir(ctx context.Context, id ipfs.CID, n *ipfs.Node, u *ipfs.UnixFS) (schema.DirEntry, error) {
+	var list []schema.DirEntry
+	if err := imp.dirEntries(ctx, n, u, &list, 0); err != nil {
+		return schema.DirEntry{}, err
+	}
+	sr, st, err := imp.s.storeDirEntries(ctx, list)
+	if err != nil {
+		return schema.DirEntry{}, err
+	}
+	ent := schema.DirEntry{Ref: sr.Ref, Mode: schema.ModeDir | 0755, Stats: st}
+	if u.Mode&0777 != 0 {
+		ent.Mode = schema.ModeDir | u.Mode&schema.ModePerm
+	}
+	imp.nodes[id.String()] = sr.Ref
+	return ent, nil
+}
+
+// dirEntries imports entries of a directory. Entries of sharded directories are collected from all shards.
+func (imp *carImporter) dirEntries(ctx context.Context, n *ipfs.Node, u *ipfs.UnixFS, list *[]schema.DirEntry, depth int) error {
+	if depth > 64 {
+		return fmt.Errorf("sharded directory is too deep")
+	}
+	prefix := 0
+	if u.Type == ipfs.TypeHAMTShard {
+		if u.Fanout < 2 || u.Fanout&(u.Fanout-1) != 0 {
+			return fmt.Errorf("invalid HAMT fanout: %d", u.Fanout)
+		}
+		// link names start with a hex index in the shard
+		prefix = len(fmt.Sprintf("%X", u.Fanout-1))
+	}
+	for _, l := range n.Links {
+		name := l.Name
+		if prefix != 0 {
+			if len(name) < prefix {
+				return fmt.Errorf("invalid HAMT link name: %q", name)
+			} else if len(name) == prefix {
+				sn, su, err := imp.node(l.CID)
+				if err != nil {
+					return err
+				} else if su.Type != ipfs.TypeHAMTShard {
+					return fmt.Errorf("expected a HAMT shard in %v", l.CID)
+				}
+				if err = imp.dirEntries(ctx, sn, su, list, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			name = name[prefix:]
+		}
+		if !validFileName(name) {
+			return fmt.Errorf("invalid file name: %q", name)
+		}
+		ent, err := imp.entry(ctx, l.CID)
+		if err != nil {
+			return err
+		}
+		ent.Name = name
+		*list = append(*list, ent)
+	}
+	return nil
+}
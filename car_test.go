@@ -0,0 +1,86 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+)
+
+func TestCAR(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	files := map[string]string{
+		"a.txt":       "a",
+		"empty.txt":   "",
+		"sub/a.txt":   "a",
+		"sub/big.bin": strings.Repeat("0123456789abcdef", 40000),
+		"sub/c/d.txt": "d",
+	}
+	ref := storeTestTree(t, s, files)
+
+	buf := bytes.NewBuffer(nil)
+	id, err := s.ExportCAR(ctx, ref, buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	buf = bytes.NewBuffer(nil)
+	id2, err := s.ExportCAR(ctx, ref, buf)
+	require.NoError(t, err)
+	require.Equal(t, id, id2)
+	require.Equal(t, data, buf.Bytes())
+
+	// same storage restores the original tree
+	roots, err := s.ImportCAR(ctx, bytes.NewReader(data), int64(len(data)), nil)
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	require.Equal(t, id, roots[0].CID)
+	require.Equal(t, ref, roots[0].Ref)
+
+	// the tree is rebuilt from blocks in a new storage
+	s2 := newTestStorage(t)
+	roots, err = s2.ImportCAR(ctx, bytes.NewReader(data), int64(len(data)), nil)
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	require.Equal(t, id, roots[0].CID)
+	require.Equal(t, files, readTestTree(t, s2, roots[0].Ref))
+
+	buf = bytes.NewBuffer(nil)
+	id2, err = s2.ExportCAR(ctx, roots[0].Ref, buf)
+	require.NoError(t, err)
+	require.Equal(t, id, id2)
+
+	// mappings that are not indexed are ignored, and reindex only restores verified ones
+	s3 := newTestStorage(t)
+	forged := storeTestTree(t, s3, map[string]string{"a.txt": "forged"})
+	_, err = s3.StoreSchema(ctx, &schema.UnixFS{CID: id.String(), Root: forged})
+	require.NoError(t, err)
+	require.NoError(t, s3.ReindexSchema(ctx, false))
+	roots, err = s3.ImportCAR(ctx, bytes.NewReader(data), int64(len(data)), nil)
+	require.NoError(t, err)
+	require.Equal(t, files, readTestTree(t, s3, roots[0].Ref))
+
+	err = s.st.DeletePin(ctx, indexPin(unixfsIndex, id.String()))
+	require.NoError(t, err)
+	_, ok, err := s.lookupUnixFS(ctx, id)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, s.ReindexSchema(ctx, false))
+	got, ok, err := s.lookupUnixFS(ctx, id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, ref, got)
+
+	// corrupted content is detected
+	bad := append([]byte{}, data...)
+	i := bytes.Index(bad, []byte("0123456789abcdef"))
+	require.True(t, i > 0)
+	bad[i] = 'x'
+	_, err = newTestStorage(t).ImportCAR(ctx, bytes.NewReader(bad), int64(len(bad)), nil)
+	require.Error(t, err)
+}
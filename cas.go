@@ -7,6 +7,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
 
 	"github.com/dennwc/cas/config"
 	"github.com/dennwc/cas/storage"
@@ -104,7 +107,24 @@ func Open(opt OpenOptions) (*Storage, error) {
 	if err != nil {
 		return nil, err
 	}
-	return New(s)
+	cs, err := New(s)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err = cs.SetHash(conf.Hash); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if len(conf.TrustedKeys) != 0 || conf.RequireSigned {
+		keys, err := ParseAuthorizedKeys([]byte(strings.Join(conf.TrustedKeys, "\n")))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		cs.SetTrustedKeys(keys, conf.RequireSigned)
+	}
+	return cs, nil
 }
 
 func New(st storage.Storage) (*Storage, error) {
@@ -122,8 +142,42 @@ var (
 type Storage struct {
 	st    storage.Storage
 	index storage.BlobIndexer
+	hash  string // hash algorithm for new blobs; empty means default
+
+	trusted       map[string]ssh.PublicKey // trusted keys by fingerprint
+	requireSigned bool                     // refuse to checkout unsigned roots
 }
 
+// SetHash sets a hash algorithm that will be used for new blobs, unless StoreConfig specifies a different one.
+// Empty name resets the algorithm to the default one.
+func (s *Storage) SetHash(name string) error {
+	if name != "" {
+		if _, err := types.NewRefWith(name); err != nil {
+			return err
+		}
+	}
+	s.hash = name
+	return nil
+}
+
+// hashContext selects a hash algorithm for new blobs. The name overrides an algorithm that was
+// selected previously. If it's empty, an algorithm from the context or the storage default is used.
+func (s *Storage) hashContext(ctx context.Context, name string) (context.Context, error) {
+	if name == "" {
+		if _, ok := ctx.Value(hashCtxCheck{}).(struct{}); ok || s.hash == "" {
+			return ctx, nil
+		}
+		name = s.hash
+	} else if _, err := types.NewRefWith(name); err != nil {
+		return ctx, err
+	}
+	ctx = storage.WithHash(ctx, name)
+	return context.WithValue(ctx, hashCtxCheck{}, struct{}{}), nil
+}
+
+// hashCtxCheck is set when the hash algorithm was selected explicitly.
+type hashCtxCheck struct{}
+
 func (s *Storage) Close() error {
 	return s.st.Close()
 }
@@ -131,6 +185,8 @@ func (s *Storage) Close() error {
 func (s *Storage) SetPin(ctx context.Context, name string, ref types.Ref) error {
 	if name == "" {
 		name = DefaultPin
+	} else if err := checkUserPin(name); err != nil {
+		return err
 	}
 	return s.st.SetPin(ctx, name, ref)
 }
@@ -138,6 +194,8 @@ func (s *Storage) SetPin(ctx context.Context, name string, ref types.Ref) error
 func (s *Storage) DeletePin(ctx context.Context, name string) error {
 	if name == "" {
 		name = DefaultPin
+	} else if err := checkUserPin(name); err != nil {
+		return err
 	}
 	return s.st.DeletePin(ctx, name)
 }
@@ -149,36 +207,93 @@ func (s *Storage) GetPin(ctx context.Context, name string) (types.Ref, error) {
 	return s.st.GetPin(ctx, name)
 }
 
+// GetPinOrRef resolves a pin name or a ref. Both may be followed by ancestry suffixes, for example "root~3" or "root^2".
 func (s *Storage) GetPinOrRef(ctx context.Context, name string) (types.Ref, error) {
+	rev := ""
+	if i := strings.IndexAny(name, "~^"); i >= 0 {
+		// pins created before ancestry suffixes were introduced may contain these characters
+		if ref, err := s.GetPin(ctx, name); err == nil {
+			return ref, nil
+		}
+		name, rev = name[:i], name[i:]
+	}
+	var (
+		ref types.Ref
+		err error
+	)
 	if !types.IsRef(name) {
-		return s.GetPin(ctx, name)
+		ref, err = s.GetPin(ctx, name)
+	} else {
+		ref, err = s.ResolveRef(ctx, name)
+	}
+	if err != nil || rev == "" {
+		return ref, err
 	}
-	return types.ParseRef(name)
+	return s.resolveAncestry(ctx, ref, rev)
 }
 
+// ResolveRef parses a ref in any text form. Abbreviated refs are resolved by searching for a matching blob.
+// It returns storage.ErrAmbiguousRef if the prefix matches more than one blob.
+func (s *Storage) ResolveRef(ctx context.Context, str string) (types.Ref, error) {
+	if ref, err := types.ParseRef(str); err == nil {
+		return ref, nil
+	}
+	pref, err := types.ParseRefPrefix(str)
+	if err != nil {
+		return types.Ref{}, err
+	}
+	return storage.ResolvePrefix(ctx, s.st, pref)
+}
+
+// IteratePins lists all pins, except the ones used internally for indexing.
 func (s *Storage) IteratePins(ctx context.Context) storage.PinIterator {
-	return s.st.IteratePins(ctx)
+	return storage.HideIndexPins(s.st.IteratePins(ctx))
+}
+
+// IteratePinsByPrefix lists pins with names that start with a given prefix, for example "datasets/".
+func (s *Storage) IteratePinsByPrefix(ctx context.Context, prefix string) storage.PinIterator {
+	return storage.HideIndexPins(storage.IteratePinsByPrefix(ctx, s.st, prefix))
 }
 
 func (s *Storage) FetchBlob(ctx context.Context, ref Ref) (io.ReadCloser, uint64, error) {
 	if ref.Empty() {
 		// generate empty blobs
 		return ioutil.NopCloser(bytes.NewReader(nil)), 0, nil
+	} else if !canAddress(ref) {
+		return s.openAlias(ctx, ref)
 	}
 	rc, sz, err := s.st.FetchBlob(ctx, ref)
 	if err == nil {
 		rc = storage.VerifyReader(rc, ref)
+		return rc, sz, nil
+	} else if err != storage.ErrNotFound {
+		return nil, 0, err
 	}
-	return rc, sz, err
+	// the ref might be computed with a different hash
+	return s.openAlias(ctx, ref)
 }
 
 func (s *Storage) IterateBlobs(ctx context.Context) storage.Iterator {
 	return s.st.IterateBlobs(ctx)
 }
 
+// canAddress checks if the ref can address blobs. Other refs, for example md5 or Git object ids, can only be resolved
+// with aliases, even if the storage has a blob with this name.
+func canAddress(ref Ref) bool {
+	f := types.GetHash(ref.Name())
+	return f != nil && f.CanAddress()
+}
+
 func (s *Storage) StatBlob(ctx context.Context, ref Ref) (uint64, error) {
 	if ref.Empty() {
 		return 0, nil
+	} else if !canAddress(ref) {
+		return s.statAlias(ctx, ref)
+	}
+	sz, err := s.st.StatBlob(ctx, ref)
+	if err != storage.ErrNotFound {
+		return sz, err
 	}
-	return s.st.StatBlob(ctx, ref)
+	// the ref might be computed with a different hash
+	return s.statAlias(ctx, ref)
 }
@@ -1,12 +1,14 @@
 package cas
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/dennwc/cas/schema"
 	"github.com/dennwc/cas/storage"
@@ -14,7 +16,11 @@ import (
 )
 
 // Checkout restores content of ref into the dst.
+// If the storage requires signed content, the ref must have a valid signature made by a trusted key.
 func (s *Storage) Checkout(ctx context.Context, ref Ref, dst string) error {
+	if err := s.checkSigned(ctx, "checkout", ref); err != nil {
+		return err
+	}
 	if _, err := os.Stat(dst); err == nil {
 		return fmt.Errorf("path already exists")
 	} else if !os.IsNotExist(err) {
@@ -31,8 +37,10 @@ func (s *Storage) checkoutBlobData(ctx context.Context, r io.Reader, sr SizedRef
 	defer f.Close()
 	var h hash.Hash
 	if !sr.Ref.Zero() {
-		h = sr.Ref.Hash()
-		r = io.TeeReader(r, h)
+		// some hashes cannot be computed directly; skip verification for them
+		if h = sr.Ref.Hash(); h != nil {
+			r = io.TeeReader(r, h)
+		}
 	}
 	if sr.Size != 0 {
 		_, err = io.CopyN(f, r, int64(sr.Size))
@@ -67,7 +75,16 @@ func (s *Storage) checkoutBlobData(ctx context.Context, r io.Reader, sr SizedRef
 
 func (s *Storage) checkoutBlob(ctx context.Context, ref Ref, dst string) error {
 	rc, sz, err := s.FetchBlob(ctx, ref)
-	if err != nil {
+	if err == storage.ErrNotFound {
+		// compressed file might be stored in a decompressed form
+		c, err2 := s.findCompressed(ctx, ref)
+		if err2 != nil {
+			return err2
+		} else if c != nil {
+			return s.checkoutCompressed(ctx, c, true, dst)
+		}
+		return err
+	} else if err != nil {
 		return err
 	}
 	defer rc.Close()
@@ -218,16 +235,15 @@ func (s *Storage) checkoutDir(ctx context.Context, ref Ref, obj schema.Object, d
 			if !ok {
 				return fmt.Errorf("expected dir entry, got: %T", e)
 			}
-			spath := filepath.Join(dst, ent.Name)
-			sub, err := s.DecodeSchema(ctx, ent.Ref)
-			if err == nil {
-				// schema object - sub directory, or schema blob
-				err = s.checkoutObject(ctx, ent.Ref, sub, spath)
-			} else if err == schema.ErrNotSchema {
-				// file blob
-				err = s.checkoutBlob(ctx, ent.Ref, spath)
+			// entries of imported trees might be crafted to write outside of the directory
+			if !validFileName(ent.Name) {
+				return fmt.Errorf("invalid file name in %v: %q", ref, ent.Name)
 			}
-			if err != nil {
+			path := filepath.Join(dst, ent.Name)
+			if _, err := os.Lstat(path); err == nil {
+				return fmt.Errorf("duplicate file name in %v: %q", ref, ent.Name)
+			}
+			if err := s.checkoutEntry(ctx, ent, path); err != nil {
 				return err
 			}
 		}
@@ -256,9 +272,30 @@ func (s *Storage) checkoutDir(ctx context.Context, ref Ref, obj schema.Object, d
 	}
 }
 
+func (s *Storage) checkoutEntry(ctx context.Context, ent *schema.DirEntry, dst string) error {
+	if ent.IsSymlink() {
+		return os.Symlink(ent.Link, dst)
+	}
+	var err error
+	if ent.Ref.Zero() {
+		// empty file
+		err = s.checkoutBlobData(ctx, bytes.NewReader(nil), SizedRef{}, dst)
+	} else {
+		err = s.checkoutFileOrDir(ctx, ent.Ref, dst)
+	}
+	if err != nil {
+		return err
+	}
+	if mode := ent.FileMode(); mode&os.ModePerm != 0 {
+		err = os.Chmod(dst, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+	}
+	return err
+}
+
 func (s *Storage) checkoutFileOrDir(ctx context.Context, ref Ref, dst string) error {
 	obj, err := s.DecodeSchema(ctx, ref)
-	if err == schema.ErrNotSchema {
+	if err == schema.ErrNotSchema || err == storage.ErrNotFound {
+		// missing blobs might be restored from other forms
 		return s.checkoutBlob(ctx, ref, dst)
 	} else if err != nil {
 		return err
@@ -286,10 +323,19 @@ func (s *Storage) checkoutObject(ctx context.Context, oref Ref, obj schema.Objec
 		default:
 			return fmt.Errorf("unsupported list element: %q", obj.Elem)
 		}
+	case *schema.Archive:
+		return s.checkoutArchive(ctx, obj, dst)
+	case *schema.Compressed:
+		c, err := codecByAlgo(obj.Algo)
+		if err != nil {
+			return err
+		}
+		// restore the archive only if the user asked for it explicitly
+		arch := strings.EqualFold(filepath.Ext(dst), c.ext)
+		return s.checkoutCompressed(ctx, obj, arch, dst)
 	case schema.BlobWrapper:
-		// unwrap blob
-		// TODO: might require recursion
-		return s.checkoutBlob(ctx, obj.DataBlob(), dst)
+		// unwrap blob; it may point to other schema objects
+		return s.checkoutFileOrDir(ctx, obj.DataBlob(), dst)
 	default:
 		// unknown schema blob - store as json
 		return s.checkoutBlob(ctx, oref, dst)
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/storage"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "car",
+		Short: "commands related to IPFS CAR files",
+	}
+	Root.AddCommand(cmd)
+
+	exportCmd := &cobra.Command{
+		Use:   "export <pin|ref>",
+		Short: "write a directory tree or a file as a UnixFS DAG in a CAR file",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected 1 argument")
+			}
+			out, _ := flags.GetString("out")
+
+			ref, err := s.GetPinOrRef(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			f := os.Stdout
+			if out != "" && out != "-" {
+				f, err = os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+			}
+			w := bufio.NewWriter(f)
+			id, err := s.ExportCAR(ctx, ref, w)
+			if err != nil {
+				return err
+			} else if err = w.Flush(); err != nil {
+				return err
+			}
+			if f == os.Stdout {
+				fmt.Fprintln(os.Stderr, id)
+				return nil
+			}
+			fmt.Println(id, out)
+			return f.Close()
+		}),
+	}
+	exportCmd.Flags().StringP("out", "o", "", "output file; defaults to stdout")
+	cmd.AddCommand(exportCmd)
+
+	importCmd := &cobra.Command{
+		Use:   "import <file.car>",
+		Short: "store UnixFS DAGs from a CAR file as directory trees",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected 1 argument")
+			}
+			pin, _ := flags.GetString("pin")
+
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			st, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			roots, err := s.ImportCAR(ctx, f, st.Size(), storeConfigFromFlags(flags))
+			if err != nil {
+				return err
+			}
+			for _, r := range roots {
+				fmt.Println(r.Ref, r.CID)
+				if pin == "" {
+					continue
+				}
+				name := pin
+				if len(roots) > 1 {
+					name += storage.PinSeparator + r.CID.String()
+				}
+				if err = s.SetPin(ctx, name, r.Ref); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+	importCmd.Flags().StringP("pin", "p", "", "pin the tree with a given name; the root CID is appended if there are multiple roots")
+	registerStoreConfFlags(importCmd.Flags())
+	cmd.AddCommand(importCmd)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/schema"
+)
+
+func init() {
+	commitCmd := &cobra.Command{
+		Use:   "commit [pin] path",
+		Short: "snapshot the path and record it in the history of a pin",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) == 0 || len(args) > 2 {
+				return fmt.Errorf("expected 1 or 2 arguments")
+			}
+			name, path := cas.DefaultPin, args[0]
+			if len(args) == 2 {
+				name, path = args[0], args[1]
+			}
+			conf := storeConfigFromFlags(flags)
+			tree, err := s.StoreAddr(ctx, path, conf)
+			if err != nil {
+				return err
+			}
+			c := schema.Commit{Tree: tree.Ref, Author: authorFromFlags(flags)}
+			c.Message, _ = flags.GetString("message")
+			sr, err := s.CommitPin(ctx, name, c)
+			if err != nil {
+				return err
+			}
+			fmt.Println(name, "=", sr.Ref)
+			return nil
+		}),
+	}
+	registerStoreConfFlags(commitCmd.Flags())
+	commitCmd.Flags().StringP("message", "m", "", "commit message")
+	commitCmd.Flags().String("author", "", "author of the commit; current user is used by default")
+	Root.AddCommand(commitCmd)
+
+	logCmd := &cobra.Command{
+		Use:   "log [pin]",
+		Short: "show the history of a pin",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("expected 0 or 1 arguments")
+			}
+			name := cas.DefaultPin
+			if len(args) != 0 {
+				name = args[0]
+			}
+			ref, err := s.GetPinOrRef(ctx, name)
+			if err != nil {
+				return err
+			}
+			limit, _ := flags.GetInt("limit")
+			oneline, _ := flags.GetBool("oneline")
+			n := 0
+			errStop := fmt.Errorf("stop")
+			err = s.Log(ctx, ref, func(ref cas.Ref, c *schema.Commit) error {
+				if limit > 0 && n >= limit {
+					return errStop
+				}
+				n++
+				if oneline {
+					msg := c.Message
+					if i := strings.Index(msg, "\n"); i >= 0 {
+						msg = msg[:i]
+					}
+					fmt.Println(ref, msg)
+					return nil
+				}
+				fmt.Println("commit", ref)
+				if len(c.Parents) > 1 {
+					parents := make([]string, 0, len(c.Parents))
+					for _, p := range c.Parents {
+						parents = append(parents, p.String())
+					}
+					fmt.Println("Merge: ", strings.Join(parents, " "))
+				}
+				fmt.Println("Tree:  ", c.Tree)
+				if c.Author != "" {
+					fmt.Println("Author:", c.Author)
+				}
+				if c.Time != nil {
+					fmt.Println("Date:  ", c.Time.Local().Format(time.RFC1123Z))
+				}
+				fmt.Println()
+				for _, line := range strings.Split(c.Message, "\n") {
+					fmt.Println("    " + line)
+				}
+				fmt.Println()
+				return nil
+			})
+			if err == errStop {
+				err = nil
+			}
+			return err
+		}),
+	}
+	logCmd.Flags().IntP("limit", "n", 0, "limit the number of commits")
+	logCmd.Flags().Bool("oneline", false, "print one commit per line")
+	Root.AddCommand(logCmd)
+}
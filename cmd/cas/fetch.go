@@ -14,11 +14,17 @@ func registerStoreConfFlags(flags *pflag.FlagSet) {
 	flags.BoolP("index", "i", false, "index only; do not store content blobs")
 	flags.Bool("split", false, "split content blobs")
 	flags.Uint64("max", 0, "max size of chunks while splitting")
+	flags.Bool("decompress", false, "store decompressed content of compressed files instead of the original")
+	flags.String("hash", "", "hash algorithm for new blobs; overrides the storage default")
+	flags.StringSlice("extra-hash", nil, "record additional refs of the content (md5, sha1, git-sha1, ...)")
 }
 
 func storeConfigFromFlags(flags *pflag.FlagSet) *cas.StoreConfig {
 	conf := &cas.StoreConfig{}
 	conf.IndexOnly, _ = flags.GetBool("index")
+	conf.Decompress, _ = flags.GetBool("decompress")
+	conf.Hash, _ = flags.GetString("hash")
+	conf.ExtraHashes, _ = flags.GetStringSlice("extra-hash")
 	if split, _ := flags.GetBool("split"); split {
 		conf.Split = &cas.SplitConfig{}
 		conf.Split.Max, _ = flags.GetUint64("max")
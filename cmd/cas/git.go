@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/git"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "git",
+		Short: "commands related to Git repositories",
+	}
+	Root.AddCommand(cmd)
+
+	importCmd := &cobra.Command{
+		Use:   "import <repo> [rev]",
+		Short: "store the tree of a commit from a local Git repository",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 1 && len(args) != 2 {
+				return fmt.Errorf("expected 1 or 2 arguments")
+			}
+			rev := "HEAD"
+			if len(args) == 2 {
+				rev = args[1]
+			}
+			pin, _ := flags.GetString("pin")
+
+			r, err := git.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			id, err := r.Resolve(rev)
+			if err == git.ErrNotFound {
+				return fmt.Errorf("unknown revision: %q", rev)
+			} else if err != nil {
+				return err
+			}
+			sr, err := s.ImportGit(ctx, r, id, storeConfigFromFlags(flags))
+			if err != nil {
+				return err
+			}
+			fmt.Println(sr.Ref, id)
+			if pin != "" {
+				return s.SetPin(ctx, pin, sr.Ref)
+			}
+			return nil
+		}),
+	}
+	importCmd.Flags().StringP("pin", "p", "", "pin the tree with a given name")
+	registerStoreConfFlags(importCmd.Flags())
+	cmd.AddCommand(importCmd)
+}
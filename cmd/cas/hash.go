@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/storage"
 	"github.com/dennwc/cas/types"
 )
 
@@ -22,6 +23,14 @@ func init() {
 			force, _ := cmd.Flags().GetBool("force")
 			ctx := cmdCtx
 			ref := types.NewRef()
+			if name, _ := cmd.Flags().GetString("hash"); name != "" {
+				var err error
+				ref, err = types.NewRefWith(name)
+				if err != nil {
+					return err
+				}
+				ctx = storage.WithHash(ctx, name)
+			}
 			h := ref.Hash()
 			if len(args) == 0 {
 				_, err := io.Copy(h, os.Stdin)
@@ -74,5 +83,6 @@ func init() {
 		},
 	}
 	hashCmd.Flags().BoolP("force", "f", false, "ignore refs cache")
+	hashCmd.Flags().String("hash", "", "hash algorithm to use")
 	Root.AddCommand(hashCmd)
 }
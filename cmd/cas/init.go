@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -15,6 +16,9 @@ import (
 	"github.com/dennwc/cas/storage/gcs"
 	"github.com/dennwc/cas/storage/http"
 	"github.com/dennwc/cas/storage/local"
+	"github.com/dennwc/cas/storage/perkeep"
+	"github.com/dennwc/cas/storage/reapi"
+	"github.com/dennwc/cas/types"
 )
 
 const casDir = cas.DefaultDir
@@ -23,11 +27,17 @@ type casInitE func(ctx context.Context, flags *pflag.FlagSet, args []string) (st
 
 func casInitCmd(fnc casInitE) cobraRunE {
 	return func(cmd *cobra.Command, args []string) error {
+		hash, _ := cmd.Flags().GetString("hash")
+		if hash != "" {
+			if _, err := types.NewRefWith(hash); err != nil {
+				return err
+			}
+		}
 		sconf, err := fnc(cmdCtx, cmd.Flags(), args)
 		if err != nil {
 			return err
 		}
-		return cas.Init(casDir, &config.Config{Storage: sconf})
+		return cas.Init(casDir, &config.Config{Storage: sconf, Hash: hash})
 	}
 }
 
@@ -35,17 +45,27 @@ func init() {
 	cmd := &cobra.Command{
 		Use:   "init",
 		Short: "init content-addressable storage in current directory",
-		RunE: casInitCmd(func(ctx context.Context, _ *pflag.FlagSet, args []string) (storage.Config, error) {
-			return &local.Config{Dir: "."}, nil
+		RunE: casInitCmd(func(ctx context.Context, flags *pflag.FlagSet, args []string) (storage.Config, error) {
+			compress, _ := flags.GetString("compress")
+			switch compress {
+			case "", local.CompressGzip, local.CompressZstd:
+			case local.CompressNone:
+				compress = ""
+			default:
+				return nil, fmt.Errorf("unsupported compression: %q", compress)
+			}
+			return &local.Config{Dir: ".", Compress: compress}, nil
 		}),
 	}
+	cmd.Flags().String("compress", "", "compress blobs at rest (zstd, gzip or none)")
+	cmd.PersistentFlags().String("hash", "", "hash algorithm for new blobs (sha256, blake3, sha512-256, ...)")
 	Root.AddCommand(cmd)
 
 	initHTTPCmd := &cobra.Command{
 		Use:     "http",
 		Aliases: []string{"remote", "client"},
 		Short:   "init a client to a remote content-addressable storage",
-		RunE: casInitCmd(func(ctx context.Context, _ *pflag.FlagSet, args []string) (storage.Config, error) {
+		RunE: casInitCmd(func(ctx context.Context, flags *pflag.FlagSet, args []string) (storage.Config, error) {
 			if len(args) != 1 {
 				return nil, fmt.Errorf("expected a URL of the server")
 			}
@@ -54,11 +74,72 @@ func init() {
 			if err != nil {
 				return nil, err
 			}
-			return &httpstor.Config{URL: addr}, nil
+			conf := &httpstor.Config{URL: addr}
+			conf.TokenFile, _ = flags.GetString("token-file")
+			conf.CACert, _ = flags.GetString("ca-cert")
+			for _, p := range []*string{&conf.TokenFile, &conf.CACert} {
+				if *p != "" {
+					if *p, err = filepath.Abs(*p); err != nil {
+						return nil, err
+					}
+				}
+			}
+			return conf, nil
 		}),
 	}
+	initHTTPCmd.Flags().String("token-file", "", "file with a bearer token for the server")
+	initHTTPCmd.Flags().String("ca-cert", "", "PEM file with CA certificates of the server")
 	cmd.AddCommand(initHTTPCmd)
 
+	initREAPICmd := &cobra.Command{
+		Use:     "reapi",
+		Aliases: []string{"grpc", "bazel"},
+		Short:   "init a client to a Remote Execution API (Bazel remote cache) server",
+		RunE: casInitCmd(func(ctx context.Context, flags *pflag.FlagSet, args []string) (storage.Config, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("expected an address of the server")
+			}
+			conf := &reapi.Config{Addr: args[0]}
+			conf.Instance, _ = flags.GetString("instance")
+			conf.Insecure, _ = flags.GetBool("insecure")
+			if ca, _ := flags.GetString("ca-cert"); ca != "" {
+				var err error
+				if conf.CACert, err = filepath.Abs(ca); err != nil {
+					return nil, err
+				}
+			}
+			return conf, nil
+		}),
+	}
+	initREAPICmd.Flags().String("instance", "", "REAPI instance name")
+	initREAPICmd.Flags().Bool("insecure", false, "do not use TLS")
+	initREAPICmd.Flags().String("ca-cert", "", "PEM file with CA certificates of the server")
+	cmd.AddCommand(initREAPICmd)
+
+	initPerkeepCmd := &cobra.Command{
+		Use:     "perkeep",
+		Aliases: []string{"camlistore"},
+		Short:   "init a client to a Perkeep blob server",
+		RunE: casInitCmd(func(ctx context.Context, flags *pflag.FlagSet, args []string) (storage.Config, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("expected a URL of the blob server")
+			}
+			conf := &perkeep.Config{URL: args[0]}
+			if _, err := url.Parse(conf.URL); err != nil {
+				return nil, err
+			}
+			if ca, _ := flags.GetString("ca-cert"); ca != "" {
+				var err error
+				if conf.CACert, err = filepath.Abs(ca); err != nil {
+					return nil, err
+				}
+			}
+			return conf, nil
+		}),
+	}
+	initPerkeepCmd.Flags().String("ca-cert", "", "PEM file with CA certificates of the server")
+	cmd.AddCommand(initPerkeepCmd)
+
 	initGCSCmd := &cobra.Command{
 		Use:     "gcs",
 		Aliases: []string{"google", "gs"},
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/schema"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "merge pin other",
+		Short: "merge the history of other pin or commit into a pin",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("expected 2 arguments")
+			}
+			name := args[0]
+			var refs [2]cas.Ref
+			for i, arg := range args {
+				ref, err := s.GetPinOrRef(ctx, arg)
+				if err != nil {
+					return err
+				}
+				// pin might point to an annotated value
+				refs[i], err = s.CommitRef(ctx, ref)
+				if err != nil {
+					return err
+				} else if refs[i].Zero() {
+					return fmt.Errorf("%s is not a commit", arg)
+				}
+			}
+			ours, theirs := refs[0], refs[1]
+			oc, err := s.GetCommit(ctx, ours)
+			if err != nil {
+				return err
+			}
+			tc, err := s.GetCommit(ctx, theirs)
+			if err != nil {
+				return err
+			}
+			base, err := s.MergeBase(ctx, ours, theirs)
+			if err != nil {
+				return err
+			}
+			var btree cas.Ref
+			if !base.Zero() {
+				bc, err := s.GetCommit(ctx, base)
+				if err != nil {
+					return err
+				}
+				btree = bc.Tree
+				if base == theirs || btree == tc.Tree {
+					fmt.Println("already up to date")
+					return nil
+				} else if base == ours {
+					// fast-forward
+					if err = s.SetPin(ctx, name, theirs); err != nil {
+						return err
+					}
+					fmt.Println(name, "=", theirs)
+					return nil
+				}
+			}
+			useTheirs, _ := flags.GetBool("theirs")
+			useOurs, _ := flags.GetBool("ours")
+			var (
+				tree      cas.SizedRef
+				conflicts []cas.MergeConflict
+			)
+			if useTheirs {
+				tree, conflicts, err = s.MergeTrees(ctx, btree, tc.Tree, oc.Tree)
+			} else {
+				tree, conflicts, err = s.MergeTrees(ctx, btree, oc.Tree, tc.Tree)
+			}
+			if err != nil {
+				return err
+			}
+			for _, c := range conflicts {
+				fmt.Println("conflict:", c.Path)
+			}
+			if len(conflicts) != 0 && !useOurs && !useTheirs {
+				return fmt.Errorf("merge failed: %d conflicts", len(conflicts))
+			}
+			c := schema.Commit{
+				Tree: tree.Ref, Parents: []cas.Ref{ours, theirs},
+				Author: authorFromFlags(flags),
+			}
+			c.Message, _ = flags.GetString("message")
+			if c.Message == "" {
+				c.Message = fmt.Sprintf("Merge %s into %s", args[1], name)
+			}
+			sr, err := s.CommitPin(ctx, name, c)
+			if err != nil {
+				return err
+			}
+			fmt.Println(name, "=", sr.Ref)
+			return nil
+		}),
+	}
+	cmd.Flags().StringP("message", "m", "", "commit message")
+	cmd.Flags().String("author", "", "author of the commit; current user is used by default")
+	cmd.Flags().Bool("ours", false, "resolve conflicts by using our version")
+	cmd.Flags().Bool("theirs", false, "resolve conflicts by using their version")
+	Root.AddCommand(cmd)
+}
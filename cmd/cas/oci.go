@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:     "oci",
+		Aliases: []string{"docker"},
+		Short:   "commands related to OCI container images",
+	}
+	Root.AddCommand(cmd)
+
+	importCmd := &cobra.Command{
+		Use:   "import <oci-layout-dir|tar|->",
+		Short: "store blobs of an OCI image layout under their digests",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected 1 argument")
+			}
+			pin, _ := flags.GetString("pin")
+			unpack, _ := flags.GetBool("unpack")
+
+			var (
+				sr  types.SizedRef
+				err error
+			)
+			if fi, err2 := os.Stat(args[0]); err2 == nil && fi.IsDir() {
+				sr, err = s.ImportOCIDir(ctx, args[0])
+			} else {
+				var r io.Reader = os.Stdin
+				if args[0] != "-" {
+					f, err := os.Open(args[0])
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					r = f
+				}
+				sr, err = s.ImportOCITar(ctx, r)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Println(sr.Ref, args[0])
+			if pin != "" {
+				if err = s.SetPin(ctx, pin, sr.Ref); err != nil {
+					return err
+				}
+			}
+			if !unpack {
+				return nil
+			}
+			images, err := s.OCIImages(ctx, sr.Ref)
+			if err != nil {
+				return err
+			}
+			conf := storeConfigFromFlags(flags)
+			for _, img := range images {
+				root, err := s.UnpackOCIImage(ctx, img.Manifest.Ref, conf)
+				if err != nil {
+					return err
+				}
+				name := img.Platform
+				if name == "" {
+					name = img.Manifest.Ref.String()
+				}
+				fmt.Println(root.Ref, name)
+				if pin == "" {
+					continue
+				}
+				// the only image is pinned as "<pin>.rootfs", others get a platform suffix
+				rpin := pin + ".rootfs"
+				if len(images) > 1 {
+					rpin += storage.PinSeparator + strings.ReplaceAll(name, "/", "-")
+				}
+				if err = s.SetPin(ctx, rpin, root.Ref); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+	importCmd.Flags().StringP("pin", "p", "", "pin the index with a given name; use \"<repo>:<tag>\" to serve it with \"cas serve\"")
+	importCmd.Flags().BoolP("unpack", "u", false, "unpack layers of each image into a directory tree")
+	registerStoreConfFlags(importCmd.Flags())
+	cmd.AddCommand(importCmd)
+
+	exportCmd := &cobra.Command{
+		Use:   "export <pin|ref>",
+		Short: "write an OCI image layout for a stored index or image manifest",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected 1 argument")
+			}
+			out, _ := flags.GetString("out")
+			asTar, _ := flags.GetBool("tar")
+
+			ref, err := s.GetPinOrRef(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			if !asTar && out != "" && out != "-" && !strings.HasSuffix(out, ".tar") {
+				return s.ExportOCIDir(ctx, ref, out)
+			}
+			if out != "" && out != "-" {
+				f, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				if err = s.ExportOCITar(ctx, ref, f); err != nil {
+					return err
+				}
+				return f.Close()
+			}
+			return s.ExportOCITar(ctx, ref, os.Stdout)
+		}),
+	}
+	exportCmd.Flags().StringP("out", "o", "", "output directory or a tar file; tar is written to stdout if not set")
+	exportCmd.Flags().Bool("tar", false, "write a tar archive even if the output name has no .tar extension")
+	cmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/storage/perkeep"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "perkeep",
+		Short: "commands related to Perkeep",
+	}
+	Root.AddCommand(cmd)
+
+	importCmd := &cobra.Command{
+		Use:   "import <url> <blobref>",
+		Short: "store a file, directory or static set from a Perkeep blob server",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("expected 2 arguments")
+			}
+			pin, _ := flags.GetString("pin")
+
+			conf := &perkeep.Config{URL: args[0]}
+			conf.CACert, _ = flags.GetString("ca-cert")
+			src, err := conf.OpenStorage(ctx)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			ref, err := perkeep.ParseBlobRef(args[1])
+			if err != nil {
+				return err
+			}
+			sr, err := s.ImportPerkeep(ctx, src, ref, storeConfigFromFlags(flags))
+			if err != nil {
+				return err
+			}
+			fmt.Println(sr.Ref, args[1])
+			if pin != "" {
+				return s.SetPin(ctx, pin, sr.Ref)
+			}
+			return nil
+		}),
+	}
+	importCmd.Flags().StringP("pin", "p", "", "pin the imported tree with a given name")
+	importCmd.Flags().String("ca-cert", "", "PEM file with CA certificates of the server")
+	registerStoreConfFlags(importCmd.Flags())
+	cmd.AddCommand(importCmd)
+}
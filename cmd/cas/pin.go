@@ -3,14 +3,52 @@ package main
 import (
 	"context"
 	"fmt"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
 	"github.com/dennwc/cas"
-	"github.com/dennwc/cas/types"
+	"github.com/dennwc/cas/schema"
 )
 
+// authorFromFlags returns an author name set by the flag, or the name of the current user.
+func authorFromFlags(flags *pflag.FlagSet) string {
+	if author, _ := flags.GetString("author"); author != "" {
+		return author
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// printPinInfo prints a pin with its annotations on a single line.
+func printPinInfo(name string, info *schema.PinInfo) {
+	line := []string{name, "=", info.Ref.String()}
+	if info.Updated != nil {
+		line = append(line, info.Updated.Local().Format(time.RFC3339))
+	}
+	if info.Author != "" {
+		line = append(line, info.Author)
+	}
+	if len(info.Labels) != 0 {
+		labels := make([]string, 0, len(info.Labels))
+		for k, v := range info.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		line = append(line, "["+strings.Join(labels, ",")+"]")
+	}
+	if info.Message != "" {
+		line = append(line, fmt.Sprintf("%q", info.Message))
+	}
+	fmt.Println(strings.Join(line, " "))
+}
+
 func init() {
 	cmd := &cobra.Command{
 		Use:   "pin [name] ref",
@@ -25,7 +63,7 @@ func init() {
 				name = args[0]
 				sref = args[1]
 			}
-			ref, err := types.ParseRef(sref)
+			ref, err := s.ResolveRef(ctx, sref)
 			if err != nil {
 				return err
 			}
@@ -39,24 +77,83 @@ func init() {
 	}
 	Root.AddCommand(cmd)
 
+	setCmd := &cobra.Command{
+		Use:   "set [name] ref",
+		Short: "set a named pin pointing to a ref, with a message and labels",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) == 0 || len(args) > 2 {
+				return fmt.Errorf("expected 1 or 2 arguments")
+			}
+			sref := args[0]
+			name := cas.DefaultPin
+			if len(args) == 2 {
+				name = args[0]
+				sref = args[1]
+			}
+			ref, err := s.ResolveRef(ctx, sref)
+			if err != nil {
+				return err
+			}
+			info := schema.PinInfo{Ref: ref}
+			info.Message, _ = flags.GetString("message")
+			info.Author = authorFromFlags(flags)
+			labels, _ := flags.GetStringArray("label")
+			for _, l := range labels {
+				i := strings.Index(l, "=")
+				if i <= 0 {
+					return fmt.Errorf("expected a label in a key=value format, got: %q", l)
+				}
+				if info.Labels == nil {
+					info.Labels = make(map[string]string)
+				}
+				info.Labels[l[:i]] = l[i+1:]
+			}
+			sr, err := s.SetPinInfo(ctx, name, info)
+			if err != nil {
+				return err
+			}
+			fmt.Println(name, "=", sr.Ref)
+			return nil
+		}),
+	}
+	setCmd.Flags().StringP("message", "m", "", "message for the pin")
+	setCmd.Flags().String("author", "", "author of the change; current user is used by default")
+	setCmd.Flags().StringArrayP("label", "l", nil, "add a label to the pin, in a key=value format")
+	cmd.AddCommand(setCmd)
+
 	listCmd := &cobra.Command{
-		Use:     "list",
+		Use:     "list [prefix]",
 		Aliases: []string{"l", "ls"},
 		Short:   "list all pins and their references",
-		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, _ *pflag.FlagSet, args []string) error {
+		Long:    "list all pins and their references; if prefix is set, only pins with names starting with it are listed",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("expected 0 or 1 arguments")
+			}
+			long, _ := flags.GetBool("long")
+			prefix := ""
 			if len(args) != 0 {
-				return fmt.Errorf("expected 0 arguments")
+				prefix = args[0]
 			}
 
-			it := s.IteratePins(ctx)
+			it := s.IteratePinsByPrefix(ctx, prefix)
 			defer it.Close()
 			for it.Next() {
 				pin := it.Pin()
-				fmt.Println(pin.Name, "=", pin.Ref)
+				if !long {
+					fmt.Println(pin.Name, "=", pin.Ref)
+					continue
+				}
+				info, err := s.PinInfo(ctx, pin.Ref)
+				if err != nil {
+					return err
+				}
+				printPinInfo(pin.Name, info)
 			}
 			return it.Err()
 		}),
 	}
+	listCmd.Flags().BoolP("long", "l", false, "show pin annotations")
 	cmd.AddCommand(listCmd)
 
 	getCmd := &cobra.Command{
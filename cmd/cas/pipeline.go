@@ -66,7 +66,7 @@ func init() {
 					}
 					continue
 				}
-				ref, err := types.ParseRef(arg)
+				ref, err := s.ResolveRef(ctx, arg)
 				if err != nil {
 					return err
 				}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/storage/http"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "push <url> [pins or refs]",
+		Short: "push pins or refs with all referenced blobs to a remote CAS server",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("expected a URL of the server")
+			}
+			conf := &httpstor.Config{URL: args[0]}
+			conf.TokenFile, _ = flags.GetString("token-file")
+			conf.CACert, _ = flags.GetString("ca-cert")
+			remote, err := conf.OpenStorage(ctx)
+			if err != nil {
+				return err
+			}
+			defer remote.Close()
+
+			args = args[1:]
+			if len(args) == 0 {
+				args = []string{cas.DefaultPin}
+			}
+			for _, arg := range args {
+				ref, pin, err := resolvePinOrRef(ctx, s, arg)
+				if err != nil {
+					return err
+				}
+				st, err := s.Push(ctx, remote, ref)
+				if err != nil {
+					return err
+				}
+				if pin != "" {
+					if err = remote.SetPin(ctx, pin, ref); err != nil {
+						return err
+					}
+				}
+				fmt.Printf("%s -> %v (%d blobs checked, %d copied)\n", arg, ref, st.Checked, st.Copied)
+			}
+			return nil
+		}),
+	}
+	cmd.Flags().String("token-file", "", "file with a bearer token for the server")
+	cmd.Flags().String("ca-cert", "", "PEM file with CA certificates of the server")
+	Root.AddCommand(cmd)
+}
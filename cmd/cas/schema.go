@@ -11,7 +11,6 @@ import (
 
 	"github.com/dennwc/cas"
 	"github.com/dennwc/cas/schema/filter"
-	"github.com/dennwc/cas/types"
 )
 
 func init() {
@@ -82,7 +81,7 @@ func init() {
 			if len(args) != 1 {
 				return fmt.Errorf("expected one argument")
 			}
-			ref, err := types.ParseRef(args[0])
+			ref, err := st.ResolveRef(ctx, args[0])
 			if err != nil {
 				return err
 			}
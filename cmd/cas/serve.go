@@ -4,30 +4,110 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 
 	"github.com/dennwc/cas"
 	"github.com/dennwc/cas/storage/http"
+	"github.com/dennwc/cas/storage/reapi"
 )
 
 func init() {
 	cmd := &cobra.Command{
 		Use:   "serve",
 		Short: "serve CAS over HTTP",
+		Long: "Serve CAS over HTTP. Images stored with \"cas oci import\" are also served with the read-only\n" +
+			"registry API on /v2/, tags are mapped to pins named \"<repo>:<tag>\".",
 		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
 			if len(args) != 0 {
 				return fmt.Errorf("unexpected argument")
 			}
 			host, _ := flags.GetString("host")
-
+			conf := &httpstor.ServerConfig{Anonymous: httpstor.PermRead}
+			if path, _ := flags.GetString("auth"); path != "" {
+				tokens, err := httpstor.ReadTokens(path)
+				if err != nil {
+					return err
+				}
+				conf.Tokens = tokens
+				conf.Anonymous = 0
+			}
+			if flags.Changed("anonymous") {
+				names, _ := flags.GetStringSlice("anonymous")
+				perm, err := httpstor.ParsePerm(names)
+				if err != nil {
+					return err
+				}
+				conf.Anonymous = perm
+			}
+			conf.LFS, _ = flags.GetBool("lfs")
+			srv, err := httpstor.NewServerWith(s, "/", conf)
+			if err != nil {
+				return err
+			}
+			cert, _ := flags.GetString("tls-cert")
+			key, _ := flags.GetString("tls-key")
+			if (cert == "") != (key == "") {
+				return fmt.Errorf("both TLS certificate and key must be set")
+			}
+			if addr, _ := flags.GetString("grpc"); addr != "" {
+				lis, err := net.Listen("tcp", addr)
+				if err != nil {
+					return err
+				}
+				auth, err := httpstor.NewAuthorizer(conf)
+				if err != nil {
+					return err
+				}
+				// the same credentials and permissions are accepted as for HTTP
+				opts := reapi.AuthOptions(func(ctx context.Context, header string, write bool) error {
+					perm := httpstor.PermReadBlobs
+					if write {
+						perm = httpstor.PermWriteBlobs
+					}
+					err := auth.Check(header, perm)
+					if err == httpstor.ErrUnauthorized {
+						return status.Error(codes.Unauthenticated, err.Error())
+					}
+					return err
+				})
+				if cert != "" {
+					creds, err := credentials.NewServerTLSFromFile(cert, key)
+					if err != nil {
+						return err
+					}
+					opts = append(opts, grpc.Creds(creds))
+				}
+				g := grpc.NewServer(opts...)
+				reapi.NewServer(s).Register(g)
+				log.Println("serving REAPI on", addr)
+				go func() {
+					if err := g.Serve(lis); err != nil {
+						log.Println("grpc:", err)
+					}
+				}()
+				defer g.Stop()
+			}
 			log.Println("listening on", host)
-			srv := httpstor.NewServer(s, "/")
+			if cert != "" {
+				return http.ListenAndServeTLS(host, cert, key, srv)
+			}
 			return http.ListenAndServe(host, srv)
 		}),
 	}
 	cmd.Flags().String("host", "localhost:9080", "host to listen on")
+	cmd.Flags().String("auth", "", "JSON file with access tokens; anonymous access is disabled if set")
+	cmd.Flags().StringSlice("anonymous", nil, "permissions for anonymous clients (blobs:read, blobs:write, pins:read, pins:write, locks:admin, read, write, all)")
+	cmd.Flags().String("tls-cert", "", "TLS certificate file")
+	cmd.Flags().String("tls-key", "", "TLS private key file")
+	cmd.Flags().Bool("lfs", false, "serve the Git LFS API on /lfs/; set lfs.url of a repository to http(s)://<host>/lfs/<repo>")
+	cmd.Flags().String("grpc", "", "address to serve the Remote Execution API (CAS and ByteStream) on; access is controlled by --auth and --anonymous")
 	Root.AddCommand(cmd)
 }
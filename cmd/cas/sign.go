@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/config"
+	"github.com/dennwc/cas/storage"
+)
+
+// resolvePinOrRef resolves the argument to a ref. The name of the pin is returned if the argument is a pin.
+func resolvePinOrRef(ctx context.Context, s *cas.Storage, name string) (cas.Ref, string, error) {
+	ref, err := s.GetPin(ctx, name)
+	if err == nil {
+		return ref, name, nil
+	} else if err != storage.ErrNotFound && err != storage.ErrInvalidRef {
+		return cas.Ref{}, "", err
+	}
+	ref, err = s.GetPinOrRef(ctx, name)
+	return ref, "", err
+}
+
+func readAuthorizedKeys(paths []string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pk, err := cas.ParseAuthorizedKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		keys = append(keys, pk...)
+	}
+	return keys, nil
+}
+
+func init() {
+	signCmd := &cobra.Command{
+		Use:   "sign [ref or pin]",
+		Short: "sign a ref or a pin value with a private key",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("expected 0 or 1 argument")
+			}
+			name := cas.DefaultPin
+			if len(args) == 1 {
+				name = args[0]
+			}
+			path, _ := flags.GetString("key")
+			if path == "" {
+				return fmt.Errorf("private key must be specified")
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			key, err := cas.ParsePrivateKey(data)
+			if err != nil {
+				return err
+			}
+			ref, pin, err := resolvePinOrRef(ctx, s, name)
+			if err != nil {
+				return err
+			}
+			sr, sig, err := s.Sign(ctx, ref, pin, key)
+			if err != nil {
+				return err
+			}
+			fmt.Println(sr.Ref, "signed", ref, "with", sig.Key)
+			return nil
+		}),
+	}
+	signCmd.Flags().StringP("key", "k", "", "private key file (OpenSSH or PEM)")
+	Root.AddCommand(signCmd)
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify-sig [ref or pin]",
+		Short: "verify signatures of a ref or a pin value",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("expected 0 or 1 argument")
+			}
+			name := cas.DefaultPin
+			if len(args) == 1 {
+				name = args[0]
+			}
+			if paths, _ := flags.GetStringSlice("trusted"); len(paths) != 0 {
+				keys, err := readAuthorizedKeys(paths)
+				if err != nil {
+					return err
+				}
+				s.SetTrustedKeys(keys, false)
+			}
+			ref, pin, err := resolvePinOrRef(ctx, s, name)
+			if err != nil {
+				return err
+			}
+			sigs, err := s.Signatures(ctx, ref)
+			if err != nil {
+				return err
+			}
+			for _, sig := range sigs {
+				status := "OK"
+				if pin != "" && sig.Pin != "" && sig.Pin != pin {
+					status = "OTHER PIN (" + sig.Pin + ")"
+				} else if err := s.CheckSignature(sig); err == cas.ErrUntrustedKey {
+					status = "UNTRUSTED"
+				} else if err != nil {
+					status = "BAD (" + err.Error() + ")"
+				}
+				fmt.Println(ref, sig.Key, status)
+			}
+			_, err = s.Verify(ctx, ref, pin)
+			return err
+		}),
+	}
+	verifyCmd.Flags().StringSlice("trusted", nil, "files with trusted public keys (authorized_keys format); overrides the config")
+	Root.AddCommand(verifyCmd)
+
+	trustCmd := &cobra.Command{
+		Use:   "trust [pubkey files]",
+		Short: "add trusted public keys to the CAS config",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			confPath := filepath.Join(casDir, config.DefaultConfig)
+			conf, err := config.ReadConfig(confPath)
+			if err != nil {
+				return err
+			}
+			keys, err := readAuthorizedKeys(args)
+			if err != nil {
+				return err
+			}
+			seen := make(map[string]bool)
+			if old, err := cas.ParseAuthorizedKeys([]byte(strings.Join(conf.TrustedKeys, "\n"))); err == nil {
+				for _, k := range old {
+					seen[ssh.FingerprintSHA256(k)] = true
+				}
+			}
+			for _, k := range keys {
+				fp := ssh.FingerprintSHA256(k)
+				if seen[fp] {
+					continue
+				}
+				seen[fp] = true
+				conf.TrustedKeys = append(conf.TrustedKeys, string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(k))))
+				fmt.Println("trusted", fp)
+			}
+			if cmd.Flags().Changed("require") {
+				conf.RequireSigned, _ = cmd.Flags().GetBool("require")
+			}
+			return config.WriteConfig(confPath, conf)
+		},
+	}
+	trustCmd.Flags().Bool("require", false, "refuse to checkout, push or export content without trusted signatures")
+	Root.AddCommand(trustCmd)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+)
+
+func init() {
+	importCmd := &cobra.Command{
+		Use:   "import-tar <file|->",
+		Short: "store the content of a tar archive as a directory tree",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected 1 argument")
+			}
+			unzip, _ := flags.GetBool("gzip")
+			conf := storeConfigFromFlags(flags)
+
+			var r io.Reader = os.Stdin
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			if unzip {
+				zr, err := gzip.NewReader(r)
+				if err != nil {
+					return err
+				}
+				defer zr.Close()
+				r = zr
+			}
+			sr, err := s.ImportTar(ctx, r, conf)
+			if err != nil {
+				return err
+			}
+			fmt.Println(sr.Ref, args[0])
+			return nil
+		}),
+	}
+	importCmd.Flags().BoolP("gzip", "z", false, "decompress the archive with gzip")
+	registerStoreConfFlags(importCmd.Flags())
+	Root.AddCommand(importCmd)
+
+	exportCmd := &cobra.Command{
+		Use:   "export-tar <pin|ref>",
+		Short: "write a directory tree as a tar archive",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected 1 argument")
+			}
+			zip, _ := flags.GetBool("gzip")
+			out, _ := flags.GetString("out")
+
+			ref, err := s.GetPinOrRef(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			var (
+				w io.Writer = os.Stdout
+				f *os.File
+			)
+			if out != "" && out != "-" {
+				f, err = os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			var zw *gzip.Writer
+			if zip {
+				zw = gzip.NewWriter(w)
+				defer zw.Close()
+				w = zw
+			}
+			if err = s.ExportTar(ctx, ref, w); err != nil {
+				return err
+			}
+			if zw != nil {
+				if err = zw.Close(); err != nil {
+					return err
+				}
+			}
+			if f != nil {
+				return f.Close()
+			}
+			return nil
+		}),
+	}
+	exportCmd.Flags().BoolP("gzip", "z", false, "compress the archive with gzip")
+	exportCmd.Flags().StringP("out", "o", "", "write the archive to a file instead of stdout")
+	Root.AddCommand(exportCmd)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "torrent",
+		Short: "commands related to BitTorrent",
+	}
+	Root.AddCommand(cmd)
+
+	createCmd := &cobra.Command{
+		Use:   "create <pin|ref>",
+		Short: "create a .torrent file for a stored directory tree or file",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected 1 argument")
+			}
+			var conf cas.TorrentConfig
+			conf.Name, _ = flags.GetString("name")
+			conf.PieceLength, _ = flags.GetInt64("piece-length")
+			conf.V1, _ = flags.GetBool("v1")
+			conf.V2, _ = flags.GetBool("v2")
+			conf.Announce, _ = flags.GetStringSlice("tracker")
+			out, _ := flags.GetString("out")
+
+			ref, err := s.GetPinOrRef(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			if conf.Name == "" && !types.IsRef(args[0]) {
+				conf.Name = strings.ReplaceAll(args[0], storage.PinSeparator, "-")
+			}
+			m, err := s.CreateTorrent(ctx, ref, &conf)
+			if err != nil {
+				return err
+			}
+			data, err := m.Encode()
+			if err != nil {
+				return err
+			}
+			if out == "-" {
+				_, err = os.Stdout.Write(data)
+				return err
+			} else if out == "" {
+				out = m.Name + ".torrent"
+			}
+			if err = ioutil.WriteFile(out, data, 0644); err != nil {
+				return err
+			}
+			fmt.Println(m.Magnet())
+			return nil
+		}),
+	}
+	createCmd.Flags().StringP("out", "o", "", "output file; defaults to <name>.torrent, use \"-\" for stdout")
+	createCmd.Flags().String("name", "", "torrent name; defaults to the pin name")
+	createCmd.Flags().Int64("piece-length", 0, "piece length in bytes; picked based on the content size by default")
+	createCmd.Flags().Bool("v1", false, "only include v1 piece hashes")
+	createCmd.Flags().Bool("v2", false, "only include v2 piece hashes")
+	createCmd.Flags().StringSliceP("tracker", "t", nil, "tracker URLs to announce to")
+	cmd.AddCommand(createCmd)
+
+	importCmd := &cobra.Command{
+		Use:   "import <file.torrent> <data dir>",
+		Short: "verify downloaded torrent data and store it as a directory tree",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, flags *pflag.FlagSet, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("expected 2 arguments")
+			}
+			pin, _ := flags.GetString("pin")
+
+			data, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			sr, t, err := s.ImportTorrent(ctx, data, args[1], storeConfigFromFlags(flags))
+			if err != nil {
+				return err
+			}
+			hash := t.InfoHash
+			if hash == "" {
+				hash = t.InfoHashV2
+			}
+			fmt.Println(sr.Ref, hash)
+			fmt.Println(t.Root.Ref, t.Name)
+			if pin != "" {
+				return s.SetPin(ctx, pin, t.Root.Ref)
+			}
+			return nil
+		}),
+	}
+	importCmd.Flags().StringP("pin", "p", "", "pin the content tree with a given name")
+	registerStoreConfFlags(importCmd.Flags())
+	cmd.AddCommand(importCmd)
+}
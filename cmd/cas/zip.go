@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "zip",
+		Short: "commands related to zip archives",
+	}
+	Root.AddCommand(cmd)
+
+	indexCmd := &cobra.Command{
+		Use:   "index <pin|ref>...",
+		Short: "index files in zip archive(s) without storing them separately",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, _ *pflag.FlagSet, args []string) error {
+			var last error
+			for _, arg := range args {
+				ref, err := s.GetPinOrRef(ctx, arg)
+				if err != nil {
+					last = err
+					fmt.Println(arg, err)
+					continue
+				}
+				sr, err := s.IndexZip(ctx, ref)
+				if err != nil {
+					last = err
+					fmt.Println(arg, err)
+					continue
+				}
+				fmt.Println(sr.Ref, arg)
+			}
+			return last
+		}),
+	}
+	cmd.AddCommand(indexCmd)
+
+	listCmd := &cobra.Command{
+		Use:     "list <ref>",
+		Aliases: []string{"l", "ls"},
+		Short:   "list files in an indexed archive",
+		RunE: casOpenCmd(func(ctx context.Context, s *cas.Storage, _ *pflag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected 1 argument")
+			}
+			ref, err := s.GetPinOrRef(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			obj, err := s.DecodeSchema(ctx, ref)
+			if err != nil {
+				return err
+			}
+			a, ok := obj.(*schema.Archive)
+			if !ok {
+				return fmt.Errorf("expected an archive index, got: %T", obj)
+			}
+			for _, e := range a.Entries {
+				where := "archive"
+				if _, err := s.StatBlob(ctx, e.Ref); err == nil {
+					where = "blob"
+				} else if err != storage.ErrNotFound {
+					return err
+				}
+				fmt.Println(e.Ref, e.Size, e.Name, "("+where+")")
+			}
+			return nil
+		}),
+	}
+	cmd.AddCommand(listCmd)
+}
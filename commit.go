@@ -0,0 +1,203 @@
+package cas
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+)
+
+// errNotCommit is returned when a ref is expected to point to a commit object.
+var errNotCommit = fmt.Errorf("not a commit")
+
+// GetCommit decodes a commit object. Annotated pin values are unwrapped automatically.
+func (s *Storage) GetCommit(ctx context.Context, ref Ref) (*schema.Commit, error) {
+	for {
+		obj, err := s.DecodeSchema(ctx, ref)
+		if err == schema.ErrNotSchema {
+			return nil, fmt.Errorf("%v: %v", ref, errNotCommit)
+		} else if err != nil {
+			return nil, err
+		}
+		switch obj := obj.(type) {
+		case *schema.Commit:
+			return obj, nil
+		case *schema.PinInfo:
+			ref = obj.Ref
+		default:
+			return nil, fmt.Errorf("%v: %v", ref, errNotCommit)
+		}
+	}
+}
+
+// CommitRef returns a ref of a commit that a pin value points to, possibly through an annotated pin value.
+// It returns a zero ref if the value is not a commit.
+func (s *Storage) CommitRef(ctx context.Context, ref Ref) (Ref, error) {
+	for {
+		obj, err := s.DecodeSchema(ctx, ref)
+		if err == schema.ErrNotSchema || err == storage.ErrNotFound {
+			return Ref{}, nil
+		} else if err != nil {
+			return Ref{}, err
+		}
+		switch obj := obj.(type) {
+		case *schema.Commit:
+			return ref, nil
+		case *schema.PinInfo:
+			ref = obj.Ref
+		default:
+			return Ref{}, nil
+		}
+	}
+}
+
+// CommitPin writes a commit for a tree and points a named pin to it.
+// If the pin already points to a commit, it is used as a parent of the new commit.
+// If the time is not set in c, the current time is used.
+func (s *Storage) CommitPin(ctx context.Context, name string, c schema.Commit) (SizedRef, error) {
+	if c.Tree.Zero() {
+		return SizedRef{}, fmt.Errorf("tree ref is not set")
+	}
+	if c.Time == nil {
+		now := time.Now().UTC()
+		c.Time = &now
+	}
+	if len(c.Parents) == 0 {
+		cur, err := s.GetPin(ctx, name)
+		if err != nil && err != storage.ErrNotFound {
+			return SizedRef{}, err
+		}
+		if err == nil {
+			parent, err := s.CommitRef(ctx, cur)
+			if err != nil {
+				return SizedRef{}, err
+			}
+			if !parent.Zero() {
+				c.Parents = []Ref{parent}
+			}
+		}
+	}
+	sr, err := s.StoreSchema(ctx, &c)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	if err = s.SetPin(ctx, name, sr.Ref); err != nil {
+		return SizedRef{}, err
+	}
+	return sr, nil
+}
+
+// commitQueue orders commits from the newest to the oldest.
+type commitQueue []commitItem
+
+type commitItem struct {
+	ref Ref
+	c   *schema.Commit
+}
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	ti, tj := q[i].c.Time, q[j].c.Time
+	if ti == nil || tj == nil {
+		return ti != nil
+	}
+	return ti.After(*tj)
+}
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(commitItem)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
+
+// Log walks the history starting from a given commit. Commits are listed from the newest to the oldest,
+// and each commit is listed only once. Returning an error from fnc stops the iteration.
+func (s *Storage) Log(ctx context.Context, ref Ref, fnc func(ref Ref, c *schema.Commit) error) error {
+	ref, err := s.CommitRef(ctx, ref)
+	if err != nil {
+		return err
+	} else if ref.Zero() {
+		return errNotCommit
+	}
+	seen := make(map[Ref]struct{})
+	var q commitQueue
+	push := func(ref Ref) error {
+		if _, ok := seen[ref]; ok {
+			return nil
+		}
+		seen[ref] = struct{}{}
+		c, err := s.GetCommit(ctx, ref)
+		if err != nil {
+			return err
+		}
+		heap.Push(&q, commitItem{ref: ref, c: c})
+		return nil
+	}
+	if err := push(ref); err != nil {
+		return err
+	}
+	for q.Len() != 0 {
+		cur := heap.Pop(&q).(commitItem)
+		if err := fnc(cur.ref, cur.c); err != nil {
+			return err
+		}
+		for _, p := range cur.c.Parents {
+			if err := push(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveAncestry applies Git-style ancestry suffixes to a commit ref: "~N" selects the N-th first-parent
+// ancestor, "^N" selects the N-th parent. "~" and "^" are the same as "~1" and "^1".
+func (s *Storage) resolveAncestry(ctx context.Context, ref Ref, rev string) (Ref, error) {
+	for rev != "" {
+		op := rev[0]
+		rev = rev[1:]
+		i := strings.IndexAny(rev, "~^")
+		if i < 0 {
+			i = len(rev)
+		}
+		n := 1
+		if i > 0 {
+			v, err := strconv.Atoi(rev[:i])
+			if err != nil || v < 0 {
+				return Ref{}, fmt.Errorf("invalid ancestry suffix: %q", string(op)+rev[:i])
+			}
+			n = v
+		}
+		rev = rev[i:]
+		if op == '^' {
+			if n == 0 {
+				continue
+			}
+			c, err := s.GetCommit(ctx, ref)
+			if err != nil {
+				return Ref{}, err
+			} else if n > len(c.Parents) {
+				return Ref{}, fmt.Errorf("commit %v has no parent %d", ref, n)
+			}
+			ref = c.Parents[n-1]
+			continue
+		}
+		for ; n > 0; n-- {
+			c, err := s.GetCommit(ctx, ref)
+			if err != nil {
+				return Ref{}, err
+			} else if len(c.Parents) == 0 {
+				return Ref{}, fmt.Errorf("commit %v has no parents", ref)
+			}
+			ref = c.Parents[0]
+		}
+	}
+	return ref, nil
+}
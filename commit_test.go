@@ -0,0 +1,125 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+)
+
+func TestCommitHistory(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	const pin = "data"
+	var commits, trees []Ref
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		tree, err := s.StoreBlob(ctx, strings.NewReader("version "+strconv.Itoa(i)), nil)
+		require.NoError(t, err)
+		tm := ts.Add(time.Duration(i) * time.Hour)
+		sr, err := s.CommitPin(ctx, pin, schema.Commit{Tree: tree.Ref, Time: &tm, Message: strconv.Itoa(i)})
+		require.NoError(t, err)
+		commits = append(commits, sr.Ref)
+		trees = append(trees, tree.Ref)
+	}
+
+	c, err := s.GetCommit(ctx, commits[2])
+	require.NoError(t, err)
+	require.Equal(t, []Ref{commits[1]}, c.Parents)
+
+	var got []Ref
+	err = s.Log(ctx, commits[2], func(ref Ref, c *schema.Commit) error {
+		got = append(got, ref)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Ref{commits[2], commits[1], commits[0]}, got)
+
+	for rev, exp := range map[string]Ref{
+		pin:         commits[2],
+		pin + "~":   commits[1],
+		pin + "^":   commits[1],
+		pin + "~2":  commits[0],
+		pin + "^~1": commits[0],
+		pin + "^0":  commits[2],
+	} {
+		ref, err := s.GetPinOrRef(ctx, rev)
+		require.NoError(t, err, rev)
+		require.Equal(t, exp, ref, rev)
+	}
+	_, err = s.GetPinOrRef(ctx, pin+"~3")
+	require.Error(t, err)
+	_, err = s.GetPinOrRef(ctx, pin+"^2")
+	require.Error(t, err)
+
+	// commits can be checked out directly
+	dir, err := ioutil.TempDir("", "cas_commit_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ref, err := s.GetPinOrRef(ctx, pin+"~1")
+	require.NoError(t, err)
+	dst := filepath.Join(dir, "out")
+	require.NoError(t, s.Checkout(ctx, ref, dst))
+	data, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "version 1", string(data))
+}
+
+func TestExportCommittedPin(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	file, err := s.StoreBlob(ctx, strings.NewReader("file a"), nil)
+	require.NoError(t, err)
+	tree, _, err := s.storeDirEntries(ctx, []schema.DirEntry{
+		{Name: "a.txt", Ref: file.Ref, Mode: schema.ModeRegular | 0644, Stats: Stats{schema.StatDataSize: file.Size}},
+	})
+	require.NoError(t, err)
+	_, err = s.CommitPin(ctx, "committed", schema.Commit{Tree: tree.Ref, Message: "first"})
+	require.NoError(t, err)
+	_, err = s.SetPinInfo(ctx, "annotated", schema.PinInfo{Ref: tree.Ref, Message: "first"})
+	require.NoError(t, err)
+
+	expTar := new(bytes.Buffer)
+	require.NoError(t, s.ExportTar(ctx, tree.Ref, expTar))
+	expCAR := new(bytes.Buffer)
+	expCID, err := s.ExportCAR(ctx, tree.Ref, expCAR)
+	require.NoError(t, err)
+	expTorrent, err := s.CreateTorrent(ctx, tree.Ref, &TorrentConfig{Name: "tree"})
+	require.NoError(t, err)
+
+	for _, pin := range []string{"committed", "annotated"} {
+		t.Run(pin, func(t *testing.T) {
+			ref, err := s.GetPin(ctx, pin)
+			require.NoError(t, err)
+			require.NotEqual(t, tree.Ref, ref)
+
+			buf := new(bytes.Buffer)
+			require.NoError(t, s.ExportTar(ctx, ref, buf))
+			require.Equal(t, expTar.Bytes(), buf.Bytes())
+
+			buf.Reset()
+			cid, err := s.ExportCAR(ctx, ref, buf)
+			require.NoError(t, err)
+			require.Equal(t, expCID, cid)
+			require.Equal(t, expCAR.Bytes(), buf.Bytes())
+
+			mi, err := s.CreateTorrent(ctx, ref, &TorrentConfig{Name: "tree"})
+			require.NoError(t, err)
+			require.Equal(t, expTorrent, mi)
+
+			require.Equal(t, map[string]string{"a.txt": "file a"}, readTestTree(t, s, ref))
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package cas
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// codec describes a compression format that is recognized by the file extension.
+type codec struct {
+	algo      string
+	ext       string
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	newWriter func(w io.Writer) (io.WriteCloser, error) // nil if compression is not supported
+}
+
+var codecs = []*codec{
+	{
+		algo: "gzip", ext: ".gz",
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+	},
+	{
+		algo: "zstd", ext: ".zst",
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+	},
+	{
+		algo: "xz", ext: ".xz",
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(zr), nil
+		},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+	},
+	{
+		algo: "bzip2", ext: ".bz2",
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(r)), nil
+		},
+	},
+}
+
+// codecByExt finds a compression codec by a file extension. It returns nil if the format is not recognized.
+func codecByExt(name string) *codec {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, c := range codecs {
+		if c.ext == ext {
+			return c
+		}
+	}
+	return nil
+}
+
+// codecByAlgo finds a compression codec by the name of an algorithm.
+func codecByAlgo(algo string) (*codec, error) {
+	for _, c := range codecs {
+		if c.algo == algo {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported compression: %q", algo)
+}
+
+// compress returns a reader with a compressed content of r.
+func (c *codec) compress(r io.Reader) (io.ReadCloser, error) {
+	if c.newWriter == nil {
+		return nil, fmt.Errorf("compression with %s is not supported", c.algo)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		zw, err := c.newWriter(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(zw, r); err != nil {
+			zw.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+	return pr, nil
+}
+
+// indexDecompressed returns a writer that accepts compressed data and writes decompressed content
+// to a separate blob. If store is false, the decompressed content is only hashed.
+func (s *Storage) indexDecompressed(ctx context.Context, c *codec, store bool) storage.BlobWriter {
+	pr, pw := io.Pipe()
+
+	errc := make(chan error, 1)
+	ch := make(chan storage.BlobWriter, 1)
+	go func() {
+		errc, ch := errc, ch
+		defer func() {
+			if errc != nil {
+				close(errc)
+			}
+		}()
+		defer pr.Close()
+		zr, err := c.newReader(pr)
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer zr.Close()
+		var hw storage.BlobWriter
+		if store {
+			hw, err = s.BeginBlob(ctx)
+			if err != nil {
+				errc <- err
+				return
+			}
+		} else if hw, err = storage.HashFor(ctx); err != nil {
+			errc <- err
+			return
+		}
+		_, err = io.Copy(hw, zr)
+		if err != nil {
+			hw.Close()
+			errc <- err
+			return
+		}
+		ch <- hw
+		errc = nil
+	}()
+	return &decompressWriter{pw: pw, errc: errc, ch: ch}
+}
+
+type decompressWriter struct {
+	pw   *io.PipeWriter
+	errc <-chan error
+	ch   <-chan storage.BlobWriter
+	hw   storage.BlobWriter
+}
+
+func (w *decompressWriter) Size() uint64 {
+	return w.hw.Size()
+}
+
+func (w *decompressWriter) Close() error {
+	if w.errc == nil {
+		return nil
+	} else if w.hw != nil {
+		return w.hw.Close()
+	}
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	select {
+	case err := <-w.errc:
+		w.errc = nil
+		return err
+	case hw := <-w.ch:
+		hw.Close()
+		return nil
+	}
+}
+
+func (w *decompressWriter) Commit() error {
+	if w.errc == nil {
+		return storage.ErrBlobDiscarded
+	}
+	if w.hw == nil {
+		if _, err := w.Complete(); err != nil {
+			return err
+		}
+	}
+	return w.hw.Commit()
+}
+
+func (w *decompressWriter) Complete() (types.SizedRef, error) {
+	if w.errc == nil {
+		return types.SizedRef{}, storage.ErrBlobDiscarded
+	}
+	if w.hw != nil {
+		return w.hw.Complete()
+	}
+	if err := w.pw.Close(); err != nil {
+		return types.SizedRef{}, err
+	}
+	select {
+	case err := <-w.errc:
+		w.errc = nil
+		return types.SizedRef{}, err
+	case hw := <-w.ch:
+		w.hw = hw
+		return hw.Complete()
+	}
+}
+
+func (w *decompressWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// compressedIndex is a kind of index pins that map refs of archives to index objects of compressed files.
+const compressedIndex = "compressed"
+
+// storeCompressed stores an index object for a compressed file and indexes it by the ref of the archive.
+// Both refs must be computed from the content by the caller.
+func (s *Storage) storeCompressed(ctx context.Context, c *schema.Compressed) (SizedRef, error) {
+	sr, err := s.StoreSchema(ctx, c)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	if err = s.st.SetPin(ctx, indexPin(compressedIndex, refKey(c.Arch.Ref)), sr.Ref); err != nil {
+		return SizedRef{}, err
+	}
+	return sr, nil
+}
+
+// findCompressed finds an index object for a compressed blob. It returns nil if there is none.
+func (s *Storage) findCompressed(ctx context.Context, arch Ref) (*schema.Compressed, error) {
+	ref, err := s.st.GetPin(ctx, indexPin(compressedIndex, refKey(arch)))
+	if err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	obj, err := s.DecodeSchema(ctx, ref)
+	if err == schema.ErrNotSchema || err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if c, ok := obj.(*schema.Compressed); ok && c.Arch.Ref == arch {
+		return c, nil
+	}
+	return nil, nil
+}
+
+// reindexCompressed adds index objects of compressed files to the index. Objects are only indexed if the archive
+// is stored and decompresses to the content they describe. If force is false, indexed objects are not verified again.
+func (s *Storage) reindexCompressed(ctx context.Context, force bool) error {
+	it := s.IterateSchema(ctx, typeCompressed)
+	defer it.Close()
+	for it.Next() {
+		obj, err := it.Decode()
+		if err != nil {
+			return err
+		}
+		c, ok := obj.(*schema.Compressed)
+		if !ok {
+			return fmt.Errorf("unexpected type: %T", obj)
+		}
+		sref := it.SchemaRef().Ref
+		name := indexPin(compressedIndex, refKey(c.Arch.Ref))
+		if !force {
+			if cur, err := s.st.GetPin(ctx, name); err == nil && cur == sref {
+				continue
+			}
+		}
+		if ok, err := s.verifyCompressed(ctx, c); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		if err = s.st.SetPin(ctx, name, sref); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// verifyCompressed checks if the archive described by the index object is stored and decompresses to its content.
+func (s *Storage) verifyCompressed(ctx context.Context, c *schema.Compressed) (bool, error) {
+	codec, err := codecByAlgo(c.Algo)
+	if err != nil {
+		return false, nil
+	}
+	rc, sr, err := s.openContent(ctx, c.Arch.Ref)
+	if err == storage.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	if sr.Size != c.Arch.Size {
+		return false, nil
+	}
+	zr, err := codec.newReader(rc)
+	if err != nil {
+		return false, nil
+	}
+	defer zr.Close()
+	got, err := types.HashWith(c.Ref.Ref.Name(), zr)
+	if err != nil {
+		return false, nil
+	}
+	return got == c.Ref, nil
+}
+
+// checkoutCompressed writes either an archive or a decompressed content described by the index object.
+// Either form is restored from the other one if it's not in the storage.
+func (s *Storage) checkoutCompressed(ctx context.Context, obj *schema.Compressed, arch bool, dst string) error {
+	c, err := codecByAlgo(obj.Algo)
+	if err != nil {
+		return err
+	}
+	exp, src := obj.Ref, obj.Arch
+	if arch {
+		exp, src = obj.Arch, obj.Ref
+	}
+	rc, _, err := s.openContent(ctx, exp.Ref)
+	if err == nil {
+		defer rc.Close()
+		return s.checkoutBlobData(ctx, rc, exp, dst)
+	} else if err != storage.ErrNotFound || src.Ref.Zero() {
+		return err
+	}
+	rc, _, err = s.openContent(ctx, src.Ref)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if !arch {
+		zr, err := c.newReader(rc)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return s.checkoutBlobData(ctx, zr, exp, dst)
+	}
+	zr, err := c.compress(rc)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	// compressed data might differ from the original archive, thus it cannot be verified
+	return s.checkoutBlobData(ctx, zr, SizedRef{}, dst)
+}
@@ -0,0 +1,168 @@
+package cas
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/types"
+)
+
+func TestStoreCompressed(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "cas_compress_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("compressed content\n"), 100)
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	archData := buf.Bytes()
+
+	src := filepath.Join(dir, "file.txt.gz")
+	err = ioutil.WriteFile(src, archData, 0644)
+	require.NoError(t, err)
+
+	for _, decompress := range []bool{false, true} {
+		name := "archive"
+		if decompress {
+			name = "decompress"
+		}
+		t.Run(name, func(t *testing.T) {
+			s := newTestStorage(t)
+			sr, err := s.StoreAddr(ctx, src, &StoreConfig{Decompress: decompress})
+			require.NoError(t, err)
+
+			c, err := s.findCompressed(ctx, types.BytesRef(archData))
+			require.NoError(t, err)
+			require.NotNil(t, c)
+			require.Equal(t, "gzip", c.Algo)
+			require.Equal(t, types.BytesRef(data), c.Ref.Ref)
+
+			if !decompress {
+				// index is restored only if the archive can be verified
+				err = s.st.DeletePin(ctx, indexPin(compressedIndex, refKey(c.Arch.Ref)))
+				require.NoError(t, err)
+				c2, err := s.findCompressed(ctx, c.Arch.Ref)
+				require.NoError(t, err)
+				require.Nil(t, c2)
+				require.NoError(t, s.ReindexSchema(ctx, false))
+				c2, err = s.findCompressed(ctx, c.Arch.Ref)
+				require.NoError(t, err)
+				require.Equal(t, c, c2)
+			}
+
+			if decompress {
+				obj, err := s.DecodeSchema(ctx, sr.Ref)
+				require.NoError(t, err)
+				require.Equal(t, c, obj.(*schema.Compressed))
+			} else {
+				require.Equal(t, types.BytesRef(archData), sr.Ref)
+				_, err = s.StatBlob(ctx, sr.Ref)
+				require.NoError(t, err)
+			}
+
+			out := filepath.Join(dir, name+".gz")
+			err = s.Checkout(ctx, sr.Ref, out)
+			require.NoError(t, err)
+			got, err := ioutil.ReadFile(out)
+			require.NoError(t, err)
+			zr, err := gzip.NewReader(bytes.NewReader(got))
+			require.NoError(t, err)
+			got, err = ioutil.ReadAll(zr)
+			require.NoError(t, err)
+			require.Equal(t, data, got)
+
+			// index object serves either form
+			out = filepath.Join(dir, name+".txt")
+			err = s.Checkout(ctx, c.Ref.Ref, out)
+			if !decompress {
+				require.Error(t, err) // only the archive is stored
+
+				sr, err = s.StoreSchema(ctx, c)
+				require.NoError(t, err)
+				err = s.Checkout(ctx, sr.Ref, out)
+			}
+			require.NoError(t, err)
+			got, err = ioutil.ReadFile(out)
+			require.NoError(t, err)
+			require.Equal(t, data, got)
+		})
+	}
+}
+
+func TestStoreCorruptCompressed(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "cas_compress_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("compressed content\n"), 100)
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	archData := buf.Bytes()
+	corrupt := []byte("not a gzip stream")
+
+	src := filepath.Join(dir, "file.txt.gz")
+	err = ioutil.WriteFile(src, corrupt, 0644)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/good.gz" {
+			w.Write(archData)
+		} else {
+			w.Write(corrupt)
+		}
+	}))
+	defer srv.Close()
+
+	for _, decompress := range []bool{false, true} {
+		s := newTestStorage(t)
+		conf := &StoreConfig{Decompress: decompress}
+
+		// files that cannot be decompressed are stored as-is
+		sr, err := s.StoreAddr(ctx, src, conf)
+		require.NoError(t, err)
+		require.Equal(t, types.BytesRef(corrupt), sr.Ref)
+		_, err = s.StatBlob(ctx, sr.Ref)
+		require.NoError(t, err)
+
+		sr, err = s.StoreAddr(ctx, srv.URL+"/file.txt.gz", conf)
+		require.NoError(t, err)
+		obj, err := s.DecodeSchema(ctx, sr.Ref)
+		require.NoError(t, err)
+		require.Equal(t, types.BytesRef(corrupt), obj.(*schema.WebContent).Ref)
+
+		// web content points to a stored blob
+		sr, err = s.StoreAddr(ctx, srv.URL+"/good.gz", conf)
+		require.NoError(t, err)
+		obj, err = s.DecodeSchema(ctx, sr.Ref)
+		require.NoError(t, err)
+		ref := obj.(*schema.WebContent).Ref
+		if !decompress {
+			require.Equal(t, types.BytesRef(archData), ref)
+			continue
+		}
+		obj, err = s.DecodeSchema(ctx, ref)
+		require.NoError(t, err)
+		c := obj.(*schema.Compressed)
+		require.Equal(t, types.BytesRef(archData), c.Arch.Ref)
+		_, err = s.StatBlob(ctx, c.Ref.Ref)
+		require.NoError(t, err)
+	}
+}
@@ -19,6 +19,12 @@ const (
 type Config struct {
 	// Storage is a config for a primary storage used in this CAS.
 	Storage storage.Config
+	// Hash is the name of a hash algorithm for new blobs. Default algorithm is used if it's not set.
+	Hash string
+	// TrustedKeys is a list of public keys (in authorized_keys format) that are trusted to sign the content.
+	TrustedKeys []string
+	// RequireSigned forbids checkout, push and export of content that is not signed by one of the trusted keys.
+	RequireSigned bool
 }
 
 // ReadConfig reads a CAS config file from a given path.
@@ -38,12 +44,16 @@ func ReadConfig(path string) (*Config, error) {
 
 	var c struct {
 		Storage json.RawMessage `json:"storage"`
+		Hash    string          `json:"hash,omitempty"`
+
+		TrustedKeys   []string `json:"trusted_keys,omitempty"`
+		RequireSigned bool     `json:"require_signed,omitempty"`
 	}
 	// TODO: should use TOML; but we rely on schema.Decode that only accepts JSON
 	if err = json.NewDecoder(f).Decode(&c); err != nil {
 		return nil, err
 	}
-	var conf Config
+	conf := Config{Hash: c.Hash, TrustedKeys: c.TrustedKeys, RequireSigned: c.RequireSigned}
 	if len(c.Storage) != 0 {
 		sc, err := storage.DecodeConfig(bytes.NewReader(c.Storage))
 		if err != nil {
@@ -78,8 +88,14 @@ func WriteConfig(path string, conf *Config) error {
 
 	var c struct {
 		Storage json.RawMessage `json:"storage"`
+		Hash    string          `json:"hash,omitempty"`
+
+		TrustedKeys   []string `json:"trusted_keys,omitempty"`
+		RequireSigned bool     `json:"require_signed,omitempty"`
 	}
 	c.Storage = json.RawMessage(buf.Bytes())
+	c.Hash = conf.Hash
+	c.TrustedKeys, c.RequireSigned = conf.TrustedKeys, conf.RequireSigned
 	enc := json.NewEncoder(f)
 	// synchronized with schema.Encode
 	enc.SetEscapeHTML(false)
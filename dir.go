@@ -0,0 +1,74 @@
+package cas
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// validFileName checks if the name can be used for a directory entry.
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// unwrap follows blob wrappers (annotated pins, commits, etc) and returns the ref of the content with its schema object.
+// The object is nil for data blobs.
+func (s *Storage) unwrap(ctx context.Context, ref Ref) (Ref, schema.Object, error) {
+	return storage.Unwrap(ctx, s.index, ref)
+}
+
+// readDir calls fnc for each entry of the directory with a given ref. Blob wrappers are followed.
+func (s *Storage) readDir(ctx context.Context, ref Ref, fnc func(ent *schema.DirEntry) error) error {
+	_, obj, err := s.unwrap(ctx, ref)
+	if err != nil {
+		return err
+	} else if obj == nil {
+		return storage.ErrNotDir
+	}
+	return storage.ReadDir(ctx, s.index, obj, fnc)
+}
+
+// openContent opens a file content described by ref. It accepts raw blobs, multipart files and blob wrappers.
+func (s *Storage) openContent(ctx context.Context, ref Ref) (io.ReadCloser, SizedRef, error) {
+	if ref.Empty() {
+		// empty blobs are not stored
+		rc, _, err := s.FetchBlob(ctx, ref)
+		return rc, SizedRef{Ref: ref}, err
+	}
+	obj, err := s.DecodeSchema(ctx, ref)
+	if err == schema.ErrNotSchema {
+		rc, sz, err := s.FetchBlob(ctx, ref)
+		if err != nil {
+			return nil, SizedRef{}, err
+		}
+		return rc, SizedRef{Ref: ref, Size: sz}, nil
+	} else if err != nil {
+		return nil, SizedRef{}, err
+	}
+	switch obj := obj.(type) {
+	case *schema.InlineList:
+		if obj.Elem == typeSizedRef {
+			return s.openMultipart(ctx, ref, obj)
+		}
+	case *schema.List:
+		if obj.Elem == typeSizedRef {
+			return s.openMultipart(ctx, ref, obj)
+		}
+	case schema.BlobWrapper:
+		return s.openContent(ctx, obj.DataBlob())
+	}
+	if storage.IsDirObject(obj) {
+		return nil, SizedRef{}, fmt.Errorf("%v is a directory", ref)
+	}
+	// unknown schema blob - serve as is
+	rc, sz, err := s.FetchBlob(ctx, ref)
+	if err != nil {
+		return nil, SizedRef{}, err
+	}
+	return rc, types.SizedRef{Ref: ref, Size: sz}, nil
+}
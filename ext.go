@@ -2,7 +2,11 @@ package cas
 
 import (
 	"context"
+	"io"
+	"log"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/dennwc/cas/schema"
 	"github.com/dennwc/cas/types"
@@ -10,23 +14,184 @@ import (
 	"github.com/dennwc/cas/storage"
 )
 
-func (s *Storage) indexFileByExt(ctx context.Context, name string) storage.BlobWriter {
-	ext := filepath.Ext(name)
-	switch ext {
-	case ".gz":
-		return s.indexGZIP(ctx)
+// indexFileByExt returns a writer that indexes the content of a file according to its extension.
+// If store is set, the decompressed content is stored as well. It returns nil if the format is not recognized.
+func (s *Storage) indexFileByExt(ctx context.Context, name string, store bool) storage.BlobWriter {
+	if c := codecByExt(name); c != nil {
+		return s.indexDecompressed(ctx, c, store)
 	}
 	return nil
 }
 
+// storeIndexByExt stores an index object for a file, according to its extension.
+// It returns an empty ref if the format is not recognized.
 func (s *Storage) storeIndexByExt(ctx context.Context, name string, orig, ref types.SizedRef) (types.SizedRef, error) {
-	ext := filepath.Ext(name)
-	switch ext {
-	case ".gz":
-		m := &schema.Compressed{
-			Arch: orig, Ref: ref, Algo: "gzip",
+	if c := codecByExt(name); c != nil {
+		return s.storeCompressed(ctx, &schema.Compressed{
+			Arch: orig, Ref: ref, Algo: c.algo,
+		})
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".zip":
+		sr, err := s.IndexZip(ctx, orig.Ref)
+		if err != nil {
+			// the file is stored already, thus it's fine to leave it without an index
+			logIndexError(name, err)
+			return types.SizedRef{}, nil
 		}
-		return s.StoreSchema(ctx, m)
+		return sr, nil
 	}
 	return types.SizedRef{}, nil
 }
+
+// logIndexError reports a file that cannot be indexed. Files that look like archives, but cannot be read as such,
+// are stored without an index instead of failing the whole operation.
+func logIndexError(name string, err error) {
+	log.Printf("cas: cannot index %q: %v", name, err)
+}
+
+// lenientWriter passes data to w until it fails, and silently drops the rest of the data.
+// It allows to index a stream without failing the storage of the stream itself.
+type lenientWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *lenientWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// spoolFile copies the content of r to a temporary file, so it can be read again.
+// The caller must close and remove the file.
+func spoolFile(r io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp("", "cas-spool-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// storeWithIndex stores the content of r and indexes it according to the file name.
+// It returns a ref of the original content and a ref of the index object, if any.
+//
+// If conf.Decompress is set, the original content of compressed files is only indexed,
+// and the decompressed content is stored instead. If the content cannot be decompressed,
+// the original is stored and the index ref is empty.
+func (s *Storage) storeWithIndex(ctx context.Context, name string, r io.Reader, conf *StoreConfig) (SizedRef, SizedRef, error) {
+	iw := s.indexFileByExt(ctx, name, conf.Decompress && !conf.IndexOnly)
+	if iw == nil {
+		sr, err := s.StoreBlob(ctx, r, conf)
+		if err != nil || conf.IndexOnly {
+			return sr, SizedRef{}, err
+		}
+		isr, err := s.storeIndexByExt(ctx, name, sr, SizedRef{})
+		return sr, isr, err
+	}
+	defer iw.Close()
+	c := *conf
+	var orig io.ReadSeeker // set if the original might be stored after a decompression failure
+	if conf.Decompress {
+		c.IndexOnly = true
+		if !conf.IndexOnly {
+			if rs, ok := r.(io.ReadSeeker); ok {
+				orig = rs
+			} else {
+				f, err := spoolFile(r)
+				if err != nil {
+					return SizedRef{}, SizedRef{}, err
+				}
+				defer func() {
+					f.Close()
+					os.Remove(f.Name())
+				}()
+				r, orig = f, f
+			}
+		}
+	}
+	lw := &lenientWriter{w: iw}
+	sr, err := s.StoreBlob(ctx, io.TeeReader(r, lw), &c)
+	if err != nil {
+		return SizedRef{}, SizedRef{}, err
+	}
+	dsr, err := iw.Complete()
+	if err == nil && lw.err != nil {
+		err = lw.err
+	}
+	if err == nil {
+		err = iw.Commit()
+	}
+	if err != nil {
+		logIndexError(name, err)
+		if orig == nil {
+			return sr, SizedRef{}, nil
+		}
+		// decompressed content was not stored - store the original instead
+		if _, err = orig.Seek(0, io.SeekStart); err != nil {
+			return SizedRef{}, SizedRef{}, err
+		}
+		sr, err = s.StoreBlob(ctx, orig, conf)
+		return sr, SizedRef{}, err
+	}
+	isr, err := s.storeIndexByExt(ctx, name, sr, dsr)
+	return sr, isr, err
+}
+
+// storeFileWithIndex stores a single local file and indexes it according to its extension.
+//
+// If conf.Decompress is set and the file is compressed, a ref of the index object is returned.
+func (s *Storage) storeFileWithIndex(ctx context.Context, path string, conf *StoreConfig) (SizedRef, error) {
+	c := codecByExt(path)
+	if c != nil && conf.Decompress {
+		f, err := os.Open(path)
+		if err != nil {
+			return SizedRef{}, err
+		}
+		defer f.Close()
+		sr, isr, err := s.storeWithIndex(ctx, path, f, conf)
+		if err != nil || isr.Ref.Zero() {
+			return sr, err
+		}
+		return isr, nil
+	}
+	ent, err := s.storeAsFile(ctx, LocalFile(path), conf)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	sr := SizedRef{Ref: ent.Ref, Size: ent.Size()}
+	if c == nil {
+		if !conf.IndexOnly {
+			_, err = s.storeIndexByExt(ctx, path, sr, SizedRef{})
+		}
+		return sr, err
+	}
+	// the file might have been cloned, so read it again to index the content
+	f, err := os.Open(path)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	defer f.Close()
+	iw := s.indexDecompressed(ctx, c, false)
+	defer iw.Close()
+	_, err = io.Copy(iw, f)
+	dsr, err2 := iw.Complete()
+	if err == nil {
+		err = err2
+	}
+	if err != nil {
+		logIndexError(path, err)
+		return sr, nil
+	}
+	_, err = s.storeIndexByExt(ctx, path, sr, dsr)
+	return sr, err
+}
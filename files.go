@@ -57,8 +57,8 @@ func (s *Storage) storeFileContent(ctx context.Context, fd FileDesc, conf *Store
 		conf.Expect = xr
 	}
 
-	// not splitting the file - can optimize in few cases
-	if conf.Split == nil {
+	// not splitting the file and not computing additional hashes - can optimize in few cases
+	if conf.Split == nil && len(conf.ExtraHashes) == 0 {
 		// we know the ref beforehand, so we might return earlier
 		// if we are in indexing mode or we have this blob
 		if !xr.Ref.Zero() {
@@ -181,6 +181,11 @@ func (s *Storage) storeDir(ctx context.Context, dir string, conf *StoreConfig) (
 			}
 		}
 	}
+	return s.storeDirEntries(ctx, base)
+}
+
+// storeDirEntries stores a list of directory entries, splitting it into multiple levels if necessary.
+func (s *Storage) storeDirEntries(ctx context.Context, base []schema.DirEntry) (SizedRef, Stats, error) {
 	sort.Slice(base, func(i, j int) bool {
 		return base[i].Name < base[j].Name
 	})
@@ -254,6 +259,11 @@ func (s *Storage) storeDir(ctx context.Context, dir string, conf *StoreConfig) (
 }
 
 func (s *Storage) StoreAsFile(ctx context.Context, fd FileDesc, conf *StoreConfig) (SizedRef, error) {
+	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, err
+	}
 	m, err := s.storeAsFile(ctx, fd, conf)
 	if err != nil {
 		return SizedRef{}, err
@@ -263,6 +273,10 @@ func (s *Storage) StoreAsFile(ctx context.Context, fd FileDesc, conf *StoreConfi
 
 func (s *Storage) StoreFilePath(ctx context.Context, path string, conf *StoreConfig) (SizedRef, error) {
 	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, err
+	}
 	fi, err := os.Stat(path)
 	if err != nil {
 		return SizedRef{}, err
@@ -0,0 +1,134 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepo creates a repository with a few commits using Git. The test is skipped if Git is not installed.
+func newTestRepo(t testing.TB) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "cas-git-")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	runGit(t, dir, "init", "-q", "-b", "main")
+	big := strings.Repeat("line of a large file\n", 1000)
+	write := func(name, data string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+	write("a.txt", "file a")
+	write("dir/big.txt", big)
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "first")
+	write("dir/big.txt", big+"one more line\n")
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(dir, "link")))
+	write("run.sh", "#!/bin/sh")
+	require.NoError(t, os.Chmod(filepath.Join(dir, "run.sh"), 0755))
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "second")
+	runGit(t, dir, "tag", "-a", "-m", "tag", "v1")
+	return dir
+}
+
+func runGit(t testing.TB, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false",
+	}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func checkTestRepo(t *testing.T, dir string) {
+	r, err := Open(dir)
+	require.NoError(t, err)
+	defer r.Close()
+
+	for _, rev := range []string{"HEAD", "main", "v1", "refs/heads/main"} {
+		id, err := r.Resolve(rev)
+		require.NoError(t, err, rev)
+		exp := runGit(t, dir, "rev-parse", rev)
+		require.Equal(t, exp, id.String(), rev)
+	}
+	head := runGit(t, dir, "rev-parse", "HEAD")
+	id, err := r.Resolve(head[:8])
+	require.NoError(t, err)
+	require.Equal(t, head, id.String())
+
+	tag, err := r.Resolve("v1")
+	require.NoError(t, err)
+	tree, err := r.PeelToTree(tag)
+	require.NoError(t, err)
+	require.Equal(t, runGit(t, dir, "rev-parse", "HEAD^{tree}"), tree.String())
+
+	_, err = r.Resolve("config")
+	require.Equal(t, ErrNotFound, err)
+
+	var walk func(tree ID, pref string)
+	walk = func(tree ID, pref string) {
+		ents, err := r.ReadTree(tree)
+		require.NoError(t, err)
+		for _, e := range ents {
+			if e.IsDir() {
+				walk(e.ID, pref+e.Name+"/")
+				continue
+			}
+			_, data, err := r.ReadObject(e.ID)
+			require.NoError(t, err)
+			exp := runGit(t, dir, "cat-file", "-p", e.ID.String())
+			require.Equal(t, exp, string(bytes.TrimSpace(data)), pref+e.Name)
+			switch e.Name {
+			case "link":
+				require.Equal(t, uint32(ModeSymlink), e.Mode)
+			case "run.sh":
+				require.Equal(t, uint32(ModeExecutable), e.Mode)
+			case "a.txt":
+				require.Equal(t, uint32(ModeRegular), e.Mode)
+			}
+		}
+	}
+	walk(tree, "")
+
+	// previous version of the large file is likely stored as a delta in packs
+	first := runGit(t, dir, "rev-parse", "HEAD~1:dir/big.txt")
+	fid, err := ParseID(first)
+	require.NoError(t, err)
+	_, data, err := r.ReadObject(fid)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("line of a large file\n", 1000), string(data))
+}
+
+func TestRepo(t *testing.T) {
+	dir := newTestRepo(t)
+	t.Run("loose", func(t *testing.T) {
+		checkTestRepo(t, dir)
+	})
+	runGit(t, dir, "gc", "-q", "--aggressive")
+	t.Run("packed", func(t *testing.T) {
+		checkTestRepo(t, dir)
+	})
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello, world")
+	// src size, dst size, copy 7 bytes from 0, insert "there"
+	delta := []byte{12, 12, 0x80 | 0x10, 7, 5, 't', 'h', 'e', 'r', 'e'}
+	out, err := applyDelta(base, delta)
+	require.NoError(t, err)
+	require.Equal(t, "hello, there", string(out))
+
+	_, err = applyDelta(base, []byte{12, 1, 0x80 | 0x01 | 0x10, 20, 5})
+	require.Error(t, err)
+}
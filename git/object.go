@@ -0,0 +1,194 @@
+// Package git reads objects from a local Git repository without running Git.
+//
+// Both loose objects and packfiles are supported. Only SHA-1 repositories can be read.
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+)
+
+// ErrNotFound is returned when an object or a reference does not exist.
+var ErrNotFound = errors.New("git: not found")
+
+// ID is a SHA-1 id of a Git object.
+type ID [sha1.Size]byte
+
+// ParseID parses a full hex object id.
+func ParseID(s string) (ID, error) {
+	var id ID
+	if len(s) != hex.EncodedLen(len(id)) {
+		return ID{}, fmt.Errorf("git: invalid object id: %q", s)
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return ID{}, fmt.Errorf("git: invalid object id: %q", s)
+	}
+	return id, nil
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Type is a type of a Git object.
+type Type int
+
+// Object types, as encoded in packfiles.
+const (
+	TypeCommit = Type(1)
+	TypeTree   = Type(2)
+	TypeBlob   = Type(3)
+	TypeTag    = Type(4)
+
+	typeOfsDelta = Type(6)
+	typeRefDelta = Type(7)
+)
+
+var typeNames = map[Type]string{
+	TypeCommit: "commit",
+	TypeTree:   "tree",
+	TypeBlob:   "blob",
+	TypeTag:    "tag",
+}
+
+func (t Type) String() string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return "type(" + strconv.Itoa(int(t)) + ")"
+}
+
+func parseType(s string) (Type, error) {
+	for t, name := range typeNames {
+		if name == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("git: unknown object type: %q", s)
+}
+
+// objectHash returns a hash that computes an id of an object with a given type and size.
+func objectHash(typ Type, size uint64) hash.Hash {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", typ, size)
+	return h
+}
+
+// Object is an open Git object. The content is verified against the id when it's read to the end.
+type Object struct {
+	ID   ID
+	Type Type
+	Size uint64
+
+	r      io.Reader
+	h      hash.Hash
+	n      uint64
+	err    error
+	closer io.Closer
+}
+
+func newObject(id ID, typ Type, size uint64, r io.Reader, c io.Closer) *Object {
+	return &Object{ID: id, Type: typ, Size: size, r: r, h: objectHash(typ, size), closer: c}
+}
+
+func newObjectBytes(id ID, typ Type, data []byte) *Object {
+	return newObject(id, typ, uint64(len(data)), bytes.NewReader(data), nil)
+}
+
+func (o *Object) Read(p []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	// compressed streams may report EOF only after a checksum is read; stop at the expected size instead
+	if rem := o.Size - o.n; uint64(len(p)) > rem {
+		p = p[:rem]
+	}
+	var (
+		n   int
+		err error
+	)
+	if len(p) != 0 {
+		n, err = o.r.Read(p)
+		o.h.Write(p[:n])
+		o.n += uint64(n)
+	}
+	if o.n == o.Size {
+		var got ID
+		o.h.Sum(got[:0])
+		if got != o.ID {
+			err = fmt.Errorf("git: object %v is corrupted: got %v", o.ID, got)
+		} else {
+			err = io.EOF
+		}
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		o.err = err
+	}
+	return n, err
+}
+
+func (o *Object) Close() error {
+	if o.closer != nil {
+		return o.closer.Close()
+	}
+	return nil
+}
+
+// readAll reads the whole object and closes it.
+func readAll(o *Object) ([]byte, error) {
+	defer o.Close()
+	w := bytes.NewBuffer(make([]byte, 0, sizeHint(o.Size)))
+	if _, err := io.Copy(w, o); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// readLoose parses a zlib-compressed loose object.
+func readLoose(id ID, rc io.ReadCloser) (*Object, error) {
+	zr, err := zlib.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	// header is "<type> <size>\x00"
+	var hdr []byte
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(zr, b[:]); err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("git: cannot read object %v: %v", id, err)
+		} else if b[0] == 0 {
+			break
+		} else if len(hdr) > 32 {
+			rc.Close()
+			return nil, fmt.Errorf("git: invalid object header: %v", id)
+		}
+		hdr = append(hdr, b[0])
+	}
+	i := bytes.IndexByte(hdr, ' ')
+	if i < 0 {
+		rc.Close()
+		return nil, fmt.Errorf("git: invalid object header: %v", id)
+	}
+	typ, err := parseType(string(hdr[:i]))
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	size, err := strconv.ParseUint(string(hdr[i+1:]), 10, 64)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("git: invalid object size: %v", id)
+	}
+	return newObject(id, typ, size, zr, rc), nil
+}
@@ -0,0 +1,372 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	packIdxMagic   = "\377tOc"
+	packIdxVersion = 2
+	packMagic      = "PACK"
+
+	// maxDeltaDepth limits delta chains to protect from cycles in corrupted packs.
+	maxDeltaDepth = 1000
+	// maxDeltaCache is the maximal size of delta bases kept in memory.
+	maxDeltaCache = 64 << 20
+)
+
+// pack is a packfile with its index.
+type pack struct {
+	f *os.File
+
+	ids     []ID     // sorted
+	offsets []uint64 // offsets of objects in the same order as ids
+
+	cache     map[uint64]packCached // delta bases by offset
+	cacheSize int
+}
+
+type packCached struct {
+	typ  Type
+	data []byte
+}
+
+// openPack opens a packfile by the path of its index.
+func openPack(idxPath string) (*pack, error) {
+	data, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	p := &pack{}
+	if err = p.parseIndex(data); err != nil {
+		return nil, fmt.Errorf("git: cannot read %q: %v", idxPath, err)
+	}
+	f, err := os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	var hdr [12]byte
+	if _, err = io.ReadFull(f, hdr[:]); err != nil {
+		f.Close()
+		return nil, err
+	} else if string(hdr[:4]) != packMagic {
+		f.Close()
+		return nil, fmt.Errorf("git: not a packfile: %q", f.Name())
+	}
+	p.f = f
+	return p, nil
+}
+
+// parseIndex parses a version 2 pack index.
+func (p *pack) parseIndex(data []byte) error {
+	if len(data) < 8+256*4 || string(data[:4]) != packIdxMagic {
+		return errors.New("unsupported index format")
+	} else if v := binary.BigEndian.Uint32(data[4:]); v != packIdxVersion {
+		return fmt.Errorf("unsupported index version: %d", v)
+	}
+	data = data[8:]
+	n := int(binary.BigEndian.Uint32(data[255*4:]))
+	data = data[256*4:]
+	if len(data) < n*(len(ID{})+4+4) {
+		return errors.New("index is truncated")
+	}
+	p.ids = make([]ID, n)
+	for i := range p.ids {
+		copy(p.ids[i][:], data[i*len(ID{}):])
+	}
+	data = data[n*len(ID{}):]
+	data = data[n*4:] // skip CRC32
+	offs32 := data[:n*4]
+	offs64 := data[n*4:]
+	p.offsets = make([]uint64, n)
+	for i := range p.offsets {
+		off := binary.BigEndian.Uint32(offs32[i*4:])
+		if off&0x80000000 == 0 {
+			p.offsets[i] = uint64(off)
+			continue
+		}
+		j := int(off &^ 0x80000000)
+		if len(offs64) < (j+1)*8 {
+			return errors.New("index is truncated")
+		}
+		p.offsets[i] = binary.BigEndian.Uint64(offs64[j*8:])
+	}
+	return nil
+}
+
+func (p *pack) Close() error {
+	return p.f.Close()
+}
+
+// find returns an offset of the object in the pack.
+func (p *pack) find(id ID) (uint64, bool) {
+	i := sort.Search(len(p.ids), func(i int) bool {
+		return bytes.Compare(p.ids[i][:], id[:]) >= 0
+	})
+	if i < len(p.ids) && p.ids[i] == id {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// findPrefix calls fnc for all ids that start with a given prefix.
+func (p *pack) findPrefix(pref []byte, fnc func(id ID)) {
+	i := sort.Search(len(p.ids), func(i int) bool {
+		return bytes.Compare(p.ids[i][:], pref) >= 0
+	})
+	for ; i < len(p.ids) && bytes.HasPrefix(p.ids[i][:], pref); i++ {
+		fnc(p.ids[i])
+	}
+}
+
+// entry is a header of an object in the pack.
+type packEntry struct {
+	typ  Type
+	size uint64
+	base uint64 // offset of a delta base for ofs deltas
+	ref  ID     // id of a delta base for ref deltas
+	r    *bufio.Reader
+}
+
+func (p *pack) readEntry(off uint64) (*packEntry, error) {
+	if off > math.MaxInt64 {
+		return nil, fmt.Errorf("git: invalid pack offset: %d", off)
+	}
+	r := bufio.NewReader(io.NewSectionReader(p.f, int64(off), math.MaxInt64-int64(off)))
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	e := &packEntry{typ: Type(c>>4) & 7, size: uint64(c & 0x0f), r: r}
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		} else if shift > 63 {
+			return nil, errors.New("git: invalid object size in pack")
+		}
+		e.size |= uint64(c&0x7f) << shift
+	}
+	switch e.typ {
+	case typeOfsDelta:
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		rel := uint64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			rel = ((rel + 1) << 7) | uint64(c&0x7f)
+		}
+		if rel == 0 || rel > off {
+			return nil, errors.New("git: invalid delta offset")
+		}
+		e.base = off - rel
+	case typeRefDelta:
+		if _, err = io.ReadFull(r, e.ref[:]); err != nil {
+			return nil, err
+		}
+	case TypeCommit, TypeTree, TypeBlob, TypeTag:
+	default:
+		return nil, fmt.Errorf("git: unsupported object type in pack: %v", e.typ)
+	}
+	return e, nil
+}
+
+// inflate reads the compressed data of the entry.
+func (e *packEntry) inflate() ([]byte, error) {
+	zr, err := zlib.NewReader(e.r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	buf := bytes.NewBuffer(make([]byte, 0, sizeHint(e.size)))
+	if n, err := io.Copy(buf, io.LimitReader(zr, int64(e.size))); err != nil {
+		return nil, err
+	} else if uint64(n) != e.size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
+
+// sizeHint limits the size of preallocated buffers, since sizes in object headers cannot be trusted.
+func sizeHint(size uint64) int {
+	const max = 16 << 20
+	if size > max {
+		return max
+	}
+	return int(size)
+}
+
+// open opens an object with a given id at an offset in the pack.
+// Objects that are not stored as deltas are streamed, the rest are reconstructed in memory.
+func (p *pack) open(r *Repo, id ID, off uint64) (*Object, error) {
+	e, err := p.readEntry(off)
+	if err != nil {
+		return nil, err
+	}
+	switch e.typ {
+	case typeOfsDelta, typeRefDelta:
+		typ, data, err := p.unpackEntry(r, e, 0)
+		if err != nil {
+			return nil, err
+		}
+		return newObjectBytes(id, typ, data), nil
+	}
+	zr, err := zlib.NewReader(e.r)
+	if err != nil {
+		return nil, err
+	}
+	return newObject(id, e.typ, e.size, zr, zr), nil
+}
+
+// unpackAt reads an object at a given offset and resolves deltas.
+func (p *pack) unpackAt(r *Repo, off uint64, depth int) (Type, []byte, error) {
+	if c, ok := p.cache[off]; ok {
+		return c.typ, c.data, nil
+	}
+	e, err := p.readEntry(off)
+	if err != nil {
+		return 0, nil, err
+	}
+	typ, data, err := p.unpackEntry(r, e, depth)
+	if err != nil {
+		return 0, nil, err
+	}
+	p.addCache(off, typ, data)
+	return typ, data, nil
+}
+
+func (p *pack) addCache(off uint64, typ Type, data []byte) {
+	if len(data) > maxDeltaCache/4 {
+		return
+	}
+	if p.cache == nil || p.cacheSize+len(data) > maxDeltaCache {
+		p.cache = make(map[uint64]packCached)
+		p.cacheSize = 0
+	}
+	p.cache[off] = packCached{typ: typ, data: data}
+	p.cacheSize += len(data)
+}
+
+func (p *pack) unpackEntry(r *Repo, e *packEntry, depth int) (Type, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, errors.New("git: delta chain is too long")
+	}
+	data, err := e.inflate()
+	if err != nil {
+		return 0, nil, err
+	}
+	var (
+		typ  Type
+		base []byte
+	)
+	switch e.typ {
+	case typeOfsDelta:
+		typ, base, err = p.unpackAt(r, e.base, depth+1)
+	case typeRefDelta:
+		var o *Object
+		if o, err = r.Open(e.ref); err == nil {
+			typ = o.Type
+			base, err = readAll(o)
+		}
+	default:
+		return e.typ, data, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err = applyDelta(base, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, data, nil
+}
+
+func readDeltaSize(d []byte) (uint64, []byte, error) {
+	var size uint64
+	for shift := uint(0); ; shift += 7 {
+		if len(d) == 0 || shift > 63 {
+			return 0, nil, errors.New("git: invalid delta header")
+		}
+		c := d[0]
+		d = d[1:]
+		size |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return size, d, nil
+		}
+	}
+}
+
+// applyDelta reconstructs an object from the base and a delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, delta, err := readDeltaSize(delta)
+	if err != nil {
+		return nil, err
+	} else if srcSize != uint64(len(base)) {
+		return nil, errors.New("git: delta base size mismatch")
+	}
+	dstSize, delta, err := readDeltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, sizeHint(dstSize))
+	for len(delta) != 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// copy from base
+			var off, n uint64
+			for i := uint(0); i < 4; i++ {
+				if op&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, errors.New("git: truncated delta")
+					}
+					off |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if op&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, errors.New("git: truncated delta")
+					}
+					n |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > uint64(len(base)) {
+				return nil, errors.New("git: delta copy is out of range")
+			}
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			// insert new data
+			if int(op) > len(delta) {
+				return nil, errors.New("git: truncated delta")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("git: invalid delta instruction")
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, errors.New("git: delta result size mismatch")
+	}
+	return out, nil
+}
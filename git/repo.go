@@ -0,0 +1,369 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Repo is a local Git repository. It's not safe for concurrent use.
+type Repo struct {
+	dir    string   // git dir
+	common string   // common dir of worktrees; same as dir for regular repositories
+	objs   []string // object directories, including alternates
+	packs  []*pack
+}
+
+// Open opens a Git repository. The path can point to a working tree, a bare repository or a .git directory.
+func Open(path string) (*Repo, error) {
+	dir, err := findGitDir(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repo{dir: dir, common: dir}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "commondir")); err == nil {
+		c := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(c) {
+			c = filepath.Join(dir, c)
+		}
+		r.common = c
+	}
+	if err = r.addObjects(filepath.Join(r.common, "objects"), 0); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// findGitDir returns a path of the .git directory.
+func findGitDir(path string) (string, error) {
+	isGitDir := func(dir string) bool {
+		_, err1 := os.Stat(filepath.Join(dir, "objects"))
+		_, err2 := os.Stat(filepath.Join(dir, "HEAD"))
+		return err1 == nil && err2 == nil
+	}
+	dot := filepath.Join(path, ".git")
+	fi, err := os.Stat(dot)
+	if err == nil && fi.IsDir() {
+		return dot, nil
+	} else if err == nil {
+		// worktrees and submodules use a file that points to the git dir
+		data, err := ioutil.ReadFile(dot)
+		if err != nil {
+			return "", err
+		}
+		line := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(line, "gitdir:") {
+			return "", fmt.Errorf("git: unexpected content of %q", dot)
+		}
+		dir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(path, dir)
+		}
+		return dir, nil
+	}
+	if isGitDir(path) {
+		return path, nil
+	}
+	return "", fmt.Errorf("git: not a repository: %q", path)
+}
+
+// addObjects adds an object directory, its packs and alternates.
+func (r *Repo) addObjects(dir string, depth int) error {
+	if depth > 5 {
+		return fmt.Errorf("git: too many nested alternates")
+	}
+	r.objs = append(r.objs, dir)
+	idx, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, path := range idx {
+		p, err := openPack(path)
+		if os.IsNotExist(err) {
+			continue // pack is being written or removed
+		} else if err != nil {
+			return err
+		}
+		r.packs = append(r.packs, p)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "info", "alternates"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(dir, line)
+		}
+		if err = r.addObjects(line, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repo) Close() error {
+	var last error
+	for _, p := range r.packs {
+		if err := p.Close(); err != nil {
+			last = err
+		}
+	}
+	r.packs = nil
+	return last
+}
+
+// Open opens an object for reading. The content is verified when it's read to the end.
+func (r *Repo) Open(id ID) (*Object, error) {
+	for _, p := range r.packs {
+		if off, ok := p.find(id); ok {
+			return p.open(r, id, off)
+		}
+	}
+	hs := id.String()
+	for _, dir := range r.objs {
+		f, err := os.Open(filepath.Join(dir, hs[:2], hs[2:]))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return readLoose(id, f)
+	}
+	return nil, ErrNotFound
+}
+
+// ReadObject reads the whole object.
+func (r *Repo) ReadObject(id ID) (Type, []byte, error) {
+	o, err := r.Open(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := readAll(o)
+	if err != nil {
+		return 0, nil, err
+	}
+	return o.Type, data, nil
+}
+
+// Tree modes of Git objects.
+const (
+	ModeDir        = 0040000
+	ModeRegular    = 0100644
+	ModeExecutable = 0100755
+	ModeSymlink    = 0120000
+	ModeSubmodule  = 0160000
+)
+
+// TreeEntry is an entry of a Git tree.
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	ID   ID
+}
+
+// IsDir checks if the entry is a subtree.
+func (e *TreeEntry) IsDir() bool {
+	return e.Mode == ModeDir
+}
+
+// ReadTree reads entries of a tree object. Commits and tags are peeled to their trees.
+func (r *Repo) ReadTree(id ID) ([]TreeEntry, error) {
+	id, err := r.PeelToTree(id)
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := r.ReadObject(id)
+	if err != nil {
+		return nil, err
+	}
+	var out []TreeEntry
+	for len(data) != 0 {
+		// "<octal mode> <name>\x00<20 byte id>"
+		i := bytes.IndexByte(data, ' ')
+		j := bytes.IndexByte(data, 0)
+		if i <= 0 || j < i || len(data) < j+1+len(ID{}) {
+			return nil, fmt.Errorf("git: invalid tree %v", id)
+		}
+		var mode uint32
+		for _, c := range data[:i] {
+			if c < '0' || c > '7' {
+				return nil, fmt.Errorf("git: invalid tree %v", id)
+			}
+			mode = mode<<3 | uint32(c-'0')
+		}
+		e := TreeEntry{Mode: mode, Name: string(data[i+1 : j])}
+		copy(e.ID[:], data[j+1:])
+		out = append(out, e)
+		data = data[j+1+len(ID{}):]
+	}
+	return out, nil
+}
+
+// PeelToTree follows tags and commits until it finds a tree.
+func (r *Repo) PeelToTree(id ID) (ID, error) {
+	for i := 0; i < 100; i++ {
+		o, err := r.Open(id)
+		if err != nil {
+			return ID{}, err
+		}
+		if o.Type == TypeTree {
+			o.Close()
+			return id, nil
+		} else if o.Type == TypeBlob {
+			o.Close()
+			return ID{}, fmt.Errorf("git: %v is a blob", id)
+		}
+		data, err := readAll(o)
+		if err != nil {
+			return ID{}, err
+		}
+		// both commits and tags start with a header that points to the next object
+		key := "tree "
+		if o.Type == TypeTag {
+			key = "object "
+		}
+		line := data
+		if k := bytes.IndexByte(line, '\n'); k >= 0 {
+			line = line[:k]
+		}
+		if !bytes.HasPrefix(line, []byte(key)) {
+			return ID{}, fmt.Errorf("git: unexpected header in %v %v", o.Type, id)
+		}
+		if id, err = ParseID(string(line[len(key):])); err != nil {
+			return ID{}, err
+		}
+	}
+	return ID{}, fmt.Errorf("git: too many nested tags")
+}
+
+// Resolve resolves a revision: a full or abbreviated object id, HEAD or a branch, tag or remote name.
+func (r *Repo) Resolve(rev string) (ID, error) {
+	if id, err := ParseID(rev); err == nil {
+		return id, nil
+	}
+	// same order as in git rev-parse
+	names := []string{
+		"refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev,
+		"refs/remotes/" + rev, "refs/remotes/" + rev + "/HEAD",
+	}
+	if strings.HasPrefix(rev, "refs/") || rev == strings.ToUpper(rev) {
+		// HEAD, FETCH_HEAD, etc are stored in the root of git dir
+		names = append([]string{rev}, names...)
+	}
+	for _, name := range names {
+		id, err := r.resolveRef(name, 0)
+		if err == nil {
+			return id, nil
+		} else if err != ErrNotFound {
+			return ID{}, err
+		}
+	}
+	if len(rev) >= 4 && len(rev) < 40 {
+		return r.resolvePrefix(rev)
+	}
+	return ID{}, ErrNotFound
+}
+
+// resolveRef resolves a full reference name. Symbolic references are followed.
+func (r *Repo) resolveRef(name string, depth int) (ID, error) {
+	if depth > 10 {
+		return ID{}, fmt.Errorf("git: too many symbolic refs")
+	} else if strings.Contains(name, "..") || strings.HasPrefix(name, "/") {
+		return ID{}, ErrNotFound
+	}
+	dirs := []string{r.dir}
+	if r.common != r.dir {
+		dirs = append(dirs, r.common)
+	}
+	for _, dir := range dirs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			// missing files and directories mean there is no such ref
+			continue
+		}
+		line := strings.TrimSpace(string(data))
+		if strings.HasPrefix(line, "ref:") {
+			return r.resolveRef(strings.TrimSpace(strings.TrimPrefix(line, "ref:")), depth+1)
+		}
+		return ParseID(line)
+	}
+	return r.packedRef(name)
+}
+
+// packedRef finds a reference in the packed-refs file.
+func (r *Repo) packedRef(name string) (ID, error) {
+	f, err := os.Open(filepath.Join(r.common, "packed-refs"))
+	if os.IsNotExist(err) {
+		return ID{}, ErrNotFound
+	} else if err != nil {
+		return ID{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || line[i+1:] != name {
+			continue
+		}
+		return ParseID(line[:i])
+	}
+	if err := sc.Err(); err != nil {
+		return ID{}, err
+	}
+	return ID{}, ErrNotFound
+}
+
+// resolvePrefix finds an object by an abbreviated hex id.
+func (r *Repo) resolvePrefix(s string) (ID, error) {
+	s = strings.ToLower(s)
+	// decode an even number of digits; the last one is checked separately
+	pref, err := hex.DecodeString(s[:len(s)&^1])
+	if err != nil {
+		return ID{}, ErrNotFound
+	}
+	found := make(map[ID]struct{})
+	add := func(id ID) {
+		if strings.HasPrefix(id.String(), s) {
+			found[id] = struct{}{}
+		}
+	}
+	for _, p := range r.packs {
+		p.findPrefix(pref, add)
+	}
+	for _, dir := range r.objs {
+		names, err := ioutil.ReadDir(filepath.Join(dir, s[:2]))
+		if err != nil {
+			continue
+		}
+		for _, fi := range names {
+			if id, err := ParseID(s[:2] + fi.Name()); err == nil {
+				add(id)
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return ID{}, ErrNotFound
+	case 1:
+		for id := range found {
+			return id, nil
+		}
+	}
+	return ID{}, fmt.Errorf("git: ambiguous object id: %q", s)
+}
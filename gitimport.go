@@ -0,0 +1,161 @@
+package cas
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dennwc/cas/git"
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// gitImporter converts Git trees to directory trees.
+type gitImporter struct {
+	s    *Storage
+	r    *git.Repo
+	conf *StoreConfig
+
+	blobs map[git.ID]SizedRef // blobs stored in this session, by Git id
+	trees map[git.ID]gitTree  // trees imported in this session
+}
+
+type gitTree struct {
+	sr    SizedRef
+	stats Stats
+}
+
+func gitRef(id git.ID) Ref {
+	// git ids are always valid refs of this hash
+	ref, _ := types.MakeRef(types.HashGitSHA1, id[:])
+	return ref
+}
+
+// lookupGitBlob finds a blob that was aliased by a Git object id. Only aliases of blobs that were verified
+// against their Git ids are indexed, see gitImporter.storeBlob.
+func (s *Storage) lookupGitBlob(ctx context.Context, id git.ID) (SizedRef, bool, error) {
+	a, err := s.lookupAlias(ctx, gitRef(id))
+	if err == storage.ErrNotFound {
+		return SizedRef{}, false, nil
+	} else if err != nil {
+		return SizedRef{}, false, err
+	}
+	return a.Ref, true, nil
+}
+
+// ImportGit stores the tree of a Git commit, tag or tree as a directory tree, preserving file modes.
+// Blobs are aliased by their Git ids, thus blobs imported previously are not read from the repository again.
+// Submodules are not included in the tree.
+func (s *Storage) ImportGit(ctx context.Context, r *git.Repo, id git.ID, conf *StoreConfig) (SizedRef, error) {
+	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	tree, err := r.PeelToTree(id)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	imp := &gitImporter{
+		s: s, r: r, conf: conf,
+		blobs: make(map[git.ID]SizedRef),
+		trees: make(map[git.ID]gitTree),
+	}
+	t, err := imp.importTree(ctx, tree)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	return t.sr, nil
+}
+
+func (imp *gitImporter) importTree(ctx context.Context, id git.ID) (gitTree, error) {
+	if t, ok := imp.trees[id]; ok {
+		return t, nil
+	}
+	ents, err := imp.r.ReadTree(id)
+	if err != nil {
+		return gitTree{}, err
+	}
+	list := make([]schema.DirEntry, 0, len(ents))
+	for _, e := range ents {
+		if !validFileName(e.Name) {
+			return gitTree{}, fmt.Errorf("invalid file name in git tree %v: %q", id, e.Name)
+		}
+		ent := schema.DirEntry{Name: e.Name}
+		switch e.Mode {
+		case git.ModeDir:
+			sub, err := imp.importTree(ctx, e.ID)
+			if err != nil {
+				return gitTree{}, err
+			}
+			ent.Ref, ent.Stats = sub.sr.Ref, sub.stats
+			ent.Mode = schema.ModeDir | 0755
+		case git.ModeSymlink:
+			_, data, err := imp.r.ReadObject(e.ID)
+			if err != nil {
+				return gitTree{}, err
+			}
+			ent.Mode = schema.ModeSymlink | 0777
+			ent.Link = string(data)
+		case git.ModeSubmodule:
+			// commits of other repositories cannot be resolved
+			continue
+		default:
+			sr, err := imp.storeBlob(ctx, e.ID)
+			if err != nil {
+				return gitTree{}, fmt.Errorf("cannot store %q: %v", e.Name, err)
+			}
+			ent.Ref = sr.Ref
+			ent.Stats = Stats{schema.StatDataSize: sr.Size}
+			ent.Mode = schema.ModeRegular | 0644
+			if e.Mode&0111 != 0 {
+				ent.Mode = schema.ModeRegular | 0755
+			}
+		}
+		list = append(list, ent)
+	}
+	sr, st, err := imp.s.storeDirEntries(ctx, list)
+	if err != nil {
+		return gitTree{}, err
+	}
+	t := gitTree{sr: sr, stats: st}
+	imp.trees[id] = t
+	return t, nil
+}
+
+// storeBlob stores a Git blob and aliases it by the Git id.
+// The content is verified against the id while it's read from the repository.
+func (imp *gitImporter) storeBlob(ctx context.Context, id git.ID) (SizedRef, error) {
+	if sr, ok := imp.blobs[id]; ok {
+		return sr, nil
+	}
+	if sr, ok, err := imp.s.lookupGitBlob(ctx, id); err != nil {
+		return SizedRef{}, err
+	} else if ok {
+		imp.blobs[id] = sr
+		return sr, nil
+	}
+	o, err := imp.r.Open(id)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	defer o.Close()
+	if o.Type != git.TypeBlob {
+		return SizedRef{}, fmt.Errorf("expected a blob, got %v", o.Type)
+	}
+	c := *imp.conf
+	c.Expect = SizedRef{}
+	sr, err := imp.s.StoreBlob(ctx, o, &c)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	if sr.Ref.Zero() {
+		sr.Ref = emptyRef(ctx)
+	} else if sr.Size != 0 {
+		if err = imp.s.storeAliasRefs(ctx, sr, []Ref{gitRef(id)}); err != nil {
+			return SizedRef{}, err
+		}
+	}
+	imp.blobs[id] = sr
+	return sr, nil
+}
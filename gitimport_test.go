@@ -0,0 +1,170 @@
+package cas
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/git"
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+func runTestGit(t testing.TB, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false",
+	}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func countGitAliases(t testing.TB, s *Storage) int {
+	ctx := context.Background()
+	it := storage.IteratePinsByPrefix(ctx, s.st, indexPin(aliasIndex, types.HashGitSHA1)+storage.PinSeparator)
+	defer it.Close()
+	n := 0
+	for it.Next() {
+		n++
+	}
+	require.NoError(t, it.Err())
+	return n
+}
+
+func TestImportGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "cas-git-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, data string, mode os.FileMode) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), mode))
+		require.NoError(t, os.Chmod(path, mode))
+	}
+	runTestGit(t, dir, "init", "-q")
+	write("a.txt", "file a", 0644)
+	write("sub/b.txt", "file b", 0644)
+	runTestGit(t, dir, "add", ".")
+	runTestGit(t, dir, "commit", "-q", "-m", "first")
+	write("sub/b.txt", "file b v2", 0644)
+	write("run.sh", "#!/bin/sh", 0755)
+	write("empty", "", 0644)
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(dir, "link")))
+	runTestGit(t, dir, "add", ".")
+	runTestGit(t, dir, "commit", "-q", "-m", "second")
+	runTestGit(t, dir, "gc", "-q")
+
+	r, err := git.Open(dir)
+	require.NoError(t, err)
+	defer r.Close()
+
+	s := newTestStorage(t)
+	first, err := git.ParseID(runTestGit(t, dir, "rev-parse", "HEAD~1"))
+	require.NoError(t, err)
+	sr1, err := s.ImportGit(ctx, r, first, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"a.txt":     "file a",
+		"sub/b.txt": "file b",
+	}, readTestTree(t, s, sr1.Ref))
+	require.Equal(t, 2, countGitAliases(t, s))
+
+	head, err := r.Resolve("HEAD")
+	require.NoError(t, err)
+	sr2, err := s.ImportGit(ctx, r, head, nil)
+	require.NoError(t, err)
+	// only changed and new files are stored
+	require.Equal(t, 4, countGitAliases(t, s))
+
+	modes := make(map[string]*schema.DirEntry)
+	err = s.readDir(ctx, sr2.Ref, func(ent *schema.DirEntry) error {
+		e := *ent
+		modes[ent.Name] = &e
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint32(schema.ModeRegular|0755), modes["run.sh"].Mode)
+	require.Equal(t, uint32(schema.ModeRegular|0644), modes["a.txt"].Mode)
+	require.True(t, modes["empty"].Ref.Empty())
+	require.True(t, modes["link"].IsSymlink())
+	require.Equal(t, "a.txt", modes["link"].Link)
+	require.Equal(t, modes["a.txt"].Ref, func() Ref {
+		var ref Ref
+		s.readDir(ctx, sr1.Ref, func(ent *schema.DirEntry) error {
+			if ent.Name == "a.txt" {
+				ref = ent.Ref
+			}
+			return nil
+		})
+		return ref
+	}())
+
+	// blobs can be found by Git ids
+	bid := runTestGit(t, dir, "rev-parse", "HEAD:sub/b.txt")
+	id, err := git.ParseID(bid)
+	require.NoError(t, err)
+	rc, _, err := s.FetchBlob(ctx, gitRef(id))
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	require.Equal(t, "file b v2", string(data))
+
+	// crafted trees cannot escape the directory
+	blob := runTestGit(t, dir, "rev-parse", "HEAD:a.txt")
+	for _, name := range []string{"..", "."} {
+		cmd := exec.Command("git", "mktree")
+		cmd.Dir = dir
+		cmd.Stdin = strings.NewReader("100644 blob " + blob + "\t" + name + "\n")
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		id, err := git.ParseID(strings.TrimSpace(string(out)))
+		require.NoError(t, err)
+		_, err = s.ImportGit(ctx, r, id, nil)
+		require.Error(t, err)
+	}
+}
+
+func TestCheckoutInvalidNames(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+	sr, err := s.StoreBlob(ctx, strings.NewReader("evil"), nil)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	for i, name := range []string{"../evil", "..", "a/../../evil", ""} {
+		d, err := s.StoreSchema(ctx, &schema.InlineList{Elem: typeDirEnt, List: []schema.Object{
+			&schema.DirEntry{Ref: sr.Ref, Name: name, Mode: schema.ModeRegular | 0644},
+		}})
+		require.NoError(t, err)
+		dst := filepath.Join(dir, "out", strconv.Itoa(i))
+		err = s.Checkout(ctx, d.Ref, dst)
+		require.Error(t, err)
+		_, err = os.Stat(filepath.Join(dir, "evil"))
+		require.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(dir, "out", "evil"))
+		require.True(t, os.IsNotExist(err))
+	}
+
+	// entries with the same name cannot replace each other
+	link := &schema.DirEntry{Name: "a", Mode: schema.ModeSymlink | 0777, Link: dir}
+	d, err := s.StoreSchema(ctx, &schema.InlineList{Elem: typeDirEnt, List: []schema.Object{
+		link, &schema.DirEntry{Ref: sr.Ref, Name: "a", Mode: schema.ModeRegular | 0644},
+	}})
+	require.NoError(t, err)
+	require.Error(t, s.Checkout(ctx, d.Ref, filepath.Join(dir, "dup")))
+}
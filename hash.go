@@ -2,10 +2,10 @@ package cas
 
 import (
 	"context"
-	"io"
 	"log"
 	"os"
 
+	"github.com/dennwc/cas/storage"
 	"github.com/dennwc/cas/types"
 )
 
@@ -22,16 +22,14 @@ func HashWith(ctx context.Context, path string, info os.FileInfo, force bool) (S
 		}
 	}
 
-	h := types.NewRef().Hash()
-	n, err := io.Copy(h, f)
+	sr, err := types.HashWith(storage.HashName(ctx), f)
 	if err != nil {
 		return SizedRef{}, err
 	}
-	ref := types.NewRef().WithHash(h)
-	if err = SaveRefFile(ctx, f, info, ref); err != nil {
+	if err = SaveRefFile(ctx, f, info, sr.Ref); err != nil {
 		log.Println(err)
 	}
-	return SizedRef{Ref: ref, Size: uint64(n)}, nil
+	return sr, nil
 }
 
 func Hash(ctx context.Context, path string) (SizedRef, error) {
@@ -0,0 +1,234 @@
+package cashttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl is a set of Cache-Control directives. Directives without a value are mapped to an empty string.
+type cacheControl map[string]string
+
+// parseCacheControl parses Cache-Control directives from the header.
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, val := part, ""
+			if i := strings.IndexByte(part, '='); i >= 0 {
+				name, val = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+				val = strings.Trim(val, `"`)
+			}
+			name = strings.ToLower(name)
+			if _, ok := cc[name]; !ok {
+				cc[name] = val
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the value of a directive like max-age. Invalid values are treated as zero, as RFC 9111 suggests.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// requestNoCache checks if the request asks to validate the cached response with the server.
+func requestNoCache(req *http.Request, cc cacheControl) bool {
+	if cc.has("no-cache") {
+		return true
+	} else if len(cc) == 0 {
+		// HTTP/1.0 clients
+		for _, v := range req.Header.Values("Pragma") {
+			if strings.Contains(strings.ToLower(v), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// heuristicStatus lists status codes that are cacheable by default.
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// reusable checks if a stored response can be used to satisfy the request, either directly or after a validation.
+func reusable(req *http.Request, resp *Response) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+	if resp.Status == http.StatusPartialContent {
+		// range requests are not supported
+		return false
+	}
+	return !parseCacheControl(http.Header(resp.Header)).has("no-store")
+}
+
+// varyMatches checks that the request has the same values of the headers listed in Vary of the stored response.
+func varyMatches(req *http.Request, r *Request, resp *Response) bool {
+	for _, line := range http.Header(resp.Header).Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			} else if name == "*" {
+				return false
+			}
+			v1 := strings.Join(req.Header.Values(name), ", ")
+			v2 := strings.Join(http.Header(r.Header).Values(name), ", ")
+			if v1 != v2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// freshness calculates the freshness lifetime and the current age of the stored response, as defined in RFC 9111.
+// It returns false if the age of the response is unknown.
+func freshness(resp *Response, sess *Session, now time.Time) (lifetime, age time.Duration, ok bool) {
+	h := http.Header(resp.Header)
+	date, err := http.ParseTime(h.Get("Date"))
+	hasDate := err == nil
+
+	var sent, received time.Time
+	switch {
+	case sess.Received != nil:
+		received = *sess.Received
+		sent = received
+		if sess.Sent != nil {
+			sent = *sess.Sent
+		}
+	case hasDate:
+		sent, received = date, date
+	default:
+		return 0, 0, false
+	}
+	if !hasDate {
+		date = received
+	}
+
+	// age calculation, section 4.2.3
+	apparent := received.Sub(date)
+	if apparent < 0 {
+		apparent = 0
+	}
+	var ageValue time.Duration
+	if v, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && v > 0 {
+		ageValue = time.Duration(v) * time.Second
+	}
+	corrected := ageValue + received.Sub(sent)
+	if corrected < apparent {
+		corrected = apparent
+	}
+	age = corrected + now.Sub(received)
+
+	// freshness lifetime, section 4.2.1
+	cc := parseCacheControl(h)
+	if v, ok := cc.seconds("max-age"); ok {
+		return v, age, true
+	}
+	if v := h.Get("Expires"); v != "" {
+		exp, err := http.ParseTime(v)
+		if err != nil {
+			// invalid values mean the response is already expired
+			return 0, age, true
+		}
+		return exp.Sub(date), age, true
+	}
+	if lm, err := http.ParseTime(h.Get("Last-Modified")); err == nil && heuristicStatus[resp.Status] && lm.Before(date) {
+		// heuristic freshness, section 4.2.2
+		return date.Sub(lm) / 10, age, true
+	}
+	return 0, age, true
+}
+
+// satisfies checks if the stored response can be served without a validation, according to directives of the request
+// and the response.
+func satisfies(req *http.Request, resp *Response, sess *Session, now time.Time) bool {
+	rcc := parseCacheControl(req.Header)
+	scc := parseCacheControl(http.Header(resp.Header))
+	if requestNoCache(req, rcc) || scc.has("no-cache") {
+		return false
+	}
+	lifetime, age, ok := freshness(resp, sess, now)
+	if !ok {
+		return false
+	}
+	if v, ok := rcc.seconds("max-age"); ok && age > v {
+		return false
+	}
+	if lifetime > age {
+		v, _ := rcc.seconds("min-fresh")
+		return lifetime-age >= v
+	}
+	// stale responses can only be served if the client allows it
+	if !rcc.has("max-stale") || scc.has("must-revalidate") {
+		return false
+	}
+	v, ok := rcc.seconds("max-stale")
+	return rcc["max-stale"] == "" || (ok && age-lifetime <= v)
+}
+
+// withValidators returns a conditional request to validate the stored response. It returns nil if the response has
+// no validators.
+func withValidators(req *http.Request, resp *Response) *http.Request {
+	h := http.Header(resp.Header)
+	etag, lm := h.Get("ETag"), h.Get("Last-Modified")
+	if etag == "" && lm == "" {
+		return nil
+	}
+	creq := req.Clone(req.Context())
+	if etag != "" && creq.Header.Get("If-None-Match") == "" {
+		creq.Header.Set("If-None-Match", etag)
+	}
+	if lm != "" && creq.Header.Get("If-Modified-Since") == "" {
+		creq.Header.Set("If-Modified-Since", lm)
+	}
+	return creq
+}
+
+// notUpdated lists headers of the stored response that are not updated from the 304 response.
+var notUpdated = map[string]bool{
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Content-Range":     true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// updateHeaders creates a new stored response with headers updated from a 304 response, as defined in section 4.3.4.
+func updateHeaders(resp *Response, h http.Header) *Response {
+	nh := http.Header(resp.Header).Clone()
+	for k, v := range h {
+		if !notUpdated[k] {
+			nh[k] = v
+		}
+	}
+	return &Response{
+		Status:  resp.Status,
+		Header:  Header(nh),
+		Body:    resp.Body,
+		Trailer: resp.Trailer,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/dennwc/cas/schema"
 	"github.com/dennwc/cas/types"
@@ -49,10 +50,21 @@ func (r *Response) References() []types.Ref {
 type Session struct {
 	Request  types.Ref `json:"request"`
 	Response types.Ref `json:"response"`
+	// Sent and Received record when the request was sent and when the response was received.
+	// They are used to calculate the age of the cached response.
+	Sent     *time.Time `json:"sent,omitempty"`
+	Received *time.Time `json:"received,omitempty"`
+	// Revalidates is set if the server confirmed that the response of another session is still valid.
+	// The response of this session has the same body and headers updated by the server.
+	Revalidates *types.Ref `json:"revalidates,omitempty"`
 }
 
 func (r *Session) References() []types.Ref {
-	return []types.Ref{r.Request, r.Response}
+	refs := []types.Ref{r.Request, r.Response}
+	if r.Revalidates != nil {
+		refs = append(refs, *r.Revalidates)
+	}
+	return refs
 }
 
 var (
@@ -4,11 +4,14 @@ package cashttp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/dennwc/cas"
 	"github.com/dennwc/cas/schema"
@@ -21,8 +24,12 @@ import (
 //
 // Requests will be matched against the cache based on exact URL match and on match of well-known headers (like Accept).
 // See MatchHeaders for more details.
+//
+// Cached responses are reused according to HTTP caching rules (RFC 9111): GET and HEAD responses are served while
+// they are fresh, stale responses are revalidated with the server, and responses with "no-store" are not recorded.
+// See ReplayOnly for reproducible replays of recorded sessions.
 func NewTransport(s *cas.Storage) *Transport {
-	t := &Transport{s: s, tr: http.DefaultTransport}
+	t := &Transport{s: s, tr: http.DefaultTransport, now: time.Now}
 	t.MatchHeaders(
 		"Accept",
 		"If-Modified-Since",
@@ -33,13 +40,18 @@ func NewTransport(s *cas.Storage) *Transport {
 
 var _ http.RoundTripper = (*Transport)(nil)
 
+// ErrNotCached is returned in replay-only mode if there is no recorded response for the request.
+var ErrNotCached = errors.New("cashttp: response is not cached")
+
 type Transport struct {
-	s  *cas.Storage
-	tr http.RoundTripper
+	s   *cas.Storage
+	tr  http.RoundTripper
+	now func() time.Time
 
 	matchHeader []string
 	reqFilter   func(*http.Request) bool
 	respFilter  func(*http.Response) bool
+	replayOnly  bool
 }
 
 // MatchHeaders adds additional headers that will be used to match requests versus cache entries.
@@ -64,17 +76,32 @@ func (t *Transport) ResponseFilter(fnc func(*http.Response) bool) {
 	t.respFilter = fnc
 }
 
+// ReplayOnly switches the transport to offline mode. In this mode the latest recorded response is replayed
+// for any matching request, regardless of its method and cache directives, and the network is never used.
+// Requests without a recorded response fail with ErrNotCached.
+func (t *Transport) ReplayOnly(on bool) {
+	t.replayOnly = on
+}
+
 // RoundTrip implements http.RoundTripper.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.replayOnly {
+		return t.replay(req)
+	}
 	if t.reqFilter != nil && !t.reqFilter(req) {
 		return t.tr.RoundTrip(req)
 	}
+	if parseCacheControl(req.Header).has("no-store") {
+		return t.tr.RoundTrip(req)
+	}
 	ctx := req.Context()
 
 	// read the body first - we need to store the trailer, so we need to drain the stream
 	body, bref, err := t.storeBody(ctx, req.Body)
 	if err != nil {
-		req.Body.Close()
+		if req.Body != nil {
+			req.Body.Close()
+		}
 		return nil, err
 	}
 	req.Body = body // restore the body
@@ -83,33 +110,60 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// user may provide a custom headers filter, so we might have a similar
 	// request already stored in the cache
 
-	if resp, err := t.serveFromCache(req); err == nil && resp != nil {
-		// no need to store the new request
-		return resp, nil
-	} else if err != nil {
+	c, err := t.checkReqCache(req)
+	if err != nil {
 		log.Println(req.Method, req.URL, err)
 	}
-
-	// cache miss - store the request
-	reqRef, err := t.storeRequest(req, bref)
-	if err != nil {
-		req.Body.Close()
-		return nil, err
+	if c != nil && !reusable(req, c.resp) {
+		c = nil
+	}
+	if c != nil && satisfies(req, c.resp, c.sess, t.now()) {
+		// no need to store the new request
+		return t.reconstruct(ctx, c, true)
+	}
+	if parseCacheControl(req.Header).has("only-if-cached") {
+		return gatewayTimeout(req), nil
+	}
+	out := req
+	if c != nil {
+		if creq := withValidators(req, c.resp); creq != nil {
+			out = creq
+		} else {
+			c = nil
+		}
 	}
 
-	resp, err := t.tr.RoundTrip(req)
+	sent := t.now().UTC()
+	resp, err := t.tr.RoundTrip(out)
 	if err != nil {
 		return nil, err
 	}
+	received := t.now().UTC()
+	if c != nil && resp.StatusCode == http.StatusNotModified {
+		return t.revalidated(req, resp, c, sent, received)
+	}
 	if t.respFilter != nil && !t.respFilter(resp) {
 		return resp, nil
 	}
+	if parseCacheControl(resp.Header).has("no-store") {
+		return resp, nil
+	}
+
+	// store the original request, without validators added by the cache
+	reqRef, err := t.storeRequest(req, bref)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
 	respRef, err := t.storeResponse(req.Context(), resp)
 	if err != nil {
 		resp.Body.Close()
 		return nil, err
 	}
-	sessRef, err := t.storeSession(ctx, reqRef, respRef)
+	sessRef, err := t.storeSession(ctx, &Session{
+		Request: reqRef, Response: respRef,
+		Sent: &sent, Received: &received,
+	})
 	if err != nil {
 		resp.Body.Close()
 		return nil, err
@@ -118,6 +172,64 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
+// replay serves the request from the cache without contacting the server.
+func (t *Transport) replay(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		// the body is not matched, but the caller expects it to be consumed
+		io.Copy(ioutil.Discard, req.Body)
+		req.Body.Close()
+	}
+	c, err := t.checkReqCache(req)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return nil, ErrNotCached
+	}
+	return t.reconstruct(req.Context(), c, false)
+}
+
+// revalidated records a new session for a cached response confirmed by the server and serves the updated response.
+func (t *Transport) revalidated(req *http.Request, resp *http.Response, c *cachedResponse, sent, received time.Time) (*http.Response, error) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	ctx := req.Context()
+	r := updateHeaders(c.resp, resp.Header)
+	sr, err := t.s.StoreSchema(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	prev := c.sessRef
+	sess := &Session{
+		Request: c.sess.Request, Response: sr.Ref,
+		Sent: &sent, Received: &received,
+		Revalidates: &prev,
+	}
+	sessRef, err := t.storeSession(ctx, sess)
+	if err != nil {
+		return nil, err
+	}
+	c = &cachedResponse{sessRef: sessRef, sess: sess, resp: r}
+	out, err := t.reconstruct(ctx, c, false)
+	if err != nil {
+		return nil, err
+	}
+	out.Header.Set("X-CAS-Session-Ref", sessRef.String())
+	return out, nil
+}
+
+// gatewayTimeout is returned for "only-if-cached" requests that cannot be served from the cache.
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusGatewayTimeout,
+		Status:     fmt.Sprintf("%d %s", http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout)),
+		Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header:  make(http.Header),
+		Body:    ioutil.NopCloser(bytes.NewReader(nil)),
+		Request: req,
+	}
+}
+
 func (t *Transport) storeBody(ctx context.Context, rc io.ReadCloser) (io.ReadCloser, types.SizedRef, error) {
 	if rc == nil {
 		return nil, types.SizedRef{Ref: types.BytesRef(nil)}, nil
@@ -225,12 +337,9 @@ func (t *Transport) storeResponse(ctx context.Context, resp *http.Response) (typ
 	return sr.Ref, nil
 }
 
-func (t *Transport) storeSession(ctx context.Context, req, resp types.Ref) (types.Ref, error) {
+func (t *Transport) storeSession(ctx context.Context, sess *Session) (types.Ref, error) {
 	// store session schema
-	sr, err := t.s.StoreSchema(ctx, &Session{
-		Request:  req,
-		Response: resp,
-	})
+	sr, err := t.s.StoreSchema(ctx, sess)
 	if err != nil {
 		return types.Ref{}, err
 	}
@@ -259,19 +368,24 @@ func (t *Transport) requestMatches(req *http.Request, obj *Request) bool {
 			}
 		}
 	}
-	// TODO: cache expiration?
 	return true
 }
 
-// checkReqCache checks if this request is cached and returns a ref of the response, if any.
-func (t *Transport) checkReqCache(req *http.Request) (*Response, error) {
+// cachedResponse is a stored response that matches the request.
+type cachedResponse struct {
+	sessRef types.Ref
+	sess    *Session
+	resp    *Response
+}
+
+// checkReqCache finds the latest recorded response that matches the request, if any.
+func (t *Transport) checkReqCache(req *http.Request) (*cachedResponse, error) {
 	// in fact, we cannot use request ref because it might contain additional headers
 	// instead, we will check all request object and match them according to our rules
 	ctx := req.Context()
+	reqs := make(map[types.Ref]*Request)
 	it := t.s.IterateSchema(ctx, requestType)
 	defer it.Close()
-
-	var last error
 	for it.Next() {
 		obj, err := it.Decode()
 		if err != nil {
@@ -282,58 +396,87 @@ func (t *Transport) checkReqCache(req *http.Request) (*Response, error) {
 			return nil, fmt.Errorf("unexpected type: %T", obj)
 		}
 		if t.requestMatches(req, r) {
-			// it's not enough to match it, it should also have a response
-			respRef, err := t.findResponseFor(ctx, it.SizedRef().Ref)
-			if err != nil {
-				last = err
-				continue
-			} else if respRef.Zero() {
-				continue
-			}
-			// and response should exist
-			obj, err := t.s.DecodeSchema(ctx, respRef)
-			if err == schema.ErrNotSchema || err == storage.ErrNotFound {
-				continue
-			} else if err != nil {
-				return nil, fmt.Errorf("failed to decode response: %v", err)
-			}
-			resp, ok := obj.(*Response)
-			if !ok {
-				continue
-			}
-			return resp, nil
+			reqs[it.SizedRef().Ref] = r
 		}
 	}
-	return nil, last
-}
-
-func (t *Transport) findResponseFor(ctx context.Context, req types.Ref) (types.Ref, error) {
-	it := t.s.IterateSchema(ctx, sessionType)
+	if err := it.Err(); err != nil {
+		return nil, err
+	} else if len(reqs) == 0 {
+		return nil, nil
+	}
+	// it's not enough to match the request, it should also have a response
+	var (
+		best *cachedResponse
+		last error
+	)
+	it = t.s.IterateSchema(ctx, sessionType)
 	defer it.Close()
 	for it.Next() {
 		obj, err := it.Decode()
 		if err != nil {
-			return types.Ref{}, err
+			return nil, err
 		}
-		r, ok := obj.(*Session)
+		sess, ok := obj.(*Session)
 		if !ok {
-			return types.Ref{}, fmt.Errorf("unexpected type: %T", obj)
+			return nil, fmt.Errorf("unexpected type: %T", obj)
+		}
+		r, ok := reqs[sess.Request]
+		if !ok || (best != nil && !newerSession(sess, best.sess)) {
+			continue
 		}
-		if r.Request == req {
-			return r.Response, nil
+		// and response should exist
+		obj, err = t.s.DecodeSchema(ctx, sess.Response)
+		if err == schema.ErrNotSchema || err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			last = fmt.Errorf("failed to decode response: %v", err)
+			continue
 		}
+		resp, ok := obj.(*Response)
+		if !ok || !varyMatches(req, r, resp) {
+			continue
+		}
+		best = &cachedResponse{sessRef: it.SizedRef().Ref, sess: sess, resp: resp}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	} else if best != nil {
+		return best, nil
+	}
+	return nil, last
+}
+
+// newerSession checks if the session s1 was recorded after s2. Sessions without a time are considered the oldest.
+func newerSession(s1, s2 *Session) bool {
+	if s1.Received == nil {
+		return false
+	} else if s2.Received == nil {
+		return true
 	}
-	return types.Ref{}, nil
+	return s1.Received.After(*s2.Received)
 }
 
-func (t *Transport) reconstruct(ctx context.Context, r *Response) (*http.Response, error) {
+// reconstruct creates an HTTP response from a cached one. If age is set, the Age header is updated.
+func (t *Transport) reconstruct(ctx context.Context, c *cachedResponse, age bool) (*http.Response, error) {
+	r := c.resp
 	resp := &http.Response{
 		StatusCode:    r.Status,
 		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
-		Header:        http.Header(r.Header),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header(r.Header).Clone(),
 		Trailer:       http.Header(r.Trailer),
 		ContentLength: int64(r.Body.Size),
 	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	if age {
+		if _, cur, ok := freshness(r, c.sess, t.now()); ok {
+			resp.Header.Set("Age", strconv.FormatInt(int64(cur/time.Second), 10))
+		}
+	}
 	if r.Body.Ref.Zero() || r.Body.Ref.Empty() {
 		resp.Body = ioutil.NopCloser(bytes.NewReader(nil))
 	} else {
@@ -345,12 +488,3 @@ func (t *Transport) reconstruct(ctx context.Context, r *Response) (*http.Respons
 	}
 	return resp, nil
 }
-
-func (t *Transport) serveFromCache(req *http.Request) (*http.Response, error) {
-	ctx := req.Context()
-	resp, err := t.checkReqCache(req)
-	if err != nil || resp == nil {
-		return nil, err
-	}
-	return t.reconstruct(ctx, resp)
-}
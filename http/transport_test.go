@@ -0,0 +1,193 @@
+package cashttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+type failTransport struct{}
+
+func (failTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("network is not available")
+}
+
+func TestTransportCache(t *testing.T) {
+	s, err := cas.New(storage.NewInMemory())
+	require.NoError(t, err)
+
+	var (
+		mu    sync.Mutex
+		clock = time.Now().UTC().Truncate(time.Second)
+		hits  = make(map[string]int)
+	)
+	now := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	count := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		clock = clock.Add(d)
+		mu.Unlock()
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		date := clock
+		mu.Unlock()
+		h := w.Header()
+		h.Set("Date", date.Format(http.TimeFormat))
+		switch r.URL.Path {
+		case "/fresh":
+			h.Set("Cache-Control", "max-age=60")
+		case "/expires":
+			h.Set("Expires", date.Add(30*time.Second).Format(http.TimeFormat))
+		case "/etag":
+			h.Set("Cache-Control", "no-cache")
+			h.Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				h.Set("X-Check", fmt.Sprint(n))
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/nostore":
+			h.Set("Cache-Control", "no-store")
+		case "/vary":
+			h.Set("Cache-Control", "max-age=60")
+			h.Set("Vary", "Accept-Language")
+			fmt.Fprint(w, r.Header.Get("Accept-Language"))
+			return
+		}
+		fmt.Fprintf(w, "%s %s %d", r.Method, r.URL.Path, n)
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(s)
+	tr.now = now
+	cli := &http.Client{Transport: tr}
+
+	do := func(cli *http.Client, method, path string, hdr ...string) (*http.Response, string) {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		require.NoError(t, err)
+		for i := 0; i < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		resp, err := cli.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(data)
+	}
+
+	// fresh responses are served from the cache until they expire
+	_, body := do(cli, "GET", "/fresh")
+	require.Equal(t, "GET /fresh 1", body)
+	advance(10 * time.Second)
+	resp, body := do(cli, "GET", "/fresh")
+	require.Equal(t, "GET /fresh 1", body)
+	require.Equal(t, "10", resp.Header.Get("Age"))
+	_, body = do(cli, "GET", "/fresh", "Cache-Control", "no-cache")
+	require.Equal(t, "GET /fresh 2", body)
+	_, body = do(cli, "GET", "/fresh", "Cache-Control", "max-age=5")
+	require.Equal(t, "GET /fresh 2", body)
+	advance(2 * time.Minute)
+	_, body = do(cli, "GET", "/fresh")
+	require.Equal(t, "GET /fresh 3", body)
+
+	_, body = do(cli, "GET", "/expires")
+	require.Equal(t, "GET /expires 1", body)
+	advance(20 * time.Second)
+	_, body = do(cli, "GET", "/expires")
+	require.Equal(t, "GET /expires 1", body)
+	advance(20 * time.Second)
+	_, body = do(cli, "GET", "/expires")
+	require.Equal(t, "GET /expires 2", body)
+
+	// only GET and HEAD responses are reused
+	_, body = do(cli, "POST", "/post")
+	require.Equal(t, "POST /post 1", body)
+	advance(time.Second)
+	_, body = do(cli, "POST", "/post")
+	require.Equal(t, "POST /post 2", body)
+
+	_, body = do(cli, "GET", "/nostore")
+	require.Equal(t, "GET /nostore 1", body)
+	_, body = do(cli, "GET", "/nostore")
+	require.Equal(t, "GET /nostore 2", body)
+
+	_, body = do(cli, "GET", "/vary", "Accept-Language", "en")
+	require.Equal(t, "en", body)
+	_, body = do(cli, "GET", "/vary", "Accept-Language", "de")
+	require.Equal(t, "de", body)
+	_, body = do(cli, "GET", "/vary", "Accept-Language", "en")
+	require.Equal(t, "en", body)
+	require.Equal(t, 2, count("/vary"))
+
+	resp, _ = do(cli, "GET", "/vary", "Cache-Control", "only-if-cached")
+	require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+
+	// responses with validators are revalidated
+	_, body = do(cli, "GET", "/etag")
+	require.Equal(t, "GET /etag 1", body)
+	advance(time.Second)
+	resp, body = do(cli, "GET", "/etag")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "GET /etag 1", body)
+	require.Equal(t, "2", resp.Header.Get("X-Check"))
+	require.Equal(t, 2, count("/etag"))
+
+	sref, err := types.ParseRef(resp.Header.Get("X-CAS-Session-Ref"))
+	require.NoError(t, err)
+	obj, err := s.DecodeSchema(context.Background(), sref)
+	require.NoError(t, err)
+	sess := obj.(*Session)
+	require.NotNil(t, sess.Revalidates)
+	require.NotNil(t, sess.Received)
+
+	// the latest revalidated response is used
+	advance(time.Second)
+	resp, _ = do(cli, "GET", "/etag")
+	require.Equal(t, "3", resp.Header.Get("X-Check"))
+
+	// replay mode serves recorded responses regardless of their freshness
+	advance(time.Hour)
+	tr = NewTransport(s)
+	tr.SetTransport(failTransport{})
+	tr.ReplayOnly(true)
+	cli = &http.Client{Transport: tr}
+
+	_, body = do(cli, "GET", "/fresh")
+	require.Equal(t, "GET /fresh 3", body)
+	_, body = do(cli, "POST", "/post")
+	require.Equal(t, "POST /post 2", body)
+	resp, body = do(cli, "GET", "/etag")
+	require.Equal(t, "GET /etag 1", body)
+	require.Equal(t, "3", resp.Header.Get("X-Check"))
+
+	_, err = cli.Get(srv.URL + "/nostore")
+	require.True(t, errors.Is(err, ErrNotCached), "%v", err)
+	_, err = cli.Get(srv.URL + "/missing")
+	require.True(t, errors.Is(err, ErrNotCached), "%v", err)
+	require.Zero(t, count("/missing"))
+}
@@ -0,0 +1,31 @@
+package cas
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/dennwc/cas/storage"
+)
+
+// indexPinPrefix is a namespace of pins that index schema blobs by a key, thus lookups don't need to list all
+// schema blobs of a given type. Index pins are only written for entries that were verified, and are not listed
+// with other pins.
+const indexPinPrefix = storage.IndexPinPrefix
+
+// indexPin returns the name of an index pin for a given kind of index and a key.
+func indexPin(kind string, key ...string) string {
+	return indexPinPrefix + kind + storage.PinSeparator + strings.Join(key, storage.PinSeparator)
+}
+
+// refKey returns a key of the ref that can be used in index pin names.
+func refKey(ref Ref) string {
+	return ref.Name() + storage.PinSeparator + hex.EncodeToString(ref.Data())
+}
+
+func checkUserPin(name string) error {
+	if storage.IsIndexPin(name) {
+		return fmt.Errorf("pin names starting with %q are reserved", indexPinPrefix)
+	}
+	return nil
+}
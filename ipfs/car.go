@@ -0,0 +1,300 @@
+package ipfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// maxCARHeader limits the size of the CAR header.
+	maxCARHeader = 1 << 20
+	// MaxBlockSize limits the size of blocks read from CAR files.
+	MaxBlockSize = 4 << 20
+)
+
+// encodeCARHeader encodes a dag-cbor map {"roots": [...], "version": 1}.
+func encodeCARHeader(roots []CID) []byte {
+	var b []byte
+	b = append(b, 0xa2) // map(2); keys are sorted by length first
+	b = appendCBORHead(b, 3, 5)
+	b = append(b, "roots"...)
+	b = appendCBORHead(b, 4, uint64(len(roots)))
+	for _, c := range roots {
+		b = append(b, 0xd8, 42) // tag(42): CID
+		cb := c.Bytes()
+		b = appendCBORHead(b, 2, uint64(len(cb)+1))
+		b = append(b, 0) // multibase prefix for binary CIDs
+		b = append(b, cb...)
+	}
+	b = appendCBORHead(b, 3, 7)
+	b = append(b, "version"...)
+	b = appendCBORHead(b, 0, 1)
+	return b
+}
+
+func appendCBORHead(b []byte, major byte, v uint64) []byte {
+	major <<= 5
+	switch {
+	case v < 24:
+		return append(b, major|byte(v))
+	case v <= 0xff:
+		return append(b, major|24, byte(v))
+	case v <= 0xffff:
+		return append(b, major|25, byte(v>>8), byte(v))
+	case v <= 0xffffffff:
+		return append(b, major|26, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	b = append(b, major|27)
+	return binary.BigEndian.AppendUint64(b, v)
+}
+
+var errCBOR = errors.New("ipfs: invalid CAR header")
+
+// cborDecoder decodes the subset of dag-cbor used in CAR headers.
+type cborDecoder struct {
+	b []byte
+}
+
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if len(d.b) == 0 {
+		return 0, 0, errCBOR
+	}
+	major, info := d.b[0]>>5, d.b[0]&0x1f
+	d.b = d.b[1:]
+	if info < 24 {
+		return major, uint64(info), nil
+	} else if info > 27 {
+		return 0, 0, errCBOR
+	}
+	n := 1 << (info - 24)
+	if len(d.b) < n {
+		return 0, 0, errCBOR
+	}
+	var v uint64
+	for _, c := range d.b[:n] {
+		v = v<<8 | uint64(c)
+	}
+	d.b = d.b[n:]
+	return major, v, nil
+}
+
+// value decodes the next value. Maps are decoded to map[string]interface{}, CIDs to CID.
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > 16 {
+		return nil, errCBOR
+	}
+	major, v, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		return v, nil
+	case 2, 3:
+		if v > uint64(len(d.b)) {
+			return nil, errCBOR
+		}
+		data := d.b[:v]
+		d.b = d.b[v:]
+		if major == 3 {
+			return string(data), nil
+		}
+		return data, nil
+	case 4:
+		var out []interface{}
+		for i := uint64(0); i < v; i++ {
+			e, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, e)
+		}
+		return out, nil
+	case 5:
+		out := make(map[string]interface{})
+		for i := uint64(0); i < v; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, errCBOR
+			}
+			if out[ks], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case 6:
+		e, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		data, ok := e.([]byte)
+		if v != 42 || !ok || len(data) == 0 || data[0] != 0 {
+			return nil, errCBOR
+		}
+		c, n, err := DecodeCID(data[1:])
+		if err != nil {
+			return nil, err
+		} else if n != len(data)-1 {
+			return nil, errInvalidCID
+		}
+		return c, nil
+	}
+	return nil, errCBOR
+}
+
+// Writer writes a CARv1 file.
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter writes a CAR header with given roots and returns a writer for blocks.
+func NewWriter(w io.Writer, roots []CID) (*Writer, error) {
+	cw := &Writer{w: w}
+	if err := cw.write(encodeCARHeader(roots)); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (w *Writer) write(parts ...[]byte) error {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	w.buf = binary.AppendUvarint(w.buf[:0], uint64(n))
+	for _, p := range append([][]byte{w.buf}, parts...) {
+		if _, err := w.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBlock writes a block. The caller is responsible for the CID being correct.
+func (w *Writer) WriteBlock(c CID, data []byte) error {
+	return w.write(c.Bytes(), data)
+}
+
+type blockPos struct {
+	off  int64
+	size int
+}
+
+// CAR is an indexed CARv1 file.
+type CAR struct {
+	Roots  []CID
+	r      io.ReaderAt
+	blocks map[CID]blockPos
+}
+
+// countingReader counts bytes that were read from the underlying reader.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *countingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// OpenCAR reads a header of a CARv1 file and indexes its blocks. Content of blocks is read on demand.
+func OpenCAR(r io.ReaderAt, size int64) (*CAR, error) {
+	cr := &countingReader{r: bufio.NewReader(io.NewSectionReader(r, 0, size))}
+	hlen, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs: cannot read CAR header: %v", err)
+	} else if hlen > maxCARHeader {
+		return nil, errors.New("ipfs: CAR header is too large")
+	}
+	hdr := make([]byte, hlen)
+	if _, err = io.ReadFull(cr, hdr); err != nil {
+		return nil, fmt.Errorf("ipfs: cannot read CAR header: %v", err)
+	}
+	d := &cborDecoder{b: hdr}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errCBOR
+	}
+	if ver, _ := m["version"].(uint64); ver != 1 {
+		return nil, fmt.Errorf("ipfs: unsupported CAR version: %v", m["version"])
+	}
+	c := &CAR{r: r, blocks: make(map[CID]blockPos)}
+	roots, _ := m["roots"].([]interface{})
+	for _, e := range roots {
+		id, ok := e.(CID)
+		if !ok {
+			return nil, errCBOR
+		}
+		c.Roots = append(c.Roots, id)
+	}
+	for {
+		n, err := binary.ReadUvarint(cr)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		start := cr.n
+		// CIDs are short, but their length is only known after parsing
+		peek, _ := cr.r.Peek(128)
+		id, clen, err := DecodeCID(peek)
+		if err != nil {
+			return nil, err
+		} else if uint64(clen) > n || n-uint64(clen) > MaxBlockSize {
+			return nil, fmt.Errorf("ipfs: invalid block size in CAR: %d", n)
+		}
+		if _, err = cr.r.Discard(int(n)); err != nil {
+			return nil, fmt.Errorf("ipfs: truncated CAR file: %v", err)
+		}
+		cr.n += int64(n)
+		if _, ok := c.blocks[id]; !ok {
+			c.blocks[id] = blockPos{off: start + int64(clen), size: int(n) - clen}
+		}
+	}
+	return c, nil
+}
+
+// ErrNotFound is returned when a block is not in the CAR file.
+var ErrNotFound = errors.New("ipfs: block not found")
+
+// Block reads and verifies a block with a given CID.
+func (c *CAR) Block(id CID) ([]byte, error) {
+	if hash, digest := id.Hash(); hash == HashIdentity {
+		return digest, nil
+	}
+	pos, ok := c.blocks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	data := make([]byte, pos.size)
+	// ReadAt may return io.EOF for the last block in the file
+	if n, err := c.r.ReadAt(data, pos.off); n != len(data) {
+		return nil, err
+	}
+	if err := id.Verify(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,139 @@
+// Package ipfs implements the subset of IPFS formats needed to exchange files offline:
+// content identifiers (CIDs), dag-pb nodes with UnixFS data and CARv1 archives.
+package ipfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Multicodec codes of content types.
+const (
+	CodecRaw     = 0x55
+	CodecDagPB   = 0x70
+	CodecDagCBOR = 0x71
+)
+
+// Multihash codes.
+const (
+	HashIdentity = 0x00
+	HashSHA256   = 0x12
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// CID is a content identifier. Version 0 CIDs are converted to version 1, thus CIDs of the same block are equal.
+type CID struct {
+	b string // binary CIDv1
+}
+
+// NewCID creates a version 1 CID from a multihash code and a digest.
+func NewCID(codec, hash uint64, digest []byte) CID {
+	buf := make([]byte, 0, 4*binary.MaxVarintLen64+len(digest))
+	buf = binary.AppendUvarint(buf, 1)
+	buf = binary.AppendUvarint(buf, codec)
+	buf = binary.AppendUvarint(buf, hash)
+	buf = binary.AppendUvarint(buf, uint64(len(digest)))
+	buf = append(buf, digest...)
+	return CID{b: string(buf)}
+}
+
+// SumCID computes a SHA-256 CID of a block.
+func SumCID(codec uint64, data []byte) CID {
+	h := sha256.Sum256(data)
+	return NewCID(codec, HashSHA256, h[:])
+}
+
+// Defined checks if the CID is set.
+func (c CID) Defined() bool {
+	return c.b != ""
+}
+
+// Bytes returns a binary form of the CID.
+func (c CID) Bytes() []byte {
+	return []byte(c.b)
+}
+
+// String returns the CID encoded with base32 multibase, as the IPFS tools do for CIDv1.
+func (c CID) String() string {
+	if !c.Defined() {
+		return ""
+	}
+	return "b" + strings.ToLower(b32.EncodeToString([]byte(c.b)))
+}
+
+// parts splits the CID to the codec, the multihash code and the digest.
+func (c CID) parts() (codec, hash uint64, digest []byte) {
+	b := []byte(c.b)
+	_, n := binary.Uvarint(b)
+	b = b[n:]
+	codec, n = binary.Uvarint(b)
+	b = b[n:]
+	hash, n = binary.Uvarint(b)
+	b = b[n:]
+	_, n = binary.Uvarint(b)
+	return codec, hash, b[n:]
+}
+
+// Codec returns the multicodec of the content.
+func (c CID) Codec() uint64 {
+	codec, _, _ := c.parts()
+	return codec
+}
+
+// Hash returns the multihash code and the digest.
+func (c CID) Hash() (uint64, []byte) {
+	_, hash, digest := c.parts()
+	return hash, digest
+}
+
+// Verify checks that the data matches the CID.
+func (c CID) Verify(data []byte) error {
+	hash, digest := c.Hash()
+	switch hash {
+	case HashIdentity:
+		if !bytes.Equal(digest, data) {
+			return fmt.Errorf("ipfs: block %v does not match", c)
+		}
+	case HashSHA256:
+		if h := sha256.Sum256(data); !bytes.Equal(digest, h[:]) {
+			return fmt.Errorf("ipfs: block %v does not match", c)
+		}
+	default:
+		return fmt.Errorf("ipfs: unsupported multihash: 0x%x", hash)
+	}
+	return nil
+}
+
+var errInvalidCID = errors.New("ipfs: invalid CID")
+
+// DecodeCID decodes a binary CID and returns the number of bytes it occupies.
+func DecodeCID(b []byte) (CID, int, error) {
+	if len(b) >= 34 && b[0] == HashSHA256 && b[1] == sha256.Size {
+		// CIDv0 is a bare SHA-256 multihash of a dag-pb block
+		return NewCID(CodecDagPB, HashSHA256, b[2:34]), 34, nil
+	}
+	off := 0
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(b[off:])
+		if n <= 0 {
+			return 0, false
+		}
+		off += n
+		return v, true
+	}
+	ver, ok1 := next()
+	codec, ok2 := next()
+	hash, ok3 := next()
+	size, ok4 := next()
+	if !ok1 || !ok2 || !ok3 || !ok4 || ver != 1 || size > uint64(len(b)-off) {
+		return CID{}, 0, errInvalidCID
+	}
+	digest := b[off : off+int(size)]
+	return NewCID(codec, hash, digest), off + int(size), nil
+}
@@ -0,0 +1,257 @@
+package ipfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Link is a link of a dag-pb node.
+type Link struct {
+	CID  CID
+	Name string
+	// Size is the total size of blocks of the linked DAG.
+	Size uint64
+}
+
+// Node is a dag-pb node.
+type Node struct {
+	Links []Link
+	Data  []byte
+}
+
+// Protobuf wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendKey(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	b = appendKey(b, field, wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+var errProto = errors.New("ipfs: invalid protobuf message")
+
+// readFields calls fnc for each field of a protobuf message. Only varint and length-delimited fields are supported.
+func readFields(b []byte, fnc func(field int, v uint64, data []byte) error) error {
+	for len(b) != 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProto
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		var (
+			v    uint64
+			data []byte
+		)
+		switch key & 7 {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errProto
+			}
+			b = b[n:]
+		case wireBytes:
+			if v, n = binary.Uvarint(b); n <= 0 || v > uint64(len(b)-n) {
+				return errProto
+			}
+			data = b[n : n+int(v)]
+			b = b[n+int(v):]
+		default:
+			return errProto
+		}
+		if err := fnc(field, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SortLinks sorts links by name, as required by the dag-pb format.
+func (n *Node) SortLinks() {
+	sort.SliceStable(n.Links, func(i, j int) bool {
+		return n.Links[i].Name < n.Links[j].Name
+	})
+}
+
+// Encode returns a dag-pb block of the node.
+func (n *Node) Encode() []byte {
+	var b []byte
+	// links are written before the data
+	for _, l := range n.Links {
+		var lb []byte
+		lb = appendBytes(lb, 1, l.CID.Bytes())
+		lb = appendBytes(lb, 2, []byte(l.Name))
+		lb = appendVarint(lb, 3, l.Size)
+		b = appendBytes(b, 2, lb)
+	}
+	if n.Data != nil {
+		b = appendBytes(b, 1, n.Data)
+	}
+	return b
+}
+
+// DecodeNode decodes a dag-pb block.
+func DecodeNode(b []byte) (*Node, error) {
+	n := &Node{}
+	err := readFields(b, func(field int, _ uint64, data []byte) error {
+		switch field {
+		case 1:
+			if data == nil {
+				return errProto
+			}
+			n.Data = data
+		case 2:
+			var l Link
+			err := readFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					c, sz, err := DecodeCID(data)
+					if err != nil {
+						return err
+					} else if sz != len(data) {
+						return errInvalidCID
+					}
+					l.CID = c
+				case 2:
+					l.Name = string(data)
+				case 3:
+					l.Size = v
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			} else if !l.CID.Defined() {
+				return errors.New("ipfs: link without a CID")
+			}
+			n.Links = append(n.Links, l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// UnixFS data types.
+const (
+	TypeRaw       = 0
+	TypeDirectory = 1
+	TypeFile      = 2
+	TypeMetadata  = 3
+	TypeSymlink   = 4
+	TypeHAMTShard = 5
+)
+
+// UnixFS is a UnixFS message stored in the data of dag-pb nodes.
+type UnixFS struct {
+	Type int
+	Data []byte
+	// FileSize is the size of the file content of the node, including its children.
+	FileSize   uint64
+	BlockSizes []uint64
+	HashType   uint64
+	Fanout     uint64
+	// Mode contains Unix permission bits. It's zero if it's not set.
+	Mode uint32
+}
+
+// Encode returns a protobuf encoding of the UnixFS data.
+func (u *UnixFS) Encode() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(u.Type))
+	if u.Data != nil {
+		b = appendBytes(b, 2, u.Data)
+	}
+	if u.Type == TypeFile || u.Type == TypeRaw {
+		b = appendVarint(b, 3, u.FileSize)
+	}
+	for _, sz := range u.BlockSizes {
+		b = appendVarint(b, 4, sz)
+	}
+	if u.Type == TypeHAMTShard {
+		b = appendVarint(b, 5, u.HashType)
+		b = appendVarint(b, 6, u.Fanout)
+	}
+	if u.Mode != 0 {
+		b = appendVarint(b, 7, uint64(u.Mode))
+	}
+	return b
+}
+
+// DecodeUnixFS decodes UnixFS data of a dag-pb node.
+func DecodeUnixFS(b []byte) (*UnixFS, error) {
+	u := &UnixFS{Type: -1}
+	err := readFields(b, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			u.Type = int(v)
+		case 2:
+			u.Data = data
+		case 3:
+			u.FileSize = v
+		case 4:
+			if data != nil {
+				// packed encoding
+				return readPacked(data, func(v uint64) {
+					u.BlockSizes = append(u.BlockSizes, v)
+				})
+			}
+			u.BlockSizes = append(u.BlockSizes, v)
+		case 5:
+			u.HashType = v
+		case 6:
+			u.Fanout = v
+		case 7:
+			u.Mode = uint32(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if u.Type < TypeRaw || u.Type > TypeHAMTShard {
+		return nil, errors.New("ipfs: invalid UnixFS data type")
+	}
+	return u, nil
+}
+
+func readPacked(b []byte, fnc func(v uint64)) error {
+	for len(b) != 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProto
+		}
+		fnc(v)
+		b = b[n:]
+	}
+	return nil
+}
+
+// Default parameters of the UnixFS file layout used by IPFS tools.
+const (
+	ChunkSize = 256 << 10
+	MaxLinks  = 174
+)
+
+// FileNode encodes an intermediate node of a file DAG. Sizes are sizes of the file content under each link.
+func FileNode(links []Link, sizes []uint64) []byte {
+	u := &UnixFS{Type: TypeFile, BlockSizes: sizes}
+	for _, sz := range sizes {
+		u.FileSize += sz
+	}
+	n := &Node{Links: links, Data: u.Encode()}
+	return n.Encode()
+}
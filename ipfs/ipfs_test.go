@@ -0,0 +1,105 @@
+package ipfs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCID(t *testing.T) {
+	c := SumCID(CodecRaw, nil)
+	require.Equal(t, "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku", c.String())
+
+	dir := (&Node{Data: (&UnixFS{Type: TypeDirectory}).Encode()}).Encode()
+	require.Equal(t, []byte{0x0a, 0x02, 0x08, 0x01}, dir)
+	d := SumCID(CodecDagPB, dir)
+	require.Equal(t, "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354", d.String())
+
+	// CIDv0 is normalized to v1
+	_, digest := d.Hash()
+	v0 := append([]byte{HashSHA256, 32}, digest...)
+	c2, n, err := DecodeCID(append(v0, 0xff))
+	require.NoError(t, err)
+	require.Equal(t, 34, n)
+	require.Equal(t, d, c2)
+
+	c2, n, err = DecodeCID(c.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, len(c.Bytes()), n)
+	require.Equal(t, c, c2)
+	require.Equal(t, uint64(CodecRaw), c2.Codec())
+
+	require.NoError(t, d.Verify(dir))
+	require.Error(t, d.Verify(nil))
+}
+
+func TestNode(t *testing.T) {
+	a := SumCID(CodecRaw, []byte("a"))
+	b := SumCID(CodecRaw, []byte("b"))
+	n := &Node{
+		Links: []Link{{CID: b, Name: "b", Size: 1}, {CID: a, Name: "a", Size: 1}},
+		Data:  (&UnixFS{Type: TypeDirectory, Mode: 0755}).Encode(),
+	}
+	n.SortLinks()
+	require.Equal(t, "a", n.Links[0].Name)
+
+	n2, err := DecodeNode(n.Encode())
+	require.NoError(t, err)
+	require.Equal(t, n, n2)
+
+	u, err := DecodeUnixFS(n2.Data)
+	require.NoError(t, err)
+	require.Equal(t, &UnixFS{Type: TypeDirectory, Mode: 0755}, u)
+
+	n2, err = DecodeNode(FileNode(n.Links, []uint64{1, 1}))
+	require.NoError(t, err)
+	u, err = DecodeUnixFS(n2.Data)
+	require.NoError(t, err)
+	require.Equal(t, &UnixFS{Type: TypeFile, FileSize: 2, BlockSizes: []uint64{1, 1}}, u)
+
+	_, err = DecodeNode([]byte{0x12, 0x05, 0x0a})
+	require.Error(t, err)
+}
+
+func TestCAR(t *testing.T) {
+	blocks := [][]byte{[]byte("a"), []byte("bc"), nil}
+	var ids []CID
+	for _, b := range blocks {
+		ids = append(ids, SumCID(CodecRaw, b))
+	}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, ids[:1])
+	require.NoError(t, err)
+	for i, b := range blocks {
+		require.NoError(t, w.WriteBlock(ids[i], b))
+	}
+	data := buf.Bytes()
+
+	c, err := OpenCAR(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Equal(t, ids[:1], c.Roots)
+	for i, id := range ids {
+		b, err := c.Block(id)
+		require.NoError(t, err)
+		require.Equal(t, string(blocks[i]), string(b))
+	}
+	_, err = c.Block(SumCID(CodecRaw, []byte("d")))
+	require.Equal(t, ErrNotFound, err)
+
+	id := NewCID(CodecRaw, HashIdentity, []byte("inline"))
+	b, err := c.Block(id)
+	require.NoError(t, err)
+	require.Equal(t, "inline", string(b))
+
+	// corrupted blocks are detected
+	i := bytes.LastIndex(data, []byte("bc"))
+	data[i] = 'x'
+	c, err = OpenCAR(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	_, err = c.Block(ids[1])
+	require.Error(t, err)
+
+	_, err = OpenCAR(bytes.NewReader(data[:len(data)-1]), int64(len(data)-1))
+	require.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+)
+
+// MergeConflict describes a path that was changed differently in both merged trees.
+// Entries are nil if the path doesn't exist in a given tree.
+type MergeConflict struct {
+	Path   string
+	Base   *schema.DirEntry
+	Ours   *schema.DirEntry
+	Theirs *schema.DirEntry
+}
+
+// MergeBase finds the most recent common ancestor of two commits. It returns a zero ref if there is none.
+func (s *Storage) MergeBase(ctx context.Context, a, b Ref) (Ref, error) {
+	seen := make(map[Ref]struct{})
+	err := s.Log(ctx, a, func(ref Ref, _ *schema.Commit) error {
+		seen[ref] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return Ref{}, err
+	}
+	var base Ref
+	err = s.Log(ctx, b, func(ref Ref, _ *schema.Commit) error {
+		if _, ok := seen[ref]; ok {
+			base = ref
+			return errStopLog
+		}
+		return nil
+	})
+	if err != nil && err != errStopLog {
+		return Ref{}, err
+	}
+	return base, nil
+}
+
+// errStopLog is used to stop Log iteration early.
+var errStopLog = errors.New("stop")
+
+// MergeTrees performs a three-way merge of directory trees. Changes made in ours and theirs relative to base
+// are combined into a new tree; refs of subtrees that were changed only on one side are reused as is.
+// Base may be a zero ref, if trees have no common ancestor.
+//
+// Paths that were changed differently on both sides are reported as conflicts. For those paths the merged tree
+// contains the entry from ours, or the entry from theirs if the path was deleted in ours.
+func (s *Storage) MergeTrees(ctx context.Context, base, ours, theirs Ref) (SizedRef, []MergeConflict, error) {
+	m := &treeMerger{s: s}
+	sr, err := m.mergeDir(ctx, "", base, ours, theirs)
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	return sr, m.conflicts, nil
+}
+
+type treeMerger struct {
+	s         *Storage
+	conflicts []MergeConflict
+}
+
+// readEntries reads all entries of a directory. Zero ref is interpreted as an empty directory.
+func (m *treeMerger) readEntries(ctx context.Context, ref Ref) (map[string]*schema.DirEntry, error) {
+	out := make(map[string]*schema.DirEntry)
+	if ref.Zero() {
+		return out, nil
+	}
+	err := m.s.readDir(ctx, ref, func(ent *schema.DirEntry) error {
+		e := *ent
+		out[e.Name] = &e
+		return nil
+	})
+	return out, err
+}
+
+// isDir checks if the entry describes a directory.
+func (m *treeMerger) isDir(ctx context.Context, ent *schema.DirEntry) (bool, error) {
+	if ent == nil || ent.IsSymlink() {
+		return false, nil
+	} else if ent.Mode != 0 {
+		return ent.Mode&schema.ModeType == schema.ModeDir, nil
+	}
+	obj, err := m.s.DecodeSchema(ctx, ent.Ref)
+	if err == schema.ErrNotSchema {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return storage.IsDirObject(obj), nil
+}
+
+// sameEntry checks if two entries describe the same content. Modification time is ignored.
+func sameEntry(a, b *schema.DirEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Ref == b.Ref && a.Mode == b.Mode && a.Link == b.Link
+}
+
+func (m *treeMerger) mergeDir(ctx context.Context, dir string, base, ours, theirs Ref) (SizedRef, error) {
+	switch {
+	case ours == theirs || base == theirs:
+		return m.dirRef(ctx, ours)
+	case base == ours:
+		return m.dirRef(ctx, theirs)
+	}
+	bents, err := m.readEntries(ctx, base)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	oents, err := m.readEntries(ctx, ours)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	tents, err := m.readEntries(ctx, theirs)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	names := make(map[string]struct{}, len(oents)+len(tents))
+	for _, ents := range []map[string]*schema.DirEntry{bents, oents, tents} {
+		for name := range ents {
+			names[name] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var out []schema.DirEntry
+	for _, name := range sorted {
+		b, o, t := bents[name], oents[name], tents[name]
+		var res *schema.DirEntry
+		switch {
+		case sameEntry(o, t), sameEntry(b, t):
+			res = o
+		case sameEntry(b, o):
+			res = t
+		default:
+			res, err = m.mergeEntry(ctx, path.Join(dir, name), b, o, t)
+			if err != nil {
+				return SizedRef{}, err
+			}
+		}
+		if res != nil {
+			out = append(out, *res)
+		}
+	}
+	sr, _, err := m.s.storeDirEntries(ctx, out)
+	return sr, err
+}
+
+// mergeEntry merges an entry that was changed on both sides. Directories are merged recursively,
+// and other entries are reported as conflicts.
+func (m *treeMerger) mergeEntry(ctx context.Context, name string, b, o, t *schema.DirEntry) (*schema.DirEntry, error) {
+	odir, err := m.isDir(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	tdir, err := m.isDir(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	if odir && tdir {
+		var bref Ref
+		if bdir, err := m.isDir(ctx, b); err != nil {
+			return nil, err
+		} else if bdir {
+			bref = b.Ref
+		}
+		sr, err := m.mergeDir(ctx, name, bref, o.Ref, t.Ref)
+		if err != nil {
+			return nil, err
+		}
+		res := *o
+		res.Ref = sr.Ref
+		return &res, m.fixDirStats(ctx, &res)
+	}
+	m.conflicts = append(m.conflicts, MergeConflict{Path: name, Base: b, Ours: o, Theirs: t})
+	if o == nil {
+		return t, nil
+	}
+	return o, nil
+}
+
+// fixDirStats recomputes stats of a merged directory entry.
+func (m *treeMerger) fixDirStats(ctx context.Context, ent *schema.DirEntry) error {
+	obj, err := m.s.DecodeSchema(ctx, ent.Ref)
+	if err != nil {
+		return err
+	}
+	switch obj := obj.(type) {
+	case *schema.InlineList:
+		ent.Stats = obj.Stats
+	case *schema.List:
+		ent.Stats = obj.Stats
+	}
+	return nil
+}
+
+// dirRef returns a sized ref of an existing directory.
+func (m *treeMerger) dirRef(ctx context.Context, ref Ref) (SizedRef, error) {
+	if ref.Zero() {
+		sr, _, err := m.s.storeDirList(ctx, nil)
+		return sr, err
+	}
+	sz, err := m.s.StatBlob(ctx, ref)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	return SizedRef{Ref: ref, Size: sz}, nil
+}
@@ -0,0 +1,156 @@
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+)
+
+func storeTestTree(t testing.TB, s *Storage, files map[string]string) Ref {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for name, data := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))})
+		require.NoError(t, err)
+		_, err = tw.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	sr, err := s.ImportTar(context.Background(), buf, nil)
+	require.NoError(t, err)
+	return sr.Ref
+}
+
+func readTestTree(t testing.TB, s *Storage, ref Ref) map[string]string {
+	ctx := context.Background()
+	out := make(map[string]string)
+	var walk func(dir string, ref Ref)
+	walk = func(dir string, ref Ref) {
+		err := s.readDir(ctx, ref, func(ent *schema.DirEntry) error {
+			name := path.Join(dir, ent.Name)
+			if ent.Mode&schema.ModeType == schema.ModeDir {
+				walk(name, ent.Ref)
+				return nil
+			}
+			rc, _, err := s.openContent(ctx, ent.Ref)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			data, err := ioutil.ReadAll(rc)
+			if err != nil {
+				return err
+			}
+			out[name] = string(data)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	walk("", ref)
+	return out
+}
+
+func TestMergeTrees(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	base := storeTestTree(t, s, map[string]string{
+		"a.txt":       "a",
+		"b.txt":       "b",
+		"c.txt":       "c",
+		"dir/x.txt":   "x",
+		"dir/y.txt":   "y",
+		"other/z.txt": "z",
+	})
+	ours := storeTestTree(t, s, map[string]string{
+		"a.txt":       "a2", // changed
+		"b.txt":       "b",
+		"c.txt":       "c-ours", // conflict
+		"dir/x.txt":   "x2",     // changed
+		"dir/y.txt":   "y",
+		"other/z.txt": "z",
+		"new.txt":     "new", // added
+	})
+	theirs := storeTestTree(t, s, map[string]string{
+		"a.txt":       "a",
+		"c.txt":       "c-theirs", // conflict; b.txt is deleted
+		"dir/x.txt":   "x",
+		"dir/y.txt":   "y2", // changed in the same dir
+		"other/z.txt": "z2", // changed
+	})
+
+	tree, conflicts, err := s.MergeTrees(ctx, base, ours, theirs)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "c.txt", conflicts[0].Path)
+	require.NotNil(t, conflicts[0].Base)
+	require.NotNil(t, conflicts[0].Ours)
+	require.NotNil(t, conflicts[0].Theirs)
+
+	require.Equal(t, map[string]string{
+		"a.txt":       "a2",
+		"c.txt":       "c-ours",
+		"dir/x.txt":   "x2",
+		"dir/y.txt":   "y2",
+		"other/z.txt": "z2",
+		"new.txt":     "new",
+	}, readTestTree(t, s, tree.Ref))
+
+	// unchanged subtrees are reused
+	var tdir, odir Ref
+	err = s.readDir(ctx, theirs, func(ent *schema.DirEntry) error {
+		if ent.Name == "other" {
+			tdir = ent.Ref
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = s.readDir(ctx, tree.Ref, func(ent *schema.DirEntry) error {
+		if ent.Name == "other" {
+			odir = ent.Ref
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, tdir, odir)
+
+	// trivial merges
+	tree, conflicts, err = s.MergeTrees(ctx, base, base, theirs)
+	require.NoError(t, err)
+	require.Empty(t, conflicts)
+	require.Equal(t, theirs, tree.Ref)
+}
+
+func TestMergeBase(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(pin string, files map[string]string, hours int) Ref {
+		tm := ts.Add(time.Duration(hours) * time.Hour)
+		sr, err := s.CommitPin(ctx, pin, schema.Commit{Tree: storeTestTree(t, s, files), Time: &tm})
+		require.NoError(t, err)
+		return sr.Ref
+	}
+	root := commit("a", map[string]string{"f": "0"}, 0)
+	require.NoError(t, s.SetPin(ctx, "b", root))
+	commit("a", map[string]string{"f": "1"}, 1)
+	a := commit("a", map[string]string{"f": "2"}, 2)
+	b := commit("b", map[string]string{"f": "3"}, 3)
+
+	base, err := s.MergeBase(ctx, a, b)
+	require.NoError(t, err)
+	require.Equal(t, root, base)
+
+	base, err = s.MergeBase(ctx, a, root)
+	require.NoError(t, err)
+	require.Equal(t, root, base)
+}
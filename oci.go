@@ -0,0 +1,567 @@
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// OCI image layout files, see https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+const (
+	ociLayoutFile    = "oci-layout"
+	ociIndexFile     = "index.json"
+	ociBlobsDir      = "blobs"
+	ociLayoutVersion = "1.0.0"
+
+	// maxOCIManifestSize limits the size of manifests and indexes that are loaded into memory.
+	maxOCIManifestSize = 4 << 20
+)
+
+// OCI and Docker media types that are recognized when walking an image.
+const (
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerImage  = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerLayer  = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	mediaTypeOCILayerPref = "application/vnd.oci.image.layer."
+)
+
+// OCI whiteout markers in image layers.
+const (
+	ociWhiteoutPrefix = ".wh."
+	ociWhiteoutOpaque = ".wh..wh..opq"
+)
+
+type ociPlatform struct {
+	Arch    string `json:"architecture"`
+	OS      string `json:"os"`
+	Variant string `json:"variant,omitempty"`
+}
+
+func (p *ociPlatform) String() string {
+	if p == nil {
+		return ""
+	}
+	s := p.OS + "/" + p.Arch
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+// ref parses the digest of the descriptor as a ref. OCI digests use the same text form as refs.
+func (d *ociDescriptor) ref() (SizedRef, error) {
+	ref, err := types.ParseRef(d.Digest)
+	if err != nil {
+		return SizedRef{}, fmt.Errorf("unsupported digest %q: %v", d.Digest, err)
+	} else if ref.Zero() || d.Size < 0 {
+		return SizedRef{}, fmt.Errorf("invalid descriptor: %q", d.Digest)
+	}
+	return SizedRef{Ref: ref, Size: uint64(d.Size)}, nil
+}
+
+// ociManifest is a union of an image index and an image manifest.
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests,omitempty"`
+	Config        *ociDescriptor  `json:"config,omitempty"`
+	Layers        []ociDescriptor `json:"layers,omitempty"`
+	Subject       *ociDescriptor  `json:"subject,omitempty"`
+}
+
+func (m *ociManifest) isIndex() bool {
+	return m.Config == nil && (m.Manifests != nil || m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerList)
+}
+
+// children returns descriptors of all blobs referenced by the manifest.
+func (m *ociManifest) children() []ociDescriptor {
+	var out []ociDescriptor
+	out = append(out, m.Manifests...)
+	if m.Config != nil {
+		out = append(out, *m.Config)
+	}
+	out = append(out, m.Layers...)
+	if m.Subject != nil {
+		out = append(out, *m.Subject)
+	}
+	return out
+}
+
+// isOCIManifest checks if a descriptor points to an index or a manifest that references other blobs.
+func isOCIManifest(mediaType string) bool {
+	switch mediaType {
+	case mediaTypeOCIIndex, mediaTypeOCIManifest, mediaTypeDockerList, mediaTypeDockerImage:
+		return true
+	}
+	return false
+}
+
+// OCIImage describes a single image manifest in an OCI index.
+type OCIImage struct {
+	Manifest SizedRef
+	Platform string // os/arch[/variant], if set in the index
+}
+
+func (s *Storage) readOCIManifest(ctx context.Context, ref Ref) (*ociManifest, []byte, error) {
+	rc, sz, err := s.FetchBlob(ctx, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	if sz > maxOCIManifestSize {
+		return nil, nil, fmt.Errorf("manifest %v is too large: %d bytes", ref, sz)
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, maxOCIManifestSize+1))
+	if err != nil {
+		return nil, nil, err
+	} else if len(data) > maxOCIManifestSize {
+		return nil, nil, fmt.Errorf("manifest %v is too large", ref)
+	}
+	var m ociManifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, nil, fmt.Errorf("cannot decode manifest %v: %v", ref, err)
+	}
+	return &m, data, nil
+}
+
+// walkOCI calls fnc for each blob reachable from the manifest, including the manifest itself.
+// Each blob is visited only once, manifests are visited before their references.
+func (s *Storage) walkOCI(ctx context.Context, root ociDescriptor, fnc func(d ociDescriptor, sr SizedRef) error) error {
+	seen := make(map[Ref]struct{})
+	var walk func(d ociDescriptor) error
+	walk = func(d ociDescriptor) error {
+		sr, err := d.ref()
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[sr.Ref]; ok {
+			return nil
+		}
+		seen[sr.Ref] = struct{}{}
+		if err = fnc(d, sr); err != nil {
+			return err
+		}
+		if !isOCIManifest(d.MediaType) {
+			return nil
+		}
+		m, _, err := s.readOCIManifest(ctx, sr.Ref)
+		if err != nil {
+			return err
+		}
+		for _, c := range m.children() {
+			if err = walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// ociRootDescriptor returns a descriptor for a stored index or manifest.
+func (s *Storage) ociRootDescriptor(ctx context.Context, ref Ref) (ociDescriptor, *ociManifest, []byte, error) {
+	m, data, err := s.readOCIManifest(ctx, ref)
+	if err != nil {
+		return ociDescriptor{}, nil, nil, err
+	}
+	d := ociDescriptor{MediaType: m.MediaType, Digest: ref.String(), Size: int64(len(data))}
+	if d.MediaType == "" {
+		d.MediaType = mediaTypeOCIManifest
+		if m.isIndex() {
+			d.MediaType = mediaTypeOCIIndex
+		}
+	}
+	return d, m, data, nil
+}
+
+// storeOCIBlob stores a blob from an OCI layout. The content must match the digest in the blob path.
+func (s *Storage) storeOCIBlob(ctx context.Context, name string, r io.Reader, size int64) error {
+	alg, enc := path.Split(strings.TrimPrefix(name, ociBlobsDir+"/"))
+	ref, err := types.ParseRef(strings.TrimSuffix(alg, "/") + ":" + enc)
+	if err != nil {
+		return fmt.Errorf("unexpected blob %q: %v", name, err)
+	}
+	_, err = s.StoreBlob(ctx, r, &StoreConfig{
+		Hash:   ref.Name(),
+		Expect: SizedRef{Ref: ref, Size: uint64(size)},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot store %q: %v", name, err)
+	}
+	return nil
+}
+
+// storeOCIIndex checks that all blobs referenced by the index are present and stores the index itself.
+func (s *Storage) storeOCIIndex(ctx context.Context, data []byte) (SizedRef, error) {
+	var m ociManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return SizedRef{}, fmt.Errorf("cannot decode %s: %v", ociIndexFile, err)
+	}
+	for _, c := range m.Manifests {
+		err := s.walkOCI(ctx, c, func(d ociDescriptor, sr SizedRef) error {
+			sz, err := s.StatBlob(ctx, sr.Ref)
+			if err == storage.ErrNotFound {
+				return fmt.Errorf("blob %v is missing in the layout", sr.Ref)
+			} else if err != nil {
+				return err
+			} else if sz != sr.Size {
+				return storage.ErrSizeMissmatch{Exp: sr.Size, Got: sz}
+			}
+			return nil
+		})
+		if err != nil {
+			return SizedRef{}, err
+		}
+	}
+	return s.StoreBlob(ctx, bytes.NewReader(data), &StoreConfig{Hash: "sha256"})
+}
+
+// ImportOCIDir stores all blobs of an OCI image layout directory under their digests.
+// It returns a ref of the stored index.json that can be passed to ExportOCIDir or ExportOCITar.
+func (s *Storage) ImportOCIDir(ctx context.Context, dir string) (SizedRef, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ociIndexFile))
+	if err != nil {
+		return SizedRef{}, err
+	}
+	root := filepath.Join(dir, ociBlobsDir)
+	err = filepath.Walk(root, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(fpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return s.storeOCIBlob(ctx, filepath.ToSlash(rel), f, fi.Size())
+	})
+	if err != nil {
+		return SizedRef{}, err
+	}
+	return s.storeOCIIndex(ctx, data)
+}
+
+// ImportOCITar is the same as ImportOCIDir, but reads the layout from a tar stream.
+func (s *Storage) ImportOCITar(ctx context.Context, r io.Reader) (SizedRef, error) {
+	var index []byte
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return SizedRef{}, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := cleanTreePath(hdr.Name)
+		switch {
+		case name == ociIndexFile:
+			if hdr.Size > maxOCIManifestSize {
+				return SizedRef{}, fmt.Errorf("%s is too large", ociIndexFile)
+			}
+			if index, err = io.ReadAll(tr); err != nil {
+				return SizedRef{}, err
+			}
+		case strings.HasPrefix(name, ociBlobsDir+"/"):
+			if err = s.storeOCIBlob(ctx, name, tr, hdr.Size); err != nil {
+				return SizedRef{}, err
+			}
+		}
+	}
+	if index == nil {
+		return SizedRef{}, fmt.Errorf("%s not found in the archive", ociIndexFile)
+	}
+	return s.storeOCIIndex(ctx, index)
+}
+
+// OCIImages lists all image manifests reachable from an OCI index. Nested indexes are flattened.
+// If the ref points to an image manifest, it is returned as the only image.
+func (s *Storage) OCIImages(ctx context.Context, ref Ref) ([]OCIImage, error) {
+	ref, _, err := s.unwrap(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	d, m, _, err := s.ociRootDescriptor(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !m.isIndex() {
+		return []OCIImage{{Manifest: SizedRef{Ref: ref, Size: uint64(d.Size)}}}, nil
+	}
+	var out []OCIImage
+	err = s.walkOCI(ctx, d, func(d ociDescriptor, sr SizedRef) error {
+		switch d.MediaType {
+		case mediaTypeOCIManifest, mediaTypeDockerImage:
+			out = append(out, OCIImage{Manifest: sr, Platform: d.Platform.String()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UnpackOCIImage applies all layers of an image manifest and stores the resulting file system as a directory tree.
+// Whiteout files in layers remove files and directory contents of the lower layers.
+func (s *Storage) UnpackOCIImage(ctx context.Context, manifest Ref, conf *StoreConfig) (SizedRef, error) {
+	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	manifest, _, err = s.unwrap(ctx, manifest)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	m, _, err := s.readOCIManifest(ctx, manifest)
+	if err != nil {
+		return SizedRef{}, err
+	} else if m.isIndex() {
+		return SizedRef{}, fmt.Errorf("%v is an image index, not a manifest", manifest)
+	}
+	root := newTreeDir()
+	for _, l := range m.Layers {
+		if err = s.applyOCILayer(ctx, root, l, conf); err != nil {
+			return SizedRef{}, err
+		}
+	}
+	sr, _, err := s.storeTree(ctx, root)
+	return sr, err
+}
+
+// openOCILayer returns an uncompressed tar stream of the layer.
+func (s *Storage) openOCILayer(ctx context.Context, d ociDescriptor) (io.ReadCloser, error) {
+	sr, err := d.ref()
+	if err != nil {
+		return nil, err
+	}
+	var algo string
+	switch {
+	case d.MediaType == mediaTypeDockerLayer:
+		algo = "gzip"
+	case strings.HasPrefix(d.MediaType, mediaTypeOCILayerPref):
+		// tar, tar+gzip, tar+zstd; non-distributable layers use the same suffixes
+		mt := strings.TrimPrefix(d.MediaType, mediaTypeOCILayerPref)
+		if i := strings.IndexByte(mt, '+'); i >= 0 {
+			algo = mt[i+1:]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported layer type: %q", d.MediaType)
+	}
+	rc, _, err := s.FetchBlob(ctx, sr.Ref)
+	if err != nil {
+		return nil, err
+	}
+	if algo == "" {
+		return rc, nil
+	}
+	c, err := codecByAlgo(algo)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	zr, err := c.newReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: zr, closers: []io.Closer{zr, rc}}, nil
+}
+
+// readCloser closes multiple readers in order.
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var last error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil {
+			last = err
+		}
+	}
+	return last
+}
+
+// applyOCILayer adds the content of a layer to the tree and applies its whiteouts.
+func (s *Storage) applyOCILayer(ctx context.Context, root *treeNode, d ociDescriptor, conf *StoreConfig) error {
+	rc, err := s.openOCILayer(ctx, d)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// paths added by this layer; opaque whiteouts must keep them
+	added := make(map[string]struct{})
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("cannot read layer %v: %v", d.Digest, err)
+		}
+		name := cleanTreePath(hdr.Name)
+		if name == "" {
+			continue
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		switch {
+		case base == ociWhiteoutOpaque:
+			if n := root.lookup(dir); n != nil && n.sub != nil {
+				for sub := range n.sub {
+					if _, ok := added[path.Join(dir, sub)]; !ok {
+						delete(n.sub, sub)
+					}
+				}
+			}
+		case strings.HasPrefix(base, ociWhiteoutPrefix):
+			root.remove(path.Join(dir, strings.TrimPrefix(base, ociWhiteoutPrefix)))
+		default:
+			if err = s.addTarEntry(ctx, root, name, hdr, tr, conf); err != nil {
+				return err
+			}
+			for p := name; p != "." && p != ""; p = path.Dir(p) {
+				added[p] = struct{}{}
+			}
+		}
+	}
+}
+
+// exportOCI passes all files of an OCI image layout for a stored index or image manifest to fnc.
+// The index is passed last, thus an interrupted export never produces a layout with missing blobs.
+func (s *Storage) exportOCI(ctx context.Context, ref Ref, fnc func(name string, size int64, r io.Reader) error) error {
+	if err := s.checkSigned(ctx, "export", ref); err != nil {
+		return err
+	}
+	ref, _, err := s.unwrap(ctx, ref)
+	if err != nil {
+		return err
+	}
+	d, m, data, err := s.ociRootDescriptor(ctx, ref)
+	if err != nil {
+		return err
+	}
+	var roots []ociDescriptor
+	if m.isIndex() {
+		roots = m.Manifests
+	} else {
+		// wrap a single manifest into an index
+		roots = []ociDescriptor{d}
+		data, err = json.Marshal(ociManifest{
+			SchemaVersion: 2,
+			MediaType:     mediaTypeOCIIndex,
+			Manifests:     roots,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	layout := fmt.Sprintf(`{"imageLayoutVersion":%q}`, ociLayoutVersion)
+	if err = fnc(ociLayoutFile, int64(len(layout)), strings.NewReader(layout)); err != nil {
+		return err
+	}
+	written := make(map[Ref]struct{})
+	for _, root := range roots {
+		err = s.walkOCI(ctx, root, func(d ociDescriptor, sr SizedRef) error {
+			if _, ok := written[sr.Ref]; ok {
+				return nil
+			}
+			written[sr.Ref] = struct{}{}
+			rc, _, err := s.FetchBlob(ctx, sr.Ref)
+			if err != nil {
+				return fmt.Errorf("cannot fetch %v: %v", sr.Ref, err)
+			}
+			defer rc.Close()
+			name := path.Join(ociBlobsDir, sr.Ref.Name(), hex.EncodeToString(sr.Ref.Data()))
+			return fnc(name, int64(sr.Size), io.LimitReader(rc, int64(sr.Size)))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return fnc(ociIndexFile, int64(len(data)), bytes.NewReader(data))
+}
+
+// ExportOCIDir writes an OCI image layout for a stored index or image manifest to a directory.
+func (s *Storage) ExportOCIDir(ctx context.Context, ref Ref, dir string) error {
+	return s.exportOCI(ctx, ref, func(name string, size int64, r io.Reader) error {
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(fpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if n, err := io.Copy(f, r); err != nil {
+			return err
+		} else if n != size {
+			return fmt.Errorf("unexpected size of %q: %d vs %d", name, n, size)
+		}
+		return f.Close()
+	})
+}
+
+// ExportOCITar is the same as ExportOCIDir, but writes the layout as a tar stream.
+func (s *Storage) ExportOCITar(ctx context.Context, ref Ref, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	dirs := make(map[string]struct{})
+	err := s.exportOCI(ctx, ref, func(name string, size int64, r io.Reader) error {
+		// write parent directories before the first file in them
+		var parents []string
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := dirs[dir]; ok {
+				break
+			}
+			dirs[dir] = struct{}{}
+			parents = append(parents, dir)
+		}
+		for i := len(parents) - 1; i >= 0; i-- {
+			if err := tw.WriteHeader(&tar.Header{Name: parents[i] + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+				return err
+			}
+		}
+		err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: size})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
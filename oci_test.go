@@ -0,0 +1,145 @@
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/types"
+)
+
+type testTarEntry struct {
+	name, data string
+	dir        bool
+}
+
+func writeTestTar(t testing.TB, files []testTarEntry) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.data))}
+		if f.dir {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(f.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// testOCILayout builds an OCI image layout with two layers as a tar archive.
+func testOCILayout(t testing.TB) []byte {
+	blobs := make(map[string][]byte)
+	add := func(mediaType string, data []byte) ociDescriptor {
+		sr, err := types.HashWith("sha256", bytes.NewReader(data))
+		require.NoError(t, err)
+		blobs["blobs/sha256/"+hex.EncodeToString(sr.Ref.Data())] = data
+		return ociDescriptor{MediaType: mediaType, Digest: sr.Ref.String(), Size: int64(sr.Size)}
+	}
+	addJSON := func(mediaType string, v interface{}) ociDescriptor {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return add(mediaType, data)
+	}
+
+	base := writeTestTar(t, []testTarEntry{
+		{name: "etc/", dir: true},
+		{name: "etc/passwd", data: "root"},
+		{name: "etc/hosts", data: "localhost"},
+		{name: "var/", dir: true},
+		{name: "var/cache/", dir: true},
+		{name: "var/cache/a", data: "a"},
+		{name: "var/cache/b", data: "b"},
+	})
+	zbuf := new(bytes.Buffer)
+	zw := gzip.NewWriter(zbuf)
+	_, err := zw.Write(base)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	top := writeTestTar(t, []testTarEntry{
+		{name: "etc/.wh.hosts"},
+		{name: "var/cache/c", data: "c"},
+		{name: "var/cache/.wh..wh..opq"},
+		{name: "app", data: "binary"},
+	})
+	manifest := addJSON(mediaTypeOCIManifest, ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        &[]ociDescriptor{add("application/vnd.oci.image.config.v1+json", []byte(`{}`))}[0],
+		Layers: []ociDescriptor{
+			add(mediaTypeOCILayerPref+"v1.tar+gzip", zbuf.Bytes()),
+			add(mediaTypeOCILayerPref+"v1.tar", top),
+		},
+	})
+	manifest.Platform = &ociPlatform{OS: "linux", Arch: "amd64"}
+	index, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests:     []ociDescriptor{manifest},
+	})
+	require.NoError(t, err)
+
+	files := []testTarEntry{
+		{name: ociLayoutFile, data: `{"imageLayoutVersion":"1.0.0"}`},
+		{name: ociIndexFile, data: string(index)},
+	}
+	for name, data := range blobs {
+		files = append(files, testTarEntry{name: name, data: string(data)})
+	}
+	return writeTestTar(t, files)
+}
+
+func TestOCI(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	sr, err := s.ImportOCITar(ctx, bytes.NewReader(testOCILayout(t)))
+	require.NoError(t, err)
+
+	images, err := s.OCIImages(ctx, sr.Ref)
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	require.Equal(t, "linux/amd64", images[0].Platform)
+
+	root, err := s.UnpackOCIImage(ctx, images[0].Manifest.Ref, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"etc/passwd":  "root",
+		"var/cache/c": "c",
+		"app":         "binary",
+	}, readTestTree(t, s, root.Ref))
+
+	dir, err := ioutil.TempDir("", "cas-oci-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, s.ExportOCIDir(ctx, sr.Ref, dir))
+	_, err = os.Stat(filepath.Join(dir, ociLayoutFile))
+	require.NoError(t, err)
+
+	// layout must be readable from scratch
+	s2 := newTestStorage(t)
+	sr2, err := s2.ImportOCIDir(ctx, dir)
+	require.NoError(t, err)
+	require.Equal(t, sr, sr2)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, s2.ExportOCITar(ctx, images[0].Manifest.Ref, buf))
+	s3 := newTestStorage(t)
+	sr3, err := s3.ImportOCITar(ctx, buf)
+	require.NoError(t, err)
+	images3, err := s3.OCIImages(ctx, sr3.Ref)
+	require.NoError(t, err)
+	require.Equal(t, images[0].Manifest, images3[0].Manifest)
+}
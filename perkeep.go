@@ -0,0 +1,295 @@
+package cas
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/storage/perkeep"
+)
+
+// maxPerkeepDepth limits the nesting of bytes and static-set schema blobs.
+const maxPerkeepDepth = 64
+
+// pkImporter converts Perkeep schema blobs to files and directory trees.
+type pkImporter struct {
+	s    *Storage
+	src  storage.BlobSource
+	conf *StoreConfig
+
+	files map[Ref]SizedRef // files imported in this session, by refs of Perkeep schema blobs
+}
+
+// ImportPerkeep stores a Perkeep file, directory or static set from src as a file or a directory tree.
+// Members of a static set become entries of the root directory.
+//
+// Files with a known whole content ref are aliased by it, thus they are not fetched again on the next import.
+func (s *Storage) ImportPerkeep(ctx context.Context, src storage.BlobSource, ref Ref, conf *StoreConfig) (SizedRef, error) {
+	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	imp := &pkImporter{s: s, src: src, conf: conf, files: make(map[Ref]SizedRef)}
+	sch, err := imp.schema(ctx, ref)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	switch sch.Type {
+	case perkeep.TypeFile, perkeep.TypeBytes:
+		return imp.file(ctx, ref, sch)
+	case perkeep.TypeDirectory:
+		sr, _, err := imp.dir(ctx, sch)
+		return sr, err
+	case perkeep.TypeStaticSet:
+		var list []schema.DirEntry
+		if err = imp.members(ctx, sch, &list, 0); err != nil {
+			return SizedRef{}, err
+		}
+		sr, _, err := s.storeDirEntries(ctx, list)
+		return sr, err
+	default:
+		return SizedRef{}, fmt.Errorf("cannot import %q schema blob", sch.Type)
+	}
+}
+
+func (imp *pkImporter) schema(ctx context.Context, ref Ref) (*perkeep.Schema, error) {
+	rc, _, err := imp.src.FetchBlob(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch %v: %v", ref, err)
+	}
+	defer rc.Close()
+	sch, err := perkeep.DecodeSchema(storage.VerifyReader(rc, ref))
+	if err == perkeep.ErrNotSchema {
+		return nil, fmt.Errorf("%v is not a schema blob", ref)
+	}
+	return sch, err
+}
+
+// schemaByBlobRef fetches a schema blob of a given type.
+func (imp *pkImporter) schemaByBlobRef(ctx context.Context, br, typ string) (Ref, *perkeep.Schema, error) {
+	ref, err := perkeep.ParseBlobRef(br)
+	if err != nil {
+		return Ref{}, nil, err
+	}
+	sch, err := imp.schema(ctx, ref)
+	if err != nil {
+		return Ref{}, nil, err
+	} else if typ != "" && sch.Type != typ {
+		return Ref{}, nil, fmt.Errorf("expected %q schema blob, got %q in %v", typ, sch.Type, ref)
+	}
+	return ref, sch, nil
+}
+
+// entry converts a file, directory or symlink schema blob to a directory entry.
+func (imp *pkImporter) entry(ctx context.Context, br string) (schema.DirEntry, error) {
+	ref, sch, err := imp.schemaByBlobRef(ctx, br, "")
+	if err != nil {
+		return schema.DirEntry{}, err
+	}
+	var ent schema.DirEntry
+	if ent.Name, err = sch.Name(); err != nil {
+		return ent, err
+	} else if !validFileName(ent.Name) {
+		return ent, fmt.Errorf("invalid file name in %v: %q", ref, ent.Name)
+	}
+	perm, err := sch.Perm()
+	if err != nil {
+		return ent, err
+	}
+	if ent.ModTime, err = sch.ModTime(); err != nil {
+		return ent, err
+	}
+	switch sch.Type {
+	case perkeep.TypeFile:
+		sr, err := imp.file(ctx, ref, sch)
+		if err != nil {
+			return ent, err
+		}
+		ent.Ref = sr.Ref
+		ent.Mode = schema.ModeRegular | 0644
+		ent.Stats = Stats{schema.StatDataSize: sr.Size}
+	case perkeep.TypeDirectory:
+		sr, st, err := imp.dir(ctx, sch)
+		if err != nil {
+			return ent, err
+		}
+		ent.Ref, ent.Stats = sr.Ref, st
+		ent.Mode = schema.ModeDir | 0755
+	case perkeep.TypeSymlink:
+		if ent.Link, err = sch.Target(); err != nil {
+			return ent, err
+		}
+		ent.Mode = schema.ModeSymlink | 0777
+		perm = 0
+	default:
+		return ent, fmt.Errorf("unsupported %q schema blob in a directory: %v", sch.Type, ref)
+	}
+	if perm != 0 {
+		ent.Mode = ent.Mode&schema.ModeType | perm&schema.ModePerm
+	}
+	return ent, nil
+}
+
+func (imp *pkImporter) dir(ctx context.Context, sch *perkeep.Schema) (SizedRef, Stats, error) {
+	_, set, err := imp.schemaByBlobRef(ctx, sch.Entries, perkeep.TypeStaticSet)
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	var list []schema.DirEntry
+	if err = imp.members(ctx, set, &list, 0); err != nil {
+		return SizedRef{}, nil, err
+	}
+	return imp.s.storeDirEntries(ctx, list)
+}
+
+// members converts members of a static set to directory entries. Subsets of large sets are merged.
+func (imp *pkImporter) members(ctx context.Context, set *perkeep.Schema, list *[]schema.DirEntry, depth int) error {
+	if depth > maxPerkeepDepth {
+		return fmt.Errorf("static set is nested too deep")
+	}
+	for _, br := range set.Members {
+		ent, err := imp.entry(ctx, br)
+		if err != nil {
+			return err
+		}
+		*list = append(*list, ent)
+	}
+	for _, br := range set.MergeSets {
+		_, sub, err := imp.schemaByBlobRef(ctx, br, perkeep.TypeStaticSet)
+		if err != nil {
+			return err
+		}
+		if err = imp.members(ctx, sub, list, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// file stores the content of a file or bytes schema blob, unless it's stored already.
+func (imp *pkImporter) file(ctx context.Context, ref Ref, sch *perkeep.Schema) (SizedRef, error) {
+	if sr, ok := imp.files[ref]; ok {
+		return sr, nil
+	}
+	size := sch.Size()
+	c := *imp.conf
+	c.Expect = SizedRef{Size: size}
+	if sch.WholeRef != "" {
+		whole, err := perkeep.ParseBlobRef(sch.WholeRef)
+		if err != nil {
+			return SizedRef{}, err
+		}
+		// only aliases verified against the content are indexed, thus it's safe to reuse them
+		if a, err := imp.s.lookupAlias(ctx, whole); err == nil && a.Ref.Size == size {
+			imp.files[ref] = a.Ref
+			return a.Ref, nil
+		} else if err != nil && err != storage.ErrNotFound {
+			return SizedRef{}, err
+		}
+		if whole.Name() == storage.HashName(ctx) {
+			if sz, err := imp.s.StatBlob(ctx, whole); err == nil && sz == size {
+				sr := SizedRef{Ref: whole, Size: size}
+				imp.files[ref] = sr
+				return sr, nil
+			}
+			if c.Split == nil {
+				c.Expect.Ref = whole
+			}
+		} else {
+			c.ExtraHashes = append(append([]string{}, c.ExtraHashes...), whole.Name())
+		}
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(imp.writeRange(ctx, pw, sch.Parts, 0, size, 0))
+	}()
+	sr, err := imp.s.StoreBlob(ctx, pr, &c)
+	pr.Close()
+	if err != nil {
+		return SizedRef{}, err
+	}
+	if sr.Ref.Zero() {
+		sr.Ref = emptyRef(ctx)
+	}
+	imp.files[ref] = sr
+	return sr, nil
+}
+
+// writeRange writes a range of the content described by parts.
+func (imp *pkImporter) writeRange(ctx context.Context, w io.Writer, parts []perkeep.Part, off, size uint64, depth int) error {
+	if depth > maxPerkeepDepth {
+		return fmt.Errorf("bytes schema blobs are nested too deep")
+	}
+	for _, p := range parts {
+		if size == 0 {
+			break
+		} else if off >= p.Size {
+			off -= p.Size
+			continue
+		}
+		n := p.Size - off
+		if n > size {
+			n = size
+		}
+		switch {
+		case p.BlobRef != "":
+			if err := imp.writeBlob(ctx, w, p.BlobRef, p.Offset+off, n); err != nil {
+				return err
+			}
+		case p.BytesRef != "":
+			_, sub, err := imp.schemaByBlobRef(ctx, p.BytesRef, perkeep.TypeBytes)
+			if err != nil {
+				return err
+			}
+			if err = imp.writeRange(ctx, w, sub.Parts, p.Offset+off, n, depth+1); err != nil {
+				return err
+			}
+		default:
+			if _, err := io.CopyN(w, zeroReader{}, int64(n)); err != nil {
+				return err
+			}
+		}
+		off = 0
+		size -= n
+	}
+	if size != 0 {
+		return fmt.Errorf("file content is shorter than expected")
+	}
+	return nil
+}
+
+// writeBlob writes a range of a raw blob. The whole blob is read to verify it.
+func (imp *pkImporter) writeBlob(ctx context.Context, w io.Writer, br string, off, size uint64) error {
+	ref, err := perkeep.ParseBlobRef(br)
+	if err != nil {
+		return err
+	}
+	rc, _, err := imp.src.FetchBlob(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("cannot fetch %v: %v", ref, err)
+	}
+	defer rc.Close()
+	r := storage.VerifyReader(rc, ref)
+	if _, err = io.CopyN(ioutil.Discard, r, int64(off)); err != nil {
+		return err
+	}
+	if _, err = io.CopyN(w, r, int64(size)); err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// zeroReader reads zero bytes indefinitely.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,155 @@
+package cas
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/storage/perkeep"
+)
+
+func TestImportPerkeep(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	src := storage.NewInMemory()
+	pctx := storage.WithHash(ctx, "sha224")
+	put := func(data []byte) string {
+		sr, err := storage.WriteBytes(pctx, src, data)
+		require.NoError(t, err)
+		br, err := perkeep.BlobRef(sr.Ref)
+		require.NoError(t, err)
+		return br
+	}
+	putSchema := func(typ string, fields map[string]interface{}) string {
+		fields["camliVersion"] = 1
+		fields["camliType"] = typ
+		data, err := json.Marshal(fields)
+		require.NoError(t, err)
+		return put(data)
+	}
+	hello := put([]byte("hello "))
+	world := put([]byte("world"))
+	bytesRef := putSchema(perkeep.TypeBytes, map[string]interface{}{
+		"parts": []perkeep.Part{{BlobRef: world, Size: 5}},
+	})
+	whole := sha256.Sum224([]byte("hello orl"))
+	wholeRef := "sha224-" + hex.EncodeToString(whole[:])
+	a := putSchema(perkeep.TypeFile, map[string]interface{}{
+		"fileName":       "a.txt",
+		"unixPermission": "0755",
+		"unixMtime":      "2020-01-02T03:04:05Z",
+		"wholeRef":       wholeRef,
+		"parts": []perkeep.Part{
+			{BlobRef: hello, Size: 6},
+			{BytesRef: bytesRef, Size: 3, Offset: 1},
+		},
+	})
+	zeros := putSchema(perkeep.TypeFile, map[string]interface{}{
+		"fileName": "zeros.bin",
+		"parts":    []perkeep.Part{{Size: 4}},
+	})
+	empty := putSchema(perkeep.TypeFile, map[string]interface{}{
+		"fileName": "empty.txt",
+	})
+	b := putSchema(perkeep.TypeFile, map[string]interface{}{
+		"fileNameBytes": []interface{}{"b", 46, "txt"},
+		"parts":         []perkeep.Part{{BlobRef: world, Size: 5}},
+	})
+	subSet := putSchema(perkeep.TypeStaticSet, map[string]interface{}{
+		"members": []string{b},
+	})
+	sub := putSchema(perkeep.TypeDirectory, map[string]interface{}{
+		"fileName": "sub",
+		"entries":  subSet,
+	})
+	merged := putSchema(perkeep.TypeStaticSet, map[string]interface{}{
+		"members": []string{sub},
+	})
+	rootSet := putSchema(perkeep.TypeStaticSet, map[string]interface{}{
+		"members":   []string{a, zeros, empty},
+		"mergeSets": []string{merged},
+	})
+	root := putSchema(perkeep.TypeDirectory, map[string]interface{}{
+		"fileName": "root",
+		"entries":  rootSet,
+	})
+
+	rootRef, err := perkeep.ParseBlobRef(root)
+	require.NoError(t, err)
+	sr, err := s.ImportPerkeep(ctx, src, rootRef, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"a.txt":     "hello orl",
+		"zeros.bin": "\x00\x00\x00\x00",
+		"empty.txt": "",
+		"sub/b.txt": "world",
+	}, readTestTree(t, s, sr.Ref))
+
+	err = s.readDir(ctx, sr.Ref, func(ent *schema.DirEntry) error {
+		if ent.Name == "a.txt" {
+			require.Equal(t, uint32(schema.ModeRegular|0755), ent.Mode)
+			require.NotNil(t, ent.ModTime)
+			require.Equal(t, int64(1577934245), ent.ModTime.Unix())
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// files are aliased by the whole content ref
+	wr, err := perkeep.ParseBlobRef(wholeRef)
+	require.NoError(t, err)
+	alias, err := s.lookupAlias(ctx, wr)
+	require.NoError(t, err)
+
+	sr2, err := s.ImportPerkeep(ctx, src, rootRef, nil)
+	require.NoError(t, err)
+	require.Equal(t, sr, sr2)
+
+	// a single file and a static set with a symlink
+	aRef, err := perkeep.ParseBlobRef(a)
+	require.NoError(t, err)
+	fsr, err := s.ImportPerkeep(ctx, src, aRef, nil)
+	require.NoError(t, err)
+	require.Equal(t, alias.Ref, fsr)
+
+	link := putSchema(perkeep.TypeSymlink, map[string]interface{}{
+		"fileName":      "link",
+		"symlinkTarget": "sub/b.txt",
+	})
+	setRef, err := perkeep.ParseBlobRef(putSchema(perkeep.TypeStaticSet, map[string]interface{}{
+		"members": []string{link},
+	}))
+	require.NoError(t, err)
+	sr, err = s.ImportPerkeep(ctx, src, setRef, nil)
+	require.NoError(t, err)
+	var ents []schema.DirEntry
+	err = s.readDir(ctx, sr.Ref, func(ent *schema.DirEntry) error {
+		ents = append(ents, *ent)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, ents, 1)
+	require.True(t, ents[0].IsSymlink())
+	require.Equal(t, "sub/b.txt", ents[0].Link)
+
+	// raw blobs and broken content are rejected
+	helloRef, err := perkeep.ParseBlobRef(hello)
+	require.NoError(t, err)
+	_, err = s.ImportPerkeep(ctx, src, helloRef, nil)
+	require.Error(t, err)
+
+	badRef, err := perkeep.ParseBlobRef(putSchema(perkeep.TypeFile, map[string]interface{}{
+		"fileName": "bad.txt",
+		"parts":    []perkeep.Part{{BlobRef: world, Size: 6}},
+	}))
+	require.NoError(t, err)
+	_, err = s.ImportPerkeep(ctx, src, badRef, nil)
+	require.Error(t, err)
+}
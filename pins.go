@@ -0,0 +1,51 @@
+package cas
+
+import (
+	"context"
+	"time"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+)
+
+// SetPinInfo stores an annotated pin value and points a named pin to it.
+// Creation time is preserved if the pin already points to an annotated value; update time is always set.
+func (s *Storage) SetPinInfo(ctx context.Context, name string, info schema.PinInfo) (SizedRef, error) {
+	now := time.Now().UTC()
+	info.Created, info.Updated = &now, &now
+	if old, err := s.GetPinInfo(ctx, name); err == nil && old.Created != nil {
+		info.Created = old.Created
+	}
+	sr, err := s.StoreSchema(ctx, &info)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	if err = s.SetPin(ctx, name, sr.Ref); err != nil {
+		return SizedRef{}, err
+	}
+	return sr, nil
+}
+
+// GetPinInfo returns an annotated value of a named pin. If the pin points to the content directly,
+// an object with only the Ref field set is returned.
+func (s *Storage) GetPinInfo(ctx context.Context, name string) (*schema.PinInfo, error) {
+	ref, err := s.GetPin(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.PinInfo(ctx, ref)
+}
+
+// PinInfo decodes an annotated pin value, or wraps a ref if it's not annotated.
+func (s *Storage) PinInfo(ctx context.Context, ref Ref) (*schema.PinInfo, error) {
+	obj, err := s.DecodeSchema(ctx, ref)
+	if err == schema.ErrNotSchema || err == storage.ErrNotFound {
+		return &schema.PinInfo{Ref: ref}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if info, ok := obj.(*schema.PinInfo); ok {
+		return info, nil
+	}
+	return &schema.PinInfo{Ref: ref}, nil
+}
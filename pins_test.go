@@ -0,0 +1,76 @@
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+)
+
+func TestPinInfo(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	data := []byte("file a")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/a.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}))
+	_, err := tw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	root, err := s.ImportTar(ctx, buf, nil)
+	require.NoError(t, err)
+
+	const name = "datasets/files/v1"
+	_, err = s.SetPinInfo(ctx, name, schema.PinInfo{
+		Ref: root.Ref, Message: "first", Author: "someone",
+		Labels: map[string]string{"k": "v"},
+	})
+	require.NoError(t, err)
+
+	info, err := s.GetPinInfo(ctx, name)
+	require.NoError(t, err)
+	require.Equal(t, root.Ref, info.Ref)
+	require.Equal(t, "first", info.Message)
+	require.Equal(t, "someone", info.Author)
+	require.Equal(t, map[string]string{"k": "v"}, info.Labels)
+	require.NotNil(t, info.Created)
+	require.Equal(t, info.Created, info.Updated)
+	created := *info.Created
+
+	_, err = s.SetPinInfo(ctx, name, schema.PinInfo{Ref: root.Ref, Message: "second"})
+	require.NoError(t, err)
+	info, err = s.GetPinInfo(ctx, name)
+	require.NoError(t, err)
+	require.Equal(t, "second", info.Message)
+	require.True(t, created.Equal(*info.Created))
+	require.False(t, info.Updated.Before(created))
+
+	// checkout should unwrap the annotated pin
+	dir, err := ioutil.TempDir("", "cas_pin_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ref, err := s.GetPinOrRef(ctx, name)
+	require.NoError(t, err)
+	err = s.Checkout(ctx, ref, filepath.Join(dir, "out"))
+	require.NoError(t, err)
+	got, err := ioutil.ReadFile(filepath.Join(dir, "out", "dir", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	// plain pins are reported as well
+	err = s.SetPin(ctx, "plain", root.Ref)
+	require.NoError(t, err)
+	info, err = s.GetPinInfo(ctx, "plain")
+	require.NoError(t, err)
+	require.Equal(t, &schema.PinInfo{Ref: root.Ref}, info)
+}
@@ -0,0 +1,92 @@
+package cas
+
+import (
+	"context"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+)
+
+// pushBatch is the number of refs that are checked in the destination storage at once.
+const pushBatch = 1000
+
+// PushStats reports the number of blobs checked and copied by Push.
+type PushStats struct {
+	Checked int
+	Copied  int
+}
+
+// Push copies a blob and all blobs referenced by it to a different storage.
+// Blobs that already exist in the destination are skipped, including everything they reference.
+// Referenced blobs that are missing in this storage (for example, indexed web content) are skipped as well.
+func (s *Storage) Push(ctx context.Context, dst storage.BlobStorage, root Ref) (PushStats, error) {
+	var st PushStats
+	if err := s.checkSigned(ctx, "push", root); err != nil {
+		return st, err
+	}
+	if _, err := s.StatBlob(ctx, root); err != nil {
+		return st, err
+	}
+	seen := map[Ref]struct{}{root: {}}
+	// missing blobs in the order of discovery; parents go before children
+	var missing []Ref
+	for level := []Ref{root}; len(level) != 0; {
+		var next []Ref
+		for len(level) != 0 {
+			batch := level
+			if len(batch) > pushBatch {
+				batch = batch[:pushBatch]
+			}
+			level = level[len(batch):]
+			st.Checked += len(batch)
+
+			have, err := storage.StatBlobs(ctx, dst, batch)
+			if err != nil {
+				return st, err
+			}
+			exists := make(map[Ref]struct{}, len(have))
+			for _, sr := range have {
+				exists[sr.Ref] = struct{}{}
+			}
+			for _, ref := range batch {
+				if _, ok := exists[ref]; ok {
+					continue
+				}
+				missing = append(missing, ref)
+				obj, err := s.DecodeSchema(ctx, ref)
+				if err == schema.ErrNotSchema || err == storage.ErrNotFound {
+					continue
+				} else if err != nil {
+					return st, err
+				}
+				for _, sub := range obj.References() {
+					if sub.Zero() {
+						continue
+					}
+					if _, ok := seen[sub]; !ok {
+						seen[sub] = struct{}{}
+						next = append(next, sub)
+					}
+				}
+			}
+		}
+		level = next
+	}
+	// copy children first, thus an interrupted push never leaves a schema blob without its references
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+	for len(missing) != 0 {
+		batch := missing
+		if len(batch) > pushBatch {
+			batch = batch[:pushBatch]
+		}
+		missing = missing[len(batch):]
+		n, err := storage.CopyBlobs(ctx, dst, s, batch)
+		st.Copied += n
+		if err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,53 @@
+package cas
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+)
+
+func TestPush(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	tree := storeTestTree(t, s, map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+		"dir/c.txt": "c",
+	})
+	info, err := s.SetPinInfo(ctx, "root", schema.PinInfo{Ref: tree, Message: "test"})
+	require.NoError(t, err)
+
+	remote := storage.NewInMemory()
+	st, err := s.Push(ctx, remote, info.Ref)
+	require.NoError(t, err)
+	// pin info, root dir, subdir and 3 files
+	require.Equal(t, PushStats{Checked: 6, Copied: 6}, st)
+
+	rs, err := New(remote)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+		"dir/c.txt": "c",
+	}, readTestTree(t, rs, tree))
+
+	// everything is in place - only the root is checked
+	st, err = s.Push(ctx, remote, info.Ref)
+	require.NoError(t, err)
+	require.Equal(t, PushStats{Checked: 1}, st)
+
+	// only the changed part is copied
+	tree2 := storeTestTree(t, s, map[string]string{
+		"a.txt":     "a2",
+		"dir/b.txt": "b",
+		"dir/c.txt": "c",
+	})
+	st, err = s.Push(ctx, remote, tree2)
+	require.NoError(t, err)
+	require.Equal(t, PushStats{Checked: 3, Copied: 2}, st)
+}
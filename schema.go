@@ -11,8 +11,10 @@ import (
 )
 
 var (
-	typeDirEnt   = schema.MustTypeOf(&schema.DirEntry{})
-	typeSizedRef = schema.MustTypeOf(&types.SizedRef{})
+	typeDirEnt     = schema.MustTypeOf(&schema.DirEntry{})
+	typeSizedRef   = schema.MustTypeOf(&types.SizedRef{})
+	typeCompressed = schema.MustTypeOf(&schema.Compressed{})
+	typeAlias      = schema.MustTypeOf(&schema.Alias{})
 )
 
 type SchemaIterator = storage.SchemaIterator
@@ -22,7 +24,14 @@ func (s *Storage) StoreSchema(ctx context.Context, o schema.Object) (SizedRef, e
 	if err := schema.Encode(buf, o); err != nil {
 		return SizedRef{}, err
 	}
-	exp := types.BytesRef(buf.Bytes())
+	ctx, err := s.hashContext(ctx, "")
+	if err != nil {
+		return SizedRef{}, err
+	}
+	exp, err := types.BytesRefWith(storage.HashName(ctx), buf.Bytes())
+	if err != nil {
+		return SizedRef{}, err
+	}
 	return s.StoreBlob(ctx, buf, &StoreConfig{
 		Expect: SizedRef{Ref: exp, Size: uint64(buf.Len())},
 	})
@@ -33,18 +42,27 @@ func (s *Storage) FetchSchema(ctx context.Context, ref types.Ref) (io.ReadCloser
 }
 
 func (s *Storage) DecodeSchema(ctx context.Context, ref types.Ref) (schema.Object, error) {
-	rc, _, err := s.index.FetchSchema(ctx, ref)
-	if err != nil {
-		return nil, err
-	}
-	defer rc.Close()
-	return schema.Decode(rc)
+	return storage.DecodeSchema(ctx, s.index, ref)
 }
 
 func (s *Storage) IterateSchema(ctx context.Context, typs ...string) SchemaIterator {
 	return s.index.IterateSchema(ctx, typs...)
 }
 
+// ReindexSchema rebuilds an index of schema blobs, as well as index pins for lookups of aliases, compressed files
+// and UnixFS nodes.
 func (s *Storage) ReindexSchema(ctx context.Context, force bool) error {
-	return s.index.ReindexSchema(ctx, force)
+	if err := s.index.ReindexSchema(ctx, force); err != nil {
+		return err
+	}
+	if err := s.reindexAliases(ctx, force); err != nil {
+		return err
+	}
+	if err := s.reindexCompressed(ctx, force); err != nil {
+		return err
+	}
+	if err := s.reindexSignatures(ctx, force); err != nil {
+		return err
+	}
+	return s.reindexUnixFS(ctx, force)
 }
@@ -0,0 +1,20 @@
+package schema
+
+import "github.com/dennwc/cas/types"
+
+func init() {
+	registerCAS(&Alias{})
+}
+
+// Alias records refs of the same content computed with different hash algorithms.
+//
+// Refs in the list are not stored as blobs, but can be resolved to the canonical ref.
+// This allows to find the content by checksums published in other systems (MD5, Git object ids, etc).
+type Alias struct {
+	Ref  types.SizedRef `json:"ref"`
+	Refs []types.Ref    `json:"refs"`
+}
+
+func (a *Alias) References() []types.Ref {
+	return []types.Ref{a.Ref.Ref}
+}
@@ -0,0 +1,49 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/dennwc/cas/types"
+)
+
+func init() {
+	registerCAS(&Archive{})
+}
+
+// Archive describes files stored in an archive blob.
+//
+// Content of each entry is addressed by its own ref, so entries can be deduplicated with other
+// blobs in the storage. If a blob for an entry is missing, it can be read directly from the archive
+// at a specified offset.
+type Archive struct {
+	Format  string         `json:"format"`
+	Arch    types.SizedRef `json:"arch"`
+	Entries []ArchiveEntry `json:"entries"`
+}
+
+func (a *Archive) References() []types.Ref {
+	refs := make([]types.Ref, 0, len(a.Entries)+1)
+	refs = append(refs, a.Arch.Ref)
+	for _, e := range a.Entries {
+		refs = append(refs, e.Ref)
+	}
+	return refs
+}
+
+// ArchiveEntry is a single file in an archive.
+type ArchiveEntry struct {
+	Name    string     `json:"name"`
+	Ref     types.Ref  `json:"ref"`
+	Size    uint64     `json:"size"`
+	Mode    uint32     `json:"mode,omitempty"`  // Unix mode, see DirEntry.Mode
+	Link    string     `json:"link,omitempty"`  // target of a symlink; the content of the entry is the same
+	ModTime *time.Time `json:"mtime,omitempty"` // modification time
+	Method  uint16     `json:"method"`          // compression method used in the archive
+	Offset  uint64     `json:"offset"`          // offset of compressed data in the archive
+	CSize   uint64     `json:"csize"`           // size of compressed data
+}
+
+// IsSymlink checks if an entry is a symlink.
+func (e *ArchiveEntry) IsSymlink() bool {
+	return e.Mode&ModeType == ModeSymlink
+}
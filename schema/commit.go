@@ -0,0 +1,34 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/dennwc/cas/types"
+)
+
+func init() {
+	registerCAS(&Commit{})
+}
+
+var _ BlobWrapper = (*Commit)(nil)
+
+// Commit is a snapshot of a tree with a reference to previous snapshots.
+type Commit struct {
+	Tree    types.Ref   `json:"tree"`
+	Parents []types.Ref `json:"parents,omitempty"`
+	Author  string      `json:"author,omitempty"`
+	Time    *time.Time  `json:"time,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// DataBlob returns a ref of the tree, thus commits can be checked out directly.
+func (c *Commit) DataBlob() types.Ref {
+	return c.Tree
+}
+
+func (c *Commit) References() []types.Ref {
+	refs := make([]types.Ref, 0, len(c.Parents)+1)
+	refs = append(refs, c.Tree)
+	refs = append(refs, c.Parents...)
+	return refs
+}
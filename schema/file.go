@@ -1,6 +1,11 @@
 package schema
 
-import "github.com/dennwc/cas/types"
+import (
+	"os"
+	"time"
+
+	"github.com/dennwc/cas/types"
+)
 
 func init() {
 	registerCAS(&DirEntry{})
@@ -8,17 +13,82 @@ func init() {
 	registerCAS(&Multipart{})
 }
 
+// Unix file type bits, as stored in DirEntry.Mode.
+const (
+	ModeType    = 0170000
+	ModeDir     = 0040000
+	ModeRegular = 0100000
+	ModeSymlink = 0120000
+	ModePerm    = 07777
+)
+
 type DirEntry struct {
-	Ref   types.Ref `json:"ref"`
-	Name  string    `json:"name"`
-	Stats Stats     `json:"stats"`
+	Ref     types.Ref  `json:"ref"`
+	Name    string     `json:"name"`
+	Mode    uint32     `json:"mode,omitempty"`  // Unix mode: file type and permission bits
+	ModTime *time.Time `json:"mtime,omitempty"` // modification time
+	Link    string     `json:"link,omitempty"`  // symlink target
+	Stats   Stats      `json:"stats"`
 }
 
 func (d *DirEntry) Size() uint64 {
 	return d.Stats.Size()
 }
 
+// IsSymlink checks if an entry describes a symbolic link.
+func (d *DirEntry) IsSymlink() bool {
+	return d.Mode&ModeType == ModeSymlink || (d.Link != "" && d.Ref.Zero())
+}
+
+// UnixMode converts os.FileMode to a Unix mode, as stored in DirEntry.Mode.
+func UnixMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	switch {
+	case m.IsDir():
+		mode |= ModeDir
+	case m&os.ModeSymlink != 0:
+		mode |= ModeSymlink
+	case m.IsRegular():
+		mode |= ModeRegular
+	}
+	return mode
+}
+
+// FileMode converts the Unix mode of the entry to os.FileMode.
+// It returns zero if the mode was not recorded.
+func (d *DirEntry) FileMode() os.FileMode {
+	m := os.FileMode(d.Mode & 0777)
+	if d.Mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if d.Mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if d.Mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	switch d.Mode & ModeType {
+	case ModeDir:
+		m |= os.ModeDir
+	case ModeSymlink:
+		m |= os.ModeSymlink
+	}
+	return m
+}
+
 func (d *DirEntry) References() []types.Ref {
+	if d.Ref.Zero() {
+		return nil
+	}
 	return []types.Ref{d.Ref}
 }
 
@@ -0,0 +1,36 @@
+package schema
+
+import (
+	"sort"
+
+	"github.com/dennwc/cas/types"
+)
+
+func init() {
+	registerCAS(&UnixFS{})
+}
+
+// UnixFS maps IPFS content ids (CIDs) of a UnixFS DAG to refs of stored files and directories.
+//
+// It's recorded when the DAG is imported from or exported to a CAR file,
+// allowing to skip content that was stored already and to restore the original tree when the DAG is imported back.
+type UnixFS struct {
+	CID  string    `json:"cid"`
+	Root types.Ref `json:"root"`
+	// Nodes maps CIDs of files and directories in the DAG to their refs.
+	Nodes map[string]types.Ref `json:"nodes,omitempty"`
+}
+
+func (u *UnixFS) References() []types.Ref {
+	keys := make([]string, 0, len(u.Nodes))
+	for k := range u.Nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	refs := make([]types.Ref, 0, len(u.Nodes)+1)
+	refs = append(refs, u.Root)
+	for _, k := range keys {
+		refs = append(refs, u.Nodes[k])
+	}
+	return refs
+}
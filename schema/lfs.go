@@ -0,0 +1,25 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/dennwc/cas/types"
+)
+
+func init() {
+	registerCAS(&LFSLock{})
+}
+
+// LFSLock is a Git LFS file lock. Locks are stored as pins by the LFS server.
+type LFSLock struct {
+	Path string `json:"path"`
+	// Owner is the name of the user that created the lock.
+	Owner string `json:"owner,omitempty"`
+	// Ref is a Git ref name the lock was created for.
+	Ref      string    `json:"ref,omitempty"`
+	LockedAt time.Time `json:"locked_at"`
+}
+
+func (l *LFSLock) References() []types.Ref {
+	return nil
+}
@@ -0,0 +1,31 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/dennwc/cas/types"
+)
+
+func init() {
+	registerCAS(&PinInfo{})
+}
+
+var _ BlobWrapper = (*PinInfo)(nil)
+
+// PinInfo is an annotated pin value. Pins may point to this object instead of pointing to the content directly.
+type PinInfo struct {
+	Ref     types.Ref         `json:"ref"`
+	Message string            `json:"message,omitempty"`
+	Author  string            `json:"author,omitempty"`
+	Created *time.Time        `json:"created,omitempty"`
+	Updated *time.Time        `json:"updated,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+func (p *PinInfo) DataBlob() types.Ref {
+	return p.Ref
+}
+
+func (p *PinInfo) References() []types.Ref {
+	return []types.Ref{p.Ref}
+}
@@ -0,0 +1,43 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/dennwc/cas/types"
+)
+
+func init() {
+	registerCAS(&Signature{})
+}
+
+// Signature is a detached signature of a schema blob or a pin value.
+//
+// Signatures are stored as separate blobs, thus they can be added to an existing content without changing its ref.
+type Signature struct {
+	Target types.Ref `json:"target"`
+	// Pin is set if the signature was made for a specific pin value.
+	Pin string `json:"pin,omitempty"`
+	// Key is a fingerprint of a public key in OpenSSH format (SHA256:...).
+	Key string `json:"key"`
+	// PublicKey is a public key in authorized_keys format. It's only used for display; trust is decided by Key.
+	PublicKey string     `json:"pubkey,omitempty"`
+	Format    string     `json:"format"`
+	Sig       []byte     `json:"sig"`
+	Time      *time.Time `json:"time,omitempty"`
+}
+
+// SignedData returns a payload that is signed by the key.
+func (s *Signature) SignedData() []byte {
+	data := "cas-signature\ntarget " + s.Target.String() + "\n"
+	if s.Pin != "" {
+		data += "pin " + s.Pin + "\n"
+	}
+	if s.Time != nil {
+		data += "time " + s.Time.UTC().Format(time.RFC3339) + "\n"
+	}
+	return []byte(data)
+}
+
+func (s *Signature) References() []types.Ref {
+	return []types.Ref{s.Target}
+}
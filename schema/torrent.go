@@ -0,0 +1,25 @@
+package schema
+
+import "github.com/dennwc/cas/types"
+
+func init() {
+	registerCAS(&Torrent{})
+}
+
+// Torrent links a BitTorrent metainfo file to the directory tree with the content it describes.
+//
+// For multi-file torrents, the tree is the content of the torrent directory.
+// Single-file torrents are stored as a directory with a single file.
+type Torrent struct {
+	Name string `json:"name"`
+	// InfoHash is a hex-encoded v1 infohash (SHA-1).
+	InfoHash string `json:"infohash,omitempty"`
+	// InfoHashV2 is a hex-encoded v2 infohash (SHA-256).
+	InfoHashV2 string         `json:"infohash_v2,omitempty"`
+	Meta       types.SizedRef `json:"meta"` // .torrent file
+	Root       types.SizedRef `json:"root"`
+}
+
+func (t *Torrent) References() []types.Ref {
+	return []types.Ref{t.Meta.Ref, t.Root.Ref}
+}
@@ -0,0 +1,197 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+)
+
+var typeSignature = schema.MustTypeOf(&schema.Signature{})
+
+// signatureIndex is a kind of index pins that map signed refs to signatures.
+const signatureIndex = "signature"
+
+var (
+	// ErrNotSigned is returned when the content has no valid signatures made by trusted keys.
+	ErrNotSigned = errors.New("no trusted signature")
+	// ErrUntrustedKey is returned when the signature is valid, but the key is not trusted.
+	ErrUntrustedKey = errors.New("signed with untrusted key")
+)
+
+// ParsePrivateKey parses a private key that can be used for signing. It accepts keys in OpenSSH and PEM formats,
+// including PKCS#8 ed25519 keys. Use ssh.NewSignerFromKey to sign with in-memory ed25519 keys.
+func ParsePrivateKey(data []byte) (ssh.Signer, error) {
+	return ssh.ParsePrivateKey(data)
+}
+
+// ParseAuthorizedKeys parses a list of public keys in authorized_keys format.
+func ParseAuthorizedKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) != 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
+
+// SetTrustedKeys sets public keys that are trusted to sign the content.
+// If require is set, Checkout, Push and exports will refuse to read roots without a valid signature made by one of these keys.
+func (s *Storage) SetTrustedKeys(keys []ssh.PublicKey, require bool) {
+	s.trusted = make(map[string]ssh.PublicKey, len(keys))
+	for _, k := range keys {
+		s.trusted[ssh.FingerprintSHA256(k)] = k
+	}
+	s.requireSigned = require
+}
+
+// Sign creates a detached signature of a ref and stores it. If the pin name is set, the signature is bound to it.
+func (s *Storage) Sign(ctx context.Context, ref Ref, pin string, key ssh.Signer) (SizedRef, *schema.Signature, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	pub := key.PublicKey()
+	sig := &schema.Signature{
+		Target:    ref,
+		Pin:       pin,
+		Key:       ssh.FingerprintSHA256(pub),
+		PublicKey: string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(pub))),
+		Time:      &now,
+	}
+	ss, err := key.Sign(nil, sig.SignedData())
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	sig.Format, sig.Sig = ss.Format, ss.Blob
+	sr, err := s.StoreSchema(ctx, sig)
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	if err = s.indexSignature(ctx, sig, sr.Ref); err != nil {
+		return SizedRef{}, nil, err
+	}
+	return sr, sig, nil
+}
+
+// signaturePin returns a name of the index pin for a signature stored as sref.
+func signaturePin(target, sref Ref) string {
+	return indexPin(signatureIndex, refKey(target), refKey(sref))
+}
+
+// indexSignature adds a stored signature to the index of the signed ref.
+func (s *Storage) indexSignature(ctx context.Context, sig *schema.Signature, sref Ref) error {
+	return s.st.SetPin(ctx, signaturePin(sig.Target, sref), sref)
+}
+
+// Signatures lists all signatures of a given ref.
+func (s *Storage) Signatures(ctx context.Context, ref Ref) ([]*schema.Signature, error) {
+	it := storage.IteratePinsByPrefix(ctx, s.st, indexPin(signatureIndex, refKey(ref))+storage.PinSeparator)
+	defer it.Close()
+	var out []*schema.Signature
+	for it.Next() {
+		obj, err := s.DecodeSchema(ctx, it.Pin().Ref)
+		if err == schema.ErrNotSchema || err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if sig, ok := obj.(*schema.Signature); ok && sig.Target == ref {
+			out = append(out, sig)
+		}
+	}
+	return out, it.Err()
+}
+
+// reindexSignatures adds stored signatures to the index. Only signatures that are valid for the embedded public key
+// or for one of the trusted keys are indexed. If force is false, indexed signatures are not verified again.
+func (s *Storage) reindexSignatures(ctx context.Context, force bool) error {
+	it := s.IterateSchema(ctx, typeSignature)
+	defer it.Close()
+	for it.Next() {
+		obj, err := it.Decode()
+		if err != nil {
+			return err
+		}
+		sig, ok := obj.(*schema.Signature)
+		if !ok {
+			return fmt.Errorf("unexpected type: %T", obj)
+		}
+		sref := it.SchemaRef().Ref
+		if !force {
+			if _, err := s.st.GetPin(ctx, signaturePin(sig.Target, sref)); err == nil {
+				continue
+			}
+		}
+		// keys may become trusted later, thus signatures of untrusted keys are indexed as well
+		if err := s.CheckSignature(sig); err != nil && err != ErrUntrustedKey {
+			continue
+		}
+		if err = s.indexSignature(ctx, sig, sref); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// checkSigned verifies that the ref has a valid signature made by a trusted key, if the storage requires signed content.
+// The operation name is only used in the error message.
+func (s *Storage) checkSigned(ctx context.Context, op string, ref Ref) error {
+	if !s.requireSigned {
+		return nil
+	}
+	if _, err := s.Verify(ctx, ref, ""); err != nil {
+		return fmt.Errorf("cannot %s %v: %v", op, ref, err)
+	}
+	return nil
+}
+
+// CheckSignature verifies the signature with trusted keys.
+// It returns ErrUntrustedKey if the signature is valid, but the key is not trusted.
+func (s *Storage) CheckSignature(sig *schema.Signature) error {
+	key, trusted := s.trusted[sig.Key]
+	if !trusted {
+		if sig.PublicKey == "" {
+			return ErrUntrustedKey
+		}
+		var err error
+		key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(sig.PublicKey))
+		if err != nil {
+			return err
+		} else if ssh.FingerprintSHA256(key) != sig.Key {
+			return fmt.Errorf("public key doesn't match the fingerprint")
+		}
+	}
+	err := key.Verify(sig.SignedData(), &ssh.Signature{Format: sig.Format, Blob: sig.Sig})
+	if err != nil {
+		return err
+	} else if !trusted {
+		return ErrUntrustedKey
+	}
+	return nil
+}
+
+// Verify finds a valid signature of a ref made by a trusted key. It returns ErrNotSigned if there is none.
+// If the pin name is set, signatures bound to other pins are ignored.
+func (s *Storage) Verify(ctx context.Context, ref Ref, pin string) (*schema.Signature, error) {
+	sigs, err := s.Signatures(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	for _, sig := range sigs {
+		if pin != "" && sig.Pin != "" && sig.Pin != pin {
+			continue
+		}
+		if s.CheckSignature(sig) == nil {
+			return sig, nil
+		}
+	}
+	return nil, ErrNotSigned
+}
@@ -0,0 +1,116 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/dennwc/cas/storage"
+)
+
+func newTestSigner(t testing.TB) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return key
+}
+
+func TestSignatures(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	key := newTestSigner(t)
+	other := newTestSigner(t)
+
+	root := storeTestTree(t, s, map[string]string{"a.txt": "a"})
+	unsigned := storeTestTree(t, s, map[string]string{"b.txt": "b"})
+
+	_, sig, err := s.Sign(ctx, root, "release", key)
+	require.NoError(t, err)
+	require.Equal(t, ssh.FingerprintSHA256(key.PublicKey()), sig.Key)
+
+	// nothing is trusted yet
+	require.Equal(t, ErrUntrustedKey, s.CheckSignature(sig))
+	_, err = s.Verify(ctx, root, "")
+	require.Equal(t, ErrNotSigned, err)
+
+	s.SetTrustedKeys([]ssh.PublicKey{key.PublicKey()}, true)
+	require.NoError(t, s.CheckSignature(sig))
+	got, err := s.Verify(ctx, root, "release")
+	require.NoError(t, err)
+	require.Equal(t, sig.Key, got.Key)
+	_, err = s.Verify(ctx, root, "other")
+	require.Equal(t, ErrNotSigned, err)
+
+	// tampered signature
+	bad := *sig
+	bad.Pin = "other"
+	require.Error(t, s.CheckSignature(&bad))
+
+	// signed by an untrusted key
+	_, _, err = s.Sign(ctx, unsigned, "", other)
+	require.NoError(t, err)
+	_, err = s.Verify(ctx, unsigned, "")
+	require.Equal(t, ErrNotSigned, err)
+
+	dir := t.TempDir()
+	require.NoError(t, s.Checkout(ctx, root, filepath.Join(dir, "root")))
+	err = s.Checkout(ctx, unsigned, filepath.Join(dir, "unsigned"))
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), ErrNotSigned.Error()))
+
+	// exports are refused as well
+	buf := new(bytes.Buffer)
+	require.NoError(t, s.ExportTar(ctx, root, buf))
+	err = s.ExportTar(ctx, unsigned, buf)
+	require.True(t, err != nil && strings.Contains(err.Error(), ErrNotSigned.Error()))
+	_, err = s.Push(ctx, storage.NewInMemory(), unsigned)
+	require.True(t, err != nil && strings.Contains(err.Error(), ErrNotSigned.Error()))
+	_, err = s.ExportCAR(ctx, unsigned, buf)
+	require.True(t, err != nil && strings.Contains(err.Error(), ErrNotSigned.Error()))
+	_, err = s.CreateTorrent(ctx, unsigned, nil)
+	require.True(t, err != nil && strings.Contains(err.Error(), ErrNotSigned.Error()))
+
+	// signatures are found through the index, and only valid ones are restored on reindex
+	sigs, err := s.Signatures(ctx, root)
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+	_, err = s.StoreSchema(ctx, &bad)
+	require.NoError(t, err)
+	it := storage.IteratePinsByPrefix(ctx, s.st, indexPin(signatureIndex))
+	for it.Next() {
+		require.NoError(t, s.st.DeletePin(ctx, it.Pin().Name))
+	}
+	require.NoError(t, it.Err())
+	it.Close()
+	sigs, err = s.Signatures(ctx, root)
+	require.NoError(t, err)
+	require.Empty(t, sigs)
+	require.NoError(t, s.ReindexSchema(ctx, false))
+	sigs, err = s.Signatures(ctx, root)
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+	require.Equal(t, "release", sigs[0].Pin)
+	sigs, err = s.Signatures(ctx, unsigned)
+	require.NoError(t, err)
+	require.Len(t, sigs, 1)
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	k1, k2 := newTestSigner(t), newTestSigner(t)
+	data := "# trusted keys\n" +
+		string(ssh.MarshalAuthorizedKey(k1.PublicKey())) + "\n" +
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k2.PublicKey()))) + " user@host\n"
+	keys, err := ParseAuthorizedKeys([]byte(data))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, ssh.FingerprintSHA256(k2.PublicKey()), ssh.FingerprintSHA256(keys[1]))
+}
@@ -1,7 +1,10 @@
 package all
 
 import (
+	_ "github.com/dennwc/cas/storage/encrypted"
 	_ "github.com/dennwc/cas/storage/gcs"
 	_ "github.com/dennwc/cas/storage/http"
 	_ "github.com/dennwc/cas/storage/local"
+	_ "github.com/dennwc/cas/storage/perkeep"
+	_ "github.com/dennwc/cas/storage/reapi"
 )
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/dennwc/cas/types"
+)
+
+// BatchStater is an optional interface for storages that can check the existence of multiple blobs at once.
+type BatchStater interface {
+	// StatBlobs returns sizes of blobs that exist in the storage. Missing blobs are not included in the result.
+	StatBlobs(ctx context.Context, refs []types.Ref) ([]types.SizedRef, error)
+}
+
+// BatchFetcher is an optional interface for storages that can stream multiple blobs at once.
+type BatchFetcher interface {
+	// FetchBlobs calls fnc for the content of each blob that exists in the storage. Missing blobs are skipped.
+	// The reader is only valid until fnc returns.
+	FetchBlobs(ctx context.Context, refs []types.Ref, fnc func(sr types.SizedRef, r io.Reader) error) error
+}
+
+// StatBlobs returns sizes of blobs that exist in the storage. Missing blobs are not included in the result.
+// If storage doesn't implement BatchStater, blobs are checked one by one.
+func StatBlobs(ctx context.Context, s BlobSource, refs []types.Ref) ([]types.SizedRef, error) {
+	if bs, ok := s.(BatchStater); ok {
+		return bs.StatBlobs(ctx, refs)
+	}
+	var out []types.SizedRef
+	for _, ref := range refs {
+		sz, err := s.StatBlob(ctx, ref)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return out, err
+		}
+		out = append(out, types.SizedRef{Ref: ref, Size: sz})
+	}
+	return out, nil
+}
+
+// FetchBlobs calls fnc for the content of each blob that exists in the storage. Missing blobs are skipped.
+// If storage doesn't implement BatchFetcher, blobs are fetched one by one.
+func FetchBlobs(ctx context.Context, s BlobSource, refs []types.Ref, fnc func(sr types.SizedRef, r io.Reader) error) error {
+	if bs, ok := s.(BatchFetcher); ok {
+		return bs.FetchBlobs(ctx, refs, fnc)
+	}
+	for _, ref := range refs {
+		rc, sz, err := s.FetchBlob(ctx, ref)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = fnc(types.SizedRef{Ref: ref, Size: sz}, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyBlobs copies blobs from one storage to another. Blobs that already exist in the destination are skipped,
+// as well as blobs that are missing in the source. It returns the number of copied blobs.
+func CopyBlobs(ctx context.Context, dst BlobStorage, src BlobSource, refs []types.Ref) (int, error) {
+	have, err := StatBlobs(ctx, dst, refs)
+	if err != nil {
+		return 0, err
+	}
+	exists := make(map[types.Ref]struct{}, len(have))
+	for _, sr := range have {
+		exists[sr.Ref] = struct{}{}
+	}
+	missing := make([]types.Ref, 0, len(refs)-len(have))
+	for _, ref := range refs {
+		if _, ok := exists[ref]; !ok {
+			missing = append(missing, ref)
+		}
+	}
+	n := 0
+	err = FetchBlobs(ctx, src, missing, func(sr types.SizedRef, r io.Reader) error {
+		w, err := dst.BeginBlob(WithHash(ctx, sr.Ref.Name()))
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		if _, err = io.Copy(w, r); err != nil {
+			return err
+		}
+		got, err := w.Complete()
+		if err != nil {
+			return err
+		} else if got.Ref != sr.Ref {
+			return ErrRefMissmatch{Exp: sr.Ref, Got: got.Ref}
+		}
+		if err = w.Commit(); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,545 @@
+// Package encrypted implements a storage wrapper that encrypts blobs and pins before passing them to other storage.
+//
+// Blob content is encrypted with AES-GCM in chunks, with a separate key derived for each blob. Since the inner
+// storage addresses blobs by a ref of the ciphertext, a mapping from a plaintext ref to the ciphertext is kept
+// in sealed record blobs that are referenced by inner pins. Names of those pins are derived from plaintext refs with a keyed hash, thus neither the content
+// nor the refs are visible to the inner storage. User pins are stored the same way.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+var (
+	_ storage.Storage = (*Storage)(nil)
+)
+
+const (
+	// KeySize is the size of the master key in bytes.
+	KeySize = 32
+
+	pinBlobPrefix = "b." // inner pins that map plaintext refs to blob records
+	pinUserPrefix = "p." // inner pins that store encrypted user pins
+
+	recordVersion = 1
+)
+
+func init() {
+	storage.RegisterConfig("cas:EncryptedConfig", &Config{})
+}
+
+// Config is a configuration for an encrypted storage. The key can be read either from a file or from
+// an environment variable; in both cases it should be 32 bytes in raw, hex or base64 form.
+type Config struct {
+	KeyFile string         `json:"key_file,omitempty"`
+	KeyEnv  string         `json:"key_env,omitempty"`
+	Storage storage.Config `json:"storage"`
+}
+
+func (c *Config) References() []types.Ref {
+	return nil
+}
+
+type jsonConfig struct {
+	KeyFile string          `json:"key_file,omitempty"`
+	KeyEnv  string          `json:"key_env,omitempty"`
+	Storage json.RawMessage `json:"storage"`
+}
+
+func (c *Config) MarshalJSON() ([]byte, error) {
+	jc := jsonConfig{KeyFile: c.KeyFile, KeyEnv: c.KeyEnv}
+	if c.Storage != nil {
+		buf := new(bytes.Buffer)
+		if err := storage.EncodeConfig(buf, c.Storage); err != nil {
+			return nil, err
+		}
+		jc.Storage = buf.Bytes()
+	}
+	return json.Marshal(jc)
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var jc jsonConfig
+	if err := json.Unmarshal(data, &jc); err != nil {
+		return err
+	}
+	*c = Config{KeyFile: jc.KeyFile, KeyEnv: jc.KeyEnv}
+	if len(jc.Storage) != 0 && string(jc.Storage) != "null" {
+		sc, err := storage.DecodeConfig(bytes.NewReader(jc.Storage))
+		if err != nil {
+			return err
+		}
+		c.Storage = sc
+	}
+	return nil
+}
+
+// readKey reads the master key from the source specified in the config.
+func (c *Config) readKey() ([]byte, error) {
+	switch {
+	case c.KeyFile != "" && c.KeyEnv != "":
+		return nil, fmt.Errorf("encrypted: either key file or key env should be set")
+	case c.KeyFile != "":
+		data, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKey(data)
+	case c.KeyEnv != "":
+		v := os.Getenv(c.KeyEnv)
+		if v == "" {
+			return nil, fmt.Errorf("encrypted: key env %q is not set", c.KeyEnv)
+		}
+		return ParseKey([]byte(v))
+	default:
+		return nil, fmt.Errorf("encrypted: key source is not set")
+	}
+}
+
+func (c *Config) OpenStorage(ctx context.Context) (storage.Storage, error) {
+	if c.Storage == nil {
+		return nil, fmt.Errorf("encrypted: inner storage is not set")
+	}
+	key, err := c.readKey()
+	if err != nil {
+		return nil, err
+	}
+	st, err := c.Storage.OpenStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(st, key)
+	if err != nil {
+		st.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// ParseKey decodes the master key. It accepts raw, hex and base64 forms.
+func ParseKey(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return data, nil
+	}
+	s := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("encrypted: key must be %d bytes in raw, hex or base64 form", KeySize)
+}
+
+// NewKey generates a new random master key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// deriveKey derives a sub-key for a specific purpose from the master key.
+func deriveKey(key []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte("cas:encrypted:" + purpose))
+	return m.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// New wraps the storage and encrypts all the data with a given master key.
+func New(st storage.Storage, key []byte) (*Storage, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encrypted: expected %d byte key, got %d", KeySize, len(key))
+	}
+	meta, err := newAEAD(deriveKey(key, "meta"))
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{
+		st: st, meta: meta,
+		data:    streamKey{key: deriveKey(key, "blob")},
+		index:   deriveKey(key, "index"),
+		records: make(map[types.Ref]blobRecord),
+	}, nil
+}
+
+// Storage encrypts blobs and pins before writing them to the inner storage.
+type Storage struct {
+	st    storage.Storage
+	data  streamKey   // encrypts blob content
+	meta  cipher.AEAD // encrypts records
+	index []byte      // key for deriving names of inner pins
+
+	mu      sync.RWMutex
+	records map[types.Ref]blobRecord
+}
+
+// blobRecord maps a plaintext ref to a ref of the encrypted blob in the inner storage.
+type blobRecord struct {
+	Ref  types.Ref `json:"ref"`
+	Size uint64    `json:"size"`
+	Data types.Ref `json:"data"`
+}
+
+// pinRecord stores the name and the value of a user pin.
+type pinRecord struct {
+	Name string    `json:"name"`
+	Ref  types.Ref `json:"ref"`
+}
+
+func (s *Storage) Close() error {
+	return s.st.Close()
+}
+
+// pinName derives a name of an inner pin with a keyed hash.
+func (s *Storage) pinName(prefix, name string) string {
+	m := hmac.New(sha256.New, s.index)
+	m.Write([]byte(prefix + name))
+	return prefix + hex.EncodeToString(m.Sum(nil))
+}
+
+func (s *Storage) blobPin(ref types.Ref) string {
+	return s.pinName(pinBlobPrefix, ref.String())
+}
+
+func (s *Storage) userPin(name string) string {
+	return s.pinName(pinUserPrefix, name)
+}
+
+// storeRecord seals a record and stores it as a blob in the inner storage.
+// The kind of the record is authenticated, so records of one kind cannot be used in place of the other.
+func (s *Storage) storeRecord(ctx context.Context, kind string, v interface{}) (types.Ref, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return types.Ref{}, err
+	}
+	nonce := make([]byte, s.meta.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return types.Ref{}, err
+	}
+	buf := append([]byte{recordVersion}, nonce...)
+	buf = s.meta.Seal(buf, nonce, data, []byte(kind))
+	sr, err := storage.WriteBytes(ctx, s.st, buf)
+	if err != nil {
+		return types.Ref{}, err
+	}
+	return sr.Ref, nil
+}
+
+// loadRecord reads and decrypts a record from the inner storage.
+func (s *Storage) loadRecord(ctx context.Context, kind string, ref types.Ref, v interface{}) error {
+	rc, _, err := s.st.FetchBlob(ctx, ref)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	buf, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	ns := s.meta.NonceSize()
+	if len(buf) < 1+ns || buf[0] != recordVersion {
+		return errCorrupted
+	}
+	data, err := s.meta.Open(nil, buf[1:1+ns], buf[1+ns:], []byte(kind))
+	if err != nil {
+		return errCorrupted
+	}
+	return json.Unmarshal(data, v)
+}
+
+// getRecord finds a ciphertext blob for a given plaintext ref.
+func (s *Storage) getRecord(ctx context.Context, ref types.Ref) (blobRecord, error) {
+	if ref.Zero() {
+		return blobRecord{}, storage.ErrInvalidRef
+	}
+	s.mu.RLock()
+	rec, ok := s.records[ref]
+	s.mu.RUnlock()
+	if ok {
+		return rec, nil
+	}
+	rref, err := s.st.GetPin(ctx, s.blobPin(ref))
+	if err != nil {
+		return blobRecord{}, err
+	}
+	if err = s.loadRecord(ctx, pinBlobPrefix, rref, &rec); err != nil {
+		return blobRecord{}, err
+	}
+	if rec.Ref != ref {
+		return blobRecord{}, errCorrupted
+	}
+	s.mu.Lock()
+	s.records[ref] = rec
+	s.mu.Unlock()
+	return rec, nil
+}
+
+func (s *Storage) StatBlob(ctx context.Context, ref types.Ref) (uint64, error) {
+	rec, err := s.getRecord(ctx, ref)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = s.st.StatBlob(ctx, rec.Data); err != nil {
+		return 0, err
+	}
+	return rec.Size, nil
+}
+
+func (s *Storage) FetchBlob(ctx context.Context, ref types.Ref) (io.ReadCloser, uint64, error) {
+	rec, err := s.getRecord(ctx, ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	rc, _, err := s.st.FetchBlob(ctx, rec.Data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return storage.VerifyReader(newStreamReader(rc, &s.data), ref), rec.Size, nil
+}
+
+func (s *Storage) IterateBlobs(ctx context.Context) storage.Iterator {
+	return &blobIterator{s: s, ctx: ctx, it: storage.IteratePinsByPrefix(ctx, s.st, pinBlobPrefix)}
+}
+
+type blobIterator struct {
+	s   *Storage
+	ctx context.Context
+	it  storage.PinIterator
+	sr  types.SizedRef
+	err error
+}
+
+func (it *blobIterator) Next() bool {
+	it.sr = types.SizedRef{}
+	if it.err != nil {
+		return false
+	}
+	for it.it.Next() {
+		p := it.it.Pin()
+		if !strings.HasPrefix(p.Name, pinBlobPrefix) {
+			continue
+		}
+		var rec blobRecord
+		if err := it.s.loadRecord(it.ctx, pinBlobPrefix, p.Ref, &rec); err != nil {
+			it.err = err
+			return false
+		}
+		it.sr = types.SizedRef{Ref: rec.Ref, Size: rec.Size}
+		return true
+	}
+	it.err = it.it.Err()
+	return false
+}
+
+func (it *blobIterator) Err() error {
+	return it.err
+}
+
+func (it *blobIterator) SizedRef() types.SizedRef {
+	return it.sr
+}
+
+func (it *blobIterator) Close() error {
+	return it.it.Close()
+}
+
+func (s *Storage) BeginBlob(ctx context.Context) (storage.BlobWriter, error) {
+	hw, err := storage.HashFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w, err := s.st.BeginBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sw, err := newStreamWriter(w, &s.data)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &blobWriter{s: s, ctx: ctx, w: w, sw: sw, hw: hw}, nil
+}
+
+type blobWriter struct {
+	s   *Storage
+	ctx context.Context
+	w   storage.BlobWriter // inner writer for the ciphertext
+	sw  *streamWriter
+	hw  storage.BlobWriter // hashes the plaintext
+	rec *blobRecord
+}
+
+func (w *blobWriter) Size() uint64 {
+	return w.hw.Size()
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	if _, err := w.hw.Write(p); err != nil {
+		return 0, err
+	}
+	return w.sw.Write(p)
+}
+
+func (w *blobWriter) Complete() (types.SizedRef, error) {
+	if w.rec != nil {
+		return types.SizedRef{Ref: w.rec.Ref, Size: w.rec.Size}, nil
+	}
+	sr, err := w.hw.Complete()
+	if err != nil {
+		return types.SizedRef{}, err
+	}
+	if err = w.sw.Close(); err != nil {
+		return types.SizedRef{}, err
+	}
+	dr, err := w.w.Complete()
+	if err != nil {
+		return types.SizedRef{}, err
+	}
+	w.rec = &blobRecord{Ref: sr.Ref, Size: sr.Size, Data: dr.Ref}
+	return sr, nil
+}
+
+func (w *blobWriter) Close() error {
+	if err := w.hw.Close(); err != nil {
+		return err
+	}
+	return w.w.Close()
+}
+
+func (w *blobWriter) Commit() error {
+	if err := w.hw.Commit(); err != nil {
+		return err
+	}
+	if w.rec == nil {
+		if _, err := w.Complete(); err != nil {
+			return err
+		}
+	}
+	rec := *w.rec
+	if _, err := w.s.StatBlob(w.ctx, rec.Ref); err == nil {
+		// already stored; ciphertext is different because each blob key is derived from a random salt, so discard it
+		_ = w.w.Close()
+		return nil
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	if err := w.w.Commit(); err != nil {
+		return err
+	}
+	rref, err := w.s.storeRecord(w.ctx, pinBlobPrefix, rec)
+	if err != nil {
+		return err
+	}
+	if err = w.s.st.SetPin(w.ctx, w.s.blobPin(rec.Ref), rref); err != nil {
+		return err
+	}
+	w.s.mu.Lock()
+	w.s.records[rec.Ref] = rec
+	w.s.mu.Unlock()
+	return nil
+}
+
+func (s *Storage) SetPin(ctx context.Context, name string, ref types.Ref) error {
+	// inner pin names are opaque, thus conflicts are checked on user pins
+	if err := storage.CheckPinConflict(ctx, s, name); err != nil {
+		return err
+	}
+	rref, err := s.storeRecord(ctx, pinUserPrefix, pinRecord{Name: name, Ref: ref})
+	if err != nil {
+		return err
+	}
+	return s.st.SetPin(ctx, s.userPin(name), rref)
+}
+
+func (s *Storage) DeletePin(ctx context.Context, name string) error {
+	return s.st.DeletePin(ctx, s.userPin(name))
+}
+
+func (s *Storage) GetPin(ctx context.Context, name string) (types.Ref, error) {
+	rref, err := s.st.GetPin(ctx, s.userPin(name))
+	if err != nil {
+		return types.Ref{}, err
+	}
+	var rec pinRecord
+	if err = s.loadRecord(ctx, pinUserPrefix, rref, &rec); err != nil {
+		return types.Ref{}, err
+	}
+	if rec.Name != name {
+		return types.Ref{}, errCorrupted
+	}
+	return rec.Ref, nil
+}
+
+func (s *Storage) IteratePins(ctx context.Context) storage.PinIterator {
+	return &pinIterator{s: s, ctx: ctx, it: storage.IteratePinsByPrefix(ctx, s.st, pinUserPrefix)}
+}
+
+type pinIterator struct {
+	s   *Storage
+	ctx context.Context
+	it  storage.PinIterator
+	cur types.Pin
+	err error
+}
+
+func (it *pinIterator) Next() bool {
+	it.cur = types.Pin{}
+	if it.err != nil {
+		return false
+	}
+	for it.it.Next() {
+		p := it.it.Pin()
+		if !strings.HasPrefix(p.Name, pinUserPrefix) {
+			continue
+		}
+		var rec pinRecord
+		if err := it.s.loadRecord(it.ctx, pinUserPrefix, p.Ref, &rec); err != nil {
+			it.err = err
+			return false
+		}
+		it.cur = types.Pin{Name: rec.Name, Ref: rec.Ref}
+		return true
+	}
+	it.err = it.it.Err()
+	return false
+}
+
+func (it *pinIterator) Err() error {
+	return it.err
+}
+
+func (it *pinIterator) Pin() types.Pin {
+	return it.cur
+}
+
+func (it *pinIterator) Close() error {
+	return it.it.Close()
+}
@@ -0,0 +1,129 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/storage/test"
+	"github.com/dennwc/cas/types"
+)
+
+func newTestStorage(t testing.TB) (*Storage, storage.Storage) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	inner := storage.NewInMemory()
+	s, err := New(inner, key)
+	require.NoError(t, err)
+	return s, inner
+}
+
+func TestEncrypted(t *testing.T) {
+	storagetest.RunTests(t, func(t testing.TB) (storage.Storage, func()) {
+		s, _ := newTestStorage(t)
+		return s, func() {}
+	})
+}
+
+func TestEncryptedNoLeaks(t *testing.T) {
+	ctx := context.Background()
+	s, inner := newTestStorage(t)
+
+	// multiple chunks, the last one is full
+	data := bytes.Repeat([]byte("secret data "), chunkSize/4)[:3*chunkSize]
+	sr, err := storage.WriteBytes(ctx, s, data)
+	require.NoError(t, err)
+	require.Equal(t, types.BytesRef(data), sr.Ref)
+
+	err = s.SetPin(ctx, "secret-pin", sr.Ref)
+	require.NoError(t, err)
+
+	ref, err := s.GetPin(ctx, "secret-pin")
+	require.NoError(t, err)
+	require.Equal(t, sr.Ref, ref)
+
+	rc, sz, err := s.FetchBlob(ctx, sr.Ref)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), sz)
+	require.True(t, bytes.Equal(data, got))
+
+	it := inner.IterateBlobs(ctx)
+	defer it.Close()
+	for it.Next() {
+		bref := it.SizedRef().Ref
+		require.NotEqual(t, sr.Ref, bref)
+		rc, _, err := inner.FetchBlob(ctx, bref)
+		require.NoError(t, err)
+		raw, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(raw, []byte("secret")))
+		require.False(t, bytes.Contains(raw, []byte(sr.Ref.String())))
+	}
+	require.NoError(t, it.Err())
+
+	pit := inner.IteratePins(ctx)
+	defer pit.Close()
+	for pit.Next() {
+		require.False(t, strings.Contains(pit.Pin().Name, "secret"))
+	}
+	require.NoError(t, pit.Err())
+
+	// a wrong key must not decrypt anything
+	key, err := NewKey()
+	require.NoError(t, err)
+	s2, err := New(inner, key)
+	require.NoError(t, err)
+	_, err = s2.StatBlob(ctx, sr.Ref)
+	require.Equal(t, storage.ErrNotFound, err)
+}
+
+func TestEncryptedConfig(t *testing.T) {
+	conf := &Config{KeyEnv: "CAS_KEY", Storage: &Config{KeyFile: "inner.key"}}
+	buf := new(bytes.Buffer)
+	err := storage.EncodeConfig(buf, conf)
+	require.NoError(t, err)
+
+	got, err := storage.DecodeConfig(buf)
+	require.NoError(t, err)
+	require.Equal(t, conf, got)
+}
+
+func TestEncryptedStreamKeys(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	s, err := New(storage.NewInMemory(), key)
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("data "), chunkSize/2)
+	encrypt := func() []byte {
+		buf := new(bytes.Buffer)
+		w, err := newStreamWriter(buf, &s.data)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	decrypt := func(enc []byte) []byte {
+		got, err := io.ReadAll(newStreamReader(io.NopCloser(bytes.NewReader(enc)), &s.data))
+		require.NoError(t, err)
+		return got
+	}
+
+	// each blob is encrypted with a different key, even if the content is the same
+	enc1, enc2 := encrypt(), encrypt()
+	require.Equal(t, byte(streamVersion), enc1[0])
+	require.NotEqual(t, enc1[:1+saltSize], enc2[:1+saltSize])
+	require.NotEqual(t, enc1[1+saltSize:], enc2[1+saltSize:])
+	require.Equal(t, data, decrypt(enc1))
+	require.Equal(t, data, decrypt(enc2))
+}
@@ -0,0 +1,208 @@
+package encrypted
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// streamVersion encrypts each blob with a separate key derived from a random salt.
+	streamVersion = 1
+
+	chunkSize  = 64 * 1024
+	prefixSize = 7  // fixed part of the nonce; the rest is a chunk counter and the last chunk flag
+	saltSize   = 32 // random salt for deriving a blob key
+	tagSize    = 16 // overhead of AES-GCM
+)
+
+var errCorrupted = errors.New("encrypted: blob is corrupted or the key is wrong")
+
+// streamKey provides keys for encrypting blob content.
+type streamKey struct {
+	key []byte // key for deriving blob keys
+}
+
+// blobAEAD derives an AEAD for a single blob from a random salt. Nonces only need to be unique within the blob.
+func (k *streamKey) blobAEAD(salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, k.key, salt, []byte("cas:encrypted:blob")), key); err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+// chunkNonce builds a nonce for a chunk with a given index. A flag for the last chunk is a part of the nonce,
+// thus the stream cannot be truncated at chunk boundaries.
+func chunkNonce(dst []byte, prefix []byte, i uint32, last bool) []byte {
+	dst = append(dst[:0], prefix...)
+	dst = binary.BigEndian.AppendUint32(dst, i)
+	if last {
+		return append(dst, 1)
+	}
+	return append(dst, 0)
+}
+
+// streamWriter encrypts the data in fixed-size chunks with AEAD.
+type streamWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	salt   []byte
+	prefix []byte
+	nonce  []byte
+	buf    []byte
+	out    []byte
+	i      uint32
+	header bool
+}
+
+func newStreamWriter(w io.Writer, k *streamKey) (*streamWriter, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := k.blobAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	return &streamWriter{
+		w: w, aead: aead, salt: salt,
+		prefix: make([]byte, prefixSize),
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (w *streamWriter) writeChunk(last bool) error {
+	if !w.header {
+		hdr := append([]byte{streamVersion}, w.salt...)
+		if _, err := w.w.Write(hdr); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	if w.i == 1<<32-1 {
+		return fmt.Errorf("encrypted: blob is too large")
+	}
+	w.nonce = chunkNonce(w.nonce, w.prefix, w.i, last)
+	w.out = w.aead.Seal(w.out[:0], w.nonce, w.buf, nil)
+	w.buf = w.buf[:0]
+	w.i++
+	_, err := w.w.Write(w.out)
+	return err
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			// there is more data, so this chunk is not the last one
+			if err := w.writeChunk(false); err != nil {
+				return 0, err
+			}
+		}
+		m := cap(w.buf) - len(w.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Close writes the last chunk. It doesn't close the underlying writer.
+func (w *streamWriter) Close() error {
+	return w.writeChunk(true)
+}
+
+// streamReader decrypts the data written by streamWriter.
+type streamReader struct {
+	r      *bufio.Reader
+	c      io.Closer
+	key    *streamKey
+	aead   cipher.AEAD
+	prefix []byte
+	nonce  []byte
+	buf    []byte
+	out    []byte
+	i      uint32
+	last   bool
+	err    error
+}
+
+func newStreamReader(rc io.ReadCloser, k *streamKey) *streamReader {
+	return &streamReader{
+		r: bufio.NewReaderSize(rc, chunkSize+tagSize+1), c: rc,
+		key: k,
+		buf: make([]byte, chunkSize+tagSize),
+	}
+}
+
+func (r *streamReader) readHeader() error {
+	vers, err := r.r.ReadByte()
+	if err != nil {
+		return errCorrupted
+	}
+	if vers != streamVersion {
+		return fmt.Errorf("encrypted: unsupported blob version: %d", vers)
+	}
+	salt := make([]byte, saltSize)
+	if _, err = io.ReadFull(r.r, salt); err != nil {
+		return errCorrupted
+	}
+	if r.aead, err = r.key.blobAEAD(salt); err != nil {
+		return err
+	}
+	r.prefix = make([]byte, prefixSize)
+	return nil
+}
+
+func (r *streamReader) readChunk() error {
+	if r.prefix == nil {
+		if err := r.readHeader(); err != nil {
+			return err
+		}
+	}
+	n, err := io.ReadFull(r.r, r.buf)
+	if err == io.ErrUnexpectedEOF {
+		r.last = true
+	} else if err != nil {
+		return errCorrupted
+	} else if _, err = r.r.Peek(1); err == io.EOF {
+		r.last = true
+	} else if err != nil {
+		return err
+	}
+	r.nonce = chunkNonce(r.nonce, r.prefix, r.i, r.last)
+	r.out, err = r.aead.Open(r.out[:0], r.nonce, r.buf[:n], nil)
+	if err != nil {
+		return errCorrupted
+	}
+	r.i++
+	return nil
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		} else if r.last {
+			return 0, io.EOF
+		}
+		r.err = r.readChunk()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	return r.c.Close()
+}
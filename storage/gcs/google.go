@@ -2,7 +2,6 @@ package gcs
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"math/rand"
 	"strconv"
@@ -18,7 +17,9 @@ import (
 )
 
 var (
-	_ storage.Storage = (*Storage)(nil)
+	_ storage.Storage            = (*Storage)(nil)
+	_ storage.BlobPrefixIterator = (*Storage)(nil)
+	_ storage.PinPrefixIterator  = (*Storage)(nil)
 )
 
 const (
@@ -115,7 +116,18 @@ func (s *Storage) IterateBlobs(ctx context.Context) storage.Iterator {
 	}
 }
 
+func (s *Storage) IterateBlobsByPrefix(ctx context.Context, pref types.RefPrefix) storage.Iterator {
+	it := s.b.Objects(ctx, &gcs.Query{Delimiter: "/", Prefix: dirBlobs + pref.String()})
+	return &blobIterator{
+		objectsIterator: objectsIterator{it: it, pref: dirBlobs},
+	}
+}
+
 func (s *Storage) BeginBlob(ctx context.Context) (storage.BlobWriter, error) {
+	hw, err := storage.HashFor(ctx)
+	if err != nil {
+		return nil, err
+	}
 	for {
 		name := dirTmp + strconv.FormatUint(rnd.Uint64(), 16)
 		_, err := s.b.Object(name).Attrs(ctx)
@@ -126,11 +138,16 @@ func (s *Storage) BeginBlob(ctx context.Context) (storage.BlobWriter, error) {
 		}
 		ctx, discard := context.WithCancel(ctx)
 		w := s.b.Object(name).If(gcs.Conditions{DoesNotExist: true}).NewWriter(ctx)
-		return &blobWriter{s: s, ctx: ctx, w: w, discard: discard, hw: storage.Hash()}, nil
+		return &blobWriter{s: s, ctx: ctx, w: w, discard: discard, hw: hw}, nil
 	}
 }
 
 func (s *Storage) SetPin(ctx context.Context, name string, ref types.Ref) error {
+	if err := storage.ValidateNewPinName(name); err != nil {
+		return err
+	} else if err = storage.CheckPinConflict(ctx, s, name); err != nil {
+		return err
+	}
 	w := s.pinObject(name).NewWriter(ctx)
 	w.ObjectAttrs.Metadata = map[string]string{
 		metaRef: ref.String(),
@@ -139,12 +156,15 @@ func (s *Storage) SetPin(ctx context.Context, name string, ref types.Ref) error
 }
 
 func (s *Storage) DeletePin(ctx context.Context, name string) error {
+	if err := storage.ValidatePinName(name); err != nil {
+		return err
+	}
 	return s.pinObject(name).Delete(ctx)
 }
 
 func (s *Storage) GetPin(ctx context.Context, name string) (types.Ref, error) {
-	if strings.ContainsAny(name, "/?&") {
-		return types.Ref{}, fmt.Errorf("invalid pin name: %q", name)
+	if err := storage.ValidatePinName(name); err != nil {
+		return types.Ref{}, err
 	}
 	info, err := s.pinObject(name).Attrs(ctx)
 	if err == gcs.ErrObjectNotExist {
@@ -160,8 +180,14 @@ func (s *Storage) GetPin(ctx context.Context, name string) (types.Ref, error) {
 }
 
 func (s *Storage) IteratePins(ctx context.Context) storage.PinIterator {
+	return s.IteratePinsByPrefix(ctx, "")
+}
+
+func (s *Storage) IteratePinsByPrefix(ctx context.Context, prefix string) storage.PinIterator {
+	// no delimiter - pins in nested namespaces are listed as well
+	it := s.b.Objects(ctx, &gcs.Query{Prefix: dirPins + prefix})
 	return &pinsIterator{
-		objectsIterator: s.iterate(ctx, dirPins),
+		objectsIterator: objectsIterator{it: it, pref: dirPins},
 	}
 }
 
@@ -1,19 +1,52 @@
 package storage
 
 import (
+	"context"
 	"hash"
 
 	"github.com/dennwc/cas/types"
 )
 
+type hashKey struct{}
+
+// WithHash returns a context that instructs BeginBlob to use a specific hash algorithm.
+// The name should be validated with types.NewRefWith before.
+func WithHash(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, hashKey{}, name)
+}
+
+// HashName returns the name of a hash algorithm set with WithHash, or the default one.
+func HashName(ctx context.Context) string {
+	if name, ok := ctx.Value(hashKey{}).(string); ok && name != "" {
+		return name
+	}
+	return types.GetDefaultHash()
+}
+
+// Hash returns a BlobWriter that only calculates the ref of the data using the default hash.
 func Hash() BlobWriter {
-	return &hashWriter{h: types.NewRef().Hash()}
+	return newHashWriter(types.NewRef())
+}
+
+// HashFor is similar to Hash, but uses the hash algorithm set with WithHash.
+// It returns an error if the hash is not registered or cannot be used to address blobs.
+func HashFor(ctx context.Context) (BlobWriter, error) {
+	ref, err := types.NewRefWith(HashName(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newHashWriter(ref), nil
+}
+
+func newHashWriter(ref types.Ref) *hashWriter {
+	return &hashWriter{h: ref.Hash(), ref: types.SizedRef{Ref: ref}}
 }
 
 type hashWriter struct {
 	h    hash.Hash
 	size uint64
 	ref  types.SizedRef
+	done bool
 }
 
 func (w *hashWriter) Size() uint64 {
@@ -31,19 +64,20 @@ func (w *hashWriter) Write(p []byte) (int, error) {
 
 func (w *hashWriter) Complete() (types.SizedRef, error) {
 	if w.h != nil {
-		w.ref.Ref = types.NewRef().WithHash(w.h)
+		w.ref.Ref = w.ref.Ref.WithHash(w.h)
 		w.ref.Size = w.size
 		w.h = nil
+		w.done = true
 		return w.ref, nil
 	}
-	if w.ref.Ref.Zero() {
+	if !w.done {
 		return types.SizedRef{}, ErrBlobDiscarded
 	}
 	return w.ref, nil
 }
 
 func (w *hashWriter) Close() error {
-	if w.h == nil && !w.ref.Ref.Zero() {
+	if w.done {
 		return ErrBlobCompleted
 	}
 	w.h = nil
@@ -56,7 +90,7 @@ func (w *hashWriter) Commit() error {
 			return err
 		}
 	}
-	if w.ref.Ref.Zero() {
+	if !w.done {
 		return ErrBlobDiscarded
 	}
 	return nil
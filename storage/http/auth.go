@@ -0,0 +1,220 @@
+package httpstor
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dennwc/cas/storage"
+)
+
+// Perm is a set of permissions granted to a client.
+type Perm uint
+
+const (
+	PermReadBlobs Perm = 1 << iota
+	PermWriteBlobs
+	PermReadPins
+	PermWritePins
+	// PermLocksAdmin allows to remove Git LFS locks of other users.
+	PermLocksAdmin
+
+	PermRead  = PermReadBlobs | PermReadPins
+	PermWrite = PermWriteBlobs | PermWritePins
+	PermAll   = PermRead | PermWrite | PermLocksAdmin
+)
+
+var permNames = []struct {
+	name string
+	perm Perm
+}{
+	{"blobs:read", PermReadBlobs},
+	{"blobs:write", PermWriteBlobs},
+	{"pins:read", PermReadPins},
+	{"pins:write", PermWritePins},
+	{"locks:admin", PermLocksAdmin},
+	{"read", PermRead},
+	{"write", PermWrite},
+	{"all", PermAll},
+}
+
+// ParsePerm parses a list of permission names: blobs:read, blobs:write, pins:read, pins:write, locks:admin, read, write or all.
+func ParsePerm(names []string) (Perm, error) {
+	var p Perm
+loop:
+	for _, name := range names {
+		for _, pn := range permNames {
+			if pn.name == name {
+				p |= pn.perm
+				continue loop
+			}
+		}
+		return 0, fmt.Errorf("unknown permission: %q", name)
+	}
+	return p, nil
+}
+
+// Token is a credential accepted by the server. Either a bearer token, or a user name with a password must be set.
+type Token struct {
+	Token    string `json:"token,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Perms is a list of permissions granted to this token. See ParsePerm.
+	Perms []string `json:"perms"`
+	// PinPrefix restricts access to pins in a given namespace, for example "team" allows "team/data".
+	PinPrefix string `json:"pin_prefix,omitempty"`
+}
+
+// ReadTokens reads a JSON file with a list of tokens.
+func ReadTokens(path string) ([]Token, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var tokens []Token
+	if err = json.NewDecoder(f).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// access describes permissions of a client.
+type access struct {
+	perm      Perm
+	pinPrefix string
+	anonymous bool
+	user      string // empty for bearer tokens and anonymous clients
+}
+
+func (a *access) can(p Perm) bool {
+	return a.perm&p == p
+}
+
+// canPin checks if the pin name is within the allowed namespace. The prefix is matched by whole name components,
+// thus "team" allows "team" and "team/data", but not "teammate".
+func (a *access) canPin(name string) bool {
+	if a.pinPrefix == "" || name == a.pinPrefix {
+		return true
+	}
+	return strings.HasPrefix(name, a.pinPrefix+storage.PinSeparator)
+}
+
+type authToken struct {
+	access
+	token          []byte
+	user, password []byte
+}
+
+func newAuthTokens(tokens []Token) ([]authToken, error) {
+	out := make([]authToken, 0, len(tokens))
+	for i, t := range tokens {
+		if t.Token == "" && t.User == "" {
+			return nil, fmt.Errorf("token %d: either token or user must be set", i)
+		} else if t.Token != "" && t.User != "" {
+			return nil, fmt.Errorf("token %d: token and user cannot be set at the same time", i)
+		}
+		perm, err := ParsePerm(t.Perms)
+		if err != nil {
+			return nil, fmt.Errorf("token %d: %v", i, err)
+		}
+		pref := strings.TrimSuffix(t.PinPrefix, storage.PinSeparator)
+		at := authToken{access: access{perm: perm, pinPrefix: pref}}
+		if t.Token != "" {
+			at.token = []byte(t.Token)
+		} else {
+			at.user, at.password = []byte(t.User), []byte(t.Password)
+			at.access.user = t.User
+		}
+		out = append(out, at)
+	}
+	return out, nil
+}
+
+var (
+	// ErrUnauthorized is returned by Authorizer if credentials are required, but are missing or not valid.
+	ErrUnauthorized = errors.New("valid credentials are required")
+	// ErrForbidden is returned by Authorizer if the client has no permissions for a request.
+	ErrForbidden = errors.New("permission denied")
+)
+
+// Authorizer checks credentials the same way as the HTTP server.
+// It allows to protect other protocols served from the same storage, for example the Remote Execution API.
+type Authorizer struct {
+	tokens []authToken
+	anon   Perm
+}
+
+// NewAuthorizer creates an Authorizer for a given access control config.
+func NewAuthorizer(conf *ServerConfig) (*Authorizer, error) {
+	if conf == nil {
+		conf = &ServerConfig{}
+	}
+	tokens, err := newAuthTokens(conf.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &Authorizer{tokens: tokens, anon: conf.Anonymous}, nil
+}
+
+// Check verifies that the value of the Authorization header grants all permissions in p.
+// The header may be empty for anonymous clients.
+func (au *Authorizer) Check(header string, p Perm) error {
+	a := au.authorize(header)
+	if a == nil || (a.anonymous && !a.can(p)) {
+		return ErrUnauthorized
+	} else if !a.can(p) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// authorize checks credentials of the request. It returns nil if credentials were provided, but are not valid.
+func (s *server) authorize(r *http.Request) *access {
+	return s.auth.authorize(r.Header.Get("Authorization"))
+}
+
+// authorize checks the value of the Authorization header. It returns nil if credentials were provided, but are not valid.
+func (au *Authorizer) authorize(h string) *access {
+	r := &http.Request{Header: http.Header{"Authorization": {h}}}
+	if user, pass, ok := r.BasicAuth(); ok {
+		for i := range au.tokens {
+			t := &au.tokens[i]
+			if t.user != nil &&
+				subtle.ConstantTimeCompare(t.user, []byte(user)) == 1 &&
+				subtle.ConstantTimeCompare(t.password, []byte(pass)) == 1 {
+				return &t.access
+			}
+		}
+		return nil
+	}
+	if h == "" {
+		return &access{perm: au.anon, anonymous: true}
+	}
+	const bearer = "Bearer "
+	if len(h) < len(bearer) || !strings.EqualFold(h[:len(bearer)], bearer) {
+		return nil
+	}
+	token := []byte(strings.TrimSpace(h[len(bearer):]))
+	for i := range au.tokens {
+		t := &au.tokens[i]
+		if t.token != nil && subtle.ConstantTimeCompare(t.token, token) == 1 {
+			return &t.access
+		}
+	}
+	return nil
+}
+
+// deny writes an error for a client that has no permissions for a request.
+func (s *server) deny(w http.ResponseWriter, a *access) {
+	if a == nil || a.anonymous {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="cas"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusForbidden)
+}
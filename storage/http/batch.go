@@ -0,0 +1,213 @@
+package httpstor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+var (
+	_ storage.BatchStater  = (*Client)(nil)
+	_ storage.BatchFetcher = (*Client)(nil)
+)
+
+const (
+	// maxBatch is the maximal number of refs accepted by batch endpoints.
+	maxBatch = 10000
+	// clientBatch is the number of refs sent by the client in a single batch request.
+	clientBatch = 1000
+
+	batchStatPath  = "batch/stat"
+	batchFetchPath = "batch/fetch"
+)
+
+// batchHeader precedes the content of each blob in the batch fetch response.
+type batchHeader struct {
+	Ref  types.Ref `json:"ref"`
+	Size uint64    `json:"size"`
+}
+
+func (s *server) readBatch(w http.ResponseWriter, r *http.Request) ([]types.Ref, bool) {
+	var refs []types.Ref
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBatch*200))
+	if err := dec.Decode(&refs); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	} else if len(refs) > maxBatch {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return refs, true
+}
+
+// serveBatch serves batch endpoints. Both accept a JSON array of refs.
+//
+// Stat endpoint returns a JSON array of sized refs for blobs that exist in the storage.
+// Fetch endpoint streams the content of existing blobs, each preceded by a JSON line with a ref and a size.
+func (s *server) serveBatch(w http.ResponseWriter, r *http.Request, kind string) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	refs, ok := s.readBatch(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	switch kind {
+	case "stat":
+		out, err := storage.StatBlobs(ctx, s.s, refs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if out == nil {
+			out = []types.SizedRef{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	case "fetch":
+		w.Header().Set("Content-Type", "application/x-cas-blobs")
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		err := storage.FetchBlobs(ctx, s.s, refs, func(sr types.SizedRef, r io.Reader) error {
+			if err := enc.Encode(batchHeader{Ref: sr.Ref, Size: sr.Size}); err != nil {
+				return err
+			}
+			n, err := io.Copy(bw, r)
+			if err != nil {
+				return err
+			} else if uint64(n) != sr.Size {
+				return storage.ErrSizeMissmatch{Exp: sr.Size, Got: uint64(n)}
+			}
+			return nil
+		})
+		if err != nil {
+			// status code was already sent; the client will notice a truncated stream
+			return
+		}
+		_ = bw.Flush()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// errNoBatch is returned when the server doesn't support batch requests.
+var errNoBatch = fmt.Errorf("batch requests are not supported")
+
+func (c *Client) postBatch(ctx context.Context, path string, refs []types.Ref) (*http.Response, error) {
+	if c.noBatch.Load() {
+		return nil, errNoBatch
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.base+"/"+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(ctx)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		// older servers don't know this endpoint
+		resp.Body.Close()
+		c.noBatch.Store(true)
+		return nil, errNoBatch
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("unexpected status code on batch request: %v", resp.Status)
+}
+
+// splitBatch calls fnc for each portion of refs that fits into a single request.
+func splitBatch(refs []types.Ref, fnc func(refs []types.Ref) error) error {
+	for len(refs) > 0 {
+		n := len(refs)
+		if n > clientBatch {
+			n = clientBatch
+		}
+		if err := fnc(refs[:n]); err != nil {
+			return err
+		}
+		refs = refs[n:]
+	}
+	return nil
+}
+
+// StatBlobs implements storage.BatchStater. It falls back to individual requests if the server doesn't support batching.
+func (c *Client) StatBlobs(ctx context.Context, refs []types.Ref) ([]types.SizedRef, error) {
+	var out []types.SizedRef
+	err := splitBatch(refs, func(refs []types.Ref) error {
+		resp, err := c.postBatch(ctx, batchStatPath, refs)
+		if err == errNoBatch {
+			// hide batch methods of the client to use a fallback implementation
+			sub, err := storage.StatBlobs(ctx, struct{ storage.BlobSource }{c}, refs)
+			out = append(out, sub...)
+			return err
+		} else if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var sub []types.SizedRef
+		if err = json.NewDecoder(resp.Body).Decode(&sub); err != nil {
+			return err
+		}
+		out = append(out, sub...)
+		return nil
+	})
+	return out, err
+}
+
+// FetchBlobs implements storage.BatchFetcher. It falls back to individual requests if the server doesn't support batching.
+func (c *Client) FetchBlobs(ctx context.Context, refs []types.Ref, fnc func(sr types.SizedRef, r io.Reader) error) error {
+	return splitBatch(refs, func(refs []types.Ref) error {
+		resp, err := c.postBatch(ctx, batchFetchPath, refs)
+		if err == errNoBatch {
+			return storage.FetchBlobs(ctx, struct{ storage.BlobSource }{c}, refs, fnc)
+		} else if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		br := bufio.NewReader(resp.Body)
+		for {
+			line, err := br.ReadBytes('\n')
+			if err == io.EOF && len(line) == 0 {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			var h batchHeader
+			if err = json.Unmarshal(line, &h); err != nil {
+				return err
+			}
+			lr := &io.LimitedReader{R: br, N: int64(h.Size)}
+			if err = fnc(types.SizedRef{Ref: h.Ref, Size: h.Size}, lr); err != nil {
+				return err
+			}
+			// skip the rest of the blob, if it wasn't read completely
+			if _, err = io.Copy(ioutil.Discard, lr); err != nil {
+				return err
+			} else if lr.N != 0 {
+				return io.ErrUnexpectedEOF
+			}
+		}
+	})
+}
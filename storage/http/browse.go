@@ -0,0 +1,197 @@
+package httpstor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// etag returns a strong entity tag for a given ref. Different representations of the same content must use different suffixes.
+func etag(ref types.Ref, suffix string) string {
+	return `"` + ref.String() + suffix + `"`
+}
+
+// checkETag sets the ETag header and checks it against If-None-Match.
+// It returns true if the client has a fresh copy and the response was already written.
+func checkETag(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimSpace(t)
+		t = strings.TrimPrefix(t, "W/")
+		if t == "*" || t == tag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// wantsHTML checks if the client prefers an HTML response (a browser).
+func wantsHTML(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		if i := strings.IndexByte(v, ';'); i >= 0 {
+			v = v[:i]
+		}
+		if strings.TrimSpace(v) == "text/html" {
+			return true
+		}
+	}
+	return false
+}
+
+// contentType detects a content type from the file name or the first bytes of the blob.
+func contentType(name string, br *bufio.Reader) string {
+	head, _ := br.Peek(512)
+	if schema.IsSchema(head) {
+		return "application/json"
+	}
+	if ext := path.Ext(name); ext != "" {
+		if typ := mime.TypeByExtension(ext); typ != "" {
+			return typ
+		}
+	}
+	return http.DetectContentType(head)
+}
+
+// errStop is used to stop the directory listing.
+var errStop = fmt.Errorf("stop")
+
+// lookupPath resolves a slash-separated path inside the tree.
+// It returns the ref of the content and its schema object (nil for data blobs).
+func (s *server) lookupPath(ctx context.Context, ref types.Ref, fpath string) (types.Ref, schema.Object, error) {
+	ref, obj, err := storage.Unwrap(ctx, s.index, ref)
+	if err != nil {
+		return ref, nil, err
+	}
+	for _, name := range strings.Split(fpath, "/") {
+		if name == "" || name == "." {
+			continue
+		} else if !storage.IsDirObject(obj) {
+			return ref, nil, storage.ErrNotFound
+		}
+		var found *schema.DirEntry
+		err = storage.ReadDir(ctx, s.index, obj, func(ent *schema.DirEntry) error {
+			if ent.Name == name {
+				found = ent
+				return errStop
+			}
+			return nil
+		})
+		if err != nil && err != errStop {
+			return ref, nil, err
+		} else if found == nil || found.Ref.Zero() {
+			return ref, nil, storage.ErrNotFound
+		}
+		ref, obj, err = storage.Unwrap(ctx, s.index, found.Ref)
+		if err != nil {
+			return ref, nil, err
+		}
+	}
+	return ref, obj, nil
+}
+
+// serveTree serves a file or a directory at a given path inside the tree.
+func (s *server) serveTree(w http.ResponseWriter, r *http.Request, root types.Ref, fpath string) {
+	ref, obj, err := s.lookupPath(r.Context(), root, fpath)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	if storage.IsDirObject(obj) && wantsHTML(r) {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// relative links require a trailing slash
+			u := *r.URL
+			u.Path += "/"
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		s.serveDirHTML(w, r, ref, obj, fpath)
+		return
+	}
+	s.serveBlob(w, r, ref, path.Base(fpath))
+}
+
+type dirItem struct {
+	Name string
+	Link string
+	Size uint64
+	Ref  types.Ref
+}
+
+var dirTemplate = template.Must(template.New("dir").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+{{if .Parent}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Items}}<tr><td><a href="{{.Link}}">{{.Name}}</a></td><td>{{.Size}}</td><td><code>{{.Ref}}</code></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (s *server) serveDirHTML(w http.ResponseWriter, r *http.Request, ref types.Ref, obj schema.Object, fpath string) {
+	if checkETag(w, r, etag(ref, "-html")) {
+		return
+	}
+	ctx := r.Context()
+	var items []dirItem
+	err := storage.ReadDir(ctx, s.index, obj, func(ent *schema.DirEntry) error {
+		it := dirItem{Name: ent.Name, Size: ent.Size(), Ref: ent.Ref}
+		dir := ent.Mode&schema.ModeType == schema.ModeDir
+		if ent.Mode == 0 && !ent.Ref.Zero() {
+			// mode was not recorded - check the content
+			_, sub, err := storage.Unwrap(ctx, s.index, ent.Ref)
+			if err != nil {
+				return err
+			}
+			dir = storage.IsDirObject(sub)
+		}
+		// "./" prefix prevents names with colons from being interpreted as URL schemes
+		it.Link = "./" + url.PathEscape(ent.Name)
+		if dir {
+			it.Name += "/"
+			it.Link += "/"
+		}
+		items = append(items, it)
+		return nil
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return
+	}
+	_ = dirTemplate.Execute(w, struct {
+		Title  string
+		Parent bool
+		Items  []dirItem
+	}{
+		Title:  r.URL.Path,
+		Parent: strings.Trim(fpath, "/") != "",
+		Items:  items,
+	})
+}
@@ -2,19 +2,25 @@ package httpstor
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync/atomic"
 
 	"github.com/dennwc/cas/storage"
 	"github.com/dennwc/cas/types"
 )
 
 var (
-	_ storage.Storage = (*Client)(nil)
+	_ storage.Storage           = (*Client)(nil)
+	_ storage.PinPrefixIterator = (*Client)(nil)
 )
 
 func init() {
@@ -23,6 +29,10 @@ func init() {
 
 type Config struct {
 	URL string `json:"url"`
+	// TokenFile is a path to a file with a bearer token. Basic auth credentials can be set in the URL instead.
+	TokenFile string `json:"token_file,omitempty"`
+	// CACert is a path to a PEM file with CA certificates used to verify the server.
+	CACert string `json:"ca_cert,omitempty"`
 }
 
 func (c *Config) References() []types.Ref {
@@ -34,7 +44,28 @@ func (c *Config) OpenStorage(ctx context.Context) (storage.Storage, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewClient(c.URL), nil
+	cli := NewClient(c.URL)
+	if c.TokenFile != "" {
+		data, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		cli.SetToken(strings.TrimSpace(string(data)))
+	}
+	if c.CACert != "" {
+		data, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %q", c.CACert)
+		}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+		cli.SetHTTPClient(&http.Client{Transport: tr})
+	}
+	return cli, nil
 }
 
 // NewClient creates a CAS HTTP client with a given base address.
@@ -51,8 +82,11 @@ func NewClient(addr string) *Client {
 
 // Client is a HTTP client for CAS.
 type Client struct {
-	cli  *http.Client
-	base string
+	cli   *http.Client
+	base  string
+	token string
+
+	noBatch atomic.Bool // set if the server doesn't support batch requests
 }
 
 func (c *Client) Close() error { return nil }
@@ -62,6 +96,18 @@ func (c *Client) SetHTTPClient(cli *http.Client) {
 	c.cli = cli
 }
 
+// SetToken sets a bearer token that will be sent with each request.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.cli.Do(req)
+}
+
 func (c *Client) blobsURL() string {
 	return c.base + "/blobs/"
 }
@@ -71,11 +117,15 @@ func (c *Client) blobURL(ref types.Ref) string {
 }
 
 func (c *Client) pinsURL() string {
-	return c.blobsURL() + "/pins/"
+	return c.base + "/pins/"
 }
 
 func (c *Client) pinURL(name string) string {
-	return c.pinsURL() + name
+	sub := strings.Split(name, storage.PinSeparator)
+	for i, s := range sub {
+		sub[i] = url.PathEscape(s)
+	}
+	return c.pinsURL() + strings.Join(sub, "/")
 }
 
 func (c *Client) StatBlob(ctx context.Context, ref types.Ref) (uint64, error) {
@@ -88,7 +138,7 @@ func (c *Client) StatBlob(ctx context.Context, ref types.Ref) (uint64, error) {
 	}
 	req = req.WithContext(ctx)
 
-	resp, err := c.cli.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return 0, err
 	}
@@ -114,7 +164,7 @@ func (c *Client) FetchBlob(ctx context.Context, ref types.Ref) (io.ReadCloser, u
 	}
 	req = req.WithContext(ctx)
 
-	resp, err := c.cli.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -131,8 +181,18 @@ func (c *Client) FetchBlob(ctx context.Context, ref types.Ref) (io.ReadCloser, u
 	}
 }
 
+// BeginBlob starts a new blob upload. The data is stored in a temporary file until the blob is committed,
+// because the server requires a ref before the upload.
 func (c *Client) BeginBlob(ctx context.Context) (storage.BlobWriter, error) {
-	return nil, storage.ErrReadOnly // TODO
+	hw, err := storage.HashFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "cas-upload-")
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{c: c, ctx: ctx, f: f, hw: hw}, nil
 }
 
 func (c *Client) IterateBlobs(ctx context.Context) storage.Iterator {
@@ -146,16 +206,64 @@ func (c *Client) IterateBlobs(ctx context.Context) storage.Iterator {
 }
 
 func (c *Client) SetPin(ctx context.Context, name string, ref types.Ref) error {
-	return storage.ErrReadOnly // TODO
+	if err := storage.ValidateNewPinName(name); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", c.pinURL(name), strings.NewReader(ref.String()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return storage.ErrReadOnly
+	case http.StatusConflict:
+		return storage.ErrPinConflict
+	default:
+		return fmt.Errorf("unexpected status code on pin set: %v", resp.Status)
+	}
 }
 
 func (c *Client) DeletePin(ctx context.Context, name string) error {
-	return storage.ErrReadOnly // TODO
+	if err := storage.ValidatePinName(name); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("DELETE", c.pinURL(name), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return storage.ErrNotFound
+	case http.StatusMethodNotAllowed:
+		return storage.ErrReadOnly
+	default:
+		return fmt.Errorf("unexpected status code on pin delete: %v", resp.Status)
+	}
 }
 
 func (c *Client) GetPin(ctx context.Context, name string) (types.Ref, error) {
-	if strings.ContainsAny(name, "/?&") {
-		return types.Ref{}, fmt.Errorf("invalid pin name: %q", name)
+	if err := storage.ValidatePinName(name); err != nil {
+		return types.Ref{}, err
 	}
 	req, err := http.NewRequest("HEAD", c.pinURL(name), nil)
 	if err != nil {
@@ -163,7 +271,7 @@ func (c *Client) GetPin(ctx context.Context, name string) (types.Ref, error) {
 	}
 	req = req.WithContext(ctx)
 
-	resp, err := c.cli.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return types.Ref{}, err
 	}
@@ -180,15 +288,103 @@ func (c *Client) GetPin(ctx context.Context, name string) (types.Ref, error) {
 }
 
 func (c *Client) IteratePins(ctx context.Context) storage.PinIterator {
+	return c.IteratePinsByPrefix(ctx, "")
+}
+
+func (c *Client) IteratePinsByPrefix(ctx context.Context, prefix string) storage.PinIterator {
+	addr := c.pinsURL()
+	if prefix != "" {
+		addr += "?" + url.Values{"prefix": {prefix}}.Encode()
+	}
 	it := &pinsIterator{
 		jsonIterator: jsonIterator{
-			c: c, ctx: ctx, url: c.pinsURL(),
+			c: c, ctx: ctx, url: addr,
 		},
 	}
 	it.dst = &it.cur
 	return it
 }
 
+type blobWriter struct {
+	c   *Client
+	ctx context.Context
+	f   *os.File
+	hw  storage.BlobWriter
+	sr  types.SizedRef
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	if _, err := w.hw.Write(p); err != nil {
+		return 0, err
+	}
+	return w.f.Write(p)
+}
+
+func (w *blobWriter) Size() uint64 {
+	return w.hw.Size()
+}
+
+func (w *blobWriter) Complete() (types.SizedRef, error) {
+	sr, err := w.hw.Complete()
+	if err != nil {
+		return types.SizedRef{}, err
+	}
+	w.sr = sr
+	return sr, nil
+}
+
+func (w *blobWriter) Close() error {
+	if w.f != nil {
+		w.cleanup()
+	}
+	return w.hw.Close()
+}
+
+// cleanup removes a temporary file.
+func (w *blobWriter) cleanup() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+	w.f = nil
+}
+
+func (w *blobWriter) Commit() error {
+	if w.f == nil {
+		return storage.ErrBlobDiscarded
+	} else if w.sr.Ref.Zero() {
+		if _, err := w.Complete(); err != nil {
+			return err
+		}
+	}
+	if err := w.hw.Commit(); err != nil {
+		return err
+	}
+	defer w.cleanup()
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", w.c.blobURL(w.sr.Ref), ioutil.NopCloser(w.f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(w.sr.Size)
+	req = req.WithContext(w.ctx)
+
+	resp, err := w.c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return storage.ErrReadOnly
+	default:
+		return fmt.Errorf("unexpected status code on blob upload: %v", resp.Status)
+	}
+}
+
 type jsonIterator struct {
 	c   *Client
 	ctx context.Context
@@ -212,7 +408,7 @@ func (it *jsonIterator) Next() bool {
 		}
 		req = req.WithContext(it.ctx)
 
-		resp, err := it.c.cli.Do(req)
+		resp, err := it.c.do(req)
 		if err != nil {
 			it.err = err
 			return false
@@ -1,15 +1,24 @@
 package httpstor
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
 
+	"github.com/dennwc/cas/schema"
 	"github.com/dennwc/cas/types"
 	"github.com/stretchr/testify/require"
 
 	"github.com/dennwc/cas/storage"
+	storagetest "github.com/dennwc/cas/storage/test"
 )
 
 func TestHTTP(t *testing.T) {
@@ -67,4 +76,577 @@ func testHTTP(t *testing.T, pref string) {
 
 	require.False(t, it.Next())
 	require.NoError(t, it.Err())
+
+	const pin = "datasets/some data"
+	err = mem.SetPin(ctx, pin, sr.Ref)
+	require.NoError(t, err)
+	err = mem.SetPin(ctx, "other", sr.Ref)
+	require.NoError(t, err)
+
+	ref, err := cli.GetPin(ctx, pin)
+	require.NoError(t, err)
+	require.Equal(t, sr.Ref, ref)
+
+	_, err = cli.GetPin(ctx, "datasets")
+	require.Equal(t, storage.ErrNotFound, err)
+
+	pit := cli.IteratePinsByPrefix(ctx, "datasets/")
+	defer pit.Close()
+
+	require.True(t, pit.Next())
+	require.Equal(t, types.Pin{Name: pin, Ref: sr.Ref}, pit.Pin())
+	require.False(t, pit.Next())
+	require.NoError(t, pit.Err())
+
+	buf := new(bytes.Buffer)
+	err = schema.Encode(buf, &schema.PinInfo{Ref: sr.Ref, Message: "annotated"})
+	require.NoError(t, err)
+	isr, err := storage.WriteBytes(ctx, mem, buf.Bytes())
+	require.NoError(t, err)
+	err = mem.SetPin(ctx, "annotated", isr.Ref)
+	require.NoError(t, err)
+
+	resp, err := hs.Client().Get(hs.URL + pref + "/pins/?prefix=annotated")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var item struct {
+		types.Pin
+		Info *schema.PinInfo `json:"info"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&item)
+	require.NoError(t, err)
+	require.Equal(t, "annotated", item.Name)
+	require.Equal(t, isr.Ref, item.Ref)
+	require.NotNil(t, item.Info)
+	require.Equal(t, "annotated", item.Info.Message)
+}
+
+func TestHTTPAuth(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemory()
+
+	h, err := NewServerWith(mem, "", &ServerConfig{
+		Tokens: []Token{
+			{Token: "reader", Perms: []string{"read"}},
+			{Token: "writer", Perms: []string{"all"}},
+			{Token: "team", Perms: []string{"blobs:read", "blobs:write", "pins:read", "pins:write"}, PinPrefix: "team/"},
+			{User: "user", Password: "pass", Perms: []string{"all"}},
+		},
+	})
+	require.NoError(t, err)
+
+	hs := httptest.NewServer(h)
+	defer hs.Close()
+
+	client := func(token string) *Client {
+		cli := NewClient(hs.URL)
+		cli.SetHTTPClient(hs.Client())
+		cli.SetToken(token)
+		return cli
+	}
+
+	data := []byte("some data")
+	ref := types.BytesRef(data)
+
+	// anonymous clients have no access
+	_, err = client("").StatBlob(ctx, ref)
+	require.Error(t, err)
+	_, err = client("wrong").StatBlob(ctx, ref)
+	require.Error(t, err)
+
+	// readers cannot write
+	_, err = storage.WriteBytes(ctx, client("reader"), data)
+	require.Error(t, err)
+	_, err = mem.StatBlob(ctx, ref)
+	require.Equal(t, storage.ErrNotFound, err)
+
+	w := client("writer")
+	sr, err := storage.WriteBytes(ctx, w, data)
+	require.NoError(t, err)
+	require.Equal(t, ref, sr.Ref)
+	sz, err := client("reader").StatBlob(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), sz)
+
+	// hashes that cannot address blobs are rejected
+	for _, ref := range []string{
+		"git-sha1:e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+		"md5:5d41402abc4b2a76b9719d911017c592",
+	} {
+		req, err := http.NewRequest("PUT", hs.URL+"/blobs/"+ref, bytes.NewBufferString("hello"))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer writer")
+		resp, err := hs.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	require.NoError(t, w.SetPin(ctx, "root", ref))
+	got, err := client("reader").GetPin(ctx, "root")
+	require.NoError(t, err)
+	require.Equal(t, ref, got)
+	require.Error(t, client("reader").DeletePin(ctx, "root"))
+
+	// index pins are reserved and are not listed
+	const index = storage.IndexPinPrefix + "alias/md5/5d41402abc4b2a76b9719d911017c592"
+	require.Error(t, w.SetPin(ctx, index, ref))
+	_, err = mem.GetPin(ctx, index)
+	require.Equal(t, storage.ErrNotFound, err)
+	require.NoError(t, mem.SetPin(ctx, index, ref))
+	require.Error(t, w.DeletePin(ctx, index))
+	pit := w.IteratePins(ctx)
+	for pit.Next() {
+		require.False(t, storage.IsIndexPin(pit.Pin().Name))
+	}
+	require.NoError(t, pit.Err())
+	pit.Close()
+
+	// pins access is limited by prefix
+	team := client("team")
+	require.Error(t, team.SetPin(ctx, "root", ref))
+	_, err = team.GetPin(ctx, "root")
+	require.Error(t, err)
+	require.NoError(t, team.SetPin(ctx, "team/data", ref))
+
+	// prefixes only match whole name components
+	require.NoError(t, mem.SetPin(ctx, "teammate/data", ref))
+	require.Error(t, team.SetPin(ctx, "teammate/other", ref))
+	_, err = team.GetPin(ctx, "teammate/data")
+	require.Error(t, err)
+	require.Error(t, team.DeletePin(ctx, "teammate/data"))
+
+	it := team.IteratePins(ctx)
+	defer it.Close()
+	require.True(t, it.Next())
+	require.Equal(t, types.Pin{Name: "team/data", Ref: ref}, it.Pin())
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+
+	require.NoError(t, team.DeletePin(ctx, "team/data"))
+	_, err = mem.GetPin(ctx, "team/data")
+	require.Equal(t, storage.ErrNotFound, err)
+
+	// basic auth
+	u, err := url.Parse(hs.URL)
+	require.NoError(t, err)
+	u.User = url.UserPassword("user", "pass")
+	basic := NewClient(u.String())
+	basic.SetHTTPClient(hs.Client())
+	require.NoError(t, basic.DeletePin(ctx, "root"))
+
+	u.User = url.UserPassword("user", "wrong")
+	basic = NewClient(u.String())
+	basic.SetHTTPClient(hs.Client())
+	_, err = basic.StatBlob(ctx, ref)
+	require.Error(t, err)
+}
+
+func TestHTTPStorage(t *testing.T) {
+	storagetest.RunTests(t, func(t testing.TB) (storage.Storage, func()) {
+		h, err := NewServerWith(storage.NewInMemory(), "", &ServerConfig{Anonymous: PermAll})
+		require.NoError(t, err)
+		hs := httptest.NewServer(h)
+		cli := NewClient(hs.URL)
+		cli.SetHTTPClient(hs.Client())
+		return cli, hs.Close
+	})
+}
+
+func writeTestSchema(t testing.TB, s storage.Storage, o schema.Object) types.SizedRef {
+	buf := new(bytes.Buffer)
+	require.NoError(t, schema.Encode(buf, o))
+	sr, err := storage.WriteBytes(context.Background(), s, buf.Bytes())
+	require.NoError(t, err)
+	return sr
+}
+
+func TestHTTPBrowse(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemory()
+
+	page := []byte("<html><body>hello</body></html>")
+	text := []byte("some text")
+	psr, err := storage.WriteBytes(ctx, mem, page)
+	require.NoError(t, err)
+	tsr, err := storage.WriteBytes(ctx, mem, text)
+	require.NoError(t, err)
+
+	typeDirEnt := schema.MustTypeOf(&schema.DirEntry{})
+	sub := writeTestSchema(t, mem, &schema.InlineList{Elem: typeDirEnt, List: []schema.Object{
+		&schema.DirEntry{Name: "notes", Ref: tsr.Ref, Mode: schema.ModeRegular | 0644, Stats: schema.Stats{"size": tsr.Size}},
+	}})
+	root := writeTestSchema(t, mem, &schema.InlineList{Elem: typeDirEnt, List: []schema.Object{
+		&schema.DirEntry{Name: "index.html", Ref: psr.Ref, Mode: schema.ModeRegular | 0644, Stats: schema.Stats{"size": psr.Size}},
+		&schema.DirEntry{Name: "sub dir", Ref: sub.Ref, Stats: schema.Stats{"size": tsr.Size}},
+	}})
+	info := writeTestSchema(t, mem, &schema.PinInfo{Ref: root.Ref, Message: "site"})
+	require.NoError(t, mem.SetPin(ctx, "site/v1", info.Ref))
+
+	hs := httptest.NewServer(NewServer(mem, ""))
+	defer hs.Close()
+	cli := hs.Client()
+	cli.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	get := func(path string, hdr map[string]string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", hs.URL+path, nil)
+		require.NoError(t, err)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		resp, err := cli.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(data)
+	}
+
+	// content type and ETag
+	resp, body := get("/blobs/"+psr.Ref.String(), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, string(page), body)
+	require.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	tag := resp.Header.Get("ETag")
+	require.Equal(t, `"`+psr.Ref.String()+`"`, tag)
+
+	resp, _ = get("/blobs/"+psr.Ref.String(), map[string]string{"If-None-Match": tag})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// missing blobs never match
+	missing := types.BytesRef([]byte("missing"))
+	resp, _ = get("/blobs/"+missing.String(), map[string]string{"If-None-Match": "*"})
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = get("/blobs/"+root.Ref.String(), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	// files inside pinned trees
+	resp, body = get("/pins/site/v1/sub%20dir/notes", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, string(text), body)
+	require.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	resp, _ = get("/pins/site/v1/missing", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = get("/blobs/"+root.Ref.String()+"/index.html", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// pins without a path are still redirected
+	resp, _ = get("/pins/site/v1", nil)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	// directory listings for browsers
+	html := map[string]string{"Accept": "text/html,application/xhtml+xml;q=0.9"}
+	resp, _ = get("/pins/site/v1", html)
+	require.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	require.Equal(t, "/pins/site/v1/", resp.Header.Get("Location"))
+
+	resp, body = get("/pins/site/v1/", html)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Contains(t, body, `href="./index.html"`)
+	require.Contains(t, body, `href="./sub%20dir/"`)
+	require.NotContains(t, body, `href="../"`)
+
+	resp, body = get("/pins/site/v1/sub%20dir/", html)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, `href="./notes"`)
+	require.Contains(t, body, `href="../"`)
+}
+
+func TestHTTPBatch(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemory()
+
+	var refs []types.Ref
+	var exp []types.SizedRef
+	for i := 0; i < 5; i++ {
+		sr, err := storage.WriteBytes(ctx, mem, bytes.Repeat([]byte{byte('a' + i)}, 10*i))
+		require.NoError(t, err)
+		refs = append(refs, sr.Ref)
+		exp = append(exp, sr)
+	}
+	missing := types.BytesRef([]byte("missing"))
+	refs = append(refs, missing)
+
+	srv := NewServer(mem, "")
+	posts := 0
+	for _, old := range []bool{false, true} {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" {
+				posts++
+				if old {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+			}
+			srv.ServeHTTP(w, r)
+		})
+		hs := httptest.NewServer(h)
+		cli := NewClient(hs.URL)
+		cli.SetHTTPClient(hs.Client())
+
+		got, err := storage.StatBlobs(ctx, cli, refs)
+		require.NoError(t, err)
+		require.Equal(t, exp, got)
+
+		var fetched []types.SizedRef
+		err = storage.FetchBlobs(ctx, cli, refs, func(sr types.SizedRef, r io.Reader) error {
+			// do not read some of the blobs
+			if sr.Size%20 == 0 {
+				fetched = append(fetched, sr)
+				return nil
+			}
+			got, err := types.Hash(r)
+			if err != nil {
+				return err
+			}
+			require.Equal(t, sr, got)
+			fetched = append(fetched, sr)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, exp, fetched)
+
+		// fallback is remembered
+		_, err = cli.StatBlobs(ctx, refs)
+		require.NoError(t, err)
+
+		hs.Close()
+	}
+	require.Equal(t, 4, posts)
+
+	// copy to a remote storage
+	remote := storage.NewInMemory()
+	h, err := NewServerWith(remote, "", &ServerConfig{Anonymous: PermAll})
+	require.NoError(t, err)
+	hs := httptest.NewServer(h)
+	defer hs.Close()
+	cli := NewClient(hs.URL)
+	cli.SetHTTPClient(hs.Client())
+
+	n, err := storage.CopyBlobs(ctx, cli, mem, refs[:3])
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	n, err = storage.CopyBlobs(ctx, cli, mem, refs)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+}
+
+func TestHTTPRegistry(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemory()
+
+	layer, err := storage.WriteBytes(ctx, mem, []byte("layer data"))
+	require.NoError(t, err)
+	config, err := storage.WriteBytes(ctx, mem, []byte(`{}`))
+	require.NoError(t, err)
+	manifest := []byte(`{"schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"` +
+		config.Ref.String() + `","size":2},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar","digest":"` +
+		layer.Ref.String() + `","size":10}]}`)
+	msr, err := storage.WriteBytes(ctx, mem, manifest)
+	require.NoError(t, err)
+	index := []byte(`{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` +
+		msr.Ref.String() + `","size":` + strconv.Itoa(len(manifest)) + `}]}`)
+	isr, err := storage.WriteBytes(ctx, mem, index)
+	require.NoError(t, err)
+	require.NoError(t, mem.SetPin(ctx, "library/app:v1", isr.Ref))
+	require.NoError(t, mem.SetPin(ctx, "library/app", msr.Ref))
+	require.NoError(t, mem.SetPin(ctx, "other:v1", msr.Ref))
+
+	h, err := NewServerWith(mem, "", &ServerConfig{
+		Tokens: []Token{
+			{User: "user", Password: "pass", Perms: []string{"read"}, PinPrefix: "library/"},
+		},
+	})
+	require.NoError(t, err)
+	hs := httptest.NewServer(h)
+	defer hs.Close()
+
+	get := func(method, path string, auth bool) (*http.Response, string) {
+		req, err := http.NewRequest(method, hs.URL+path, nil)
+		require.NoError(t, err)
+		if auth {
+			req.SetBasicAuth("user", "pass")
+		}
+		resp, err := hs.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(data)
+	}
+
+	// clients check the API version first to find out how to authenticate
+	resp, _ := get("GET", "/v2/", false)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, `Basic realm="cas"`, resp.Header.Get("WWW-Authenticate"))
+	resp, _ = get("GET", "/v2/", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "registry/2.0", resp.Header.Get("Docker-Distribution-API-Version"))
+
+	// index with a single image is unwrapped
+	resp, body := get("GET", "/v2/library/app/manifests/v1", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, string(manifest), body)
+	require.Equal(t, "application/vnd.oci.image.manifest.v1+json", resp.Header.Get("Content-Type"))
+	require.Equal(t, msr.Ref.String(), resp.Header.Get("Docker-Content-Digest"))
+
+	resp, body = get("HEAD", "/v2/library/app/manifests/"+isr.Ref.String(), true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "", body)
+	require.Equal(t, "application/vnd.oci.image.index.v1+json", resp.Header.Get("Content-Type"))
+	require.Equal(t, strconv.Itoa(len(index)), resp.Header.Get("Content-Length"))
+
+	resp, body = get("GET", "/v2/library/app/blobs/"+layer.Ref.String(), true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "layer data", body)
+	require.Equal(t, layer.Ref.String(), resp.Header.Get("Docker-Content-Digest"))
+
+	resp, body = get("GET", "/v2/library/app/tags/list", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `{"name":"library/app","tags":["latest","v1"]}`, body)
+
+	resp, body = get("GET", "/v2/library/app/manifests/v2", true)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Contains(t, body, "MANIFEST_UNKNOWN")
+
+	// pins outside of the allowed prefix are not visible
+	resp, _ = get("GET", "/v2/other/manifests/v1", true)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = get("PUT", "/v2/library/app/manifests/v2", true)
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestHTTPLFS(t *testing.T) {
+	mem := storage.NewInMemory()
+	h, err := NewServerWith(mem, "", &ServerConfig{
+		LFS: true,
+		Tokens: []Token{
+			{User: "alice", Password: "a", Perms: []string{"all"}},
+			{User: "bob", Password: "b", Perms: []string{"all"}},
+			{User: "carol", Password: "c", Perms: []string{"read", "write"}},
+			{Token: "ci", Perms: []string{"all"}},
+		},
+	})
+	require.NoError(t, err)
+	hs := httptest.NewServer(h)
+	defer hs.Close()
+
+	do := func(user, method, path string, body string) (*http.Response, string) {
+		req, err := http.NewRequest(method, hs.URL+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		if user == "ci" {
+			req.Header.Set("Authorization", "Bearer "+user)
+		} else if user != "" {
+			req.SetBasicAuth(user, user[:1])
+		}
+		req.Header.Set("Accept", "application/vnd.git-lfs+json")
+		resp, err := hs.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(data)
+	}
+
+	data := "large file content"
+	sr, err := types.Hash(bytes.NewBufferString(data))
+	require.NoError(t, err)
+	oid := hex.EncodeToString(sr.Ref.Data())
+	obj := `{"oid":"` + oid + `","size":` + strconv.Itoa(len(data)) + `}`
+
+	resp, _ := do("", "POST", "/lfs/repo/objects/batch", `{"operation":"download","objects":[`+obj+`]}`)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, `Basic realm="cas"`, resp.Header.Get("LFS-Authenticate"))
+
+	var batch lfsBatchResponse
+	resp, body := do("alice", "POST", "/lfs/repo.git/info/lfs/objects/batch", `{"operation":"upload","objects":[`+obj+`]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &batch))
+	require.Len(t, batch.Objects, 1)
+	upload := batch.Objects[0].Actions["upload"]
+	require.NotNil(t, upload)
+	require.Equal(t, hs.URL+"/lfs/repo.git/info/lfs/objects/"+oid, upload.Href)
+
+	req, err := http.NewRequest("PUT", upload.Href, bytes.NewBufferString(data))
+	require.NoError(t, err)
+	for k, v := range upload.Header {
+		req.Header.Set(k, v)
+	}
+	resp, err = hs.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, _ = do("alice", "POST", "/lfs/repo/objects/"+oid+"/verify", obj)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// objects are stored as regular blobs
+	sz, err := mem.StatBlob(context.Background(), sr.Ref)
+	require.NoError(t, err)
+	require.Equal(t, sr.Size, sz)
+
+	batch = lfsBatchResponse{}
+	_, body = do("bob", "POST", "/lfs/other/objects/batch", `{"operation":"download","objects":[`+obj+`,{"oid":"`+oid[:10]+`","size":1}]}`)
+	require.NoError(t, json.Unmarshal([]byte(body), &batch))
+	require.Len(t, batch.Objects, 2)
+	require.NotNil(t, batch.Objects[0].Actions["download"])
+	require.NotNil(t, batch.Objects[1].Error)
+
+	resp, body = do("bob", "GET", "/lfs/other/objects/"+oid, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, data, body)
+
+	// locks
+	var created struct {
+		Lock lfsLock `json:"lock"`
+	}
+	resp, body = do("alice", "POST", "/lfs/repo/locks", `{"path":"assets/a.bin"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	require.Equal(t, "alice", created.Lock.Owner.Name)
+
+	resp, _ = do("bob", "POST", "/lfs/repo/locks", `{"path":"assets/a.bin"}`)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, body = do("bob", "GET", "/lfs/repo/locks?path=assets/a.bin", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, created.Lock.ID)
+
+	_, body = do("bob", "GET", "/lfs/other/locks", "")
+	require.JSONEq(t, `{"locks":[]}`, body)
+
+	var verify struct {
+		Ours   []lfsLock `json:"ours"`
+		Theirs []lfsLock `json:"theirs"`
+	}
+	_, body = do("bob", "POST", "/lfs/repo/locks/verify", `{}`)
+	require.NoError(t, json.Unmarshal([]byte(body), &verify))
+	require.Len(t, verify.Ours, 0)
+	require.Len(t, verify.Theirs, 1)
+
+	resp, _ = do("bob", "POST", "/lfs/repo/locks/"+created.Lock.ID+"/unlock", `{}`)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// clients without a user name cannot own locks
+	resp, _ = do("ci", "POST", "/lfs/repo/locks", `{"path":"assets/b.bin"}`)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = do("ci", "POST", "/lfs/repo/locks/"+created.Lock.ID+"/unlock", `{}`)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// writers cannot remove locks of other users, even with force
+	resp, _ = do("carol", "POST", "/lfs/repo/locks/"+created.Lock.ID+"/unlock", `{"force":true}`)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _ = do("bob", "POST", "/lfs/repo/locks/"+created.Lock.ID+"/unlock", `{"force":true}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = do("alice", "POST", "/lfs/repo/locks/"+created.Lock.ID+"/unlock", `{}`)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
 }
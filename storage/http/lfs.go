@@ -0,0 +1,547 @@
+package httpstor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// Git LFS API lets Git LFS clients store objects in CAS directly, since LFS object IDs are sha256 refs.
+// See https://github.com/git-lfs/git-lfs/tree/main/docs/api
+//
+// The API is served on "/lfs/", optionally followed by a repository name: "/lfs/<repo>/objects/batch".
+// Repositories share all objects, but have separate locks. Locks are stored as pins named "lfs-locks/<repo>/<id>".
+// Lock owners are identified by the user name, thus clients authenticated with bearer tokens and anonymous clients
+// cannot create locks. Locks of other users can only be removed with force by clients with the locks:admin permission.
+const (
+	lfsPrefix      = "lfs"
+	lfsMediaType   = "application/vnd.git-lfs+json"
+	lfsLocksPin    = "lfs-locks"
+	lfsHashAlgo    = "sha256"
+	lfsTransfer    = "basic"
+	lfsMaxBody     = 16 << 20
+	lfsActionValid = 24 * time.Hour
+)
+
+type lfsObject struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+type lfsAction struct {
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header,omitempty"`
+	ExpiresIn int               `json:"expires_in,omitempty"`
+}
+
+type lfsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lfsObjectResponse struct {
+	lfsObject
+	Authenticated bool                  `json:"authenticated,omitempty"`
+	Actions       map[string]*lfsAction `json:"actions,omitempty"`
+	Error         *lfsError             `json:"error,omitempty"`
+}
+
+type lfsGitRef struct {
+	Name string `json:"name"`
+}
+
+type lfsBatchRequest struct {
+	Operation string      `json:"operation"`
+	Transfers []string    `json:"transfers,omitempty"`
+	Ref       *lfsGitRef  `json:"ref,omitempty"`
+	Objects   []lfsObject `json:"objects"`
+	HashAlgo  string      `json:"hash_algo,omitempty"`
+}
+
+type lfsBatchResponse struct {
+	Transfer string              `json:"transfer"`
+	Objects  []lfsObjectResponse `json:"objects"`
+	HashAlgo string              `json:"hash_algo"`
+}
+
+type lfsLockOwner struct {
+	Name string `json:"name"`
+}
+
+type lfsLock struct {
+	ID       string        `json:"id"`
+	Path     string        `json:"path"`
+	LockedAt time.Time     `json:"locked_at"`
+	Owner    *lfsLockOwner `json:"owner,omitempty"`
+}
+
+// ownedBy checks if the lock is owned by the client. Clients without a user name do not own any locks.
+func (l *lfsLock) ownedBy(a *access) bool {
+	return a.user != "" && l.Owner != nil && l.Owner.Name == a.user
+}
+
+func lfsRef(oid string) (types.Ref, error) {
+	return types.ParseRef(lfsHashAlgo + ":" + oid)
+}
+
+func writeLFS(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", lfsMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func lfsErr(w http.ResponseWriter, status int, msg string) {
+	writeLFS(w, status, struct {
+		Message string `json:"message"`
+	}{msg})
+}
+
+// lfsDeny is the same as deny, but sends a basic auth challenge expected by Git LFS clients.
+func (s *server) lfsDeny(w http.ResponseWriter, a *access) {
+	if a == nil || a.anonymous {
+		w.Header().Set("LFS-Authenticate", `Basic realm="cas"`)
+		w.Header().Set("WWW-Authenticate", `Basic realm="cas"`)
+		lfsErr(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	lfsErr(w, http.StatusForbidden, "access denied")
+}
+
+func readLFSRequest(r *http.Request, v interface{}) error {
+	return json.NewDecoder(io.LimitReader(r.Body, lfsMaxBody)).Decode(v)
+}
+
+// serveLFS serves the Git LFS API. The path is relative to the "/lfs/" prefix.
+func (s *server) serveLFS(w http.ResponseWriter, r *http.Request, a *access, fpath string) {
+	if a == nil {
+		s.lfsDeny(w, a)
+		return
+	}
+	parts := strings.Split(fpath, "/")
+	i := 0
+	for ; i < len(parts); i++ {
+		if parts[i] == "objects" || parts[i] == "locks" {
+			break
+		}
+	}
+	if i == len(parts) {
+		lfsErr(w, http.StatusNotFound, "unsupported LFS endpoint")
+		return
+	}
+	// accept the default LFS endpoint of a Git remote as well: <repo>.git/info/lfs
+	repo := strings.Join(parts[:i], "/")
+	repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/info/lfs"), ".git")
+	base := s.lfsBaseURL(r, parts[:i])
+	kind, rest := parts[i], parts[i+1:]
+	switch {
+	case kind == "objects" && len(rest) == 1 && rest[0] == "batch":
+		if r.Method != "POST" {
+			lfsErr(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.serveLFSBatch(w, r, a, base)
+	case kind == "objects" && len(rest) == 1:
+		s.serveLFSObject(w, r, a, rest[0])
+	case kind == "objects" && len(rest) == 2 && rest[1] == "verify":
+		s.serveLFSVerify(w, r, a, rest[0])
+	case kind == "locks":
+		s.serveLFSLocks(w, r, a, repo, rest)
+	default:
+		lfsErr(w, http.StatusNotFound, "unsupported LFS endpoint")
+	}
+}
+
+// lfsBaseURL returns an absolute URL of the LFS endpoint for a request.
+func (s *server) lfsBaseURL(r *http.Request, repo []string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	} else if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		scheme = p
+	}
+	u := scheme + "://" + r.Host + s.pref + "/" + lfsPrefix
+	for _, p := range repo {
+		u += "/" + url.PathEscape(p)
+	}
+	return u
+}
+
+func (s *server) serveLFSBatch(w http.ResponseWriter, r *http.Request, a *access, base string) {
+	var req lfsBatchRequest
+	if err := readLFSRequest(r, &req); err != nil {
+		lfsErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.HashAlgo != "" && req.HashAlgo != lfsHashAlgo {
+		lfsErr(w, http.StatusConflict, "unsupported hash algorithm: "+req.HashAlgo)
+		return
+	} else if len(req.Transfers) != 0 && !containsString(req.Transfers, lfsTransfer) {
+		lfsErr(w, http.StatusConflict, "only basic transfer is supported")
+		return
+	}
+	upload := false
+	switch req.Operation {
+	case "download":
+		if !a.can(PermReadBlobs) {
+			s.lfsDeny(w, a)
+			return
+		}
+	case "upload":
+		if !a.can(PermWriteBlobs) {
+			s.lfsDeny(w, a)
+			return
+		}
+		upload = true
+	default:
+		lfsErr(w, http.StatusBadRequest, "unsupported operation: "+req.Operation)
+		return
+	}
+	// pass credentials of the request, so the client can use them for transfers
+	var hdr map[string]string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		hdr = map[string]string{"Authorization": auth}
+	}
+	action := func(href string) *lfsAction {
+		return &lfsAction{Href: href, Header: hdr, ExpiresIn: int(lfsActionValid / time.Second)}
+	}
+	resp := lfsBatchResponse{Transfer: lfsTransfer, HashAlgo: lfsHashAlgo}
+	refs := make([]types.Ref, len(req.Objects))
+	valid := make([]types.Ref, 0, len(req.Objects))
+	for i, o := range req.Objects {
+		if ref, err := lfsRef(o.OID); err == nil && !ref.Zero() && o.Size >= 0 {
+			refs[i] = ref
+			valid = append(valid, ref)
+		}
+	}
+	found, err := storage.StatBlobs(r.Context(), s.s, valid)
+	if err != nil {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sizes := make(map[types.Ref]uint64, len(found))
+	for _, sr := range found {
+		sizes[sr.Ref] = sr.Size
+	}
+	for i, o := range req.Objects {
+		or := lfsObjectResponse{lfsObject: o, Authenticated: true}
+		href := base + "/objects/" + o.OID
+		switch sz, ok := sizes[refs[i]]; {
+		case refs[i].Zero():
+			or.Error = &lfsError{Code: http.StatusUnprocessableEntity, Message: "invalid object"}
+		case ok && sz != uint64(o.Size):
+			or.Error = &lfsError{Code: http.StatusUnprocessableEntity, Message: "size mismatch"}
+		case ok && upload:
+			// object exists; no actions needed
+		case ok:
+			or.Actions = map[string]*lfsAction{"download": action(href)}
+		case upload:
+			or.Actions = map[string]*lfsAction{
+				"upload": action(href),
+				"verify": action(href + "/verify"),
+			}
+		default:
+			or.Error = &lfsError{Code: http.StatusNotFound, Message: "object does not exist"}
+		}
+		resp.Objects = append(resp.Objects, or)
+	}
+	writeLFS(w, http.StatusOK, resp)
+}
+
+func (s *server) serveLFSObject(w http.ResponseWriter, r *http.Request, a *access, oid string) {
+	ref, err := lfsRef(oid)
+	if err != nil || ref.Zero() {
+		lfsErr(w, http.StatusUnprocessableEntity, "invalid object id")
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		if !a.can(PermReadBlobs) {
+			s.lfsDeny(w, a)
+			return
+		}
+		s.serveBlob(w, r, ref, "")
+	case "PUT":
+		if !a.can(PermWriteBlobs) {
+			s.lfsDeny(w, a)
+			return
+		}
+		s.putBlob(w, r, ref)
+	default:
+		lfsErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *server) serveLFSVerify(w http.ResponseWriter, r *http.Request, a *access, oid string) {
+	if r.Method != "POST" {
+		lfsErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	} else if !a.can(PermReadBlobs) {
+		s.lfsDeny(w, a)
+		return
+	}
+	var o lfsObject
+	if err := readLFSRequest(r, &o); err != nil {
+		lfsErr(w, http.StatusBadRequest, err.Error())
+		return
+	} else if o.OID != oid {
+		lfsErr(w, http.StatusUnprocessableEntity, "object id mismatch")
+		return
+	}
+	ref, err := lfsRef(oid)
+	if err != nil || ref.Zero() {
+		lfsErr(w, http.StatusUnprocessableEntity, "invalid object id")
+		return
+	}
+	sz, err := s.s.StatBlob(r.Context(), ref)
+	if err == storage.ErrNotFound {
+		lfsErr(w, http.StatusNotFound, "object does not exist")
+		return
+	} else if err != nil {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if sz != uint64(o.Size) {
+		lfsErr(w, http.StatusUnprocessableEntity, "size mismatch")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// lfsLockPin returns a pin name for a lock. Lock ID is derived from the path, thus there is at most one lock per file.
+func lfsLockPin(repo, id string) string {
+	if repo == "" {
+		return lfsLocksPin + storage.PinSeparator + id
+	}
+	return lfsLocksPin + storage.PinSeparator + repo + storage.PinSeparator + id
+}
+
+func lfsLockID(path string) string {
+	h := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(h[:16])
+}
+
+func (s *server) getLFSLock(r *http.Request, repo, id string) (*lfsLock, error) {
+	ref, err := s.s.GetPin(r.Context(), lfsLockPin(repo, id))
+	if err != nil {
+		return nil, err
+	}
+	obj, err := storage.DecodeSchema(r.Context(), s.index, ref)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := obj.(*schema.LFSLock)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &lfsLock{ID: id, Path: l.Path, LockedAt: l.LockedAt, Owner: &lfsLockOwner{Name: l.Owner}}, nil
+}
+
+// listLFSLocks lists all locks of the repository.
+func (s *server) listLFSLocks(r *http.Request, repo string) ([]lfsLock, error) {
+	prefix := lfsLockPin(repo, "")
+	it := storage.IteratePinsByPrefix(r.Context(), s.s, prefix)
+	defer it.Close()
+	var ids []string
+	for it.Next() {
+		id := strings.TrimPrefix(it.Pin().Name, prefix)
+		if !strings.Contains(id, storage.PinSeparator) {
+			ids = append(ids, id)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	locks := []lfsLock{}
+	for _, id := range ids {
+		l, err := s.getLFSLock(r, repo, id)
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		locks = append(locks, *l)
+	}
+	return locks, nil
+}
+
+func (s *server) serveLFSLocks(w http.ResponseWriter, r *http.Request, a *access, repo string, rest []string) {
+	perm := PermReadPins
+	if r.Method == "POST" && (len(rest) == 0 || rest[len(rest)-1] == "unlock") {
+		perm = PermWritePins | PermWriteBlobs
+	}
+	if !a.can(perm) || !a.canPin(lfsLockPin(repo, "")) {
+		s.lfsDeny(w, a)
+		return
+	}
+	validate := storage.ValidatePinName
+	if len(rest) == 0 && r.Method == "POST" {
+		// new locks are stored as pins
+		validate = storage.ValidateNewPinName
+	}
+	if err := validate(lfsLockPin(repo, "id")); err != nil {
+		lfsErr(w, http.StatusBadRequest, "invalid repository name: "+err.Error())
+		return
+	}
+	switch {
+	case len(rest) == 0 && r.Method == "GET":
+		s.serveLFSLocksList(w, r, repo)
+	case len(rest) == 0 && r.Method == "POST":
+		s.createLFSLock(w, r, a, repo)
+	case len(rest) == 1 && rest[0] == "verify" && r.Method == "POST":
+		s.verifyLFSLocks(w, r, a, repo)
+	case len(rest) == 2 && rest[1] == "unlock" && r.Method == "POST":
+		s.deleteLFSLock(w, r, a, repo, rest[0])
+	default:
+		lfsErr(w, http.StatusNotFound, "unsupported LFS endpoint")
+	}
+}
+
+func (s *server) serveLFSLocksList(w http.ResponseWriter, r *http.Request, repo string) {
+	q := r.URL.Query()
+	var (
+		locks []lfsLock
+		err   error
+	)
+	if id, path := q.Get("id"), q.Get("path"); id != "" || path != "" {
+		if id == "" {
+			id = lfsLockID(path)
+		}
+		var l *lfsLock
+		l, err = s.getLFSLock(r, repo, id)
+		if err == nil && (path == "" || l.Path == path) {
+			locks = append(locks, *l)
+		} else if err == storage.ErrNotFound {
+			err = nil
+		}
+	} else {
+		locks, err = s.listLFSLocks(r, repo)
+	}
+	if err != nil {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if locks == nil {
+		locks = []lfsLock{}
+	}
+	writeLFS(w, http.StatusOK, struct {
+		Locks []lfsLock `json:"locks"`
+	}{locks})
+}
+
+func (s *server) createLFSLock(w http.ResponseWriter, r *http.Request, a *access, repo string) {
+	var req struct {
+		Path string     `json:"path"`
+		Ref  *lfsGitRef `json:"ref,omitempty"`
+	}
+	if err := readLFSRequest(r, &req); err != nil {
+		lfsErr(w, http.StatusBadRequest, err.Error())
+		return
+	} else if req.Path == "" {
+		lfsErr(w, http.StatusBadRequest, "path is not set")
+		return
+	} else if a.user == "" {
+		lfsErr(w, http.StatusForbidden, "locks can only be created by named users")
+		return
+	}
+	id := lfsLockID(req.Path)
+
+	s.lfsMu.Lock()
+	defer s.lfsMu.Unlock()
+	if l, err := s.getLFSLock(r, repo, id); err == nil {
+		writeLFS(w, http.StatusConflict, struct {
+			Lock    *lfsLock `json:"lock"`
+			Message string   `json:"message"`
+		}{l, "already locked"})
+		return
+	} else if err != storage.ErrNotFound {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	lock := &schema.LFSLock{Path: req.Path, Owner: a.user, LockedAt: time.Now().UTC().Truncate(time.Second)}
+	if req.Ref != nil {
+		lock.Ref = req.Ref.Name
+	}
+	buf := new(bytes.Buffer)
+	if err := schema.Encode(buf, lock); err != nil {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sr, err := storage.WriteBytes(r.Context(), s.s, buf.Bytes())
+	if err == nil {
+		err = s.s.SetPin(r.Context(), lfsLockPin(repo, id), sr.Ref)
+	}
+	if err == storage.ErrReadOnly {
+		lfsErr(w, http.StatusMethodNotAllowed, "storage is read-only")
+		return
+	} else if err != nil {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeLFS(w, http.StatusCreated, struct {
+		Lock lfsLock `json:"lock"`
+	}{lfsLock{ID: id, Path: lock.Path, LockedAt: lock.LockedAt, Owner: &lfsLockOwner{Name: lock.Owner}}})
+}
+
+func (s *server) verifyLFSLocks(w http.ResponseWriter, r *http.Request, a *access, repo string) {
+	locks, err := s.listLFSLocks(r, repo)
+	if err != nil {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp := struct {
+		Ours   []lfsLock `json:"ours"`
+		Theirs []lfsLock `json:"theirs"`
+	}{Ours: []lfsLock{}, Theirs: []lfsLock{}}
+	for _, l := range locks {
+		if l.ownedBy(a) {
+			resp.Ours = append(resp.Ours, l)
+		} else {
+			resp.Theirs = append(resp.Theirs, l)
+		}
+	}
+	writeLFS(w, http.StatusOK, resp)
+}
+
+func (s *server) deleteLFSLock(w http.ResponseWriter, r *http.Request, a *access, repo, id string) {
+	var req struct {
+		Force bool `json:"force"`
+	}
+	if err := readLFSRequest(r, &req); err != nil && err != io.EOF {
+		lfsErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.lfsMu.Lock()
+	defer s.lfsMu.Unlock()
+	l, err := s.getLFSLock(r, repo, id)
+	if err == storage.ErrNotFound {
+		lfsErr(w, http.StatusNotFound, "lock does not exist")
+		return
+	} else if err != nil {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if !l.ownedBy(a) {
+		if !req.Force {
+			lfsErr(w, http.StatusForbidden, "lock is owned by "+l.Owner.Name)
+			return
+		} else if !a.can(PermLocksAdmin) {
+			lfsErr(w, http.StatusForbidden, "not allowed to remove locks of other users")
+			return
+		}
+	}
+	if err = s.s.DeletePin(r.Context(), lfsLockPin(repo, id)); err != nil && err != storage.ErrNotFound {
+		lfsErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeLFS(w, http.StatusOK, struct {
+		Lock *lfsLock `json:"lock"`
+	}{l})
+}
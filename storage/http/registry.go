@@ -0,0 +1,299 @@
+package httpstor
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// Registry API serves images stored in CAS to Docker and other OCI clients in read-only mode.
+// See https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+//
+// Blobs and manifests are served by digest, since OCI digests are valid refs. Tags are mapped to pins:
+// a tag T of the repository N is a pin named "N:T". A pin named "N" is used as the "latest" tag.
+// The registry is only available if the server is mounted at the root path.
+const (
+	registryPrefix    = "v2"
+	registryTagSep    = ":"
+	registryLatestTag = "latest"
+
+	headerRegistryVersion = "Docker-Distribution-API-Version"
+	headerContentDigest   = "Docker-Content-Digest"
+
+	// maxRegistryManifest is the maximal size of a manifest that will be served by the registry.
+	maxRegistryManifest = 4 << 20
+)
+
+// Media types of manifests recognized by the registry.
+const (
+	registryOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	registryOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// Error codes of the registry API.
+const (
+	registryNameUnknown     = "NAME_UNKNOWN"
+	registryManifestUnknown = "MANIFEST_UNKNOWN"
+	registryBlobUnknown     = "BLOB_UNKNOWN"
+	registryDigestInvalid   = "DIGEST_INVALID"
+	registryUnauthorized    = "UNAUTHORIZED"
+	registryDenied          = "DENIED"
+	registryUnsupported     = "UNSUPPORTED"
+)
+
+type registryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// registryManifest is a part of an OCI index or manifest that is required to serve it.
+type registryManifest struct {
+	MediaType string `json:"mediaType,omitempty"`
+	Config    *struct {
+		MediaType string `json:"mediaType"`
+	} `json:"config,omitempty"`
+	Manifests []struct {
+		MediaType string          `json:"mediaType"`
+		Digest    string          `json:"digest"`
+		Platform  json.RawMessage `json:"platform,omitempty"`
+	} `json:"manifests,omitempty"`
+}
+
+// contentType returns a media type of the manifest. Manifests in OCI layouts may omit it.
+func (m *registryManifest) contentType() string {
+	if m.MediaType != "" {
+		return m.MediaType
+	} else if m.Config == nil && m.Manifests != nil {
+		return registryOCIIndex
+	}
+	return registryOCIManifest
+}
+
+func registryErr(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Errors []registryError `json:"errors"`
+	}{[]registryError{{Code: code, Message: msg}}})
+}
+
+// registryDeny is the same as deny, but sends a basic auth challenge expected by registry clients.
+func (s *server) registryDeny(w http.ResponseWriter, a *access) {
+	if a == nil || a.anonymous {
+		w.Header().Set("WWW-Authenticate", `Basic realm="cas"`)
+		registryErr(w, http.StatusUnauthorized, registryUnauthorized, "authentication required")
+		return
+	}
+	registryErr(w, http.StatusForbidden, registryDenied, "access denied")
+}
+
+// serveRegistry serves the registry API. The path is relative to the "/v2/" prefix.
+func (s *server) serveRegistry(w http.ResponseWriter, r *http.Request, a *access, fpath string) {
+	w.Header().Set(headerRegistryVersion, "registry/2.0")
+	if r.Method != "GET" && r.Method != "HEAD" {
+		registryErr(w, http.StatusMethodNotAllowed, registryUnsupported, "registry is read-only")
+		return
+	}
+	if fpath == "" {
+		// API version check; clients use it to discover if authentication is required
+		if a == nil || (a.anonymous && a.perm == 0) {
+			s.registryDeny(w, a)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	} else if a == nil {
+		s.registryDeny(w, a)
+		return
+	}
+	if name := strings.TrimSuffix(fpath, "/tags/list"); name != fpath {
+		s.serveRegistryTags(w, r, a, name)
+		return
+	}
+	for _, kind := range []string{"manifests", "blobs"} {
+		i := strings.LastIndex(fpath, "/"+kind+"/")
+		if i <= 0 {
+			continue
+		}
+		name, ref := fpath[:i], fpath[i+len(kind)+2:]
+		if kind == "blobs" {
+			s.serveRegistryBlob(w, r, a, ref)
+		} else {
+			s.serveRegistryManifest(w, r, a, name, ref)
+		}
+		return
+	}
+	registryErr(w, http.StatusNotFound, registryUnsupported, "unsupported registry endpoint")
+}
+
+func (s *server) serveRegistryBlob(w http.ResponseWriter, r *http.Request, a *access, digest string) {
+	if !a.can(PermReadBlobs) {
+		s.registryDeny(w, a)
+		return
+	}
+	ref, err := types.ParseRef(digest)
+	if err != nil || ref.Zero() {
+		registryErr(w, http.StatusBadRequest, registryDigestInvalid, "invalid digest")
+		return
+	}
+	if _, err = s.s.StatBlob(r.Context(), ref); err == storage.ErrNotFound {
+		registryErr(w, http.StatusNotFound, registryBlobUnknown, "blob unknown")
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set(headerContentDigest, ref.String())
+	s.serveBlob(w, r, ref, "")
+}
+
+// resolveTag finds a manifest ref for a tag of the repository.
+func (s *server) resolveTag(r *http.Request, a *access, name, tag string) (types.Ref, bool) {
+	pins := []string{name + registryTagSep + tag}
+	if tag == registryLatestTag {
+		pins = append(pins, name)
+	}
+	for _, pin := range pins {
+		if storage.ValidatePinName(pin) != nil || !a.canPin(pin) {
+			continue
+		}
+		ref, err := s.s.GetPin(r.Context(), pin)
+		if err == nil {
+			return ref, true
+		}
+	}
+	return types.Ref{}, false
+}
+
+func (s *server) readRegistryManifest(r *http.Request, ref types.Ref) (*registryManifest, []byte, error) {
+	rc, sz, err := s.s.FetchBlob(r.Context(), ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	if sz > maxRegistryManifest {
+		return nil, nil, storage.ErrNotFound
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(storage.VerifyReader(rc, ref), maxRegistryManifest))
+	if err != nil {
+		return nil, nil, err
+	}
+	var m registryManifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		// not a manifest
+		return nil, nil, storage.ErrNotFound
+	}
+	return &m, data, nil
+}
+
+func (s *server) serveRegistryManifest(w http.ResponseWriter, r *http.Request, a *access, name, reference string) {
+	if !a.can(PermReadBlobs) {
+		s.registryDeny(w, a)
+		return
+	}
+	var (
+		ref   types.Ref
+		byTag = !types.IsRef(reference)
+	)
+	if byTag {
+		if !a.can(PermReadPins) {
+			s.registryDeny(w, a)
+			return
+		}
+		var ok bool
+		ref, ok = s.resolveTag(r, a, name, reference)
+		if !ok {
+			registryErr(w, http.StatusNotFound, registryManifestUnknown, "unknown tag: "+reference)
+			return
+		}
+	} else {
+		var err error
+		ref, err = types.ParseRef(reference)
+		if err != nil || ref.Zero() {
+			registryErr(w, http.StatusBadRequest, registryDigestInvalid, "invalid digest")
+			return
+		}
+	}
+	m, data, err := s.readRegistryManifest(r, ref)
+	if err == nil && byTag && len(m.Manifests) == 1 && len(m.Manifests[0].Platform) == 0 &&
+		m.Manifests[0].MediaType == registryOCIManifest {
+		// OCI layouts usually wrap a single image into an index without a platform,
+		// but clients will refuse to pull it; serve the image manifest instead
+		var sub types.Ref
+		if sub, err = types.ParseRef(m.Manifests[0].Digest); err == nil {
+			ref = sub
+			m, data, err = s.readRegistryManifest(r, ref)
+		}
+	}
+	if err == storage.ErrNotFound {
+		registryErr(w, http.StatusNotFound, registryManifestUnknown, "manifest unknown")
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set(headerContentDigest, ref.String())
+	w.Header().Set("Content-Type", m.contentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if checkETag(w, r, etag(ref, "")) {
+		return
+	}
+	if r.Method == "GET" {
+		w.Write(data)
+	}
+}
+
+func (s *server) serveRegistryTags(w http.ResponseWriter, r *http.Request, a *access, name string) {
+	if !a.can(PermReadPins) {
+		s.registryDeny(w, a)
+		return
+	}
+	prefix := name + registryTagSep
+	it := storage.IteratePinsByPrefix(r.Context(), s.s, prefix)
+	defer it.Close()
+	tags := []string{}
+	for it.Next() {
+		pin := it.Pin().Name
+		tag := strings.TrimPrefix(pin, prefix)
+		if a.canPin(pin) && !strings.Contains(tag, storage.PinSeparator) {
+			tags = append(tags, tag)
+		}
+	}
+	if err := it.Err(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if _, ok := s.resolveTag(r, a, name, registryLatestTag); ok && !containsString(tags, registryLatestTag) {
+		tags = append(tags, registryLatestTag)
+	}
+	if len(tags) == 0 {
+		registryErr(w, http.StatusNotFound, registryNameUnknown, "unknown repository: "+name)
+		return
+	}
+	sort.Strings(tags)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{Name: name, Tags: tags})
+}
+
+func containsString(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
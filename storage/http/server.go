@@ -1,65 +1,206 @@
 package httpstor
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/dennwc/cas/schema"
 	"github.com/dennwc/cas/storage"
 	"github.com/dennwc/cas/types"
 )
 
 // NewServer creates a CAS HTTP server for a given URL path.
+// The server allows anyone to read the content, see NewServerWith for access control.
 func NewServer(s storage.Storage, urlPref string) http.Handler {
+	h, err := NewServerWith(s, urlPref, &ServerConfig{Anonymous: PermRead})
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// ServerConfig configures access to the server.
+type ServerConfig struct {
+	// Tokens is a list of credentials accepted by the server.
+	Tokens []Token
+	// Anonymous is a set of permissions granted to clients without credentials.
+	Anonymous Perm
+	// LFS enables the Git LFS API on "/lfs/".
+	LFS bool
+}
+
+// NewServerWith creates a CAS HTTP server for a given URL path with a specific access control config.
+func NewServerWith(s storage.Storage, urlPref string, conf *ServerConfig) (http.Handler, error) {
+	if conf == nil {
+		conf = &ServerConfig{}
+	}
+	auth, err := NewAuthorizer(conf)
+	if err != nil {
+		return nil, err
+	}
 	urlPref = strings.TrimSuffix(urlPref, "/")
-	return &server{s: s, pref: urlPref}
+	return &server{
+		s: s, index: storage.NewBlobIndexer(s), pref: urlPref,
+		auth: auth, lfs: conf.LFS,
+	}, nil
 }
 
 type server struct {
-	s    storage.Storage
-	pref string
+	s     storage.Storage
+	index storage.BlobIndexer
+	pref  string
+
+	auth *Authorizer
+
+	lfs   bool
+	lfsMu sync.Mutex // serializes changes to LFS locks
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" && r.Method != "HEAD" {
+	switch r.Method {
+	case "GET", "HEAD", "PUT", "DELETE", "POST":
+	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	a := s.authorize(r)
 	path := strings.TrimPrefix(r.URL.Path, s.pref)
 	path = strings.Trim(path, "/")
-	sub := strings.SplitN(path, "/", 3)
+	sub := strings.SplitN(path, "/", 2)
 
 	kind := sub[0]
 	sub = sub[1:]
+	if kind == registryPrefix && s.pref == "" {
+		// registry clients expect a different auth challenge
+		fpath := ""
+		if len(sub) != 0 {
+			fpath = sub[0]
+		}
+		s.serveRegistry(w, r, a, fpath)
+		return
+	} else if kind == lfsPrefix && s.lfs {
+		fpath := ""
+		if len(sub) != 0 {
+			fpath = sub[0]
+		}
+		s.serveLFS(w, r, a, fpath)
+		return
+	} else if a == nil {
+		s.deny(w, nil)
+		return
+	}
 	switch kind {
 	case "blobs":
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		perm := PermReadBlobs
+		if r.Method == "PUT" || r.Method == "DELETE" {
+			perm = PermWriteBlobs
+		}
+		if !a.can(perm) {
+			s.deny(w, a)
+			return
+		}
 		if len(sub) == 0 {
 			s.serveBlobsList(w, r)
 			return
-		} else if len(sub) != 1 {
-			w.WriteHeader(http.StatusBadRequest)
-			return
 		}
-		ref, err := types.ParseRef(sub[0])
+		sref, fpath := sub[0], ""
+		if i := strings.IndexByte(sref, '/'); i >= 0 {
+			sref, fpath = sref[:i], sref[i+1:]
+		}
+		ref, err := types.ParseRef(sref)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
-		s.serveBlob(w, r, ref)
+		if r.Method == "PUT" {
+			if fpath != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.putBlob(w, r, ref)
+			return
+		} else if fpath != "" || wantsHTML(r) {
+			s.serveTree(w, r, ref, fpath)
+			return
+		}
+		s.serveBlob(w, r, ref, "")
+		return
+	case "batch":
+		if !a.can(PermReadBlobs) {
+			s.deny(w, a)
+			return
+		} else if len(sub) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.serveBatch(w, r, sub[0])
 		return
 	case "pins":
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		perm := PermReadPins
+		if r.Method == "PUT" || r.Method == "DELETE" {
+			perm = PermWritePins
+		}
+		if !a.can(perm) {
+			s.deny(w, a)
+			return
+		}
 		if len(sub) == 0 {
-			s.servePinsList(w, r)
+			prefix := r.URL.Query().Get("prefix")
+			if !a.canPin(prefix) {
+				if !strings.HasPrefix(a.pinPrefix, prefix) {
+					s.deny(w, a)
+					return
+				}
+				prefix = a.pinPrefix
+			}
+			s.servePinsList(w, r, a, prefix)
+			return
+		}
+		if r.Method == "GET" || r.Method == "HEAD" {
+			s.servePinPath(w, r, a, sub[0])
 			return
-		} else if len(sub) != 1 {
+		}
+		name := sub[0]
+		validate := storage.ValidatePinName
+		if r.Method == "PUT" {
+			validate = storage.ValidateNewPinName
+		}
+		if err := validate(name); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		} else if !a.canPin(name) {
+			s.deny(w, a)
 			return
+		} else if storage.IsIndexPin(name) {
+			// index entries are trusted by CAS, thus only CAS itself can write them
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("pin names starting with " + storage.IndexPinPrefix + " are reserved"))
+			return
+		}
+		if r.Method == "PUT" {
+			s.putPin(w, r, name)
+		} else {
+			s.deletePin(w, r, name)
 		}
-		s.servePin(w, r, sub[0])
 		return
 	}
 	w.WriteHeader(http.StatusForbidden)
@@ -95,12 +236,14 @@ func (s *server) serveBlobsList(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (s *server) serveBlob(w http.ResponseWriter, r *http.Request, ref types.Ref) {
+// serveBlob serves the content of a blob. The name is used to detect the content type, if set.
+func (s *server) serveBlob(w http.ResponseWriter, r *http.Request, ref types.Ref, name string) {
 	if r.Method != "GET" && r.Method != "HEAD" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	// TODO: handle If-None-Match and write ETag for non-CAS clients
+	w.Header().Set("X-CAS-Ref", ref.String())
+	// the blob must exist before the ETag is checked, otherwise "If-None-Match: *" will match missing blobs
 	switch r.Method {
 	case "HEAD":
 		sz, err := s.s.StatBlob(r.Context(), ref)
@@ -112,8 +255,10 @@ func (s *server) serveBlob(w http.ResponseWriter, r *http.Request, ref types.Ref
 			w.Write([]byte(err.Error()))
 			return
 		}
+		if checkETag(w, r, etag(ref, "")) {
+			return
+		}
 		w.Header().Set("Content-Length", strconv.FormatUint(sz, 10))
-		w.Header().Set("X-CAS-Ref", ref.String())
 		return
 	case "GET":
 		rc, sz, err := s.s.FetchBlob(r.Context(), ref)
@@ -126,28 +271,84 @@ func (s *server) serveBlob(w http.ResponseWriter, r *http.Request, ref types.Ref
 			return
 		}
 		defer rc.Close()
+		if checkETag(w, r, etag(ref, "")) {
+			return
+		}
+		br := bufio.NewReaderSize(rc, 512)
+		w.Header().Set("Content-Type", contentType(name, br))
 		w.Header().Set("Content-Length", strconv.FormatUint(sz, 10))
-		w.Header().Set("X-CAS-Ref", ref.String())
-		_, _ = io.Copy(w, rc)
+		_, _ = io.Copy(w, br)
 		return
 	}
 	w.WriteHeader(http.StatusMethodNotAllowed)
 }
 
-func (s *server) servePinsList(w http.ResponseWriter, r *http.Request) {
-	it := s.s.IteratePins(r.Context())
+func (s *server) servePinsList(w http.ResponseWriter, r *http.Request, a *access, prefix string) {
+	it := allowedPins{storage.HideIndexPins(storage.IteratePinsByPrefix(r.Context(), s.s, prefix)), a}
 	defer it.Close()
 	s.serveIter(w, r, it, func(it storage.BaseIterator) interface{} {
-		return it.(storage.PinIterator).Pin()
+		pin := it.(storage.PinIterator).Pin()
+		return pinItem{Pin: pin, Info: s.pinInfo(r.Context(), pin.Ref)}
 	})
 }
 
-func (s *server) servePin(w http.ResponseWriter, r *http.Request, name string) {
-	if r.Method != "GET" && r.Method != "HEAD" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+// allowedPins skips pins that are outside of the namespace allowed for the client.
+type allowedPins struct {
+	storage.PinIterator
+	a *access
+}
+
+func (it allowedPins) Next() bool {
+	for it.PinIterator.Next() {
+		if it.a.canPin(it.Pin().Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinItem is an element of the pins list. It includes pin annotations, if any.
+type pinItem struct {
+	types.Pin
+	Info *schema.PinInfo `json:"info,omitempty"`
+}
+
+// pinInfo returns annotations of a pin, or nil if the pin points to the content directly.
+func (s *server) pinInfo(ctx context.Context, ref types.Ref) *schema.PinInfo {
+	obj, err := storage.DecodeSchema(ctx, s.index, ref)
+	if err != nil {
+		return nil
 	}
-	ref, err := s.s.GetPin(r.Context(), name)
+	info, _ := obj.(*schema.PinInfo)
+	return info
+}
+
+// resolvePin finds the longest pin name that is a prefix of a given path.
+// The rest of the path is returned, so it can be resolved inside the pinned tree.
+func (s *server) resolvePin(ctx context.Context, fpath string) (string, string, types.Ref, error) {
+	name, rest := fpath, ""
+	for name != "" {
+		if storage.ValidatePinName(name) == nil {
+			ref, err := s.s.GetPin(ctx, name)
+			if err == nil {
+				return name, rest, ref, nil
+			} else if err != storage.ErrNotFound {
+				return "", "", types.Ref{}, err
+			}
+		}
+		i := strings.LastIndexByte(name, '/')
+		if i < 0 {
+			break
+		}
+		rest = path.Join(name[i+1:], rest)
+		name = name[:i]
+	}
+	return "", "", types.Ref{}, storage.ErrNotFound
+}
+
+// servePinPath serves a pin value, or a file inside the pinned tree.
+func (s *server) servePinPath(w http.ResponseWriter, r *http.Request, a *access, fpath string) {
+	name, rest, ref, err := s.resolvePin(r.Context(), fpath)
 	if err == storage.ErrNotFound {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -155,9 +356,22 @@ func (s *server) servePin(w http.ResponseWriter, r *http.Request, name string) {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
+	} else if !a.canPin(name) {
+		s.deny(w, a)
+		return
 	}
+	if rest == "" && !wantsHTML(r) {
+		s.servePin(w, r, ref)
+		return
+	} else if !a.can(PermReadBlobs) {
+		s.deny(w, a)
+		return
+	}
+	s.serveTree(w, r, ref, rest)
+}
+
+func (s *server) servePin(w http.ResponseWriter, r *http.Request, ref types.Ref) {
 	w.Header().Set("X-CAS-Ref", ref.String())
-	// TODO: handle If-None-Match and write ETag for non-CAS clients
 	switch r.Method {
 	case "HEAD":
 		return
@@ -168,3 +382,91 @@ func (s *server) servePin(w http.ResponseWriter, r *http.Request, name string) {
 	}
 	w.WriteHeader(http.StatusMethodNotAllowed)
 }
+
+func (s *server) putBlob(w http.ResponseWriter, r *http.Request, ref types.Ref) {
+	if _, err := types.NewRefWith(ref.Name()); err != nil {
+		// some hashes can only be used for aliases
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ctx := storage.WithHash(r.Context(), ref.Name())
+	bw, err := s.s.BeginBlob(ctx)
+	if err == storage.ErrReadOnly {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer bw.Close()
+	if _, err = io.Copy(bw, r.Body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	sr, err := bw.Complete()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	} else if sr.Ref != ref {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("ref mismatch: got " + sr.Ref.String()))
+		return
+	}
+	if err = bw.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("X-CAS-Ref", ref.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// maxPinBody is the maximal size of a request body that sets a pin.
+const maxPinBody = 1024
+
+func (s *server) putPin(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPinBody))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ref, err := types.ParseRef(strings.TrimSpace(string(data)))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err = s.s.SetPin(r.Context(), name, ref); err == storage.ErrReadOnly {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if err == storage.ErrPinConflict {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) deletePin(w http.ResponseWriter, r *http.Request, name string) {
+	err := s.s.DeletePin(r.Context(), name)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case storage.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case storage.ErrReadOnly:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
@@ -0,0 +1,388 @@
+package local
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/dennwc/cas/schema"
+)
+
+const (
+	// CompressNone disables compression of blobs.
+	CompressNone = "none"
+	// CompressGzip compresses blobs with gzip.
+	CompressGzip = "gzip"
+	// CompressZstd compresses blobs with zstd.
+	CompressZstd = "zstd"
+)
+
+const (
+	// headerMagic starts a header of compressed blob files. Raw blobs that start with the same bytes
+	// are stored with a header as well, thus the blob file format is never ambiguous.
+	headerMagic = "\x00cas:z\x00\x01"
+	// headerSize is the size of the blob file header: magic, algorithm id and the size of the content.
+	headerSize = len(headerMagic) + 1 + 8
+
+	// algoRaw is an algorithm id of blobs that are stored with a header, but without compression.
+	algoRaw = 0
+
+	// compressPeek is the amount of data that is compressed to check if the blob is compressible.
+	compressPeek = 64 * 1024
+	// compressRatio is the maximal ratio of compressed and uncompressed sizes.
+	// Blobs that compress worse than this are stored raw.
+	compressRatio = 0.9
+)
+
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type compressor struct {
+	id        byte // algorithm id stored in the blob file header
+	newWriter func(w io.Writer) (flushWriter, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = map[string]compressor{
+	CompressGzip: {
+		id: 1,
+		newWriter: func(w io.Writer) (flushWriter, error) {
+			return gzip.NewWriter(w), nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	CompressZstd: {
+		id: 2,
+		newWriter: func(w io.Writer) (flushWriter, error) {
+			return zstd.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	},
+}
+
+// SetCompression sets an algorithm that will be used to compress new blobs.
+// Existing blobs are not affected. Empty string or CompressNone disables the compression.
+func (s *Storage) SetCompression(algo string) error {
+	if algo == CompressNone {
+		algo = ""
+	}
+	if algo != "" {
+		if _, ok := compressors[algo]; !ok {
+			return fmt.Errorf("unsupported compression: %q", algo)
+		}
+	}
+	s.compress = algo
+	return nil
+}
+
+// compressorByID returns the compressor for an algorithm id from the blob file header.
+func compressorByID(id byte) (compressor, bool) {
+	for _, c := range compressors {
+		if c.id == id {
+			return c, true
+		}
+	}
+	return compressor{}, false
+}
+
+// blobHeader is a header of blob files that are compressed or start with headerMagic.
+type blobHeader struct {
+	algo byte   // algorithm id; algoRaw if the content is not compressed
+	size uint64 // size of the content
+}
+
+func (h blobHeader) encode() []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, headerMagic)
+	buf[len(headerMagic)] = h.algo
+	binary.BigEndian.PutUint64(buf[len(headerMagic)+1:], h.size)
+	return buf
+}
+
+// hasMagic checks if the data starts with the header magic.
+func hasMagic(p []byte) bool {
+	return bytes.HasPrefix(p, []byte(headerMagic))
+}
+
+// readHeader reads the blob file header, if any. The file offset is not changed.
+func readHeader(f io.ReaderAt) (blobHeader, bool, error) {
+	var buf [headerSize]byte
+	n, err := f.ReadAt(buf[:], 0)
+	if err == io.EOF {
+		err = nil
+	} else if err != nil {
+		return blobHeader{}, false, err
+	}
+	if n < len(headerMagic) || string(buf[:len(headerMagic)]) != headerMagic {
+		return blobHeader{}, false, nil
+	} else if n < headerSize {
+		return blobHeader{}, false, fmt.Errorf("truncated blob header")
+	}
+	h := blobHeader{
+		algo: buf[len(headerMagic)],
+		size: binary.BigEndian.Uint64(buf[len(headerMagic)+1:]),
+	}
+	return h, true, nil
+}
+
+// openCompressed checks if the blob file is compressed and returns a reader for uncompressed content.
+// It returns nil if the blob is stored raw.
+func openCompressed(f *os.File) (io.ReadCloser, uint64, error) {
+	h, ok, err := readHeader(f)
+	if err != nil {
+		return nil, 0, err
+	} else if !ok {
+		return nil, 0, nil
+	}
+	if _, err = f.Seek(int64(headerSize), io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	if h.algo == algoRaw {
+		return f, h.size, nil
+	}
+	c, ok := compressorByID(h.algo)
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported blob compression: %d", h.algo)
+	}
+	zr, err := c.newReader(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: zr,
+		Closer: closers{zr, f},
+	}, h.size, nil
+}
+
+type closers []io.Closer
+
+func (arr closers) Close() error {
+	var last error
+	for _, c := range arr {
+		if err := c.Close(); err != nil {
+			last = err
+		}
+	}
+	return last
+}
+
+// blobSize returns the size of the blob content. For compressed blobs it's different from the file size.
+func blobSize(path string, fi os.FileInfo) (uint64, error) {
+	if fi.Size() < int64(len(headerMagic)) {
+		return uint64(fi.Size()), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h, ok, err := readHeader(f)
+	if err != nil {
+		return 0, err
+	} else if !ok {
+		return uint64(fi.Size()), nil
+	}
+	return h.size, nil
+}
+
+// compressible checks if the beginning of a file can be compressed efficiently.
+func compressible(algo string, r io.Reader) (bool, error) {
+	buf := make([]byte, compressPeek)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	} else if err != nil {
+		return false, err
+	}
+	buf = buf[:n]
+	if len(buf) == 0 || schema.IsSchema(buf) {
+		return false, nil
+	}
+	var zbuf bytes.Buffer
+	zw, err := compressors[algo].newWriter(&zbuf)
+	if err != nil {
+		return false, err
+	}
+	if _, err = zw.Write(buf); err != nil {
+		zw.Close()
+		return false, err
+	}
+	if err = zw.Close(); err != nil {
+		return false, err
+	}
+	return float64(zbuf.Len()) <= compressRatio*float64(len(buf)), nil
+}
+
+// switchWriter allows to change the destination of a compressor.
+type switchWriter struct {
+	w io.Writer
+}
+
+func (w *switchWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// compressWriter buffers the beginning of the blob to decide if it should be compressed.
+// Schema blobs and incompressible blobs are written as-is, unless they start with the header magic.
+// If the algorithm is not set, blobs are never compressed.
+type compressWriter struct {
+	f    tempFile
+	algo string
+
+	buf     []byte // first bytes of the blob; nil after the decision is made
+	size    uint64 // uncompressed size
+	zbuf    bytes.Buffer
+	sw      switchWriter
+	zw      flushWriter
+	decided bool
+	// header is set if the file starts with a header
+	header bool
+	// compressed is set if the data written to the file is compressed
+	compressed bool
+}
+
+func newCompressWriter(f tempFile, algo string) *compressWriter {
+	peek := compressPeek
+	if algo == "" {
+		peek = len(headerMagic)
+	}
+	return &compressWriter{f: f, algo: algo, buf: make([]byte, 0, peek)}
+}
+
+// writeRaw writes the beginning of an uncompressed blob.
+func (w *compressWriter) writeRaw(buf []byte) error {
+	if hasMagic(buf) {
+		// the blob would be mistaken for a compressed one
+		if _, err := w.f.Write(blobHeader{algo: algoRaw}.encode()); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	_, err := w.f.Write(buf)
+	return err
+}
+
+// decide compresses the buffered data and checks if it's worth to compress the blob.
+// If final is set, no more data will be written.
+func (w *compressWriter) decide(final bool) error {
+	w.decided = true
+	buf := w.buf
+	w.buf = nil
+	if w.algo == "" || len(buf) == 0 || schema.IsSchema(buf) {
+		// schema blobs are read directly by indexes, thus they must be stored raw
+		return w.writeRaw(buf)
+	}
+	c := compressors[w.algo]
+	w.sw.w = &w.zbuf
+	zw, err := c.newWriter(&w.sw)
+	if err != nil {
+		return err
+	}
+	if _, err = zw.Write(buf); err != nil {
+		zw.Close()
+		return err
+	}
+	if final {
+		err = zw.Close()
+	} else {
+		err = zw.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if float64(w.zbuf.Len()) > compressRatio*float64(len(buf)) {
+		// incompressible
+		zw.Close()
+		w.zbuf.Reset()
+		return w.writeRaw(buf)
+	}
+	// the size is not known yet, it will be written by Finish
+	if _, err = w.f.Write(blobHeader{algo: c.id}.encode()); err != nil {
+		zw.Close()
+		return err
+	}
+	w.header = true
+	if _, err = w.f.Write(w.zbuf.Bytes()); err != nil {
+		zw.Close()
+		return err
+	}
+	w.zbuf.Reset()
+	w.sw.w = w.f
+	w.compressed = true
+	if !final {
+		w.zw = zw
+	}
+	return nil
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		return w.write(p)
+	}
+	n := cap(w.buf) - len(w.buf)
+	if n > len(p) {
+		n = len(p)
+	}
+	w.buf = append(w.buf, p[:n]...)
+	w.size += uint64(n)
+	if len(w.buf) < cap(w.buf) {
+		return len(p), nil
+	}
+	if err := w.decide(false); err != nil {
+		return 0, err
+	}
+	if _, err := w.write(p[n:]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *compressWriter) write(p []byte) (int, error) {
+	w.size += uint64(len(p))
+	if w.zw != nil {
+		return w.zw.Write(p)
+	}
+	return w.f.Write(p)
+}
+
+// Finish flushes all the data to the file and writes the size of the content to the header, if necessary.
+func (w *compressWriter) Finish() error {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return err
+		}
+	}
+	if w.zw != nil {
+		if err := w.zw.Close(); err != nil {
+			return err
+		}
+		w.zw = nil
+	}
+	if !w.header {
+		return nil
+	}
+	h := blobHeader{algo: algoRaw, size: w.size}
+	if w.compressed {
+		h.algo = compressors[w.algo].id
+	}
+	_, err := w.f.File().WriteAt(h.encode(), 0)
+	return err
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/dennwc/cas/schema"
@@ -47,8 +49,10 @@ var (
 )
 
 var (
-	_ storage.Storage     = (*Storage)(nil)
-	_ storage.BlobIndexer = (*Storage)(nil)
+	_ storage.Storage            = (*Storage)(nil)
+	_ storage.BlobIndexer        = (*Storage)(nil)
+	_ storage.BlobPrefixIterator = (*Storage)(nil)
+	_ storage.PinPrefixIterator  = (*Storage)(nil)
 )
 
 func init() {
@@ -57,6 +61,8 @@ func init() {
 
 type Config struct {
 	Dir string `json:"dir"`
+	// Compress is an algorithm used to compress blobs at rest: "zstd", "gzip" or "none".
+	Compress string `json:"compress,omitempty"`
 }
 
 func (c *Config) References() []types.Ref {
@@ -68,6 +74,10 @@ func (c *Config) OpenStorage(ctx context.Context) (storage.Storage, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = s.SetCompression(c.Compress); err != nil {
+		s.Close()
+		return nil, err
+	}
 	return s, nil
 }
 
@@ -110,6 +120,7 @@ func New(dir string, create bool) (*Storage, error) {
 
 type Storage struct {
 	dir       string
+	compress  string // compression algorithm for new blobs
 	unindexed *os.File
 	storageImpl
 }
@@ -217,7 +228,8 @@ func (s *Storage) StatBlob(ctx context.Context, ref types.Ref) (uint64, error) {
 	if ref.Zero() {
 		return 0, storage.ErrInvalidRef
 	}
-	fi, err := os.Stat(s.blobPath(ref))
+	path := s.blobPath(ref)
+	fi, err := os.Stat(path)
 	if err != nil {
 		return 0, err
 	}
@@ -226,7 +238,7 @@ func (s *Storage) StatBlob(ctx context.Context, ref types.Ref) (uint64, error) {
 	} else if invalid {
 		return 0, storage.ErrNotFound
 	}
-	return uint64(fi.Size()), nil
+	return blobSize(path, fi)
 }
 
 func (s *Storage) FetchBlob(ctx context.Context, ref types.Ref) (io.ReadCloser, uint64, error) {
@@ -251,6 +263,12 @@ func (s *Storage) FetchBlob(ctx context.Context, ref types.Ref) (io.ReadCloser,
 		f.Close()
 		return nil, 0, storage.ErrNotFound
 	}
+	if rc, size, err := openCompressed(f); err != nil {
+		f.Close()
+		return nil, 0, err
+	} else if rc != nil {
+		return rc, size, nil
+	}
 	return f, uint64(fi.Size()), nil
 }
 
@@ -264,6 +282,20 @@ func (s *Storage) ImportFile(ctx context.Context, path string) (types.SizedRef,
 	}
 	defer inp.Close()
 
+	// blobs that start with a header magic must be written with BeginBlob, since they need a header
+	var magic [len(headerMagic)]byte
+	if n, _ := inp.ReadAt(magic[:], 0); hasMagic(magic[:n]) {
+		return types.SizedRef{}, errCantClone
+	}
+	if s.compress != "" {
+		// only incompressible files are cloned, the rest should be written with BeginBlob
+		if ok, err := compressible(s.compress, inp); err != nil {
+			return types.SizedRef{}, err
+		} else if ok {
+			return types.SizedRef{}, errCantClone
+		}
+	}
+
 	dst, err := s.tmpFile(true)
 	if err != nil {
 		return types.SizedRef{}, err
@@ -276,7 +308,7 @@ func (s *Storage) ImportFile(ctx context.Context, path string) (types.SizedRef,
 		return types.SizedRef{}, err
 	}
 	// get the hash of the file by reading the clone (snapshot)
-	sr, err := types.Hash(dst)
+	sr, err := types.HashWith(storage.HashName(ctx), dst)
 	if err != nil {
 		dst.Close()
 		return types.SizedRef{}, err
@@ -296,6 +328,10 @@ func (s *Storage) addNotIndexed(f *os.File, ref types.Ref) error {
 }
 
 func (s *Storage) BeginBlob(ctx context.Context) (storage.BlobWriter, error) {
+	hw, err := storage.HashFor(ctx)
+	if err != nil {
+		return nil, err
+	}
 	f, err := s.tmpFile(false)
 	if err != nil {
 		return nil, err
@@ -303,13 +339,16 @@ func (s *Storage) BeginBlob(ctx context.Context) (storage.BlobWriter, error) {
 	if t, ok := ctx.Deadline(); ok {
 		f.SetWriteDeadline(t)
 	}
-	return &blobWriter{s: s, ctx: ctx, f: f, hw: storage.Hash()}, nil
+	w := &blobWriter{s: s, ctx: ctx, f: f, hw: hw}
+	w.cw = newCompressWriter(f, s.compress)
+	return w, nil
 }
 
 type blobWriter struct {
 	s   *Storage
 	ctx context.Context
 	f   tempFile
+	cw  *compressWriter
 	sr  types.SizedRef
 	hw  storage.BlobWriter
 }
@@ -326,7 +365,7 @@ func (w *blobWriter) Write(p []byte) (int, error) {
 	if w.f == nil {
 		return 0, storage.ErrBlobCompleted
 	}
-	return w.f.Write(p)
+	return w.cw.Write(p)
 }
 
 func (w *blobWriter) Complete() (types.SizedRef, error) {
@@ -365,6 +404,11 @@ func (w *blobWriter) Commit() error {
 			return err
 		}
 	}
+	if err := w.cw.Finish(); err != nil {
+		w.f.Close()
+		w.f = nil
+		return err
+	}
 	// file already closed, we only need a name now
 	err := w.f.Commit(w.sr.Ref)
 	w.f = nil
@@ -375,9 +419,14 @@ func (s *Storage) IterateBlobs(ctx context.Context) storage.Iterator {
 	return &dirIterator{s: s, dir: filepath.Join(s.dir, dirBlobs)}
 }
 
+func (s *Storage) IterateBlobsByPrefix(ctx context.Context, pref types.RefPrefix) storage.Iterator {
+	return &dirIterator{s: s, dir: filepath.Join(s.dir, dirBlobs), prefix: pref.String()}
+}
+
 type dirIterator struct {
-	s   *Storage
-	dir string
+	s      *Storage
+	dir    string
+	prefix string // only list blobs with this prefix in the name
 
 	err   error
 	infos []os.FileInfo
@@ -415,10 +464,13 @@ func (it *dirIterator) Next() bool {
 		}
 		info := it.infos[0]
 		it.infos = it.infos[1:]
-		if !info.Mode().IsRegular() {
+		if !info.Mode().IsRegular() || !strings.HasPrefix(info.Name(), it.prefix) {
 			continue
 		}
-		it.sr.Size = uint64(info.Size())
+		it.sr.Size, it.err = blobSize(filepath.Join(it.dir, info.Name()), info)
+		if it.err != nil {
+			return false
+		}
 		it.sr.Ref, it.err = types.ParseRef(info.Name())
 		if it.err != nil {
 			return false
@@ -447,22 +499,62 @@ func (it *dirIterator) Close() error {
 }
 
 func (s *Storage) pinPath(name string) string {
-	return filepath.Join(s.dir, dirPins, name)
+	return filepath.Join(s.dir, dirPins, filepath.FromSlash(name))
 }
 
 func (s *Storage) SetPin(ctx context.Context, name string, ref types.Ref) error {
-	return ioutil.WriteFile(s.pinPath(name), []byte(ref.String()), 0644)
+	if err := storage.ValidateNewPinName(name); err != nil {
+		return err
+	}
+	path := s.pinPath(name)
+	var err error
+	if strings.Contains(name, storage.PinSeparator) {
+		err = os.MkdirAll(filepath.Dir(path), dirPerm)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(ref.String()), 0644)
+	}
+	if err != nil {
+		// either a parent is a pin file, or the name is a namespace directory
+		if cerr := storage.CheckPinConflict(ctx, s, name); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	return nil
 }
 
 func (s *Storage) DeletePin(ctx context.Context, name string) error {
-	return os.Remove(s.pinPath(name))
+	if err := storage.ValidatePinName(name); err != nil {
+		return err
+	}
+	path := s.pinPath(name)
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	// remove empty namespace directories
+	root := filepath.Join(s.dir, dirPins)
+	for dir := filepath.Dir(path); dir != root; dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break // not empty
+		}
+	}
+	return nil
 }
 
 func (s *Storage) GetPin(ctx context.Context, name string) (types.Ref, error) {
+	if err := storage.ValidatePinName(name); err != nil {
+		return types.Ref{}, err
+	}
 	data, err := ioutil.ReadFile(s.pinPath(name))
-	if os.IsNotExist(err) {
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		// ENOTDIR means that one of the parent namespaces is a pin
 		return types.Ref{}, storage.ErrNotFound
 	} else if err != nil {
+		if fi, err2 := os.Stat(s.pinPath(name)); err2 == nil && fi.IsDir() {
+			// a namespace, not a pin
+			return types.Ref{}, storage.ErrNotFound
+		}
 		return types.Ref{}, err
 	}
 	return types.ParseRef(string(data))
@@ -472,47 +564,76 @@ func (s *Storage) IteratePins(ctx context.Context) storage.PinIterator {
 	return &pinIterator{s: s, dir: filepath.Join(s.dir, dirPins)}
 }
 
+func (s *Storage) IteratePinsByPrefix(ctx context.Context, prefix string) storage.PinIterator {
+	return &pinIterator{s: s, dir: filepath.Join(s.dir, dirPins), prefix: prefix}
+}
+
 type pinIterator struct {
-	s   *Storage
-	dir string
+	s      *Storage
+	dir    string
+	prefix string
 
 	err   error
-	infos []os.FileInfo
+	names []string
 	cur   types.Pin
 }
 
-func (it *pinIterator) Next() bool {
-	it.cur = types.Pin{}
-	if it.err != nil {
-		return false
+// listPins lists names of all pins that match the prefix. Only the namespace that contains the prefix is scanned.
+func (it *pinIterator) listPins() ([]string, error) {
+	names := []string{}
+	start := ""
+	if i := strings.LastIndex(it.prefix, storage.PinSeparator); i >= 0 {
+		start = it.prefix[:i]
 	}
-	if it.infos == nil {
-		d, err := os.Open(it.dir)
+	root := filepath.Join(it.dir, filepath.FromSlash(start))
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if os.IsNotExist(err) {
-			it.infos = []os.FileInfo{}
-			return false
+			return nil
 		} else if err != nil {
-			it.err = err
-			return false
+			return err
 		}
-		infos, err := d.Readdir(-1)
-		d.Close()
+		rel, err := filepath.Rel(it.dir, path)
 		if err != nil {
-			it.err = err
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			if path != root && !strings.HasPrefix(name+storage.PinSeparator, it.prefix) &&
+				!strings.HasPrefix(it.prefix, name+storage.PinSeparator) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && strings.HasPrefix(name, it.prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (it *pinIterator) Next() bool {
+	it.cur = types.Pin{}
+	if it.err != nil {
+		return false
+	}
+	if it.names == nil {
+		it.names, it.err = it.listPins()
+		if it.err != nil {
 			return false
 		}
-		sort.Slice(infos, func(i, j int) bool {
-			return infos[i].Name() < infos[j].Name()
-		})
-		it.infos = infos
 	}
-	if len(it.infos) == 0 {
+	if len(it.names) == 0 {
 		return false
 	}
-	info := it.infos[0]
-	it.infos = it.infos[1:]
-	it.cur.Name = info.Name()
-	data, err := ioutil.ReadFile(filepath.Join(it.dir, info.Name()))
+	name := it.names[0]
+	it.names = it.names[1:]
+	it.cur.Name = name
+	data, err := ioutil.ReadFile(filepath.Join(it.dir, filepath.FromSlash(name)))
 	if err != nil {
 		it.err = err
 		return false
@@ -533,7 +654,7 @@ func (it *pinIterator) Pin() types.Pin {
 }
 
 func (it *pinIterator) Close() error {
-	it.infos = []os.FileInfo{}
+	it.names = []string{}
 	return nil
 }
 
@@ -1,6 +1,9 @@
 package local
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"os"
 	"testing"
 
@@ -8,20 +11,144 @@ import (
 
 	"github.com/dennwc/cas/storage"
 	"github.com/dennwc/cas/storage/test"
+	"github.com/dennwc/cas/types"
+	"github.com/dennwc/cas/xattr"
 )
 
+func newTestStorage(t testing.TB, compress string) (*Storage, func()) {
+	dir, err := os.MkdirTemp("", "cas_local_")
+	require.NoError(t, err)
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+	s, err := New(dir, true)
+	if err == nil {
+		err = s.SetCompression(compress)
+	}
+	if err != nil {
+		cleanup()
+	}
+	require.NoError(t, err)
+	return s, cleanup
+}
+
 func TestLocalDir(t *testing.T) {
 	storagetest.RunTests(t, func(t testing.TB) (storage.Storage, func()) {
-		dir, err := os.MkdirTemp("", "cas_local_")
+		return newTestStorage(t, "")
+	})
+}
+
+func TestLocalDirCompressed(t *testing.T) {
+	for _, algo := range []string{CompressGzip, CompressZstd} {
+		t.Run(algo, func(t *testing.T) {
+			storagetest.RunTests(t, func(t testing.TB) (storage.Storage, func()) {
+				return newTestStorage(t, algo)
+			})
+
+			ctx := context.Background()
+			s, cleanup := newTestStorage(t, algo)
+			defer cleanup()
+
+			data := bytes.Repeat([]byte(`{"level":"info","msg":"text-heavy log line"}`+"\n"), 10000)
+			sr, err := storage.WriteBytes(ctx, s, data)
+			require.NoError(t, err)
+			require.Equal(t, types.BytesRef(data), sr.Ref)
+
+			fi, err := os.Stat(s.blobPath(sr.Ref))
+			require.NoError(t, err)
+			require.True(t, fi.Size() < int64(len(data))/10, "blob is not compressed: %d", fi.Size())
+
+			sz, err := s.StatBlob(ctx, sr.Ref)
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(data)), sz)
+
+			rc, sz, err := s.FetchBlob(ctx, sr.Ref)
+			require.NoError(t, err)
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(data)), sz)
+			require.True(t, bytes.Equal(data, got))
+		})
+	}
+}
+
+func TestLocalDirCopiedWithoutXattrs(t *testing.T) {
+	ctx := context.Background()
+	src, cleanup := newTestStorage(t, CompressZstd)
+	defer cleanup()
+	dst, cleanup2 := newTestStorage(t, "")
+	defer cleanup2()
+
+	compressed := bytes.Repeat([]byte("compressible content\n"), 10000)
+	raw := append([]byte(headerMagic), "raw content that looks like a header"...)
+	for _, data := range [][]byte{compressed, raw} {
+		sr, err := storage.WriteBytes(ctx, src, data)
 		require.NoError(t, err)
-		cleanup := func() {
-			os.RemoveAll(dir)
-		}
-		s, err := New(dir, true)
-		if err != nil {
-			cleanup()
-		}
+
+		// copy the blob file as cp or rsync without xattrs would do
+		file, err := os.ReadFile(src.blobPath(sr.Ref))
 		require.NoError(t, err)
-		return s, cleanup
-	})
+		require.True(t, hasMagic(file))
+		err = os.WriteFile(dst.blobPath(sr.Ref), file, roPerm)
+		require.NoError(t, err)
+
+		sz, err := dst.StatBlob(ctx, sr.Ref)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(data)), sz)
+
+		rc, sz, err := dst.FetchBlob(ctx, sr.Ref)
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(data)), sz)
+		require.True(t, bytes.Equal(data, got))
+	}
+}
+
+func TestLocalDirIgnoresCompressXattrs(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := newTestStorage(t, "")
+	defer cleanup()
+
+	data := []byte("raw content")
+	sr, err := storage.WriteBytes(ctx, s, data)
+	require.NoError(t, err)
+	path := s.blobPath(sr.Ref)
+	require.NoError(t, os.Chmod(path, 0644))
+	if err = xattr.SetString(path, xattrNS+"compress", CompressZstd); err != nil {
+		t.Skip("xattrs are not supported:", err)
+	}
+	require.NoError(t, xattr.SetUint(path, xattrNS+"data.size", 1<<20))
+
+	// compression is only recorded in the blob file header
+	sz, err := s.StatBlob(ctx, sr.Ref)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), sz)
+	rc, _, err := s.FetchBlob(ctx, sr.Ref)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+}
+
+func TestLocalDirLegacyPinNames(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := newTestStorage(t, "")
+	defer cleanup()
+
+	ref := types.StringRef("data")
+	require.Error(t, s.SetPin(ctx, "old~1", ref))
+
+	// pins created before the restriction was introduced are still accessible
+	err := os.WriteFile(s.pinPath("old~1"), []byte(ref.String()), 0644)
+	require.NoError(t, err)
+	got, err := s.GetPin(ctx, "old~1")
+	require.NoError(t, err)
+	require.Equal(t, ref, got)
+	require.NoError(t, s.DeletePin(ctx, "old~1"))
+	_, err = s.GetPin(ctx, "old~1")
+	require.Equal(t, storage.ErrNotFound, err)
 }
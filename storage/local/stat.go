@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 
+	"github.com/dennwc/cas/storage"
 	"github.com/dennwc/cas/types"
 	"github.com/dennwc/cas/xattr"
 )
@@ -31,7 +32,8 @@ func StatFile(ctx context.Context, f *os.File) (types.SizedRef, error) {
 		return sr, nil
 	}
 	ref, err := types.ParseRef(sref)
-	if err != nil {
+	if err != nil || ref.Name() != storage.HashName(ctx) {
+		// ref with a different hash algorithm is not useful for the caller
 		return sr, nil
 	}
 	// to verify that this hash is correct, read an old size and mtime
@@ -52,7 +52,11 @@ func (s *memStorage) FetchBlob(ctx context.Context, ref types.Ref) (io.ReadClose
 }
 
 func (s *memStorage) BeginBlob(ctx context.Context) (BlobWriter, error) {
-	return &memWriter{s: s, hw: Hash()}, nil
+	hw, err := HashFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &memWriter{s: s, hw: hw}, nil
 }
 
 type memWriter struct {
@@ -153,8 +157,13 @@ func (it *memIter) SizedRef() types.SizedRef {
 
 func (s *memStorage) SetPin(ctx context.Context, name string, ref types.Ref) error {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+	for pin := range s.pins {
+		if pinsConflict(pin, name) {
+			return ErrPinConflict
+		}
+	}
 	s.pins[name] = ref
-	s.mu.Unlock()
 	return nil
 }
 
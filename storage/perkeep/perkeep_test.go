@@ -0,0 +1,223 @@
+package perkeep
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+// testServer is a minimal stand-in for a Perkeep blob server.
+type testServer struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func blobRefOf(data []byte) string {
+	h := sha256.Sum224(data)
+	return "sha224-" + hex.EncodeToString(h[:])
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if u, p, _ := r.BasicAuth(); u != "user" || p != "pass" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type info struct {
+		BlobRef string `json:"blobRef"`
+		Size    int    `json:"size"`
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/bs/camli/")
+	switch {
+	case path == "stat":
+		if err := r.ParseForm(); err != nil || r.Form.Get("camliversion") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		out := []info{}
+		for k, v := range r.Form {
+			if b, ok := s.blobs[v[0]]; ok && strings.HasPrefix(k, "blob") {
+				out = append(out, info{v[0], len(b)})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"stat": out})
+	case path == "upload":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		out := []info{}
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			data, _ := ioutil.ReadAll(p)
+			if br := p.FormName(); br != blobRefOf(data) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.blobs[p.FormName()] = data
+			out = append(out, info{p.FormName(), len(data)})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"received": out})
+	case path == "enumerate-blobs":
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		after := r.URL.Query().Get("after")
+		var refs []string
+		for br := range s.blobs {
+			if br > after {
+				refs = append(refs, br)
+			}
+		}
+		sort.Strings(refs)
+		resp := map[string]interface{}{}
+		if limit <= 0 || limit > 2 {
+			// small pages to check the continuation
+			limit = 2
+		}
+		if len(refs) > limit {
+			refs = refs[:limit]
+			resp["continueAfter"] = refs[limit-1]
+		}
+		out := []info{}
+		for _, br := range refs {
+			out = append(out, info{br, len(s.blobs[br])})
+		}
+		resp["blobs"] = out
+		json.NewEncoder(w).Encode(resp)
+	default:
+		b, ok := s.blobs[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv := &testServer{blobs: map[string][]byte{
+		"sha1-da39a3ee5e6b4b0d3255bfef95601890afd80709": nil,
+	}}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	cli, err := NewClient(strings.Replace(hs.URL, "http://", "http://user:pass@", 1) + "/bs/")
+	require.NoError(t, err)
+
+	ctx := storage.WithHash(context.Background(), "sha224")
+	blobs := [][]byte{[]byte("a"), []byte("bc"), []byte("def"), nil}
+	var refs []types.Ref
+	for _, data := range blobs {
+		sr, err := storage.WriteBytes(ctx, cli, data)
+		require.NoError(t, err)
+		require.Equal(t, "sha224", sr.Ref.Name())
+		br, err := BlobRef(sr.Ref)
+		require.NoError(t, err)
+		require.Equal(t, blobRefOf(data), br)
+		refs = append(refs, sr.Ref)
+	}
+	for i, ref := range refs {
+		sz, err := cli.StatBlob(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(blobs[i])), sz)
+
+		rc, _, err := cli.FetchBlob(ctx, ref)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.Equal(t, string(blobs[i]), string(data))
+	}
+	missing := types.BytesRef([]byte("missing"))
+	_, err = cli.StatBlob(ctx, missing)
+	require.Equal(t, storage.ErrNotFound, err)
+	_, _, err = cli.FetchBlob(ctx, missing)
+	require.Equal(t, storage.ErrNotFound, err)
+
+	out, err := cli.StatBlobs(ctx, append([]types.Ref{missing}, refs...))
+	require.NoError(t, err)
+	require.Len(t, out, len(refs))
+
+	var listed []types.Ref
+	it := cli.IterateBlobs(ctx)
+	for it.Next() {
+		listed = append(listed, it.SizedRef().Ref)
+	}
+	require.NoError(t, it.Err())
+	require.NoError(t, it.Close())
+	require.ElementsMatch(t, refs, listed)
+
+	_, err = cli.BeginBlob(storage.WithHash(ctx, "blake3"))
+	require.Error(t, err)
+	require.Equal(t, ErrNoPins, cli.SetPin(ctx, "a", refs[0]))
+
+	w, err := cli.BeginBlob(ctx)
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, MaxBlobSize+1))
+	require.Error(t, err)
+	w.Close()
+
+	cli, err = NewClient(hs.URL + "/bs")
+	require.NoError(t, err)
+	_, err = cli.StatBlob(ctx, refs[0])
+	require.Error(t, err)
+}
+
+func TestBlobRef(t *testing.T) {
+	ref := types.BytesRef([]byte("data"))
+	br, err := BlobRef(ref)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(br, "sha256-"))
+	ref2, err := ParseBlobRef(br)
+	require.NoError(t, err)
+	require.Equal(t, ref, ref2)
+
+	_, err = ParseBlobRef("sha1-da39a3ee5e6b4b0d3255bfef95601890afd80709")
+	require.Error(t, err)
+	_, err = ParseBlobRef("sha224")
+	require.Error(t, err)
+}
+
+func TestSchema(t *testing.T) {
+	_, err := DecodeSchema(bytes.NewReader([]byte("raw data")))
+	require.Equal(t, ErrNotSchema, err)
+
+	s, err := DecodeSchema(strings.NewReader(`{"camliVersion": 1,
+  "camliType": "file",
+  "fileNameBytes": ["a", 255, "b"],
+  "unixPermission": "0755",
+  "unixMtime": "2020-01-02T03:04:05.5Z",
+  "parts": [{"blobRef": "sha224-00", "size": 3}, {"size": 2, "offset": 1}]
+}`))
+	require.NoError(t, err)
+	require.Equal(t, TypeFile, s.Type)
+	name, err := s.Name()
+	require.NoError(t, err)
+	require.Equal(t, "a\xffb", name)
+	perm, err := s.Perm()
+	require.NoError(t, err)
+	require.Equal(t, uint32(0755), perm)
+	mt, err := s.ModTime()
+	require.NoError(t, err)
+	require.Equal(t, int64(1577934245), mt.Unix())
+	require.Equal(t, uint64(5), s.Size())
+}
@@ -0,0 +1,150 @@
+package perkeep
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+// Types of Perkeep schema blobs.
+const (
+	TypeFile      = "file"
+	TypeBytes     = "bytes"
+	TypeDirectory = "directory"
+	TypeStaticSet = "static-set"
+	TypeSymlink   = "symlink"
+)
+
+// maxSchemaSize limits the size of schema blobs that can be decoded.
+const maxSchemaSize = 1 << 20
+
+// ErrNotSchema is returned when the blob is not a Perkeep schema blob.
+var ErrNotSchema = errors.New("perkeep: not a schema blob")
+
+// Part is a part of the content of file and bytes schema blobs.
+// It refers to either a raw blob or a bytes schema blob. If neither is set, the part contains zero bytes.
+type Part struct {
+	BlobRef  string `json:"blobRef,omitempty"`
+	BytesRef string `json:"bytesRef,omitempty"`
+	Size     uint64 `json:"size"`
+	// Offset is the offset of the part in the referenced content.
+	Offset uint64 `json:"offset,omitempty"`
+}
+
+// Schema is a Perkeep schema blob. Only fields of file, bytes, directory, static-set and symlink blobs are decoded.
+type Schema struct {
+	Version int    `json:"camliVersion"`
+	Type    string `json:"camliType"`
+
+	FileName      string        `json:"fileName,omitempty"`
+	FileNameBytes []interface{} `json:"fileNameBytes,omitempty"`
+
+	UnixPermission string `json:"unixPermission,omitempty"`
+	UnixMtime      string `json:"unixMtime,omitempty"`
+
+	// Parts is the content of file and bytes blobs.
+	Parts []Part `json:"parts,omitempty"`
+	// WholeRef is an optional blobref of the whole content of a file.
+	WholeRef string `json:"wholeRef,omitempty"`
+
+	// Entries is a blobref of a static set with members of a directory.
+	Entries string `json:"entries,omitempty"`
+
+	// Members are blobrefs of static set members. Large sets are split into subsets listed in MergeSets.
+	Members   []string `json:"members,omitempty"`
+	MergeSets []string `json:"mergeSets,omitempty"`
+
+	SymlinkTarget      string        `json:"symlinkTarget,omitempty"`
+	SymlinkTargetBytes []interface{} `json:"symlinkTargetBytes,omitempty"`
+}
+
+// DecodeSchema decodes a Perkeep schema blob. It returns ErrNotSchema if the blob contains raw data.
+func DecodeSchema(r io.Reader) (*Schema, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxSchemaSize+1))
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) > maxSchemaSize || !bytes.HasPrefix(data, []byte("{")) || !bytes.Contains(data, []byte(`"camliVersion"`)) {
+		return nil, ErrNotSchema
+	}
+	var s Schema
+	if err = json.Unmarshal(data, &s); err != nil || s.Version == 0 || s.Type == "" {
+		return nil, ErrNotSchema
+	}
+	return &s, nil
+}
+
+// mixedBytes decodes a mixed array of UTF-8 strings and bytes used by Perkeep for names that are not valid UTF-8.
+func mixedBytes(arr []interface{}) (string, error) {
+	var buf []byte
+	for _, v := range arr {
+		switch v := v.(type) {
+		case string:
+			buf = append(buf, v...)
+		case float64:
+			if v < 0 || v > 255 || v != float64(int(v)) {
+				return "", fmt.Errorf("perkeep: invalid byte: %v", v)
+			}
+			buf = append(buf, byte(v))
+		default:
+			return "", fmt.Errorf("perkeep: unexpected value in a byte array: %T", v)
+		}
+	}
+	return string(buf), nil
+}
+
+// Name returns the file name of a file, directory or symlink.
+func (s *Schema) Name() (string, error) {
+	if s.FileName != "" || len(s.FileNameBytes) == 0 {
+		return s.FileName, nil
+	}
+	return mixedBytes(s.FileNameBytes)
+}
+
+// Target returns the target of a symlink.
+func (s *Schema) Target() (string, error) {
+	if s.SymlinkTarget != "" || len(s.SymlinkTargetBytes) == 0 {
+		return s.SymlinkTarget, nil
+	}
+	return mixedBytes(s.SymlinkTargetBytes)
+}
+
+// Perm returns Unix permission bits. It returns zero if they are not set.
+func (s *Schema) Perm() (uint32, error) {
+	if s.UnixPermission == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s.UnixPermission, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("perkeep: invalid permissions: %q", s.UnixPermission)
+	}
+	return uint32(v), nil
+}
+
+// ModTime returns the modification time. It returns nil if it's not set.
+func (s *Schema) ModTime() (*time.Time, error) {
+	if s.UnixMtime == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.UnixMtime)
+	if err != nil {
+		return nil, fmt.Errorf("perkeep: invalid modification time: %q", s.UnixMtime)
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// Size returns the size of the content of file and bytes blobs.
+func (s *Schema) Size() uint64 {
+	var n uint64
+	for _, p := range s.Parts {
+		n += p.Size
+	}
+	return n
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PinSeparator separates components of hierarchical pin names, for example "datasets/imagenet/v3".
+const PinSeparator = "/"
+
+// IndexPinPrefix is a namespace of pins that CAS uses to index verified schema blobs.
+// Servers must not let clients change these pins, and should not list them.
+const IndexPinPrefix = ".index" + PinSeparator
+
+// IsIndexPin checks if the pin name belongs to the index namespace.
+func IsIndexPin(name string) bool {
+	return strings.HasPrefix(name, IndexPinPrefix)
+}
+
+// ValidatePinName checks if a pin name is valid. Names may consist of multiple components separated
+// by PinSeparator. Components cannot be empty, or be equal to "." or "..".
+//
+// Names of new pins must be checked with ValidateNewPinName instead.
+func ValidatePinName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty pin name")
+	} else if strings.ContainsAny(name, "\\\x00") {
+		return fmt.Errorf("invalid pin name: %q", name)
+	}
+	for _, sub := range strings.Split(name, PinSeparator) {
+		switch sub {
+		case "", ".", "..":
+			return fmt.Errorf("invalid pin name: %q", name)
+		}
+	}
+	return nil
+}
+
+// ValidateNewPinName checks if a name can be used for a new pin. In addition to ValidatePinName,
+// names cannot contain "~" and "^", since those are used as ancestry suffixes.
+// Existing pins with such names can still be read and deleted.
+func ValidateNewPinName(name string) error {
+	if err := ValidatePinName(name); err != nil {
+		return err
+	} else if strings.ContainsAny(name, "~^") {
+		return fmt.Errorf("pin names cannot contain ancestry suffixes: %q", name)
+	}
+	return nil
+}
+
+// CheckPinConflict checks that a new pin name doesn't conflict with existing pins. It returns ErrPinConflict
+// if one of the parent namespaces of the name is a pin, or if the name is a namespace of other pins.
+// Storages that cannot detect conflicts on their own should call it from SetPin.
+func CheckPinConflict(ctx context.Context, s PinStorage, name string) error {
+	for i := 0; ; {
+		j := strings.Index(name[i:], PinSeparator)
+		if j < 0 {
+			break
+		}
+		i += j
+		if _, err := s.GetPin(ctx, name[:i]); err == nil {
+			return ErrPinConflict
+		} else if err != ErrNotFound {
+			return err
+		}
+		i += len(PinSeparator)
+	}
+	it := IteratePinsByPrefix(ctx, s, name+PinSeparator)
+	defer it.Close()
+	if it.Next() {
+		return ErrPinConflict
+	}
+	return it.Err()
+}
+
+// pinsConflict checks if one of the pin names is a namespace of the other.
+func pinsConflict(a, b string) bool {
+	return strings.HasPrefix(a, b+PinSeparator) || strings.HasPrefix(b, a+PinSeparator)
+}
+
+// PinPrefixIterator is an optional interface for storages that can efficiently list pins by a name prefix.
+type PinPrefixIterator interface {
+	// IteratePinsByPrefix lists pins with names that start with a given prefix.
+	IteratePinsByPrefix(ctx context.Context, prefix string) PinIterator
+}
+
+// IteratePinsByPrefix lists pins with names that start with a given prefix.
+// The prefix is matched as a string, thus "datasets/" will list all pins in this namespace.
+// If storage doesn't implement PinPrefixIterator, it will iterate over all pins.
+func IteratePinsByPrefix(ctx context.Context, s PinStorage, prefix string) PinIterator {
+	if prefix == "" {
+		return s.IteratePins(ctx)
+	}
+	if ps, ok := s.(PinPrefixIterator); ok {
+		return ps.IteratePinsByPrefix(ctx, prefix)
+	}
+	return &pinPrefixIterator{PinIterator: s.IteratePins(ctx), prefix: prefix}
+}
+
+// HideIndexPins wraps the iterator to skip index pins.
+func HideIndexPins(it PinIterator) PinIterator {
+	return hideIndexPins{it}
+}
+
+type hideIndexPins struct {
+	PinIterator
+}
+
+func (it hideIndexPins) Next() bool {
+	for it.PinIterator.Next() {
+		if !IsIndexPin(it.Pin().Name) {
+			return true
+		}
+	}
+	return false
+}
+
+type pinPrefixIterator struct {
+	PinIterator
+	prefix string
+}
+
+func (it *pinPrefixIterator) Next() bool {
+	for it.PinIterator.Next() {
+		if strings.HasPrefix(it.Pin().Name, it.prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dennwc/cas/types"
+)
+
+// ErrAmbiguousRef is returned when a ref prefix matches more than one blob.
+type ErrAmbiguousRef struct {
+	Prefix types.RefPrefix
+	Refs   []types.Ref
+}
+
+func (e ErrAmbiguousRef) Error() string {
+	refs := make([]string, 0, len(e.Refs))
+	for _, r := range e.Refs {
+		refs = append(refs, r.String())
+	}
+	return fmt.Sprintf("ambiguous ref %v; candidates:\n\t%s", e.Prefix, strings.Join(refs, "\n\t"))
+}
+
+// BlobPrefixIterator is an optional interface for storages that can efficiently list blobs by a ref prefix.
+type BlobPrefixIterator interface {
+	// IterateBlobsByPrefix lists blobs with refs that match a given prefix.
+	IterateBlobsByPrefix(ctx context.Context, pref types.RefPrefix) Iterator
+}
+
+// IterateBlobsByPrefix lists blobs with refs that match a given prefix.
+// If storage doesn't implement BlobPrefixIterator, it will iterate over all blobs.
+func IterateBlobsByPrefix(ctx context.Context, s BlobSource, pref types.RefPrefix) Iterator {
+	if ps, ok := s.(BlobPrefixIterator); ok {
+		return ps.IterateBlobsByPrefix(ctx, pref)
+	}
+	return &prefixIterator{Iterator: s.IterateBlobs(ctx), pref: pref}
+}
+
+type prefixIterator struct {
+	Iterator
+	pref types.RefPrefix
+}
+
+func (it *prefixIterator) Next() bool {
+	for it.Iterator.Next() {
+		if it.pref.Match(it.SizedRef().Ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxAmbiguousRefs is the maximal number of candidates listed in ErrAmbiguousRef.
+const maxAmbiguousRefs = 10
+
+// ResolvePrefix finds a blob that matches a ref prefix.
+// It returns ErrNotFound if there are no such blobs and ErrAmbiguousRef if there is more than one.
+func ResolvePrefix(ctx context.Context, s BlobSource, pref types.RefPrefix) (types.Ref, error) {
+	if ref, ok := pref.Ref(); ok {
+		return ref, nil
+	}
+	it := IterateBlobsByPrefix(ctx, s, pref)
+	defer it.Close()
+	var refs []types.Ref
+	for it.Next() && len(refs) < maxAmbiguousRefs {
+		refs = append(refs, it.SizedRef().Ref)
+	}
+	if err := it.Err(); err != nil {
+		return types.Ref{}, err
+	}
+	switch len(refs) {
+	case 0:
+		return types.Ref{}, ErrNotFound
+	case 1:
+		return refs[0], nil
+	}
+	return types.Ref{}, ErrAmbiguousRef{Prefix: pref, Refs: refs}
+}
@@ -0,0 +1,54 @@
+package reapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthFunc checks credentials of a request. The header is the value of the "authorization" request metadata,
+// and write is set for requests that store blobs. Errors without a gRPC status are reported as PermissionDenied.
+type AuthFunc func(ctx context.Context, header string, write bool) error
+
+// writeMethods is a set of methods that store blobs.
+var writeMethods = map[string]bool{
+	"/build.bazel.remote.execution.v2.ContentAddressableStorage/BatchUpdateBlobs": true,
+	"/google.bytestream.ByteStream/Write":                                         true,
+	"/google.bytestream.ByteStream/QueryWriteStatus":                              true,
+}
+
+// AuthOptions returns gRPC server options that check credentials of all requests with a given function.
+func AuthOptions(auth AuthFunc) []grpc.ServerOption {
+	check := func(ctx context.Context, method string) error {
+		var header string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get("authorization"); len(v) != 0 {
+				header = v[0]
+			}
+		}
+		err := auth(ctx, header, writeMethods[method])
+		if err == nil {
+			return nil
+		} else if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+			if err := check(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := check(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	}
+}
@@ -0,0 +1,481 @@
+package reapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+var (
+	_ storage.Storage     = (*Client)(nil)
+	_ storage.BatchStater = (*Client)(nil)
+)
+
+// ErrNoPins is returned when trying to modify pins, since REAPI has no notion of them.
+var ErrNoPins = errors.New("reapi: pins are not supported")
+
+// maxKnownSizes is the maximal number of blob sizes remembered by the client.
+const maxKnownSizes = 1 << 16
+
+func init() {
+	storage.RegisterConfig("cas:REAPIClientConfig", &Config{})
+}
+
+// Config is a config for a REAPI client.
+//
+// REAPI addresses blobs by a hash and a size. The client only knows sizes of blobs that it wrote or listed recently,
+// other blobs can only be fetched from servers that accept unknown sizes, like the one returned by NewServer.
+type Config struct {
+	// Addr is the address of the gRPC server.
+	Addr string `json:"addr"`
+	// Instance is the REAPI instance name.
+	Instance string `json:"instance,omitempty"`
+	// Insecure disables TLS.
+	Insecure bool `json:"insecure,omitempty"`
+	// CACert is a path to a PEM file with CA certificates used to verify the server.
+	CACert string `json:"ca_cert,omitempty"`
+}
+
+func (c *Config) References() []types.Ref {
+	return nil
+}
+
+func (c *Config) OpenStorage(ctx context.Context) (storage.Storage, error) {
+	creds := insecure.NewCredentials()
+	if !c.Insecure {
+		conf := &tls.Config{}
+		if c.CACert != "" {
+			data, err := ioutil.ReadFile(c.CACert)
+			if err != nil {
+				return nil, err
+			}
+			conf.RootCAs = x509.NewCertPool()
+			if !conf.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates found in %q", c.CACert)
+			}
+		}
+		creds = credentials.NewTLS(conf)
+	}
+	conn, err := grpc.DialContext(ctx, c.Addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	cli := NewClient(conn, c.Instance)
+	cli.conn = conn
+	return cli, nil
+}
+
+// NewClient creates a REAPI client on a given gRPC connection. The client won't close the connection.
+//
+// REAPI requires clients to know sizes of blobs. The client remembers sizes of a limited number of blobs
+// that it writes or checks, and relies on a server extension for blobs with unknown sizes (see sizeHeader).
+func NewClient(cc grpc.ClientConnInterface, instance string) *Client {
+	return &Client{
+		cas:      repb.NewContentAddressableStorageClient(cc),
+		bs:       bytestream.NewByteStreamClient(cc),
+		instance: instance,
+		sizes:    make(map[types.Ref]int64),
+	}
+}
+
+// Client is a storage backed by a REAPI server. It doesn't support listing blobs and pins.
+type Client struct {
+	conn     *grpc.ClientConn
+	cas      repb.ContentAddressableStorageClient
+	bs       bytestream.ByteStreamClient
+	instance string
+
+	mu    sync.Mutex
+	sizes map[types.Ref]int64 // known blob sizes
+}
+
+func (c *Client) Close() error {
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *Client) setSize(ref types.Ref, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.sizes[ref]; !ok && len(c.sizes) >= maxKnownSizes {
+		// forget a random size; it can be requested from the server again
+		for r := range c.sizes {
+			delete(c.sizes, r)
+			break
+		}
+	}
+	c.sizes[ref] = size
+}
+
+// digest returns a digest of a blob. The size is negative if it's unknown.
+func (c *Client) digest(ref types.Ref) (*repb.Digest, error) {
+	if ref.Zero() {
+		return nil, storage.ErrInvalidRef
+	}
+	c.mu.Lock()
+	size, ok := c.sizes[ref]
+	c.mu.Unlock()
+	if !ok {
+		size = -1
+	}
+	return digestFromRef(ref, size)
+}
+
+func clientError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return storage.ErrNotFound
+	case codes.PermissionDenied:
+		return storage.ErrReadOnly
+	}
+	return err
+}
+
+// read starts a ByteStream read and returns the size of the blob.
+func (c *Client) read(ctx context.Context, d *repb.Digest, limit int64) (bytestream.ByteStream_ReadClient, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	rc, err := c.bs.Read(ctx, &bytestream.ReadRequest{
+		ResourceName: resourceName(c.instance, d),
+		ReadLimit:    limit,
+	})
+	if err != nil {
+		cancel()
+		return nil, 0, clientError(err)
+	}
+	md, err := rc.Header()
+	if err != nil {
+		cancel()
+		return nil, 0, clientError(err)
+	}
+	size := d.SizeBytes
+	if size < 0 {
+		v := md.Get(sizeHeader)
+		if len(v) == 0 {
+			// headers are empty if the stream failed
+			_, err = rc.Recv()
+			cancel()
+			if err == nil || err == io.EOF {
+				err = errors.New("server doesn't report blob sizes")
+			} else if err = clientError(err); err == storage.ErrNotFound {
+				return nil, 0, err
+			}
+			return nil, 0, fmt.Errorf("reapi: size of %s/%s is unknown and the server cannot find it: %w", hashName, d.Hash, err)
+		}
+		size, err = strconv.ParseInt(v[0], 10, 64)
+		if err != nil {
+			cancel()
+			return nil, 0, err
+		}
+	}
+	return cancelStream{rc, cancel}, size, nil
+}
+
+type cancelStream struct {
+	bytestream.ByteStream_ReadClient
+	cancel func()
+}
+
+func (c *Client) StatBlob(ctx context.Context, ref types.Ref) (uint64, error) {
+	d, err := c.digest(ref)
+	if err != nil {
+		return 0, err
+	}
+	if d.SizeBytes >= 0 {
+		resp, err := c.cas.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{
+			InstanceName: c.instance, BlobDigests: []*repb.Digest{d},
+		})
+		if err != nil {
+			return 0, clientError(err)
+		} else if len(resp.MissingBlobDigests) != 0 {
+			return 0, storage.ErrNotFound
+		}
+		return uint64(d.SizeBytes), nil
+	}
+	rc, size, err := c.read(ctx, d, 1)
+	if err != nil {
+		return 0, err
+	}
+	rc.(cancelStream).cancel()
+	c.setSize(ref, size)
+	return uint64(size), nil
+}
+
+// StatBlobs implements storage.BatchStater. Blobs with known sizes are checked with a single request.
+func (c *Client) StatBlobs(ctx context.Context, refs []types.Ref) ([]types.SizedRef, error) {
+	var (
+		known   []*repb.Digest
+		unknown []types.Ref
+	)
+	for _, ref := range refs {
+		d, err := c.digest(ref)
+		if err != nil {
+			return nil, err
+		} else if d.SizeBytes < 0 {
+			unknown = append(unknown, ref)
+		} else {
+			known = append(known, d)
+		}
+	}
+	var out []types.SizedRef
+	if len(known) != 0 {
+		resp, err := c.cas.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{
+			InstanceName: c.instance, BlobDigests: known,
+		})
+		if err != nil {
+			return nil, clientError(err)
+		}
+		missing := make(map[string]struct{}, len(resp.MissingBlobDigests))
+		for _, d := range resp.MissingBlobDigests {
+			missing[d.Hash] = struct{}{}
+		}
+		for _, d := range known {
+			if _, ok := missing[d.Hash]; ok {
+				continue
+			}
+			ref, err := refFromDigest(d)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, types.SizedRef{Ref: ref, Size: uint64(d.SizeBytes)})
+		}
+	}
+	for _, ref := range unknown {
+		sz, err := c.StatBlob(ctx, ref)
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			return out, err
+		}
+		out = append(out, types.SizedRef{Ref: ref, Size: sz})
+	}
+	return out, nil
+}
+
+func (c *Client) FetchBlob(ctx context.Context, ref types.Ref) (io.ReadCloser, uint64, error) {
+	d, err := c.digest(ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	rc, size, err := c.read(ctx, d, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.setSize(ref, size)
+	return &streamReader{s: rc.(cancelStream)}, uint64(size), nil
+}
+
+type streamReader struct {
+	s   cancelStream
+	buf []byte
+	err error
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		resp, err := r.s.Recv()
+		if err != nil {
+			r.err = clientError(err)
+			continue
+		}
+		r.buf = resp.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	r.s.cancel()
+	return nil
+}
+
+// BeginBlob starts a new blob upload. The data is stored in a temporary file until the blob is committed,
+// because the server requires a digest before the upload.
+func (c *Client) BeginBlob(ctx context.Context) (storage.BlobWriter, error) {
+	if storage.HashName(ctx) != hashName {
+		return nil, fmt.Errorf("reapi: unsupported hash function: %q", storage.HashName(ctx))
+	}
+	hw, err := storage.HashFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "cas-upload-")
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{c: c, ctx: ctx, f: f, hw: hw}, nil
+}
+
+func (c *Client) IterateBlobs(ctx context.Context) storage.Iterator {
+	return &errIterator{err: fmt.Errorf("reapi: listing blobs is not supported")}
+}
+
+func (c *Client) SetPin(ctx context.Context, name string, ref types.Ref) error {
+	return ErrNoPins
+}
+
+func (c *Client) DeletePin(ctx context.Context, name string) error {
+	return ErrNoPins
+}
+
+func (c *Client) GetPin(ctx context.Context, name string) (types.Ref, error) {
+	return types.Ref{}, storage.ErrNotFound
+}
+
+func (c *Client) IteratePins(ctx context.Context) storage.PinIterator {
+	return &errIterator{}
+}
+
+// errIterator is an empty iterator that returns an error, if set.
+type errIterator struct {
+	err error
+}
+
+func (it *errIterator) Next() bool               { return false }
+func (it *errIterator) Err() error               { return it.err }
+func (it *errIterator) Close() error             { return nil }
+func (it *errIterator) SizedRef() types.SizedRef { return types.SizedRef{} }
+func (it *errIterator) Pin() types.Pin           { return types.Pin{} }
+
+// writeChunk is the size of chunks sent in ByteStream writes.
+const writeChunk = 64 << 10
+
+type blobWriter struct {
+	c   *Client
+	ctx context.Context
+	f   *os.File
+	hw  storage.BlobWriter
+	sr  types.SizedRef
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	if _, err := w.hw.Write(p); err != nil {
+		return 0, err
+	}
+	return w.f.Write(p)
+}
+
+func (w *blobWriter) Size() uint64 {
+	return w.hw.Size()
+}
+
+func (w *blobWriter) Complete() (types.SizedRef, error) {
+	sr, err := w.hw.Complete()
+	if err != nil {
+		return types.SizedRef{}, err
+	}
+	w.sr = sr
+	return sr, nil
+}
+
+func (w *blobWriter) Close() error {
+	if w.f != nil {
+		w.cleanup()
+	}
+	return w.hw.Close()
+}
+
+// cleanup removes a temporary file.
+func (w *blobWriter) cleanup() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+	w.f = nil
+}
+
+func (w *blobWriter) Commit() error {
+	if w.f == nil {
+		return storage.ErrBlobDiscarded
+	} else if w.sr.Ref.Zero() {
+		if _, err := w.Complete(); err != nil {
+			return err
+		}
+	}
+	if err := w.hw.Commit(); err != nil {
+		return err
+	}
+	defer w.cleanup()
+	if err := w.upload(); err != nil {
+		return err
+	}
+	w.c.setSize(w.sr.Ref, int64(w.sr.Size))
+	return nil
+}
+
+func (w *blobWriter) upload() error {
+	d, err := digestFromRef(w.sr.Ref, int64(w.sr.Size))
+	if err != nil {
+		return err
+	}
+	if _, err = w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	uuid := make([]byte, 16)
+	if _, err = rand.Read(uuid); err != nil {
+		return err
+	}
+	name := "uploads/" + hex.EncodeToString(uuid) + "/blobs/" + d.Hash + "/" + strconv.FormatInt(d.SizeBytes, 10)
+	if w.c.instance != "" {
+		name = w.c.instance + "/" + name
+	}
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+	ws, err := w.c.bs.Write(ctx)
+	if err != nil {
+		return clientError(err)
+	}
+	buf := make([]byte, writeChunk)
+	var off int64
+	for {
+		n, err := io.ReadFull(w.f, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		req := &bytestream.WriteRequest{WriteOffset: off, Data: buf[:n], FinishWrite: last}
+		if off == 0 {
+			req.ResourceName = name
+		}
+		if err = ws.Send(req); err == io.EOF {
+			// server closed the stream early; the blob might already exist
+			break
+		} else if err != nil {
+			return clientError(err)
+		}
+		off += int64(n)
+		if last {
+			break
+		}
+	}
+	resp, err := ws.CloseAndRecv()
+	if err != nil {
+		return clientError(err)
+	} else if resp.CommittedSize != d.SizeBytes {
+		return fmt.Errorf("reapi: unexpected committed size: %d, expected %d", resp.CommittedSize, d.SizeBytes)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Package reapi implements a Bazel Remote Execution API (REAPI) compatible CAS server and client.
+//
+// Only the content-addressable part of the API is implemented: ContentAddressableStorage, ByteStream and Capabilities.
+// Blobs are keyed by SHA-256 digests.
+package reapi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+
+	"github.com/dennwc/cas/types"
+)
+
+// hashName is the name of the only hash function supported by this package.
+const hashName = "sha256"
+
+// refFromDigest converts a REAPI digest to a ref.
+func refFromDigest(d *repb.Digest) (types.Ref, error) {
+	if d == nil {
+		return types.Ref{}, fmt.Errorf("digest is not set")
+	}
+	data, err := hex.DecodeString(d.Hash)
+	if err != nil {
+		return types.Ref{}, fmt.Errorf("invalid digest %q: %v", d.Hash, err)
+	}
+	return types.MakeRef(hashName, data)
+}
+
+// digestFromRef converts a ref to a REAPI digest.
+func digestFromRef(ref types.Ref, size int64) (*repb.Digest, error) {
+	if ref.Name() != hashName {
+		return nil, fmt.Errorf("unsupported hash function: %q", ref.Name())
+	}
+	return &repb.Digest{Hash: hex.EncodeToString(ref.Data()), SizeBytes: size}, nil
+}
+
+// parseResource parses a ByteStream resource name of a blob:
+//
+//	{instance}/blobs/{hash}/{size}
+//	{instance}/uploads/{uuid}/blobs/{hash}/{size}{/optional_metadata}
+//
+// Compressed blobs are only accepted with the identity compressor.
+func parseResource(name string) (*repb.Digest, error) {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		var rest []string
+		switch p {
+		case "blobs":
+			rest = parts[i+1:]
+		case "compressed-blobs":
+			if i+1 >= len(parts) || parts[i+1] != "identity" {
+				return nil, fmt.Errorf("unsupported compressor in %q", name)
+			}
+			rest = parts[i+2:]
+		default:
+			continue
+		}
+		if len(rest) < 2 {
+			break
+		}
+		size, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in %q: %v", name, err)
+		}
+		return &repb.Digest{Hash: rest[0], SizeBytes: size}, nil
+	}
+	return nil, fmt.Errorf("invalid resource name: %q", name)
+}
+
+// resourceName returns a name of the blob for ByteStream reads.
+func resourceName(instance string, d *repb.Digest) string {
+	name := "blobs/" + d.Hash + "/" + strconv.FormatInt(d.SizeBytes, 10)
+	if instance != "" {
+		name = instance + "/" + name
+	}
+	return name
+}
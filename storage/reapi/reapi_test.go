@@ -0,0 +1,272 @@
+package reapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+func newTestServer(t testing.TB, s storage.Storage, opts ...grpc.ServerOption) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	g := grpc.NewServer(opts...)
+	NewServer(s).Register(g)
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func digestOf(data []byte) *repb.Digest {
+	h := sha256.Sum256(data)
+	return &repb.Digest{Hash: hex.EncodeToString(h[:]), SizeBytes: int64(len(data))}
+}
+
+func TestREAPIClient(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemory()
+	conn := newTestServer(t, mem)
+
+	cli := NewClient(conn, "main")
+	small := []byte("some data")
+	large := bytes.Repeat([]byte("large blob "), 3*writeChunk/10)
+
+	for _, data := range [][]byte{small, large, nil} {
+		sr, err := storage.WriteBytes(ctx, cli, data)
+		require.NoError(t, err)
+		require.Equal(t, types.BytesRef(data), sr.Ref)
+
+		sz, err := cli.StatBlob(ctx, sr.Ref)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(data)), sz)
+
+		if len(data) != 0 {
+			sz, err = mem.StatBlob(ctx, sr.Ref)
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(data)), sz)
+		}
+	}
+
+	// sizes are not known to a new client
+	cli = NewClient(conn, "main")
+	ref := types.BytesRef(large)
+	sz, err := cli.StatBlob(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(large)), sz)
+
+	rc, sz, err := NewClient(conn, "").FetchBlob(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(large)), sz)
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	require.Equal(t, large, got)
+
+	missing := types.BytesRef([]byte("missing"))
+	_, err = cli.StatBlob(ctx, missing)
+	require.Equal(t, storage.ErrNotFound, err)
+	_, _, err = cli.FetchBlob(ctx, missing)
+	require.Equal(t, storage.ErrNotFound, err)
+
+	srs, err := storage.StatBlobs(ctx, cli, []types.Ref{missing, ref, types.BytesRef(small)})
+	require.NoError(t, err)
+	require.Equal(t, []types.SizedRef{
+		{Ref: ref, Size: uint64(len(large))},
+		{Ref: types.BytesRef(small), Size: uint64(len(small))},
+	}, srs)
+
+	_, err = storage.WriteBytes(storage.WithHash(ctx, "blake3"), cli, small)
+	require.Error(t, err)
+	require.Equal(t, ErrNoPins, cli.SetPin(ctx, "root", ref))
+
+	// the number of remembered sizes is limited
+	for i := 0; i < maxKnownSizes+10; i++ {
+		cli.setSize(types.BytesRef([]byte{byte(i), byte(i >> 8), byte(i >> 16)}), int64(i))
+	}
+	require.Len(t, cli.sizes, maxKnownSizes)
+}
+
+func TestREAPIServer(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemory()
+	conn := newTestServer(t, mem)
+	cas := repb.NewContentAddressableStorageClient(conn)
+	bs := bytestream.NewByteStreamClient(conn)
+
+	a, b := []byte("blob a"), []byte("blob b")
+	_, err := storage.WriteBytes(ctx, mem, a)
+	require.NoError(t, err)
+
+	resp, err := cas.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{
+		BlobDigests: []*repb.Digest{digestOf(a), digestOf(b), digestOf(nil)},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.MissingBlobDigests, 1)
+	require.Equal(t, digestOf(b).Hash, resp.MissingBlobDigests[0].Hash)
+
+	// only the empty blob has zero size
+	zero := digestOf(a)
+	zero.SizeBytes = 0
+	resp, err = cas.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{
+		BlobDigests: []*repb.Digest{zero},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.MissingBlobDigests, 1)
+	zrd, err := cas.BatchReadBlobs(ctx, &repb.BatchReadBlobsRequest{Digests: []*repb.Digest{zero}})
+	require.NoError(t, err)
+	require.Equal(t, int32(codes.NotFound), zrd.Responses[0].Status.Code)
+
+	bad := digestOf(b)
+	bad.SizeBytes++
+	up, err := cas.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
+		Requests: []*repb.BatchUpdateBlobsRequest_Request{
+			{Digest: digestOf(b), Data: b},
+			{Digest: bad, Data: b},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, up.Responses, 2)
+	require.Equal(t, int32(codes.OK), up.Responses[0].Status.Code)
+	require.Equal(t, int32(codes.InvalidArgument), up.Responses[1].Status.Code)
+
+	rd, err := cas.BatchReadBlobs(ctx, &repb.BatchReadBlobsRequest{
+		Digests: []*repb.Digest{digestOf(a), digestOf(b), digestOf([]byte("missing"))},
+	})
+	require.NoError(t, err)
+	require.Len(t, rd.Responses, 3)
+	require.Equal(t, a, rd.Responses[0].Data)
+	require.Equal(t, b, rd.Responses[1].Data)
+	require.Equal(t, int32(codes.NotFound), rd.Responses[2].Status.Code)
+
+	// unknown sizes are not accepted in batches
+	unknown := digestOf(a)
+	unknown.SizeBytes = -1
+	_, err = cas.BatchReadBlobs(ctx, &repb.BatchReadBlobsRequest{
+		Digests: []*repb.Digest{unknown},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// partial reads
+	rs, err := bs.Read(ctx, &bytestream.ReadRequest{
+		ResourceName: resourceName("main", digestOf(a)),
+		ReadOffset:   2, ReadLimit: 3,
+	})
+	require.NoError(t, err)
+	r, err := rs.Recv()
+	require.NoError(t, err)
+	require.Equal(t, a[2:5], r.Data)
+}
+
+func TestParseResource(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		hash string
+		size int64
+		err  bool
+	}{
+		{name: "blobs/abc/12", hash: "abc", size: 12},
+		{name: "inst/name/blobs/abc/12", hash: "abc", size: 12},
+		{name: "inst/uploads/uuid/blobs/abc/12/meta/data", hash: "abc", size: 12},
+		{name: "uploads/uuid/compressed-blobs/identity/abc/3", hash: "abc", size: 3},
+		{name: "uploads/uuid/compressed-blobs/zstd/abc/3", err: true},
+		{name: "blobs/abc", err: true},
+		{name: "blobs/abc/x", err: true},
+	} {
+		d, err := parseResource(c.name)
+		if c.err {
+			require.Error(t, err, c.name)
+			continue
+		}
+		require.NoError(t, err, c.name)
+		require.Equal(t, c.hash, d.Hash)
+		require.Equal(t, c.size, d.SizeBytes)
+	}
+}
+
+func TestREAPIAuth(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemory()
+	conn := newTestServer(t, mem, AuthOptions(func(ctx context.Context, header string, write bool) error {
+		switch header {
+		case "Bearer writer":
+			return nil
+		case "Bearer reader":
+			if write {
+				return errors.New("read-only token")
+			}
+			return nil
+		}
+		return status.Error(codes.Unauthenticated, "no credentials")
+	})...)
+	cas := repb.NewContentAddressableStorageClient(conn)
+	bs := bytestream.NewByteStreamClient(conn)
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	data := []byte("blob")
+	upload := func(ctx context.Context) error {
+		resp, err := cas.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
+			Requests: []*repb.BatchUpdateBlobsRequest_Request{{Digest: digestOf(data), Data: data}},
+		})
+		if err != nil {
+			return err
+		}
+		require.Equal(t, int32(codes.OK), resp.Responses[0].Status.Code)
+		return nil
+	}
+	write := func(ctx context.Context) error {
+		ws, err := bs.Write(ctx)
+		require.NoError(t, err)
+		err = ws.Send(&bytestream.WriteRequest{
+			ResourceName: "main/uploads/u/" + resourceName("", digestOf(data)),
+			Data:         data, FinishWrite: true,
+		})
+		if err != nil && err != io.EOF {
+			return err
+		}
+		_, err = ws.CloseAndRecv()
+		return err
+	}
+
+	require.Equal(t, codes.Unauthenticated, status.Code(upload(ctx)))
+	require.Equal(t, codes.Unauthenticated, status.Code(write(ctx)))
+	require.Equal(t, codes.PermissionDenied, status.Code(upload(withToken("reader"))))
+	require.Equal(t, codes.PermissionDenied, status.Code(write(withToken("reader"))))
+	_, err := mem.StatBlob(ctx, types.BytesRef(data))
+	require.Equal(t, storage.ErrNotFound, err)
+
+	require.NoError(t, upload(withToken("writer")))
+
+	_, err = cas.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{BlobDigests: []*repb.Digest{digestOf(data)}})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	resp, err := cas.FindMissingBlobs(withToken("reader"), &repb.FindMissingBlobsRequest{BlobDigests: []*repb.Digest{digestOf(data)}})
+	require.NoError(t, err)
+	require.Empty(t, resp.MissingBlobDigests)
+}
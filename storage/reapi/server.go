@@ -0,0 +1,351 @@
+package reapi
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/bazelbuild/remote-apis/build/bazel/semver"
+	"google.golang.org/genproto/googleapis/bytestream"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+const (
+	// maxBatchSize is the maximal total size of blobs in batch requests.
+	maxBatchSize = 4 << 20
+	// readChunk is the size of chunks sent in ByteStream reads.
+	readChunk = 64 << 10
+)
+
+// sizeHeader is a response header of ByteStream reads with the size of the blob.
+//
+// REAPI requires clients to know the size of the blob in advance. As an extension, this server accepts negative sizes
+// in digests as "unknown" and reports the actual size in this header. This allows to fetch blobs by a hash alone.
+const sizeHeader = "x-cas-blob-size"
+
+// NewServer creates a REAPI server backed by a given storage. Register it with Register.
+func NewServer(s storage.Storage) *Server {
+	return &Server{s: s}
+}
+
+// Server exposes a storage via ContentAddressableStorage, ByteStream and Capabilities REAPI services.
+// Instance names are ignored.
+type Server struct {
+	s storage.Storage
+}
+
+// Register registers all services on a gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	repb.RegisterContentAddressableStorageServer(g, casServer{s})
+	repb.RegisterCapabilitiesServer(g, capsServer{s})
+	bytestream.RegisterByteStreamServer(g, byteStreamServer{s})
+}
+
+func grpcError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case storage.ErrNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case storage.ErrInvalidRef:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case storage.ErrReadOnly:
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func rpcStatus(err error) *rpcstatus.Status {
+	if err == nil {
+		return &rpcstatus.Status{Code: int32(codes.OK)}
+	}
+	return status.Convert(grpcError(err)).Proto()
+}
+
+// stat checks if a blob exists. Empty blobs always exist, but only if the digest has the hash of an empty blob.
+func (s *Server) stat(ctx context.Context, d *repb.Digest) (types.Ref, error) {
+	ref, err := refFromDigest(d)
+	if err != nil {
+		return ref, status.Error(codes.InvalidArgument, err.Error())
+	} else if ref.Empty() && d.SizeBytes <= 0 {
+		return ref, nil
+	}
+	sz, err := s.s.StatBlob(ctx, ref)
+	if err != nil {
+		return ref, err
+	} else if d.SizeBytes >= 0 && uint64(d.SizeBytes) != sz {
+		return ref, storage.ErrNotFound
+	}
+	return ref, nil
+}
+
+// write stores a blob and checks that it matches the digest.
+func (s *Server) write(ctx context.Context, d *repb.Digest, r io.Reader) error {
+	ref, err := refFromDigest(d)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	w, err := s.s.BeginBlob(storage.WithHash(ctx, hashName))
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+	sr, err := w.Complete()
+	if err != nil {
+		return err
+	} else if sr.Ref != ref || int64(sr.Size) != d.SizeBytes {
+		return status.Errorf(codes.InvalidArgument, "digest mismatch: expected %s/%d, got %v/%d", d.Hash, d.SizeBytes, sr.Ref, sr.Size)
+	}
+	return w.Commit()
+}
+
+type casServer struct {
+	*Server
+}
+
+func (s casServer) FindMissingBlobs(ctx context.Context, req *repb.FindMissingBlobsRequest) (*repb.FindMissingBlobsResponse, error) {
+	refs := make([]types.Ref, 0, len(req.BlobDigests))
+	for _, d := range req.BlobDigests {
+		ref, err := refFromDigest(d)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		refs = append(refs, ref)
+	}
+	have, err := storage.StatBlobs(ctx, s.s, refs)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	sizes := make(map[types.Ref]uint64, len(have))
+	for _, sr := range have {
+		sizes[sr.Ref] = sr.Size
+	}
+	resp := &repb.FindMissingBlobsResponse{}
+	for i, d := range req.BlobDigests {
+		if d.SizeBytes == 0 && refs[i].Empty() {
+			continue
+		}
+		if sz, ok := sizes[refs[i]]; !ok || int64(sz) != d.SizeBytes {
+			resp.MissingBlobDigests = append(resp.MissingBlobDigests, d)
+		}
+	}
+	return resp, nil
+}
+
+func (s casServer) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest) (*repb.BatchUpdateBlobsResponse, error) {
+	total := 0
+	for _, r := range req.Requests {
+		total += len(r.Data)
+	}
+	if total > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "batch is too large: %d > %d", total, maxBatchSize)
+	}
+	resp := &repb.BatchUpdateBlobsResponse{}
+	for _, r := range req.Requests {
+		var err error
+		if r.Compressor != repb.Compressor_IDENTITY {
+			err = status.Errorf(codes.InvalidArgument, "unsupported compressor: %v", r.Compressor)
+		} else if r.Digest == nil {
+			err = status.Error(codes.InvalidArgument, "digest is not set")
+		} else {
+			err = s.write(ctx, r.Digest, bytes.NewReader(r.Data))
+		}
+		resp.Responses = append(resp.Responses, &repb.BatchUpdateBlobsResponse_Response{
+			Digest: r.Digest, Status: rpcStatus(err),
+		})
+	}
+	return resp, nil
+}
+
+func (s casServer) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest) (*repb.BatchReadBlobsResponse, error) {
+	var total int64
+	for _, d := range req.Digests {
+		if d.SizeBytes < 0 {
+			// unknown sizes are only accepted by ByteStream, otherwise they would bypass the batch size limit
+			return nil, status.Errorf(codes.InvalidArgument, "blob size must be known in batch reads: %s", d.Hash)
+		}
+		total += d.SizeBytes
+	}
+	if total > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "batch is too large: %d > %d", total, maxBatchSize)
+	}
+	resp := &repb.BatchReadBlobsResponse{}
+	for _, d := range req.Digests {
+		out := &repb.BatchReadBlobsResponse_Response{Digest: d}
+		out.Data, out.Status = s.readAll(ctx, d)
+		resp.Responses = append(resp.Responses, out)
+	}
+	return resp, nil
+}
+
+func (s casServer) readAll(ctx context.Context, d *repb.Digest) ([]byte, *rpcstatus.Status) {
+	ref, err := s.stat(ctx, d)
+	if err != nil {
+		return nil, rpcStatus(err)
+	} else if ref.Empty() {
+		return nil, rpcStatus(nil)
+	}
+	rc, sz, err := s.s.FetchBlob(ctx, ref)
+	if err != nil {
+		return nil, rpcStatus(err)
+	}
+	defer rc.Close()
+	data := make([]byte, sz)
+	if _, err = io.ReadFull(rc, data); err != nil {
+		return nil, rpcStatus(err)
+	}
+	return data, rpcStatus(nil)
+}
+
+func (s casServer) GetTree(req *repb.GetTreeRequest, srv repb.ContentAddressableStorage_GetTreeServer) error {
+	return status.Error(codes.Unimplemented, "GetTree is not supported")
+}
+
+type capsServer struct {
+	*Server
+}
+
+func (s capsServer) GetCapabilities(ctx context.Context, req *repb.GetCapabilitiesRequest) (*repb.ServerCapabilities, error) {
+	return &repb.ServerCapabilities{
+		CacheCapabilities: &repb.CacheCapabilities{
+			DigestFunctions:        []repb.DigestFunction_Value{repb.DigestFunction_SHA256},
+			MaxBatchTotalSizeBytes: maxBatchSize,
+			SupportedCompressors:   []repb.Compressor_Value{repb.Compressor_IDENTITY},
+		},
+		LowApiVersion:  &semver.SemVer{Major: 2},
+		HighApiVersion: &semver.SemVer{Major: 2, Minor: 3},
+	}, nil
+}
+
+type byteStreamServer struct {
+	*Server
+}
+
+func (s byteStreamServer) Read(req *bytestream.ReadRequest, srv bytestream.ByteStream_ReadServer) error {
+	ctx := srv.Context()
+	d, err := parseResource(req.ResourceName)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	ref, err := s.stat(ctx, d)
+	if err != nil {
+		return grpcError(err)
+	}
+	if req.ReadOffset < 0 || req.ReadLimit < 0 {
+		return status.Error(codes.OutOfRange, "negative offset or limit")
+	} else if ref.Empty() {
+		return srv.SendHeader(metadata.Pairs(sizeHeader, "0"))
+	}
+	rc, sz, err := s.s.FetchBlob(ctx, ref)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer rc.Close()
+	if err = srv.SendHeader(metadata.Pairs(sizeHeader, strconv.FormatUint(sz, 10))); err != nil {
+		return err
+	}
+	if req.ReadOffset > int64(sz) {
+		return status.Error(codes.OutOfRange, "offset is larger than the blob")
+	}
+	if _, err = io.CopyN(io.Discard, rc, req.ReadOffset); err != nil {
+		return grpcError(err)
+	}
+	var r io.Reader = rc
+	if req.ReadLimit > 0 {
+		r = io.LimitReader(r, req.ReadLimit)
+	}
+	buf := make([]byte, readChunk)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := srv.Send(&bytestream.ReadResponse{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return grpcError(err)
+		}
+	}
+}
+
+func (s byteStreamServer) Write(srv bytestream.ByteStream_WriteServer) error {
+	ctx := srv.Context()
+	req, err := srv.Recv()
+	if err != nil {
+		return err
+	}
+	d, err := parseResource(req.ResourceName)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err = s.stat(ctx, d); err == nil {
+		// blob already exists - the upload can be skipped
+		return srv.SendAndClose(&bytestream.WriteResponse{CommittedSize: d.SizeBytes})
+	} else if err != storage.ErrNotFound {
+		return grpcError(err)
+	}
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		err := s.write(ctx, d, pr)
+		pr.CloseWithError(err)
+		errc <- err
+	}()
+	var off int64
+	for {
+		if req.WriteOffset != off {
+			pw.CloseWithError(io.ErrUnexpectedEOF)
+			<-errc
+			return status.Errorf(codes.InvalidArgument, "unexpected write offset: %d, expected %d", req.WriteOffset, off)
+		}
+		if _, err = pw.Write(req.Data); err != nil {
+			break
+		}
+		off += int64(len(req.Data))
+		if req.FinishWrite {
+			break
+		}
+		req, err = srv.Recv()
+		if err != nil {
+			pw.CloseWithError(err)
+			<-errc
+			return err
+		}
+	}
+	pw.Close()
+	if err := <-errc; err != nil {
+		return grpcError(err)
+	}
+	return srv.SendAndClose(&bytestream.WriteResponse{CommittedSize: off})
+}
+
+func (s byteStreamServer) QueryWriteStatus(ctx context.Context, req *bytestream.QueryWriteStatusRequest) (*bytestream.QueryWriteStatusResponse, error) {
+	d, err := parseResource(req.ResourceName)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// uploads cannot be resumed; report either a complete blob or an empty upload
+	if _, err = s.stat(ctx, d); err == nil {
+		return &bytestream.QueryWriteStatusResponse{CommittedSize: d.SizeBytes, Complete: true}, nil
+	} else if err != storage.ErrNotFound {
+		return nil, grpcError(err)
+	}
+	return &bytestream.QueryWriteStatusResponse{}, nil
+}
@@ -22,6 +22,9 @@ var (
 	ErrBlobDiscarded = errors.New("blob was discarded")
 	// ErrBlobCompleted is returned for BlobWriter operations after the blob was completed.
 	ErrBlobCompleted = errors.New("blob was completed")
+	// ErrPinConflict is returned when setting a pin that is a namespace of other pins, or a pin in a namespace
+	// that is a pin itself. For example, pins "a" and "a/b" cannot exist at the same time.
+	ErrPinConflict = errors.New("pin: name conflicts with an existing pin")
 )
 
 // ErrRefMissmatch is returned when the streamed content doesn't match an expected blob ref.
@@ -117,6 +120,7 @@ type BlobWriter interface {
 // PinStorage is a minimal interface for implementing a mutable storage over immutable storage.
 type PinStorage interface {
 	// SetPin overwrites or creates a named pin with a specified blob ref.
+	// It returns ErrPinConflict if the name conflicts with existing pins.
 	SetPin(ctx context.Context, name string, ref types.Ref) error
 	// DeletePin removes a named pin.
 	DeletePin(ctx context.Context, name string) error
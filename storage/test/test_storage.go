@@ -21,6 +21,12 @@ func RunTests(t *testing.T, fnc StorageFunc) {
 	t.Run("overwrite", func(t *testing.T) {
 		testOverwrite(t, fnc)
 	})
+	t.Run("pins", func(t *testing.T) {
+		testPins(t, fnc)
+	})
+	t.Run("pin conflicts", func(t *testing.T) {
+		testPinConflicts(t, fnc)
+	})
 }
 
 func testSimple(t *testing.T, fnc StorageFunc) {
@@ -100,3 +106,91 @@ func testOverwrite(t *testing.T, fnc StorageFunc) {
 	writeBlob(t, s, data, expRef)
 	writeBlob(t, s, data, expRef)
 }
+
+func listPins(t testing.TB, s storage.Storage, prefix string) map[string]types.Ref {
+	it := storage.IteratePinsByPrefix(context.Background(), s, prefix)
+	defer it.Close()
+	out := make(map[string]types.Ref)
+	for it.Next() {
+		p := it.Pin()
+		out[p.Name] = p.Ref
+	}
+	require.NoError(t, it.Err())
+	return out
+}
+
+func testPins(t *testing.T, fnc StorageFunc) {
+	s, closer := fnc(t)
+	defer closer()
+
+	ctx := context.Background()
+	r1, r2, r3 := types.StringRef("1"), types.StringRef("2"), types.StringRef("3")
+
+	pins := map[string]types.Ref{
+		"root":                  r1,
+		"datasets/imagenet/v3":  r2,
+		"datasets/imagenet/v4":  r3,
+		"datasets/coco":         r1,
+		"datasets-old/imagenet": r2,
+	}
+	for name, ref := range pins {
+		require.NoError(t, s.SetPin(ctx, name, ref))
+	}
+	for name, ref := range pins {
+		got, err := s.GetPin(ctx, name)
+		require.NoError(t, err, name)
+		require.Equal(t, ref, got, name)
+	}
+	_, err := s.GetPin(ctx, "datasets/imagenet")
+	require.Equal(t, storage.ErrNotFound, err)
+
+	require.Equal(t, pins, listPins(t, s, ""))
+	require.Equal(t, map[string]types.Ref{
+		"datasets/imagenet/v3": r2,
+		"datasets/imagenet/v4": r3,
+		"datasets/coco":        r1,
+	}, listPins(t, s, "datasets/"))
+	require.Equal(t, map[string]types.Ref{
+		"datasets/imagenet/v3": r2,
+		"datasets/imagenet/v4": r3,
+	}, listPins(t, s, "datasets/ima"))
+	require.Len(t, listPins(t, s, "datasets"), 4)
+
+	require.NoError(t, s.DeletePin(ctx, "datasets/imagenet/v3"))
+	_, err = s.GetPin(ctx, "datasets/imagenet/v3")
+	require.Equal(t, storage.ErrNotFound, err)
+	require.Len(t, listPins(t, s, "datasets/"), 2)
+}
+
+func testPinConflicts(t *testing.T, fnc StorageFunc) {
+	s, closer := fnc(t)
+	defer closer()
+
+	ctx := context.Background()
+	r1, r2 := types.StringRef("1"), types.StringRef("2")
+
+	require.NoError(t, s.SetPin(ctx, "a", r1))
+	require.NoError(t, s.SetPin(ctx, "x/y/z", r1))
+
+	for _, name := range []string{"a/b", "a/b/c", "x", "x/y", "x/y/z/w"} {
+		err := s.SetPin(ctx, name, r2)
+		require.Equal(t, storage.ErrPinConflict, err, name)
+		_, err = s.GetPin(ctx, name)
+		require.Equal(t, storage.ErrNotFound, err, name)
+	}
+	require.Equal(t, map[string]types.Ref{
+		"a":     r1,
+		"x/y/z": r1,
+	}, listPins(t, s, ""))
+
+	// overwriting a pin and using similar names is not a conflict
+	require.NoError(t, s.SetPin(ctx, "a", r2))
+	require.NoError(t, s.SetPin(ctx, "ab/c", r2))
+	require.NoError(t, s.SetPin(ctx, "x/yy", r2))
+
+	require.NoError(t, s.DeletePin(ctx, "a"))
+	require.NoError(t, s.SetPin(ctx, "a/b", r2))
+	got, err := s.GetPin(ctx, "a/b")
+	require.NoError(t, err)
+	require.Equal(t, r2, got)
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/types"
+)
+
+// ErrNotDir is returned when a directory tree is expected, but the blob describes something else.
+var ErrNotDir = errors.New("not a directory")
+
+var typeDirEnt = schema.MustTypeOf(&schema.DirEntry{})
+
+// maxWrapDepth limits the number of nested blob wrappers that are followed.
+const maxWrapDepth = 16
+
+// DecodeSchema fetches and decodes a schema blob. It returns schema.ErrNotSchema for data blobs.
+func DecodeSchema(ctx context.Context, s BlobIndexer, ref types.Ref) (schema.Object, error) {
+	rc, _, err := s.FetchSchema(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return schema.Decode(rc)
+}
+
+// Unwrap follows blob wrappers (annotated pins, commits, etc) and returns the ref of the content with its schema object.
+// The object is nil for data blobs.
+func Unwrap(ctx context.Context, s BlobIndexer, ref types.Ref) (types.Ref, schema.Object, error) {
+	for i := 0; i < maxWrapDepth; i++ {
+		obj, err := DecodeSchema(ctx, s, ref)
+		if err == schema.ErrNotSchema {
+			return ref, nil, nil
+		} else if err != nil {
+			return ref, nil, err
+		}
+		bw, ok := obj.(schema.BlobWrapper)
+		if !ok {
+			return ref, obj, nil
+		}
+		ref = bw.DataBlob()
+	}
+	return ref, nil, fmt.Errorf("too many nested objects in %v", ref)
+}
+
+// IsDirObject checks if the schema object describes a directory.
+func IsDirObject(obj schema.Object) bool {
+	switch obj := obj.(type) {
+	case *schema.InlineList:
+		return obj.Elem == typeDirEnt
+	case *schema.List:
+		return obj.Elem == typeDirEnt
+	}
+	return false
+}
+
+// ReadDir calls fnc for each entry of the directory described by obj.
+// Entries of directories that span multiple schema blobs are listed in order.
+func ReadDir(ctx context.Context, s BlobIndexer, obj schema.Object, fnc func(ent *schema.DirEntry) error) error {
+	switch obj := obj.(type) {
+	case *schema.InlineList:
+		if obj.Elem != typeDirEnt {
+			return ErrNotDir
+		}
+		for _, e := range obj.List {
+			ent, ok := e.(*schema.DirEntry)
+			if !ok {
+				return fmt.Errorf("expected dir entry, got: %T", e)
+			}
+			if err := fnc(ent); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *schema.List:
+		if obj.Elem != typeDirEnt {
+			return ErrNotDir
+		}
+		for _, ref := range obj.List {
+			sub, err := DecodeSchema(ctx, s, ref)
+			if err == schema.ErrNotSchema {
+				return fmt.Errorf("expected a schema blob in JoinDirectories")
+			} else if err != nil {
+				return err
+			}
+			if err = ReadDir(ctx, s, sub, fnc); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return ErrNotDir
+	}
+}
@@ -10,8 +10,29 @@ import (
 // VerifyReader wraps a reader and calculates a ref of the data on EOF.
 // It returns an error from Read if ref doesn't match the expected one.
 func VerifyReader(rc io.ReadCloser, ref types.Ref) io.ReadCloser {
+	h := ref.Hash()
+	if h == nil {
+		// cannot verify refs of this type
+		return rc
+	}
+	return &verifyReader{
+		rc: rc, exp: ref, h: h,
+	}
+}
+
+// VerifySizedReader is similar to VerifyReader, but can also verify refs of hashes that depend on the size of
+// the data, for example Git object ids.
+func VerifySizedReader(rc io.ReadCloser, ref types.Ref, size uint64) io.ReadCloser {
+	h := ref.Hash()
+	if h == nil {
+		f := types.GetHash(ref.Name())
+		if f == nil || f.NewSized == nil {
+			return rc
+		}
+		h = f.NewSized(size)
+	}
 	return &verifyReader{
-		rc: rc, exp: ref, h: ref.Hash(),
+		rc: rc, exp: ref, h: h,
 	}
 }
 
@@ -20,6 +20,14 @@ type StoreConfig struct {
 	Expect    types.SizedRef // expected size and ref; can be set separately
 	IndexOnly bool           // write metadata only
 	Split     *SplitConfig
+	// Decompress stores decompressed content of compressed files instead of the original.
+	// Original files are still indexed and can be restored on checkout.
+	Decompress bool
+	// Hash is the name of a hash algorithm for new blobs. Storage default is used if it's not set.
+	Hash string
+	// ExtraHashes lists hash algorithms for additional refs of the content. Those refs are recorded
+	// in an alias object and can be used to find the content by checksums published elsewhere.
+	ExtraHashes []string
 }
 
 func (c *StoreConfig) checkRef(sr SizedRef) error {
@@ -40,13 +48,51 @@ type SplitConfig struct {
 }
 
 func (s *Storage) BeginBlob(ctx context.Context) (storage.BlobWriter, error) {
+	ctx, err := s.hashContext(ctx, "")
+	if err != nil {
+		return nil, err
+	}
 	return s.st.BeginBlob(ctx)
 }
 
+// emptyRef returns a ref of an empty blob for the hash used for new blobs.
+// StoreBlob returns a zero ref for empty content, thus importers use it for empty files.
+func emptyRef(ctx context.Context) Ref {
+	if f := types.GetHash(storage.HashName(ctx)); f != nil {
+		return f.Empty()
+	}
+	return types.BytesRef(nil)
+}
+
 // StoreBlob writes the data from r according to a config.
 func (s *Storage) StoreBlob(ctx context.Context, r io.Reader, conf *StoreConfig) (SizedRef, error) {
 	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, err
+	}
 
+	var extra *extraHashes
+	if len(conf.ExtraHashes) != 0 {
+		extra, err = newExtraHashes(conf.ExtraHashes, conf.IndexOnly)
+		if err != nil {
+			return SizedRef{}, err
+		}
+		r = io.TeeReader(r, extra)
+	}
+	sr, err := s.storeBlob(ctx, r, conf, extra != nil)
+	if err != nil || extra == nil {
+		return sr, err
+	}
+	if err = s.storeAlias(ctx, sr, extra); err != nil {
+		return SizedRef{}, err
+	}
+	return sr, nil
+}
+
+// storeBlob stores the content of r, as described in StoreBlob. If the whole content must be read,
+// it won't skip writing the blob that exists in the storage already.
+func (s *Storage) storeBlob(ctx context.Context, r io.Reader, conf *StoreConfig, readAll bool) (SizedRef, error) {
 	if conf.Split != nil {
 		// we need to split the blob - use a different code path
 		href, sr, err := s.splitBlob(ctx, r, conf.Split, conf.IndexOnly)
@@ -62,7 +108,7 @@ func (s *Storage) StoreBlob(ctx context.Context, r io.Reader, conf *StoreConfig)
 		return SizedRef{Ref: href.Ref, Size: sr.Size}, nil
 	}
 
-	if !conf.Expect.Ref.Zero() {
+	if !conf.Expect.Ref.Zero() && !readAll {
 		// if we have this blob already, don't bother saving it again
 		if sz, err := s.StatBlob(ctx, conf.Expect.Ref); err == nil {
 			// TODO: hash the reader to make sure that caller provided the right file?
@@ -76,7 +122,7 @@ func (s *Storage) StoreBlob(ctx context.Context, r io.Reader, conf *StoreConfig)
 		err error
 	)
 	if conf.IndexOnly {
-		w = storage.Hash()
+		w, err = storage.HashFor(ctx)
 	} else {
 		w, err = s.st.BeginBlob(ctx)
 	}
@@ -130,7 +176,11 @@ func (s *Storage) splitBlob(ctx context.Context, r io.Reader, conf *SplitConfig,
 		conf.Max = 64 * 1024 * 1024
 	}
 	// hash whole stream content in the background
-	h := types.NewRef().Hash()
+	cref, err := types.NewRefWith(storage.HashName(ctx))
+	if err != nil {
+		return types.SizedRef{}, types.SizedRef{}, err
+	}
+	h := cref.Hash()
 	r = io.TeeReader(r, h)
 
 	bsize := 128 * 1024
@@ -149,7 +199,7 @@ func (s *Storage) splitBlob(ctx context.Context, r io.Reader, conf *SplitConfig,
 			err error
 		)
 		if indexOnly {
-			bw = storage.Hash()
+			bw, err = storage.HashFor(ctx)
 		} else {
 			bw, err = s.BeginBlob(ctx)
 		}
@@ -214,7 +264,7 @@ func (s *Storage) splitBlob(ctx context.Context, r io.Reader, conf *SplitConfig,
 		refs = append(refs, sr)
 	}
 	// calculate the content ref
-	ref := types.NewRef().WithHash(h)
+	ref := cref.WithHash(h)
 	// collect all chunk refs to a schema blob
 	list := &schema.InlineList{
 		Ref:  &ref,
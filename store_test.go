@@ -0,0 +1,207 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+func TestStoreMixedHashes(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	data := []byte("mixed hashes")
+	fetch := func(ref types.Ref) []byte {
+		rc, _, err := s.FetchBlob(ctx, ref)
+		require.NoError(t, err)
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		return b
+	}
+
+	def, err := s.StoreBlob(ctx, bytes.NewReader(data), nil)
+	require.NoError(t, err)
+	require.Equal(t, types.DefaultHash, def.Ref.Name())
+
+	b3, err := s.StoreBlob(ctx, bytes.NewReader(data), &StoreConfig{Hash: "blake3"})
+	require.NoError(t, err)
+	require.Equal(t, "blake3", b3.Ref.Name())
+	require.Equal(t, def.Size, b3.Size)
+	require.Equal(t, data, fetch(def.Ref))
+	require.Equal(t, data, fetch(b3.Ref))
+
+	_, err = s.StoreBlob(ctx, bytes.NewReader(data), &StoreConfig{Hash: types.HashGitSHA1})
+	require.Error(t, err)
+
+	err = s.SetHash("sha512-256")
+	require.NoError(t, err)
+
+	sr, err := s.StoreSchema(ctx, &schema.DirEntry{Ref: b3.Ref, Name: "file"})
+	require.NoError(t, err)
+	require.Equal(t, "sha512-256", sr.Ref.Name())
+	obj, err := s.DecodeSchema(ctx, sr.Ref)
+	require.NoError(t, err)
+	require.Equal(t, b3.Ref, obj.(*schema.DirEntry).Ref)
+
+	// explicit hash in the config takes precedence over the storage default
+	sp, err := s.StoreBlob(ctx, bytes.NewReader(data), &StoreConfig{
+		Hash: "blake3", Split: &SplitConfig{Max: 4},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "blake3", sp.Ref.Name())
+	list, err := s.DecodeSchema(ctx, sp.Ref)
+	require.NoError(t, err)
+	require.Equal(t, b3.Ref, *list.(*schema.InlineList).Ref)
+}
+
+func TestStoreAliases(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	data := []byte("hello\n")
+	sr, err := s.StoreBlob(ctx, bytes.NewReader(data), &StoreConfig{
+		ExtraHashes: []string{"md5", "sha1", types.HashGitSHA1},
+	})
+	require.NoError(t, err)
+	require.Equal(t, types.DefaultHash, sr.Ref.Name())
+
+	for _, name := range []string{
+		"md5:b1946ac92492d2347c6235b4d2611184",
+		"sha1:f572d396fae9206628714fb2ce00f72e94f2258f",
+		"git-sha1:ce013625030ba8dba906f756967f9e9ca394464a",
+	} {
+		t.Run(name, func(t *testing.T) {
+			ref := types.MustParseRef(name)
+			sz, err := s.StatBlob(ctx, ref)
+			require.NoError(t, err)
+			require.Equal(t, sr.Size, sz)
+
+			rc, sz, err := s.FetchBlob(ctx, ref)
+			require.NoError(t, err)
+			defer rc.Close()
+			require.Equal(t, sr.Size, sz)
+			got, err := ioutil.ReadAll(rc)
+			require.NoError(t, err)
+			require.Equal(t, data, got)
+		})
+	}
+	_, err = s.StatBlob(ctx, types.MustParseRef("md5:00000000000000000000000000000000"))
+	require.Equal(t, storage.ErrNotFound, err)
+
+	// weak hashes can only be used for aliases
+	for _, name := range []string{"md5", "sha1"} {
+		_, err = s.StoreBlob(ctx, bytes.NewReader(data), &StoreConfig{Hash: name})
+		require.Error(t, err)
+	}
+
+	_, err = s.StoreBlob(ctx, bytes.NewReader(data), &StoreConfig{
+		IndexOnly: true, ExtraHashes: []string{types.HashGitSHA1},
+	})
+	require.Error(t, err)
+}
+
+func TestForgedAlias(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	evil, err := s.StoreBlob(ctx, strings.NewReader("evil"), nil)
+	require.NoError(t, err)
+	ref := types.MustParseRef("md5:b1946ac92492d2347c6235b4d2611184")
+	for _, sz := range []uint64{evil.Size, 6} {
+		aref, err := s.StoreSchema(ctx, &schema.Alias{Ref: SizedRef{Ref: evil.Ref, Size: sz}, Refs: []types.Ref{ref}})
+		require.NoError(t, err)
+
+		// aliases that are not indexed are not used
+		_, err = s.StatBlob(ctx, ref)
+		require.Equal(t, storage.ErrNotFound, err)
+
+		// aliases are only indexed if they match the content
+		require.NoError(t, s.ReindexSchema(ctx, true))
+		_, err = s.StatBlob(ctx, ref)
+		require.Equal(t, storage.ErrNotFound, err)
+
+		// forged index entries are verified as well
+		pin := indexPin(aliasIndex, refKey(ref))
+		require.NoError(t, s.st.SetPin(ctx, pin, aref.Ref))
+		rc, _, err := s.FetchBlob(ctx, ref)
+		if sz != evil.Size {
+			require.Error(t, err)
+			_, err = s.StatBlob(ctx, ref)
+			require.Error(t, err)
+		} else {
+			require.NoError(t, err)
+			_, err = ioutil.ReadAll(rc)
+			rc.Close()
+			require.Error(t, err)
+		}
+		require.NoError(t, s.st.DeletePin(ctx, pin))
+	}
+
+	// valid aliases are indexed
+	sr, err := s.StoreBlob(ctx, strings.NewReader("hello\n"), nil)
+	require.NoError(t, err)
+	_, err = s.StoreSchema(ctx, &schema.Alias{Ref: sr, Refs: []types.Ref{ref}})
+	require.NoError(t, err)
+	require.NoError(t, s.ReindexSchema(ctx, false))
+	sz, err := s.StatBlob(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, sr.Size, sz)
+
+	// index pins are not listed
+	it := s.IteratePins(ctx)
+	defer it.Close()
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+}
+
+func TestResolveRef(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	// store blobs until two of them share a prefix
+	byPrefix := make(map[string]SizedRef)
+	var a, b SizedRef
+	for i := 0; a.Ref.Zero(); i++ {
+		sr, err := s.StoreBlob(ctx, strings.NewReader(strconv.Itoa(i)), nil)
+		require.NoError(t, err)
+		pref := sr.Ref.Short(types.MinPrefixLen)
+		if o, ok := byPrefix[pref]; ok {
+			a, b = o, sr
+		}
+		byPrefix[pref] = sr
+	}
+
+	ref, err := s.ResolveRef(ctx, a.Ref.String())
+	require.NoError(t, err)
+	require.Equal(t, a.Ref, ref)
+
+	ref, err = s.GetPinOrRef(ctx, a.Ref.Base32())
+	require.NoError(t, err)
+	require.Equal(t, a.Ref, ref)
+
+	_, err = s.ResolveRef(ctx, a.Ref.Short(types.MinPrefixLen))
+	require.IsType(t, storage.ErrAmbiguousRef{}, err)
+	require.ElementsMatch(t, []types.Ref{a.Ref, b.Ref}, err.(storage.ErrAmbiguousRef).Refs)
+
+	// find the shortest unambiguous prefix
+	n := types.MinPrefixLen + 1
+	for a.Ref.Short(n) == b.Ref.Short(n) {
+		n++
+	}
+	ref, err = s.GetPinOrRef(ctx, a.Ref.Short(n))
+	require.NoError(t, err)
+	require.Equal(t, a.Ref, ref)
+
+	_, err = s.ResolveRef(ctx, "sha512:0000")
+	require.Equal(t, storage.ErrNotFound, err)
+}
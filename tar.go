@@ -0,0 +1,262 @@
+package cas
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+)
+
+// treeNode is an in-memory representation of a directory tree that is being built.
+// Only the metadata is kept in memory, file content is stored as blobs right away.
+type treeNode struct {
+	ent schema.DirEntry
+	sub map[string]*treeNode // nil for non-directory nodes
+}
+
+func newTreeDir() *treeNode {
+	return &treeNode{
+		ent: schema.DirEntry{Mode: schema.ModeDir | 0755},
+		sub: make(map[string]*treeNode),
+	}
+}
+
+// cleanTreePath normalizes a slash-separated path and makes sure it cannot escape the root.
+// It returns an empty string for the root itself.
+func cleanTreePath(p string) string {
+	p = path.Clean("/" + p)
+	return strings.TrimPrefix(p, "/")
+}
+
+// lookup finds a node by a clean slash-separated path.
+func (t *treeNode) lookup(p string) *treeNode {
+	cur := t
+	if p == "" {
+		return cur
+	}
+	for _, name := range strings.Split(p, "/") {
+		if cur.sub == nil {
+			return nil
+		}
+		cur = cur.sub[name]
+		if cur == nil {
+			return nil
+		}
+	}
+	return cur
+}
+
+// mkdir returns a directory node for a given path, creating all missing directories.
+// Non-directory nodes on the path are replaced.
+func (t *treeNode) mkdir(p string) *treeNode {
+	cur := t
+	if p == "" {
+		return cur
+	}
+	for _, name := range strings.Split(p, "/") {
+		next := cur.sub[name]
+		if next == nil || next.sub == nil {
+			next = newTreeDir()
+			cur.sub[name] = next
+		}
+		cur = next
+	}
+	return cur
+}
+
+// put adds a node to the tree, replacing an existing one. Parent directories are created if necessary.
+func (t *treeNode) put(p string, n *treeNode) {
+	dir, name := path.Split(p)
+	t.mkdir(strings.TrimSuffix(dir, "/")).sub[name] = n
+}
+
+// remove deletes a node from the tree.
+func (t *treeNode) remove(p string) {
+	dir, name := path.Split(p)
+	if d := t.lookup(strings.TrimSuffix(dir, "/")); d != nil && d.sub != nil {
+		delete(d.sub, name)
+	}
+}
+
+// storeTree stores all directories in the tree and returns a ref of the root directory.
+func (s *Storage) storeTree(ctx context.Context, n *treeNode) (SizedRef, Stats, error) {
+	list := make([]schema.DirEntry, 0, len(n.sub))
+	for name, c := range n.sub {
+		ent := c.ent
+		ent.Name = name
+		if c.sub != nil {
+			sr, st, err := s.storeTree(ctx, c)
+			if err != nil {
+				return SizedRef{}, nil, err
+			}
+			ent.Ref, ent.Stats = sr.Ref, st
+		}
+		list = append(list, ent)
+	}
+	return s.storeDirEntries(ctx, list)
+}
+
+func tarModTime(hdr *tar.Header) *time.Time {
+	// zero time is written as Unix epoch on export
+	if hdr.ModTime.IsZero() || hdr.ModTime.Unix() == 0 {
+		return nil
+	}
+	t := hdr.ModTime.UTC()
+	return &t
+}
+
+// addTarEntry adds a single tar entry to the tree. Content of regular files is stored as blobs.
+func (s *Storage) addTarEntry(ctx context.Context, root *treeNode, name string, hdr *tar.Header, r io.Reader, conf *StoreConfig) error {
+	perm := uint32(hdr.Mode) & schema.ModePerm
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		d := root.mkdir(name)
+		d.ent.Mode = schema.ModeDir | perm
+		d.ent.ModTime = tarModTime(hdr)
+	case tar.TypeReg, tar.TypeRegA:
+		c := *conf
+		c.Expect = SizedRef{}
+		sr, err := s.StoreBlob(ctx, r, &c)
+		if err != nil {
+			return fmt.Errorf("cannot store %q: %v", hdr.Name, err)
+		}
+		if sr.Ref.Zero() {
+			sr.Ref = emptyRef(ctx)
+		}
+		root.put(name, &treeNode{ent: schema.DirEntry{
+			Ref:     sr.Ref,
+			Mode:    schema.ModeRegular | perm,
+			ModTime: tarModTime(hdr),
+			Stats:   Stats{schema.StatDataSize: sr.Size},
+		}})
+	case tar.TypeSymlink:
+		root.put(name, &treeNode{ent: schema.DirEntry{
+			Mode:    schema.ModeSymlink | perm,
+			ModTime: tarModTime(hdr),
+			Link:    hdr.Linkname,
+		}})
+	case tar.TypeLink:
+		targ := root.lookup(cleanTreePath(hdr.Linkname))
+		if targ == nil || targ.sub != nil {
+			return fmt.Errorf("hard link %q points to a missing file %q", hdr.Name, hdr.Linkname)
+		}
+		root.put(name, &treeNode{ent: targ.ent})
+	default:
+		// devices, fifos, etc. cannot be represented in the schema
+	}
+	return nil
+}
+
+// ImportTar stores the content of a tar stream as a directory tree without extracting it to disk.
+// It returns a ref of the root directory.
+func (s *Storage) ImportTar(ctx context.Context, r io.Reader, conf *StoreConfig) (SizedRef, error) {
+	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	root := newTreeDir()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return SizedRef{}, err
+		}
+		name := cleanTreePath(hdr.Name)
+		if name == "" {
+			continue
+		}
+		if err = s.addTarEntry(ctx, root, name, hdr, tr, conf); err != nil {
+			return SizedRef{}, err
+		}
+	}
+	sr, _, err := s.storeTree(ctx, root)
+	return sr, err
+}
+
+// ExportTar writes a directory tree with a given ref as a tar stream. The ref may point to a commit or an annotated pin.
+func (s *Storage) ExportTar(ctx context.Context, ref Ref, w io.Writer) error {
+	if err := s.checkSigned(ctx, "export", ref); err != nil {
+		return err
+	}
+	_, obj, err := s.unwrap(ctx, ref)
+	if err != nil {
+		return err
+	} else if obj == nil {
+		return storage.ErrNotDir
+	}
+	tw := tar.NewWriter(w)
+	if err = s.exportTarDir(ctx, tw, obj, ""); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func (s *Storage) exportTarDir(ctx context.Context, tw *tar.Writer, obj schema.Object, dir string) error {
+	return storage.ReadDir(ctx, s.index, obj, func(ent *schema.DirEntry) error {
+		hdr := &tar.Header{
+			Name: dir + ent.Name,
+			Mode: int64(ent.Mode & schema.ModePerm),
+		}
+		if ent.ModTime != nil {
+			hdr.ModTime = *ent.ModTime
+		} else {
+			hdr.ModTime = time.Unix(0, 0)
+		}
+		if ent.IsSymlink() {
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = ent.Link
+			if hdr.Mode == 0 {
+				hdr.Mode = 0777
+			}
+			return tw.WriteHeader(hdr)
+		}
+		if ent.Ref.Zero() || ent.Ref.Empty() {
+			hdr.Typeflag = tar.TypeReg
+			if hdr.Mode == 0 {
+				hdr.Mode = 0644
+			}
+			return tw.WriteHeader(hdr)
+		}
+		_, sub, err := s.unwrap(ctx, ent.Ref)
+		if err != nil {
+			return err
+		} else if storage.IsDirObject(sub) {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			if hdr.Mode == 0 {
+				hdr.Mode = 0755
+			}
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			return s.exportTarDir(ctx, tw, sub, hdr.Name)
+		}
+		rc, sr, err := s.openContent(ctx, ent.Ref)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(sr.Size)
+		if sr.Size == 0 {
+			hdr.Size = int64(ent.Size())
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, rc, hdr.Size)
+		return err
+	})
+}
@@ -0,0 +1,120 @@
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+func newTestStorage(t testing.TB) *Storage {
+	s, err := New(storage.NewInMemory())
+	require.NoError(t, err)
+	return s
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	files := []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{hdr: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0750}},
+		{hdr: tar.Header{Name: "dir/a.txt", Typeflag: tar.TypeReg, Mode: 0644}, data: "file a"},
+		{hdr: tar.Header{Name: "dir/sub/b.bin", Typeflag: tar.TypeReg, Mode: 0755}, data: "file b"},
+		{hdr: tar.Header{Name: "empty", Typeflag: tar.TypeReg, Mode: 0600}},
+		{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/a.txt", Mode: 0777}},
+		{hdr: tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "dir/a.txt"}},
+	}
+	for _, f := range files {
+		hdr := f.hdr
+		hdr.ModTime = mtime
+		hdr.Size = int64(len(f.data))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(f.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	sr1, err := s.ImportTar(ctx, buf, nil)
+	require.NoError(t, err)
+
+	out1 := new(bytes.Buffer)
+	err = s.ExportTar(ctx, sr1.Ref, out1)
+	require.NoError(t, err)
+
+	exp := map[string]string{
+		"dir/":          "",
+		"dir/a.txt":     "file a",
+		"dir/sub/":      "",
+		"dir/sub/b.bin": "file b",
+		"empty":         "",
+		"hard":          "file a",
+		"link":          "-> dir/a.txt",
+	}
+	got := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(out1.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeSymlink {
+			got[hdr.Name] = "-> " + hdr.Linkname
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		got[hdr.Name] = string(data)
+		if hdr.Name == "dir/" {
+			require.Equal(t, int64(0750), hdr.Mode)
+			require.True(t, mtime.Equal(hdr.ModTime))
+		}
+	}
+	require.Equal(t, exp, got)
+
+	// importing an exported archive must result in the same tree and the same archive
+	sr2, err := s.ImportTar(ctx, bytes.NewReader(out1.Bytes()), nil)
+	require.NoError(t, err)
+	require.Equal(t, sr1.Ref, sr2.Ref)
+
+	out2 := new(bytes.Buffer)
+	err = s.ExportTar(ctx, sr2.Ref, out2)
+	require.NoError(t, err)
+	require.Equal(t, out1.Bytes(), out2.Bytes())
+}
+
+func TestTarEmptyFileHash(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "empty", Typeflag: tar.TypeReg, Mode: 0644}))
+	require.NoError(t, tw.Close())
+
+	sr, err := s.ImportTar(ctx, buf, &StoreConfig{Hash: "blake3"})
+	require.NoError(t, err)
+	var refs []types.Ref
+	err = s.readDir(ctx, sr.Ref, func(ent *schema.DirEntry) error {
+		refs = append(refs, ent.Ref)
+		return nil
+	})
+	require.NoError(t, err)
+	// empty files are referenced with the hash used for the import
+	require.Equal(t, []types.Ref{types.GetHash("blake3").Empty()}, refs)
+}
@@ -0,0 +1,223 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/torrent"
+)
+
+// TorrentConfig is a configuration for BitTorrent metainfo created from stored content.
+type TorrentConfig struct {
+	// Name of the torrent. It's required for directories; files are named after the ref by default.
+	Name string
+	// PieceLength is a power of two, at least 16 KiB. Zero picks a length based on the content size.
+	PieceLength int64
+	// V1 and V2 select versions of piece hashes. Hybrid metainfo is created if both or neither are set.
+	V1, V2 bool
+	// Announce is a list of tracker URLs.
+	Announce []string
+}
+
+type torrentFile struct {
+	path []string
+	ref  Ref
+	size uint64
+	exec bool
+}
+
+// torrentFiles lists regular files of a directory tree. Symbolic links are skipped.
+func (s *Storage) torrentFiles(ctx context.Context, obj schema.Object, dir []string, out []torrentFile) ([]torrentFile, error) {
+	err := storage.ReadDir(ctx, s.index, obj, func(ent *schema.DirEntry) error {
+		if ent.IsSymlink() {
+			return nil
+		}
+		path := append(dir[:len(dir):len(dir)], ent.Name)
+		f := torrentFile{path: path, ref: ent.Ref, size: ent.Size(), exec: ent.Mode&0111 != 0}
+		if ent.Ref.Zero() || ent.Ref.Empty() {
+			f.size = 0
+			out = append(out, f)
+			return nil
+		}
+		_, sub, err := s.unwrap(ctx, ent.Ref)
+		if err != nil {
+			return err
+		} else if storage.IsDirObject(sub) {
+			out, err = s.torrentFiles(ctx, sub, path, out)
+			return err
+		}
+		out = append(out, f)
+		return nil
+	})
+	return out, err
+}
+
+// CreateTorrent creates BitTorrent metainfo for a stored directory tree or a file.
+// Piece hashes are computed by streaming the content from the storage. Symbolic links are not included.
+func (s *Storage) CreateTorrent(ctx context.Context, ref Ref, conf *TorrentConfig) (*torrent.MetaInfo, error) {
+	if err := s.checkSigned(ctx, "export", ref); err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		conf = &TorrentConfig{}
+	}
+	name := conf.Name
+	var files []torrentFile
+	ref, obj, err := s.unwrap(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	isDir := storage.IsDirObject(obj)
+	if isDir {
+		if name == "" {
+			return nil, fmt.Errorf("torrent name is required for directories")
+		}
+		files, err = s.torrentFiles(ctx, obj, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		// keep v1 and v2 file lists in the same order
+		sort.Slice(files, func(i, j int) bool {
+			return strings.Join(files[i].path, "\x00") < strings.Join(files[j].path, "\x00")
+		})
+	} else {
+		if name == "" {
+			name = ref.String()
+		}
+		files = []torrentFile{{path: []string{name}, ref: ref}}
+	}
+	v1, v2 := conf.V1, conf.V2
+	if !v1 && !v2 {
+		v1, v2 = true, true
+	}
+	pieceLen := conf.PieceLength
+	if pieceLen == 0 {
+		var total uint64
+		for _, f := range files {
+			total += f.size
+		}
+		pieceLen = torrent.PieceLength(int64(total))
+	}
+	b, err := torrent.NewBuilder(pieceLen, v1, v2)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		attr := ""
+		if f.exec {
+			attr = string(torrent.AttrExecutable)
+		}
+		if err = b.AddFile(f.path, attr); err != nil {
+			return nil, err
+		}
+		if f.ref.Zero() || f.ref.Empty() {
+			continue
+		}
+		rc, _, err := s.openContent(ctx, f.ref)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(b, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	m, err := b.MetaInfo(name, isDir)
+	if err != nil {
+		return nil, err
+	}
+	m.Announce = conf.Announce
+	return m, nil
+}
+
+// ImportTorrent verifies local data against piece hashes of the metainfo and stores it as a directory tree.
+// The directory must contain the file or the directory named after the torrent, the same way BitTorrent clients save it.
+// The metainfo is stored as well, and both are linked with a schema.Torrent object. Pad files and symbolic links are not stored.
+func (s *Storage) ImportTorrent(ctx context.Context, data []byte, dir string, conf *StoreConfig) (SizedRef, *schema.Torrent, error) {
+	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	m, err := torrent.Parse(data)
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	root := newTreeDir()
+	c := m.NewChecker()
+	for {
+		f, err := c.Next()
+		if err != nil {
+			return SizedRef{}, nil, err
+		} else if f == nil {
+			break
+		}
+		p := filepath.Join(dir, filepath.Join(f.Path...))
+		if m.Dir {
+			p = filepath.Join(dir, m.Name, filepath.Join(f.Path...))
+		}
+		sr, err := s.storeTorrentFile(ctx, p, c, conf)
+		if err != nil {
+			return SizedRef{}, nil, err
+		}
+		perm := uint32(0644)
+		if f.IsExecutable() {
+			perm = 0755
+		}
+		root.put(strings.Join(f.Path, "/"), &treeNode{ent: schema.DirEntry{
+			Ref:   sr.Ref,
+			Mode:  schema.ModeRegular | perm,
+			Stats: Stats{schema.StatDataSize: sr.Size},
+		}})
+	}
+	tree, _, err := s.storeTree(ctx, root)
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	c2 := *conf
+	c2.Expect = SizedRef{}
+	meta, err := s.StoreBlob(ctx, bytes.NewReader(data), &c2)
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	t := &schema.Torrent{
+		Name:       m.Name,
+		InfoHash:   m.InfoHash(),
+		InfoHashV2: m.InfoHashV2(),
+		Meta:       meta,
+		Root:       tree,
+	}
+	sr, err := s.StoreSchema(ctx, t)
+	if err != nil {
+		return SizedRef{}, nil, err
+	}
+	return sr, t, nil
+}
+
+// storeTorrentFile stores a local file while passing its content to the checker.
+func (s *Storage) storeTorrentFile(ctx context.Context, path string, w io.Writer, conf *StoreConfig) (SizedRef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	defer f.Close()
+	c := *conf
+	c.Expect = SizedRef{}
+	sr, err := s.StoreBlob(ctx, io.TeeReader(f, w), &c)
+	if err != nil {
+		return SizedRef{}, fmt.Errorf("cannot store %q: %v", path, err)
+	}
+	if sr.Ref.Zero() {
+		sr.Ref = emptyRef(ctx)
+	}
+	return sr, nil
+}
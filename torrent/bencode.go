@@ -0,0 +1,195 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Bencoded values are represented as int64, string, list and dict.
+type (
+	list []interface{}
+	dict map[string]interface{}
+	// raw is a value that is bencoded already.
+	raw []byte
+)
+
+var errSyntax = errors.New("torrent: invalid bencoding")
+
+// encode appends a bencoded value to the buffer. Keys of dictionaries are sorted, as required by the format.
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case int:
+		return encode(buf, int64(v))
+	case int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v, 10))
+		buf.WriteByte('e')
+	case string:
+		buf.WriteString(strconv.Itoa(len(v)))
+		buf.WriteByte(':')
+		buf.WriteString(v)
+	case []byte:
+		return encode(buf, string(v))
+	case raw:
+		buf.Write(v)
+	case []string:
+		l := make(list, 0, len(v))
+		for _, s := range v {
+			l = append(l, s)
+		}
+		return encode(buf, l)
+	case list:
+		buf.WriteByte('l')
+		for _, e := range v {
+			if err := encode(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case dict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			encode(buf, k)
+			if err := encode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("torrent: cannot encode %T", v)
+	}
+	return nil
+}
+
+// decoder parses bencoded values.
+type decoder struct {
+	data []byte
+	off  int
+}
+
+// decode parses a single value that spans the whole data.
+func decode(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	} else if d.off != len(data) {
+		return nil, errSyntax
+	}
+	return v, nil
+}
+
+// skip parses the next value and returns its raw encoding.
+func (d *decoder) skip() ([]byte, error) {
+	start := d.off
+	if _, err := d.value(0); err != nil {
+		return nil, err
+	}
+	return d.data[start:d.off], nil
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if depth > 64 {
+		return nil, errors.New("torrent: bencoded value is nested too deep")
+	} else if d.off >= len(d.data) {
+		return nil, errSyntax
+	}
+	switch c := d.data[d.off]; {
+	case c == 'i':
+		end := bytes.IndexByte(d.data[d.off:], 'e')
+		if end < 0 {
+			return nil, errSyntax
+		}
+		v, err := strconv.ParseInt(string(d.data[d.off+1:d.off+end]), 10, 64)
+		if err != nil {
+			return nil, errSyntax
+		}
+		d.off += end + 1
+		return v, nil
+	case c >= '0' && c <= '9':
+		return d.str()
+	case c == 'l':
+		d.off++
+		var out list
+		for d.off < len(d.data) && d.data[d.off] != 'e' {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		if d.off >= len(d.data) {
+			return nil, errSyntax
+		}
+		d.off++
+		return out, nil
+	case c == 'd':
+		d.off++
+		out := make(dict)
+		for d.off < len(d.data) && d.data[d.off] != 'e' {
+			k, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = v
+		}
+		if d.off >= len(d.data) {
+			return nil, errSyntax
+		}
+		d.off++
+		return out, nil
+	default:
+		return nil, errSyntax
+	}
+}
+
+func (d *decoder) str() (string, error) {
+	i := bytes.IndexByte(d.data[d.off:], ':')
+	if i < 0 {
+		return "", errSyntax
+	}
+	n, err := strconv.Atoi(string(d.data[d.off : d.off+i]))
+	if err != nil || n < 0 || n > len(d.data)-(d.off+i+1) {
+		return "", errSyntax
+	}
+	start := d.off + i + 1
+	d.off = start + n
+	return string(d.data[start:d.off]), nil
+}
+
+// rawDict splits a bencoded dictionary into raw values of its keys.
+// It's used to hash the info dictionary exactly as it was encoded.
+func rawDict(data []byte) (map[string][]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, errSyntax
+	}
+	d := &decoder{data: data, off: 1}
+	out := make(map[string][]byte)
+	for d.off < len(data) && data[d.off] != 'e' {
+		k, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.skip()
+		if err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	if d.off != len(data)-1 {
+		return nil, errSyntax
+	}
+	return out, nil
+}
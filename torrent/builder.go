@@ -0,0 +1,351 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Builder computes piece hashes of files and creates the metainfo.
+// File content is written to the builder after each call to AddFile.
+type Builder struct {
+	pieceLen int64
+	v1, v2   bool
+	pad      bool // align files to pieces with pad files in hybrid torrents
+
+	files  []File
+	open   bool     // the last file is being written
+	last   []string // path of the last non-pad file
+	pieces []byte
+
+	// v1 state
+	h1 hash.Hash // current piece
+	n1 int64     // bytes in the current piece
+	// v2 state of the current file
+	h2     hash.Hash // current block
+	n2     int       // bytes in the current block
+	blocks [][sha256.Size]byte
+	layer  [][sha256.Size]byte
+}
+
+// NewBuilder creates a builder for v1, v2 or hybrid metainfo.
+// Piece length must be a power of two and no less than BlockSize.
+func NewBuilder(pieceLen int64, v1, v2 bool) (*Builder, error) {
+	if !v1 && !v2 {
+		return nil, errors.New("torrent: either v1 or v2 hashes must be enabled")
+	} else if pieceLen < BlockSize || pieceLen&(pieceLen-1) != 0 {
+		return nil, fmt.Errorf("torrent: piece length must be a power of two and at least %d", BlockSize)
+	}
+	return newBuilder(pieceLen, v1, v2), nil
+}
+
+func newBuilder(pieceLen int64, v1, v2 bool) *Builder {
+	return &Builder{
+		pieceLen: pieceLen, v1: v1, v2: v2, pad: true,
+		h1: sha1.New(), h2: sha256.New(),
+	}
+}
+
+// PieceLength picks a piece length for the content of a given size.
+func PieceLength(size int64) int64 {
+	const (
+		targetPieces = 1500
+		maxPieceLen  = 16 << 20
+	)
+	n := int64(BlockSize)
+	for n < maxPieceLen && size/n > targetPieces {
+		n *= 2
+	}
+	return n
+}
+
+func comparePaths(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// AddFile starts a new file. Files with v2 hashes must be added in the order of their paths.
+func (b *Builder) AddFile(path []string, attr string) error {
+	if err := checkPath(path); err != nil {
+		return err
+	}
+	b.closeFile()
+	f := File{Path: path, Attr: attr}
+	if !f.IsPad() {
+		if b.v1 && b.v2 && b.pad && b.n1 != 0 {
+			n := b.pieceLen - b.n1
+			b.files = append(b.files, File{Path: []string{".pad", strconv.FormatInt(n, 10)}, Attr: string(AttrPad)})
+			b.open = true
+			b.writeZeros(n)
+			b.closeFile()
+		}
+		if b.v2 && b.last != nil && comparePaths(b.last, path) >= 0 {
+			return fmt.Errorf("torrent: files are not sorted: %q", path)
+		}
+		b.last = path
+	}
+	b.files = append(b.files, f)
+	b.open = true
+	return nil
+}
+
+func (b *Builder) writeZeros(n int64) {
+	var buf [BlockSize]byte
+	for n > 0 {
+		m := int64(len(buf))
+		if m > n {
+			m = n
+		}
+		b.Write(buf[:m])
+		n -= m
+	}
+}
+
+// Write adds the content of the current file.
+func (b *Builder) Write(p []byte) (int, error) {
+	if !b.open {
+		return 0, errors.New("torrent: no file to write")
+	}
+	f := &b.files[len(b.files)-1]
+	f.Length += int64(len(p))
+	if b.v1 {
+		for data := p; len(data) != 0; {
+			n := b.pieceLen - b.n1
+			if n > int64(len(data)) {
+				n = int64(len(data))
+			}
+			b.h1.Write(data[:n])
+			b.n1 += n
+			data = data[n:]
+			if b.n1 == b.pieceLen {
+				b.flushPiece()
+			}
+		}
+	}
+	if b.v2 && !f.IsPad() {
+		for data := p; len(data) != 0; {
+			if b.n2 == 0 && len(b.blocks) == int(b.pieceLen/BlockSize) {
+				// more data after a full piece; the file will have a piece layer
+				b.layer = append(b.layer, merkleRoot(b.blocks, len(b.blocks), [sha256.Size]byte{}))
+				b.blocks = b.blocks[:0]
+			}
+			n := BlockSize - b.n2
+			if n > len(data) {
+				n = len(data)
+			}
+			b.h2.Write(data[:n])
+			b.n2 += n
+			data = data[n:]
+			if b.n2 == BlockSize {
+				b.flushBlock()
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (b *Builder) flushPiece() {
+	b.pieces = b.h1.Sum(b.pieces)
+	b.h1.Reset()
+	b.n1 = 0
+}
+
+func (b *Builder) flushBlock() {
+	var h [sha256.Size]byte
+	b.h2.Sum(h[:0])
+	b.h2.Reset()
+	b.n2 = 0
+	b.blocks = append(b.blocks, h)
+}
+
+// closeFile computes v2 hashes of the current file.
+func (b *Builder) closeFile() {
+	if !b.open {
+		return
+	}
+	b.open = false
+	f := &b.files[len(b.files)-1]
+	if !b.v2 || f.IsPad() {
+		return
+	}
+	if b.n2 != 0 {
+		b.flushBlock()
+	}
+	var zero [sha256.Size]byte
+	switch {
+	case f.Length == 0:
+	case len(b.layer) == 0:
+		f.Root = merkleRoot(b.blocks, nextPow2(len(b.blocks)), zero)
+	default:
+		perPiece := int(b.pieceLen / BlockSize)
+		if len(b.blocks) != 0 {
+			b.layer = append(b.layer, merkleRoot(b.blocks, perPiece, zero))
+		}
+		f.Root = merkleRoot(b.layer, nextPow2(len(b.layer)), merkleRoot(nil, perPiece, zero))
+		f.Layer = make([]byte, 0, len(b.layer)*sha256.Size)
+		for _, h := range b.layer {
+			f.Layer = append(f.Layer, h[:]...)
+		}
+	}
+	b.blocks = b.blocks[:0]
+	b.layer = b.layer[:0]
+}
+
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+// merkleRoot computes a root of a merkle tree with a given number of leaves, which must be a power of two.
+// Leaves that are not in the list are set to pad.
+func merkleRoot(leaves [][sha256.Size]byte, width int, pad [sha256.Size]byte) [sha256.Size]byte {
+	layer := leaves
+	for ; width > 1; width /= 2 {
+		next := make([][sha256.Size]byte, 0, (len(layer)+1)/2)
+		for i := 0; i < len(layer); i += 2 {
+			r := pad
+			if i+1 < len(layer) {
+				r = layer[i+1]
+			}
+			next = append(next, hashPair(layer[i], r))
+		}
+		pad = hashPair(pad, pad)
+		layer = next
+	}
+	if len(layer) == 0 {
+		return pad
+	}
+	return layer[0]
+}
+
+func hashPair(l, r [sha256.Size]byte) [sha256.Size]byte {
+	var buf [2 * sha256.Size]byte
+	copy(buf[:], l[:])
+	copy(buf[sha256.Size:], r[:])
+	return sha256.Sum256(buf[:])
+}
+
+// MetaInfo finishes the last file and returns the metainfo. Single-file torrents must have one file named after the torrent.
+func (b *Builder) MetaInfo(name string, dir bool) (*MetaInfo, error) {
+	b.closeFile()
+	if b.n1 != 0 {
+		b.flushPiece()
+	}
+	if !dir && (len(b.files) != 1 || len(b.files[0].Path) != 1 || b.files[0].Path[0] != name) {
+		return nil, errors.New("torrent: single-file torrent must have one file with the torrent name")
+	}
+	if err := checkPath([]string{name}); err != nil {
+		return nil, err
+	}
+	m := &MetaInfo{
+		Name: name, PieceLength: b.pieceLen, Dir: dir,
+		V1: b.v1, V2: b.v2,
+		Files: b.files, Pieces: b.pieces,
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, m.infoDict()); err != nil {
+		return nil, err
+	}
+	m.info = buf.Bytes()
+	return m, nil
+}
+
+// Checker verifies file content against hashes of the metainfo.
+// Content of each file returned by Next must be written to the checker.
+type Checker struct {
+	m      *MetaInfo
+	b      *Builder
+	i      int   // next file in m.Files
+	cur    *File // file that is being written
+	pieces int   // number of v1 pieces that were verified
+}
+
+// NewChecker creates a checker for the metainfo.
+func (m *MetaInfo) NewChecker() *Checker {
+	b := newBuilder(m.PieceLength, m.V1, m.V2)
+	// pad files are listed in the metainfo
+	b.pad = false
+	return &Checker{m: m, b: b}
+}
+
+func (c *Checker) Write(p []byte) (int, error) {
+	if c.cur == nil {
+		return 0, errors.New("torrent: no file to check")
+	}
+	return c.b.Write(p)
+}
+
+// Next verifies the file that was written and returns the next file to check.
+// Pad files and symbolic links are skipped. It returns nil when all files were verified.
+func (c *Checker) Next() (*File, error) {
+	if c.cur != nil {
+		if err := c.checkFile(); err != nil {
+			return nil, err
+		}
+		c.cur = nil
+	}
+	for c.i < len(c.m.Files) {
+		f := &c.m.Files[c.i]
+		c.i++
+		if f.IsSymlink() {
+			continue
+		}
+		if err := c.b.AddFile(f.Path, f.Attr); err != nil {
+			return nil, err
+		}
+		if f.IsPad() {
+			c.b.writeZeros(f.Length)
+			continue
+		}
+		c.cur = f
+		return f, nil
+	}
+	c.b.closeFile()
+	if c.b.n1 != 0 {
+		c.b.flushPiece()
+	}
+	if err := c.checkPieces([]string{c.m.Name}); err != nil {
+		return nil, err
+	} else if len(c.b.pieces) != len(c.m.Pieces) {
+		return nil, errors.New("torrent: unexpected number of pieces")
+	}
+	return nil, nil
+}
+
+func (c *Checker) checkFile() error {
+	f := c.cur
+	c.b.closeFile()
+	got := &c.b.files[len(c.b.files)-1]
+	if got.Length != f.Length {
+		return fmt.Errorf("torrent: %s: expected %d bytes, got %d", strings.Join(f.Path, "/"), f.Length, got.Length)
+	} else if c.m.V2 && got.Root != f.Root {
+		return fmt.Errorf("torrent: %s: content does not match", strings.Join(f.Path, "/"))
+	}
+	return c.checkPieces(f.Path)
+}
+
+// checkPieces verifies v1 pieces that were completed.
+func (c *Checker) checkPieces(path []string) error {
+	if !c.m.V1 {
+		return nil
+	}
+	for ; c.pieces*sha1.Size < len(c.b.pieces); c.pieces++ {
+		i := c.pieces * sha1.Size
+		if i+sha1.Size > len(c.m.Pieces) || !bytes.Equal(c.b.pieces[i:i+sha1.Size], c.m.Pieces[i:i+sha1.Size]) {
+			return fmt.Errorf("torrent: %s: piece %d does not match", strings.Join(path, "/"), c.pieces)
+		}
+	}
+	return nil
+}
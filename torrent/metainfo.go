@@ -0,0 +1,444 @@
+// Package torrent creates and verifies BitTorrent metainfo (.torrent) files.
+//
+// Both v1 (BEP 3) and v2 (BEP 52) metainfo is supported, including hybrid torrents that have both.
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// BlockSize is the size of leaf blocks of v2 merkle trees.
+const BlockSize = 16 << 10
+
+// File attributes, see BEP 47.
+const (
+	AttrPad        = 'p'
+	AttrExecutable = 'x'
+	AttrSymlink    = 'l'
+)
+
+// File is a file described by the metainfo.
+type File struct {
+	// Path is relative to the torrent directory. Single-file torrents have a single path element equal to the torrent name.
+	Path   []string
+	Length int64
+	Attr   string
+	// Root is a root of the v2 merkle tree of the file. It's zero for empty files and v1 torrents.
+	Root [sha256.Size]byte
+	// Layer is a list of v2 piece hashes. It's only set for files larger than a piece.
+	Layer []byte
+}
+
+func (f *File) hasAttr(c byte) bool {
+	return strings.IndexByte(f.Attr, c) >= 0
+}
+
+// IsPad checks if the file only exists to align the next file to a piece boundary.
+func (f *File) IsPad() bool {
+	return f.hasAttr(AttrPad)
+}
+
+// IsExecutable checks if the file has an executable attribute.
+func (f *File) IsExecutable() bool {
+	return f.hasAttr(AttrExecutable)
+}
+
+// IsSymlink checks if the file is a symbolic link.
+func (f *File) IsSymlink() bool {
+	return f.hasAttr(AttrSymlink)
+}
+
+// MetaInfo is a content of a .torrent file.
+type MetaInfo struct {
+	Name        string
+	PieceLength int64
+	// Dir is set for multi-file torrents. Their files are stored in a directory with the torrent name.
+	Dir bool
+	// V1 and V2 are set if the metainfo has hashes of a given version. Hybrid torrents have both.
+	V1, V2 bool
+	// Files are listed in the order of the v1 data stream, including pad files.
+	Files []File
+	// Pieces are concatenated SHA-1 hashes of v1 pieces.
+	Pieces []byte
+	// Announce is a list of tracker URLs.
+	Announce []string
+
+	info []byte // bencoded info dictionary
+}
+
+// InfoHash returns a hex-encoded v1 infohash, or an empty string if there are no v1 hashes.
+func (m *MetaInfo) InfoHash() string {
+	if !m.V1 {
+		return ""
+	}
+	h := sha1.Sum(m.info)
+	return hex.EncodeToString(h[:])
+}
+
+// InfoHashV2 returns a hex-encoded v2 infohash, or an empty string if there are no v2 hashes.
+func (m *MetaInfo) InfoHashV2() string {
+	if !m.V2 {
+		return ""
+	}
+	h := sha256.Sum256(m.info)
+	return hex.EncodeToString(h[:])
+}
+
+// Size returns the size of the content, excluding pad files.
+func (m *MetaInfo) Size() int64 {
+	var n int64
+	for _, f := range m.Files {
+		if !f.IsPad() {
+			n += f.Length
+		}
+	}
+	return n
+}
+
+func fileDict(f *File) dict {
+	d := dict{"length": f.Length}
+	if f.Attr != "" {
+		d["attr"] = f.Attr
+	}
+	return d
+}
+
+// infoDict returns the info dictionary, which is hashed to get the infohash.
+func (m *MetaInfo) infoDict() dict {
+	info := dict{
+		"name":         m.Name,
+		"piece length": m.PieceLength,
+	}
+	if m.V1 {
+		info["pieces"] = m.Pieces
+		if m.Dir {
+			var files list
+			for i := range m.Files {
+				d := fileDict(&m.Files[i])
+				d["path"] = m.Files[i].Path
+				files = append(files, d)
+			}
+			info["files"] = files
+		} else if len(m.Files) == 1 {
+			info["length"] = m.Files[0].Length
+			if a := m.Files[0].Attr; a != "" {
+				info["attr"] = a
+			}
+		}
+	}
+	if m.V2 {
+		info["meta version"] = 2
+		tree := make(dict)
+		for i := range m.Files {
+			f := &m.Files[i]
+			if f.IsPad() {
+				continue
+			}
+			cur := tree
+			for _, name := range f.Path {
+				sub, ok := cur[name].(dict)
+				if !ok {
+					sub = make(dict)
+					cur[name] = sub
+				}
+				cur = sub
+			}
+			d := fileDict(f)
+			if f.Length != 0 {
+				d["pieces root"] = f.Root[:]
+			}
+			cur[""] = d
+		}
+		info["file tree"] = tree
+	}
+	return info
+}
+
+// Encode returns the content of the .torrent file.
+func (m *MetaInfo) Encode() ([]byte, error) {
+	if m.info == nil {
+		var buf bytes.Buffer
+		if err := encode(&buf, m.infoDict()); err != nil {
+			return nil, err
+		}
+		m.info = buf.Bytes()
+	}
+	top := dict{"info": raw(m.info)}
+	if len(m.Announce) != 0 {
+		top["announce"] = m.Announce[0]
+	}
+	if len(m.Announce) > 1 {
+		var tiers list
+		for _, u := range m.Announce {
+			tiers = append(tiers, list{u})
+		}
+		top["announce-list"] = tiers
+	}
+	if m.V2 {
+		layers := make(dict)
+		for _, f := range m.Files {
+			if len(f.Layer) != 0 {
+				layers[string(f.Root[:])] = f.Layer
+			}
+		}
+		top["piece layers"] = layers
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, top); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkPath checks that path elements cannot escape the torrent directory.
+func checkPath(path []string) error {
+	if len(path) == 0 {
+		return errors.New("torrent: empty file path")
+	}
+	for _, name := range path {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+			return fmt.Errorf("torrent: invalid file path: %q", path)
+		}
+	}
+	return nil
+}
+
+func parsePath(v interface{}) ([]string, error) {
+	l, ok := v.(list)
+	if !ok {
+		return nil, errors.New("torrent: invalid file path")
+	}
+	path := make([]string, 0, len(l))
+	for _, e := range l {
+		s, ok := e.(string)
+		if !ok {
+			return nil, errors.New("torrent: invalid file path")
+		}
+		path = append(path, s)
+	}
+	return path, checkPath(path)
+}
+
+func parseFile(d dict) (File, error) {
+	var f File
+	n, ok := d["length"].(int64)
+	if !ok || n < 0 {
+		return f, errors.New("torrent: invalid file length")
+	}
+	f.Length = n
+	f.Attr, _ = d["attr"].(string)
+	return f, nil
+}
+
+// walkFileTree calls fnc for each file of the v2 file tree, in order.
+func walkFileTree(tree dict, path []string, fnc func(path []string, d dict) error) error {
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sub, ok := tree[k].(dict)
+		if !ok {
+			return errors.New("torrent: invalid file tree")
+		}
+		p := append(path[:len(path):len(path)], k)
+		if err := checkPath(p); err != nil {
+			return err
+		}
+		if leaf, ok := sub[""].(dict); ok && len(sub) == 1 {
+			if err := fnc(p, leaf); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := walkFileTree(sub, p, fnc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse parses the content of a .torrent file.
+func Parse(data []byte) (*MetaInfo, error) {
+	top, err := rawDict(data)
+	if err != nil {
+		return nil, err
+	}
+	m := &MetaInfo{info: top["info"]}
+	v, err := decode(m.info)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := v.(dict)
+	if !ok {
+		return nil, errors.New("torrent: info is not a dictionary")
+	}
+	m.Name, _ = info["name"].(string)
+	if err = checkPath([]string{m.Name}); err != nil {
+		return nil, err
+	}
+	m.PieceLength, _ = info["piece length"].(int64)
+	if m.PieceLength <= 0 {
+		return nil, errors.New("torrent: invalid piece length")
+	}
+	if pieces, ok := info["pieces"].(string); ok {
+		if len(pieces)%sha1.Size != 0 {
+			return nil, errors.New("torrent: invalid pieces")
+		}
+		m.V1 = true
+		m.Pieces = []byte(pieces)
+		if files, ok := info["files"].(list); ok {
+			m.Dir = true
+			for _, e := range files {
+				d, ok := e.(dict)
+				if !ok {
+					return nil, errors.New("torrent: invalid file list")
+				}
+				f, err := parseFile(d)
+				if err != nil {
+					return nil, err
+				}
+				if f.Path, err = parsePath(d["path"]); err != nil {
+					return nil, err
+				}
+				m.Files = append(m.Files, f)
+			}
+		} else {
+			f, err := parseFile(info)
+			if err != nil {
+				return nil, err
+			}
+			f.Path = []string{m.Name}
+			m.Files = []File{f}
+		}
+	}
+	if ver, _ := info["meta version"].(int64); ver == 2 {
+		tree, ok := info["file tree"].(dict)
+		if !ok {
+			return nil, errors.New("torrent: invalid file tree")
+		}
+		m.V2 = true
+		layers := make(map[string]string)
+		if v, err := decode(top["piece layers"]); err == nil {
+			if d, ok := v.(dict); ok {
+				for k, l := range d {
+					layers[k], _ = l.(string)
+				}
+			}
+		}
+		var files []File
+		err = walkFileTree(tree, nil, func(path []string, d dict) error {
+			f, err := parseFile(d)
+			if err != nil {
+				return err
+			}
+			f.Path = path
+			if f.Length != 0 {
+				root, _ := d["pieces root"].(string)
+				if len(root) != sha256.Size {
+					return fmt.Errorf("torrent: invalid pieces root for %q", path)
+				}
+				copy(f.Root[:], root)
+				if l := layers[root]; l != "" {
+					f.Layer = []byte(l)
+				}
+			}
+			files = append(files, f)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if m.V1 {
+			err = mergeFiles(m.Files, files)
+		} else {
+			m.Files = files
+			m.Dir = !(len(files) == 1 && len(files[0].Path) == 1 && files[0].Path[0] == m.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if !m.V1 {
+		return nil, errors.New("torrent: no piece hashes in metainfo")
+	}
+	if m.V2 && (m.PieceLength < BlockSize || m.PieceLength&(m.PieceLength-1) != 0) {
+		return nil, errors.New("torrent: invalid piece length")
+	}
+	if v, err := decode(top["announce"]); err == nil {
+		if u, ok := v.(string); ok && u != "" {
+			m.Announce = append(m.Announce, u)
+		}
+	}
+	if v, err := decode(top["announce-list"]); err == nil {
+		tiers, _ := v.(list)
+		for _, t := range tiers {
+			urls, _ := t.(list)
+			for _, u := range urls {
+				if u, ok := u.(string); ok && u != "" && !containsString(m.Announce, u) {
+					m.Announce = append(m.Announce, u)
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// mergeFiles copies v2 hashes to the v1 file list of a hybrid torrent. Both lists must describe the same files.
+func mergeFiles(v1, v2 []File) error {
+	byPath := make(map[string]*File, len(v2))
+	for i := range v2 {
+		byPath[strings.Join(v2[i].Path, "/")] = &v2[i]
+	}
+	n := 0
+	for i := range v1 {
+		f := &v1[i]
+		if f.IsPad() {
+			continue
+		}
+		f2 := byPath[strings.Join(f.Path, "/")]
+		if f2 == nil || f2.Length != f.Length {
+			return fmt.Errorf("torrent: v1 and v2 files differ: %q", f.Path)
+		}
+		f.Root, f.Layer = f2.Root, f2.Layer
+		n++
+	}
+	if n != len(v2) {
+		return errors.New("torrent: v1 and v2 files differ")
+	}
+	return nil
+}
+
+func containsString(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Magnet returns a magnet link for the torrent.
+func (m *MetaInfo) Magnet() string {
+	q := make(url.Values)
+	if h := m.InfoHash(); h != "" {
+		q.Add("xt", "urn:btih:"+h)
+	}
+	if h := m.InfoHashV2(); h != "" {
+		// multihash prefix of SHA-256
+		q.Add("xt", "urn:btmh:1220"+h)
+	}
+	q.Set("dn", m.Name)
+	for _, u := range m.Announce {
+		q.Add("tr", u)
+	}
+	return "magnet:?" + q.Encode()
+}
@@ -0,0 +1,222 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBencode(t *testing.T) {
+	var buf bytes.Buffer
+	err := encode(&buf, dict{
+		"b": list{int64(-3), "x"},
+		"a": "spam",
+		"c": dict{},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "d1:a4:spam1:bli-3e1:xe1:cdee", buf.String())
+
+	v, err := decode(buf.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, dict{"a": "spam", "b": list{int64(-3), "x"}, "c": dict{}}, v)
+
+	raw, err := rawDict([]byte("d1:ad1:bi1ee1:c0:e"))
+	require.NoError(t, err)
+	require.Equal(t, "d1:bi1ee", string(raw["a"]))
+
+	for _, s := range []string{"", "i1", "5:abc", "l", "d1:a", "di1ei2ee", "1:ab"} {
+		_, err = decode([]byte(s))
+		require.Error(t, err, s)
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	b := func(c byte) []byte {
+		return bytes.Repeat([]byte{c}, BlockSize)
+	}
+	var content []byte
+	var leaves [][sha256.Size]byte
+	for _, c := range []byte{'a', 'b', 'c'} {
+		content = append(content, b(c)...)
+		leaves = append(leaves, sha256.Sum256(b(c)))
+	}
+	var zero [sha256.Size]byte
+	pair := func(l, r [sha256.Size]byte) [sha256.Size]byte {
+		return sha256.Sum256(append(l[:], r[:]...))
+	}
+
+	// two blocks per piece: the file has a piece layer
+	bl, err := NewBuilder(2*BlockSize, false, true)
+	require.NoError(t, err)
+	require.NoError(t, bl.AddFile([]string{"f"}, ""))
+	bl.Write(content)
+	m, err := bl.MetaInfo("f", false)
+	require.NoError(t, err)
+	p1, p2 := pair(leaves[0], leaves[1]), pair(leaves[2], zero)
+	require.Equal(t, pair(p1, p2), m.Files[0].Root)
+	require.Equal(t, append(p1[:], p2[:]...), m.Files[0].Layer)
+
+	// the whole file fits into a piece
+	bl, err = NewBuilder(8*BlockSize, false, true)
+	require.NoError(t, err)
+	require.NoError(t, bl.AddFile([]string{"f"}, ""))
+	bl.Write(content)
+	m, err = bl.MetaInfo("f", false)
+	require.NoError(t, err)
+	require.Equal(t, pair(p1, p2), m.Files[0].Root)
+	require.Nil(t, m.Files[0].Layer)
+
+	// a single block is its own root
+	bl, err = NewBuilder(BlockSize, false, true)
+	require.NoError(t, err)
+	require.NoError(t, bl.AddFile([]string{"f"}, ""))
+	bl.Write([]byte("abc"))
+	m, err = bl.MetaInfo("f", false)
+	require.NoError(t, err)
+	require.Equal(t, sha256.Sum256([]byte("abc")), m.Files[0].Root)
+}
+
+type testFile struct {
+	path string
+	data string
+}
+
+func buildTest(t testing.TB, pieceLen int64, v1, v2 bool, files []testFile) *MetaInfo {
+	b, err := NewBuilder(pieceLen, v1, v2)
+	require.NoError(t, err)
+	for _, f := range files {
+		require.NoError(t, b.AddFile(strings.Split(f.path, "/"), ""))
+		_, err = b.Write([]byte(f.data))
+		require.NoError(t, err)
+	}
+	m, err := b.MetaInfo("test", true)
+	require.NoError(t, err)
+	return m
+}
+
+func checkTest(m *MetaInfo, files map[string]string) error {
+	c := m.NewChecker()
+	for {
+		f, err := c.Next()
+		if err != nil {
+			return err
+		} else if f == nil {
+			return nil
+		}
+		c.Write([]byte(files[strings.Join(f.Path, "/")]))
+	}
+}
+
+func TestMetaInfo(t *testing.T) {
+	files := []testFile{
+		{"a.txt", strings.Repeat("a", 3*BlockSize+10)},
+		{"dir/b.txt", "b"},
+		{"dir/empty", ""},
+		{"dir/sub/c.txt", strings.Repeat("c", BlockSize)},
+		{"z", "zzz"},
+	}
+	content := make(map[string]string)
+	var size int64
+	for _, f := range files {
+		content[f.path] = f.data
+		size += int64(len(f.data))
+	}
+	for _, c := range []struct {
+		name   string
+		v1, v2 bool
+	}{
+		{"v1", true, false},
+		{"v2", false, true},
+		{"hybrid", true, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			m := buildTest(t, 2*BlockSize, c.v1, c.v2, files)
+			m.Announce = []string{"http://tracker.example/announce", "udp://tracker.example:80"}
+			data, err := m.Encode()
+			require.NoError(t, err)
+
+			m2, err := Parse(data)
+			require.NoError(t, err)
+			require.Equal(t, m, m2)
+			require.Equal(t, c.v1, m2.InfoHash() != "")
+			require.Equal(t, c.v2, m2.InfoHashV2() != "")
+			require.Equal(t, size, m2.Size())
+
+			if c.v1 {
+				var stream []byte
+				for _, f := range m2.Files {
+					if f.IsPad() {
+						stream = append(stream, make([]byte, f.Length)...)
+					} else {
+						stream = append(stream, content[strings.Join(f.Path, "/")]...)
+					}
+				}
+				var pieces []byte
+				for len(stream) != 0 {
+					n := int(m.PieceLength)
+					if n > len(stream) {
+						n = len(stream)
+					}
+					h := sha1.Sum(stream[:n])
+					pieces = append(pieces, h[:]...)
+					stream = stream[n:]
+				}
+				require.Equal(t, pieces, m2.Pieces)
+			}
+			if c.v1 && c.v2 {
+				// files are aligned to pieces
+				require.True(t, m2.Files[1].IsPad())
+				require.Equal(t, []string{".pad", "16374"}, m2.Files[1].Path)
+			}
+
+			require.NoError(t, checkTest(m2, content))
+
+			bad := make(map[string]string)
+			for k, v := range content {
+				bad[k] = v
+			}
+			bad["dir/sub/c.txt"] = strings.Repeat("x", BlockSize)
+			require.Error(t, checkTest(m2, bad))
+
+			bad["dir/sub/c.txt"] = "short"
+			require.Error(t, checkTest(m2, bad))
+		})
+	}
+}
+
+func TestBuilderOrder(t *testing.T) {
+	b, err := NewBuilder(BlockSize, true, true)
+	require.NoError(t, err)
+	require.NoError(t, b.AddFile([]string{"b"}, ""))
+	require.Error(t, b.AddFile([]string{"a"}, ""))
+	require.Error(t, b.AddFile([]string{"..", "c"}, ""))
+
+	_, err = NewBuilder(BlockSize+1, true, false)
+	require.Error(t, err)
+}
+
+func TestParseSingle(t *testing.T) {
+	b, err := NewBuilder(BlockSize, true, true)
+	require.NoError(t, err)
+	require.NoError(t, b.AddFile([]string{"file.bin"}, "x"))
+	b.Write([]byte("data"))
+	m, err := b.MetaInfo("file.bin", false)
+	require.NoError(t, err)
+	data, err := m.Encode()
+	require.NoError(t, err)
+
+	m2, err := Parse(data)
+	require.NoError(t, err)
+	require.False(t, m2.Dir)
+	require.Equal(t, m.Files, m2.Files)
+	require.True(t, m2.Files[0].IsExecutable())
+	require.Contains(t, m2.Magnet(), "xt=urn%3Abtih%3A"+m2.InfoHash())
+	require.Contains(t, m2.Magnet(), "xt=urn%3Abtmh%3A1220"+m2.InfoHashV2())
+
+	_, err = Parse([]byte("d4:infod4:name2:..12:piece lengthi16384e6:pieces0:6:lengthi0eee"))
+	require.Error(t, err)
+}
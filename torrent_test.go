@@ -0,0 +1,99 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+)
+
+func TestTorrent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	files := map[string]string{
+		"a.txt":       strings.Repeat("a", 40000),
+		"sub/b.txt":   "b",
+		"sub/c/d.txt": strings.Repeat("d", 20000),
+	}
+	ref := storeTestTree(t, s, files)
+
+	m, err := s.CreateTorrent(ctx, ref, &TorrentConfig{Name: "data"})
+	require.NoError(t, err)
+	require.True(t, m.Dir && m.V1 && m.V2)
+	data, err := m.Encode()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, s.Checkout(ctx, ref, filepath.Join(dir, "data")))
+
+	sr, tr, err := s.ImportTorrent(ctx, data, dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, ref, tr.Root.Ref)
+	require.Equal(t, m.InfoHash(), tr.InfoHash)
+	require.Equal(t, m.InfoHashV2(), tr.InfoHashV2)
+
+	obj, err := s.DecodeSchema(ctx, sr.Ref)
+	require.NoError(t, err)
+	require.Equal(t, tr, obj.(*schema.Torrent))
+
+	rc, _, err := s.FetchBlob(ctx, tr.Meta.Ref)
+	require.NoError(t, err)
+	meta, err := ioutil.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	require.Equal(t, data, meta)
+
+	// v1-only metainfo is verified by pieces spanning multiple files
+	m, err = s.CreateTorrent(ctx, ref, &TorrentConfig{Name: "data", V1: true})
+	require.NoError(t, err)
+	require.False(t, m.V2)
+	data, err = m.Encode()
+	require.NoError(t, err)
+	_, tr, err = s.ImportTorrent(ctx, data, dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, ref, tr.Root.Ref)
+	require.Empty(t, tr.InfoHashV2)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "data", "sub", "c", "d.txt"), []byte(strings.Repeat("x", 20000)), 0644)
+	require.NoError(t, err)
+	_, _, err = s.ImportTorrent(ctx, data, dir, nil)
+	require.Error(t, err)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "data", "a.txt")))
+	_, _, err = s.ImportTorrent(ctx, data, dir, nil)
+	require.Error(t, err)
+}
+
+func TestTorrentSingleFile(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	content := strings.Repeat("data", 10000)
+	sr, err := s.StoreBlob(ctx, bytes.NewReader([]byte(content)), nil)
+	require.NoError(t, err)
+
+	m, err := s.CreateTorrent(ctx, sr.Ref, &TorrentConfig{Name: "file.bin", V2: true})
+	require.NoError(t, err)
+	require.False(t, m.Dir)
+	require.Empty(t, m.InfoHash())
+	data, err := m.Encode()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	err = ioutil.WriteFile(filepath.Join(dir, "file.bin"), []byte(content), 0644)
+	require.NoError(t, err)
+	_, tr, err := s.ImportTorrent(ctx, data, dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"file.bin": content}, readTestTree(t, s, tr.Root.Ref))
+
+	_, err = s.CreateTorrent(ctx, storeTestTree(t, s, map[string]string{"a": "a"}), nil)
+	require.Error(t, err)
+}
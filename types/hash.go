@@ -0,0 +1,185 @@
+package types
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"sync/atomic"
+
+	"github.com/zeebo/blake3"
+)
+
+const (
+	// MaxHashSize is the maximal size of a hash digest that can be stored in a Ref.
+	MaxHashSize = sha512.Size
+
+	hashSha256Name     = "sha256"
+	hashSha224Name     = "sha224"
+	hashSha512Name     = "sha512"
+	hashSha512_256Name = "sha512-256"
+	hashSha1Name       = "sha1"
+	hashMD5Name        = "md5"
+	hashBlake3Name     = "blake3"
+	// HashGitSHA1 is an id of Git objects. It cannot address blobs, but can be used for aliases.
+	HashGitSHA1 = "git-sha1"
+
+	gitEmptyBlob = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+)
+
+// HashFunc describes a hash algorithm that can be used in refs.
+type HashFunc struct {
+	Name string // name of the algorithm, used as a prefix of refs
+	Size int    // size of the digest in bytes
+	// New creates a new hash. It is nil for algorithms that cannot hash a stream of data directly,
+	// for example Git object ids that depend on the object header. Refs of such hashes can only be used
+	// as aliases, and cannot address blobs.
+	New func() hash.Hash
+	// NewSized creates a new hash for the data of a known size. It is set for hashes that cannot be
+	// created with New, but can still be computed if the size of the data is known in advance.
+	NewSized func(size uint64) hash.Hash
+	// AliasOnly is set for hashes that are not collision resistant. They can be computed with New,
+	// but their refs can only be used as aliases, and cannot address blobs.
+	AliasOnly bool
+
+	empty Ref
+}
+
+// CanAddress reports whether refs of this hash can address blobs.
+func (f *HashFunc) CanAddress() bool {
+	return f.New != nil && !f.AliasOnly
+}
+
+// Empty returns a ref of an empty blob for this hash.
+func (f *HashFunc) Empty() Ref {
+	return f.empty
+}
+
+var hashes = builtinHashes()
+
+func builtinHashes() map[string]*HashFunc {
+	m := make(map[string]*HashFunc)
+	add := func(name string, size int, fnc func() hash.Hash, empty string) {
+		f := &HashFunc{Name: name, Size: size, New: fnc}
+		f.empty.name = name
+		if fnc != nil {
+			fnc().Sum(f.empty.data[:0])
+		} else if _, err := hex.Decode(f.empty.data[:], []byte(empty)); err != nil {
+			panic(err)
+		}
+		m[name] = f
+	}
+	add(hashSha256Name, sha256.Size, sha256.New, "")
+	add(hashSha224Name, sha256.Size224, sha256.New224, "")
+	add(hashSha512Name, sha512.Size, sha512.New, "")
+	add(hashSha512_256Name, sha512.Size256, sha512.New512_256, "")
+	add(hashSha1Name, sha1.Size, sha1.New, "")
+	add(hashBlake3Name, 32, func() hash.Hash { return blake3.New() }, "")
+	add(hashMD5Name, md5.Size, md5.New, "")
+	add(HashGitSHA1, sha1.Size, nil, gitEmptyBlob)
+	m[HashGitSHA1].NewSized = newGitBlobHash
+	// collisions of these hashes can be computed, thus blobs cannot be trusted to match their refs
+	m[hashSha1Name].AliasOnly = true
+	m[hashMD5Name].AliasOnly = true
+	return m
+}
+
+// newGitBlobHash creates a hash that computes Git object id of a blob with a given size.
+func newGitBlobHash(size uint64) hash.Hash {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", size)
+	return h
+}
+
+// RegisterHash adds a new hash algorithm. It should only be called during initialization.
+func RegisterHash(name string, size int, fnc func() hash.Hash) {
+	if _, ok := hashes[name]; ok {
+		panic(fmt.Errorf("hash %q is already registered", name))
+	} else if size <= 0 || size > MaxHashSize {
+		panic(fmt.Errorf("unsupported size of hash %q: %d", name, size))
+	} else if fnc == nil {
+		panic(fmt.Errorf("hash function is not set for %q", name))
+	}
+	f := &HashFunc{Name: name, Size: size, New: fnc}
+	f.empty.name = name
+	fnc().Sum(f.empty.data[:0])
+	hashes[name] = f
+}
+
+// GetHash returns a hash algorithm by name. It returns nil if the algorithm is not registered.
+func GetHash(name string) *HashFunc {
+	return hashes[name]
+}
+
+// Hashes lists names of all registered hash algorithms.
+func Hashes() []string {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var defaultHash atomic.Value
+
+// SetDefaultHash changes the hash algorithm used by NewRef and BytesRef.
+func SetDefaultHash(name string) error {
+	f := GetHash(name)
+	if f == nil {
+		return fmt.Errorf("unsupported hash: %q", name)
+	} else if !f.CanAddress() {
+		return fmt.Errorf("hash %q cannot be used to address blobs", name)
+	}
+	defaultHash.Store(name)
+	return nil
+}
+
+// GetDefaultHash returns the name of the hash algorithm used by NewRef.
+func GetDefaultHash() string {
+	if name, ok := defaultHash.Load().(string); ok {
+		return name
+	}
+	return DefaultHash
+}
+
+// NewRefWith is similar to NewRef, but uses a specific hash algorithm.
+func NewRefWith(name string) (Ref, error) {
+	f := GetHash(name)
+	if f == nil {
+		return Ref{}, fmt.Errorf("unsupported hash: %q", name)
+	} else if !f.CanAddress() {
+		return Ref{}, fmt.Errorf("hash %q cannot be used to address blobs", name)
+	}
+	return Ref{name: name}, nil
+}
+
+// BytesRefWith computes a Ref for a byte slice p using a specific hash algorithm.
+func BytesRefWith(name string, p []byte) (Ref, error) {
+	ref, err := NewRefWith(name)
+	if err != nil {
+		return Ref{}, err
+	}
+	h := ref.Hash()
+	if _, err = h.Write(p); err != nil {
+		return Ref{}, err
+	}
+	return ref.WithHash(h), nil
+}
+
+// HashWith computes the ref for the specified reader using a specific hash algorithm.
+func HashWith(name string, r io.Reader) (SizedRef, error) {
+	ref, err := NewRefWith(name)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	h := ref.Hash()
+	n, err := io.Copy(h, r)
+	ref = ref.WithHash(h)
+	return SizedRef{Ref: ref, Size: uint64(n)}, err
+}
@@ -0,0 +1,99 @@
+package types
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// MinPrefixLen is the minimal number of hex digits in an abbreviated ref.
+const MinPrefixLen = 4
+
+// RefPrefix is an abbreviated form of a ref, similar to short commit ids in Git.
+// It consists of a hash name and a few first hex digits of the hash value.
+type RefPrefix struct {
+	name string
+	hex  string
+}
+
+// ParseRefPrefix parses an abbreviated ref, for example "sha256:694b27f0". A full ref is also accepted.
+func ParseRefPrefix(s string) (RefPrefix, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return RefPrefix{}, fmt.Errorf("not a ref")
+	}
+	p := RefPrefix{name: s[:i], hex: s[i+1:]}
+	f := GetHash(p.name)
+	if f == nil {
+		return RefPrefix{}, fmt.Errorf("unsupported ref type: %q", p.name)
+	}
+	if len(p.hex) == refEnc.EncodedLen(f.Size) && !isHex(p.hex) {
+		// full base32 ref - convert to hex; strings that are valid hex are hex prefixes of the same length
+		ref, err := ParseRef(s)
+		if err != nil {
+			return RefPrefix{}, err
+		}
+		return ref.Prefix(), nil
+	}
+	if len(p.hex) < MinPrefixLen {
+		return RefPrefix{}, fmt.Errorf("ref prefix is too short: %q", s)
+	} else if len(p.hex) > hex.EncodedLen(f.Size) {
+		return RefPrefix{}, fmt.Errorf("ref prefix is too long: %q", s)
+	}
+	if !isHex(p.hex) {
+		return RefPrefix{}, fmt.Errorf("invalid ref prefix: %q", s)
+	}
+	return p, nil
+}
+
+// isHex checks if the string consists of lowercase hex digits.
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// IsRefPrefix checks if a string is a full or an abbreviated ref.
+func IsRefPrefix(s string) bool {
+	_, err := ParseRefPrefix(s)
+	return err == nil
+}
+
+// Prefix returns a prefix that matches this ref only.
+func (r Ref) Prefix() RefPrefix {
+	return RefPrefix{name: r.name, hex: hex.EncodeToString(r.Data())}
+}
+
+// Name returns the name of the hash function used in this prefix.
+func (p RefPrefix) Name() string {
+	return p.name
+}
+
+// String returns a string representation of a prefix.
+func (p RefPrefix) String() string {
+	return p.name + ":" + p.hex
+}
+
+// Ref returns a full ref, if prefix describes the whole hash value.
+func (p RefPrefix) Ref() (Ref, bool) {
+	f := GetHash(p.name)
+	if f == nil || len(p.hex) != hex.EncodedLen(f.Size) {
+		return Ref{}, false
+	}
+	ref, err := ParseRef(p.String())
+	if err != nil {
+		return Ref{}, false
+	}
+	return ref, true
+}
+
+// Match checks if a ref starts with this prefix.
+func (p RefPrefix) Match(r Ref) bool {
+	if r.name != p.name {
+		return false
+	}
+	return strings.HasPrefix(hex.EncodeToString(r.Data()), p.hex)
+}
@@ -2,7 +2,6 @@ package types
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding"
 	"encoding/base32"
 	"encoding/hex"
@@ -13,20 +12,21 @@ import (
 )
 
 const (
+	// DefaultHash is the hash algorithm that is used unless changed with SetDefaultHash.
 	DefaultHash = hashSha256Name
 )
 
-const (
-	useBase32      = false
-	hashSha256Name = "sha256"
-	hashBufSize    = sha256.Size
-)
-
-var refEnc = base32.StdEncoding.WithPadding(base32.NoPadding)
+// refEnc is a lowercase base32 encoding without padding. It's used for a compact text form of refs that is safe
+// to use in URLs and case-insensitive file systems.
+var refEnc = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
 
 // IsRef checks if string is a text representation of a Ref.
 func IsRef(s string) bool {
-	return strings.HasPrefix(s, DefaultHash+":")
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return false
+	}
+	return GetHash(s[:i]) != nil
 }
 
 // ParseRef parses the string as a Ref.
@@ -35,6 +35,7 @@ func ParseRef(s string) (Ref, error) {
 }
 
 // ParseRef parses byte slice as a string representation of a Ref.
+// It accepts both hex and base32 forms; the form is detected by the length of the string.
 func ParseRefBytes(s []byte) (Ref, error) {
 	if len(s) == 0 {
 		return Ref{}, nil
@@ -47,30 +48,22 @@ func ParseRefBytes(s []byte) (Ref, error) {
 		name: string(s[:i]),
 	}
 	s = s[i+1:]
-	sz := 0
-	switch ref.name {
-	case hashSha256Name:
-		sz = sha256.Size
-	default:
+	f := GetHash(ref.name)
+	if f == nil {
 		return Ref{}, fmt.Errorf("unsupported ref type: %q", ref.name)
 	}
-	var dsz int
-	if useBase32 {
-		dsz = refEnc.DecodedLen(len(s))
-	} else {
-		dsz = hex.DecodedLen(len(s))
-	}
-	if dsz != sz {
-		return Ref{}, fmt.Errorf("wrong size for %s ref: expected %d, got %d", ref.name, sz, dsz)
-	}
+	sz := f.Size
 	var (
 		n   int
 		err error
 	)
-	if useBase32 {
-		n, err = refEnc.Decode(ref.data[:], s)
-	} else {
-		n, err = hex.Decode(ref.data[:], s)
+	switch len(s) {
+	case hex.EncodedLen(sz):
+		n, err = hex.Decode(ref.data[:sz], s)
+	case refEnc.EncodedLen(sz):
+		n, err = refEnc.Decode(ref.data[:sz], s)
+	default:
+		return Ref{}, fmt.Errorf("wrong size for %s ref: expected %d, got %d", ref.name, sz, hex.DecodedLen(len(s)))
 	}
 	if err != nil {
 		return Ref{}, err
@@ -89,7 +82,7 @@ func MustParseRef(s string) Ref {
 	return ref
 }
 
-// NewRef creates a new zero ref with a default hash function.
+// NewRef creates a new zero ref with a default hash function. See SetDefaultHash.
 //
 // Example:
 //	ref := NewRef()
@@ -97,18 +90,16 @@ func MustParseRef(s string) Ref {
 //	h.Write(p)
 //	ref = ref.WithHash(h)
 func NewRef() Ref {
-	return Ref{name: DefaultHash}
+	return Ref{name: GetDefaultHash()}
 }
 
 // MakeRef creates a ref with a specified hash algorithm and value.
 func MakeRef(name string, data []byte) (Ref, error) {
-	sz := 0
-	switch name {
-	case hashSha256Name:
-		sz = sha256.Size
-	default:
+	f := GetHash(name)
+	if f == nil {
 		return Ref{}, fmt.Errorf("unsupported ref type: %q", name)
 	}
+	sz := f.Size
 	if sz != len(data) {
 		return Ref{}, fmt.Errorf("wrong size for %s ref: expected %d, got %d", name, sz, len(data))
 	}
@@ -182,15 +173,11 @@ var (
 	_ encoding.TextUnmarshaler = (*Ref)(nil)
 )
 
-var (
-	emptyRef = BytesRef(nil)
-)
-
 // Ref is a reference to a blob in content-addressable storage.
 // It consists of a hash type and the hash data. Refs are comparable.
 type Ref struct {
 	name string
-	data [hashBufSize]byte
+	data [MaxHashSize]byte // only the first N bytes are used, according to the hash size
 }
 
 // MarshalText implements encoding.TextMarshaler interface.
@@ -215,15 +202,29 @@ func (r Ref) Zero() bool {
 
 // Empty checks if this ref describes an empty blob (0 bytes).
 func (r Ref) Empty() bool {
-	return r.name == DefaultHash && r == emptyRef
+	f := GetHash(r.name)
+	return f != nil && r == f.empty
 }
+
+// size returns the size of the hash data.
+func (r Ref) size() int {
+	if f := GetHash(r.name); f != nil {
+		return f.Size
+	}
+	return 0
+}
+
 func (r Ref) stringBytes() []byte {
+	return r.encode(false)
+}
+
+func (r Ref) encode(b32 bool) []byte {
 	if r.Zero() {
 		return nil
 	}
 	sz := len(r.name) + 1
-	data := r.data[:]
-	if useBase32 {
+	data := r.data[:r.size()]
+	if b32 {
 		sz += refEnc.EncodedLen(len(data))
 	} else {
 		sz += hex.EncodedLen(len(data))
@@ -233,7 +234,7 @@ func (r Ref) stringBytes() []byte {
 	buf[i] = ':'
 	i++
 
-	if useBase32 {
+	if b32 {
 		refEnc.Encode(buf[i:], data)
 	} else {
 		hex.Encode(buf[i:], data)
@@ -246,6 +247,24 @@ func (r Ref) String() string {
 	return string(r.stringBytes())
 }
 
+// Base32 returns a compact string representation of a ref that uses lowercase base32 encoding.
+// It is suitable for URLs and file names, and is accepted by ParseRef.
+func (r Ref) Base32() string {
+	return string(r.encode(true))
+}
+
+// Short returns an abbreviated string representation of a ref with n hex digits.
+func (r Ref) Short(n int) string {
+	s := r.String()
+	if r.Zero() || n <= 0 {
+		return s
+	}
+	if n += len(r.name) + 1; n < len(s) {
+		s = s[:n]
+	}
+	return s
+}
+
 // GoString returns a ref representation suitable for the use in the Go source code.
 func (r Ref) GoString() string {
 	return fmt.Sprintf("types.MustParseRef(%q)", r.String())
@@ -259,24 +278,27 @@ func (r Ref) Name() string {
 // Data returns the hash value of this ref.
 func (r Ref) Data() []byte {
 	d := r.data
-	return d[:]
+	return d[:r.size()]
 }
 
 // Hash initializes a new hash to populate the ref.
+// It returns nil for hash algorithms that cannot be computed directly, see HashFunc.
 //
 // Example:
 //	h := ref.Hash()
 //	h.Write(p)
 //	ref = ref.WithHash(h)
 func (r Ref) Hash() hash.Hash {
-	switch r.name {
-	case "":
+	if r.name == "" {
 		return nil
-	case hashSha256Name:
-		return sha256.New()
-	default:
+	}
+	f := GetHash(r.name)
+	if f == nil {
 		panic(fmt.Errorf("hash with unknown type: %q", r.name))
+	} else if f.New == nil {
+		return nil
 	}
+	return f.New()
 }
 
 // WithHash returns a ref that is described by the specified hash.
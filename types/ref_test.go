@@ -26,6 +26,80 @@ func TestRef(t *testing.T) {
 	r, err := ParseRef(s)
 	require.NoError(t, err)
 	require.Equal(t, "sha256", r.name)
-	require.Equal(t, b, r.data)
+	require.Equal(t, b[:], r.Data())
 	require.Equal(t, s, r.String())
 }
+
+func TestRefHashes(t *testing.T) {
+	for _, name := range []string{"sha256", "sha512-256", "blake3"} {
+		t.Run(name, func(t *testing.T) {
+			r, err := BytesRefWith(name, []byte("abc"))
+			require.NoError(t, err)
+			require.Equal(t, name, r.Name())
+			require.Equal(t, GetHash(name).Size, len(r.Data()))
+
+			r2, err := ParseRef(r.String())
+			require.NoError(t, err)
+			require.Equal(t, r, r2)
+
+			e, err := BytesRefWith(name, nil)
+			require.NoError(t, err)
+			require.True(t, e.Empty())
+			require.False(t, r.Empty())
+		})
+	}
+	r, err := BytesRefWith("blake3", []byte("abc"))
+	require.NoError(t, err)
+	require.Equal(t, "blake3:6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85", r.String())
+
+	for _, name := range []string{HashGitSHA1, "md5", "sha1"} {
+		_, err = NewRefWith(name)
+		require.Error(t, err)
+		require.Error(t, SetDefaultHash(name))
+	}
+	md5, err := ParseRef("md5:d41d8cd98f00b204e9800998ecf8427e")
+	require.NoError(t, err)
+	require.True(t, md5.Empty())
+	g := MustParseRef("git-sha1:e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")
+	require.True(t, g.Empty())
+	require.Nil(t, g.Hash())
+}
+
+func TestRefBase32(t *testing.T) {
+	r := StringRef("abc")
+	s := r.Base32()
+	require.Equal(t, "sha256:xj4bnp4pahh6uqkbidpf3lrceoyagyndsylxvhfucd7wd4qacwwq", s)
+	r2, err := ParseRef(s)
+	require.NoError(t, err)
+	require.Equal(t, r, r2)
+}
+
+func TestRefPrefix(t *testing.T) {
+	r := StringRef("abc")
+	require.Equal(t, "sha256:ba7816bf", r.Short(8))
+
+	p, err := ParseRefPrefix("sha256:ba7816bf")
+	require.NoError(t, err)
+	require.True(t, p.Match(r))
+	require.False(t, p.Match(StringRef("abd")))
+	_, ok := p.Ref()
+	require.False(t, ok)
+
+	p, err = ParseRefPrefix(r.Base32())
+	require.NoError(t, err)
+	full, ok := p.Ref()
+	require.True(t, ok)
+	require.Equal(t, r, full)
+
+	// hex prefixes with the length of a base32 ref are still hex
+	long := r.Short(52)
+	p, err = ParseRefPrefix(long)
+	require.NoError(t, err)
+	require.Equal(t, long, p.String())
+	require.True(t, p.Match(r))
+
+	for _, s := range []string{"sha256:ba7", "sha256:BA7816BF", "sha256:xyz123", "foo:ba7816bf", "ba7816bf"} {
+		_, err = ParseRefPrefix(s)
+		require.Error(t, err, s)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -22,9 +23,16 @@ func (s *Storage) StoreAddr(ctx context.Context, addr string, conf *StoreConfig)
 		return types.SizedRef{}, err
 	}
 	conf = checkConfig(conf)
+	ctx, err = s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return types.SizedRef{}, err
+	}
 	if u.Scheme != "" {
 		return s.StoreURLContent(ctx, addr, conf)
 	}
+	if fi, err := os.Stat(addr); err == nil && !fi.IsDir() {
+		return s.storeFileWithIndex(ctx, addr, conf)
+	}
 	return s.StoreFilePath(ctx, addr, conf)
 }
 
@@ -64,6 +72,10 @@ func (s *Storage) storeWebContentSchema(ctx context.Context, sr SizedRef, req *h
 
 func (s *Storage) StoreHTTPContent(ctx context.Context, req *http.Request, conf *StoreConfig) (SizedRef, error) {
 	conf = checkConfig(conf)
+	ctx, err := s.hashContext(ctx, conf.Hash)
+	if err != nil {
+		return SizedRef{}, err
+	}
 
 	req = req.WithContext(ctx)
 	resp, err := http.DefaultClient.Do(req)
@@ -75,11 +87,15 @@ func (s *Storage) StoreHTTPContent(ctx context.Context, req *http.Request, conf
 	if resp.StatusCode != http.StatusOK {
 		return SizedRef{}, fmt.Errorf("status: %v", resp.Status)
 	}
-	sr, err := s.StoreBlob(ctx, resp.Body, conf)
+	sr, isr, err := s.storeWithIndex(ctx, req.URL.Path, resp.Body, conf)
 	if err != nil {
 		return SizedRef{}, err
 	}
 	resp.Body.Close()
+	if conf.Decompress && !isr.Ref.Zero() {
+		// only the decompressed content is stored, thus point to the index object that describes both forms
+		sr = isr
+	}
 
 	return s.storeWebContentSchema(ctx, sr, req, resp)
 }
@@ -141,7 +157,14 @@ func (s *Storage) syncWebContent(ctx context.Context, oref Ref, obj *schema.WebC
 		return Ref{}, err
 	}
 	resp.Body.Close()
-	if sr.Ref == obj.Ref {
+	exp := obj.Ref
+	if c, err := s.DecodeSchema(ctx, obj.Ref); err == nil {
+		if c, ok := c.(*schema.Compressed); ok {
+			// content was stored decompressed
+			exp = c.Arch.Ref
+		}
+	}
+	if sr.Ref == exp {
 		return oref, nil
 	}
 
@@ -0,0 +1,214 @@
+package cas
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+const formatZip = "zip"
+
+// maxLinkSize is the maximal length of a symlink target in archives.
+const maxLinkSize = 4096
+
+// openReaderAt opens a blob for random access. If the storage cannot provide it directly,
+// the blob is copied to a temporary file.
+func (s *Storage) openReaderAt(ctx context.Context, ref Ref) (io.ReaderAt, uint64, func(), error) {
+	rc, sz, err := s.st.FetchBlob(ctx, ref)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if ra, ok := rc.(io.ReaderAt); ok {
+		return ra, sz, func() { rc.Close() }, nil
+	}
+	defer rc.Close()
+	f, err := ioutil.TempFile("", "cas_blob_")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	closer := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	n, err := io.Copy(f, storage.VerifyReader(rc, ref))
+	if err != nil {
+		closer()
+		return nil, 0, nil, err
+	}
+	return f, uint64(n), closer, nil
+}
+
+// IndexZip reads a zip archive stored in a blob and stores a schema object that describes each file in it.
+// Content of files is not stored separately; entries only record refs of their content, thus files that
+// already exist in the storage are deduplicated, and the rest can be read from the archive itself.
+func (s *Storage) IndexZip(ctx context.Context, arch Ref) (SizedRef, error) {
+	ctx, err := s.hashContext(ctx, "")
+	if err != nil {
+		return SizedRef{}, err
+	}
+	ra, size, closer, err := s.openReaderAt(ctx, arch)
+	if err != nil {
+		return SizedRef{}, err
+	}
+	defer closer()
+
+	zr, err := zip.NewReader(ra, int64(size))
+	if err != nil {
+		return SizedRef{}, err
+	}
+	m := &schema.Archive{
+		Format:  formatZip,
+		Arch:    SizedRef{Ref: arch, Size: size},
+		Entries: make([]schema.ArchiveEntry, 0, len(zr.File)),
+	}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue // directory
+		}
+		off, err := f.DataOffset()
+		if err != nil {
+			return SizedRef{}, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return SizedRef{}, fmt.Errorf("cannot open %q: %v", f.Name, err)
+		}
+		var link bytes.Buffer
+		r := io.Reader(rc)
+		if f.Mode()&os.ModeSymlink != 0 {
+			// content of a symlink is its target
+			r = io.TeeReader(io.LimitReader(rc, maxLinkSize+1), &link)
+		}
+		sr, err := types.HashWith(storage.HashName(ctx), r)
+		rc.Close()
+		if err != nil {
+			return SizedRef{}, fmt.Errorf("cannot read %q: %v", f.Name, err)
+		} else if link.Len() > maxLinkSize {
+			return SizedRef{}, fmt.Errorf("symlink %q is too long", f.Name)
+		}
+		ent := schema.ArchiveEntry{
+			Name:   f.Name,
+			Ref:    sr.Ref,
+			Size:   sr.Size,
+			Mode:   schema.UnixMode(f.Mode()),
+			Method: f.Method,
+			Offset: uint64(off),
+			CSize:  f.CompressedSize64,
+			Link:   link.String(),
+		}
+		if !f.Modified.IsZero() {
+			t := f.Modified.UTC()
+			ent.ModTime = &t
+		}
+		m.Entries = append(m.Entries, ent)
+	}
+	return s.StoreSchema(ctx, m)
+}
+
+// OpenArchiveEntry opens a file stored in an archive. It will use a blob with the same content, if it exists,
+// and will fallback to reading the file from the archive otherwise.
+func (s *Storage) OpenArchiveEntry(ctx context.Context, a *schema.Archive, e *schema.ArchiveEntry) (io.ReadCloser, error) {
+	if e.Size == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	rc, _, err := s.FetchBlob(ctx, e.Ref)
+	if err == nil {
+		return rc, nil
+	} else if err != storage.ErrNotFound {
+		return nil, err
+	}
+	if a.Format != formatZip {
+		return nil, fmt.Errorf("unsupported archive format: %q", a.Format)
+	}
+	arc, _, err := s.st.FetchBlob(ctx, a.Arch.Ref)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if ra, ok := arc.(io.ReaderAt); ok {
+		r = io.NewSectionReader(ra, int64(e.Offset), int64(e.CSize))
+	} else {
+		if _, err = io.CopyN(ioutil.Discard, arc, int64(e.Offset)); err != nil {
+			arc.Close()
+			return nil, err
+		}
+		r = io.LimitReader(arc, int64(e.CSize))
+	}
+	var closer io.Closer = arc
+	switch e.Method {
+	case zip.Store:
+	case zip.Deflate:
+		zr := flate.NewReader(r)
+		r, closer = zr, closeBoth{zr, arc}
+	default:
+		arc.Close()
+		return nil, fmt.Errorf("unsupported compression method: %d", e.Method)
+	}
+	return storage.VerifyReader(struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: r,
+		Closer: closer,
+	}, e.Ref), nil
+}
+
+// closeBoth closes a reader and the underlying reader.
+type closeBoth [2]io.Closer
+
+func (c closeBoth) Close() error {
+	err := c[0].Close()
+	if err2 := c[1].Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (s *Storage) checkoutArchive(ctx context.Context, a *schema.Archive, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for i := range a.Entries {
+		e := &a.Entries[i]
+		name := cleanTreePath(e.Name)
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(dst, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if e.IsSymlink() {
+			if err := os.Symlink(e.Link, path); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := s.OpenArchiveEntry(ctx, a, e)
+		if err != nil {
+			return err
+		}
+		err = s.checkoutBlobData(ctx, rc, SizedRef{Ref: e.Ref, Size: e.Size}, path)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if perm := os.FileMode(e.Mode) & os.ModePerm; perm != 0 {
+			if err = os.Chmod(path, perm); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package cas
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennwc/cas/schema"
+	"github.com/dennwc/cas/storage"
+	"github.com/dennwc/cas/types"
+)
+
+func TestIndexZip(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t)
+
+	files := map[string]string{
+		"a.txt":     "some text that is compressed with deflate",
+		"dir/b.txt": "stored as is",
+	}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"a.txt", "dir/b.txt"} {
+		method := zip.Deflate
+		if name == "dir/b.txt" {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	hdr := &zip.FileHeader{Name: "link"}
+	hdr.SetMode(os.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(hdr)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a.txt"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	arch, err := storage.WriteBytes(ctx, s, buf.Bytes())
+	require.NoError(t, err)
+
+	// one of the files already exists in the storage
+	_, err = storage.WriteBytes(ctx, s, []byte(files["a.txt"]))
+	require.NoError(t, err)
+
+	sr, err := s.IndexZip(ctx, arch.Ref)
+	require.NoError(t, err)
+
+	obj, err := s.DecodeSchema(ctx, sr.Ref)
+	require.NoError(t, err)
+	a, ok := obj.(*schema.Archive)
+	require.True(t, ok)
+	require.Equal(t, arch, a.Arch)
+	require.Len(t, a.Entries, 3)
+	link := a.Entries[2]
+	require.True(t, link.IsSymlink())
+	require.Equal(t, "a.txt", link.Link)
+
+	for i := range a.Entries[:2] {
+		e := &a.Entries[i]
+		exp := files[e.Name]
+		require.Equal(t, types.StringRef(exp), e.Ref)
+
+		rc, err := s.OpenArchiveEntry(ctx, a, e)
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.Equal(t, exp, string(data))
+	}
+
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "out")
+	require.NoError(t, s.Checkout(ctx, sr.Ref, dst))
+	for name, exp := range files {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		require.NoError(t, err)
+		require.Equal(t, exp, string(data))
+	}
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	require.Equal(t, "a.txt", target)
+
+	// entries are hashed with the selected hash
+	sr, err = s.IndexZip(storage.WithHash(ctx, "blake3"), arch.Ref)
+	require.NoError(t, err)
+	obj, err = s.DecodeSchema(ctx, sr.Ref)
+	require.NoError(t, err)
+	for _, e := range obj.(*schema.Archive).Entries {
+		exp, err := types.BytesRefWith("blake3", []byte(files[e.Name]+e.Link))
+		require.NoError(t, err)
+		require.Equal(t, exp, e.Ref)
+	}
+}